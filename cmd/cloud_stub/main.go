@@ -0,0 +1,134 @@
+// Package main is a local stand-in for the cloud layer above the fog.
+// It accepts the fog's sync batches, appends their records to one JSON-lines
+// file per fog and stream, and serves a config/mission update from a file.
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"LoraFog/internal/model"
+	"LoraFog/internal/util"
+)
+
+// main serves /ingest/{stream} and /config until interrupted.
+func main() {
+	util.SetupLogger()
+
+	addr := flag.String("addr", ":9000", "listen address")
+	dir := flag.String("dir", "tmp/cloud", "directory for received records")
+	cfgPath := flag.String("config", "", "JSON CloudUpdate served on /config (optional)")
+	token := flag.String("token", "", "required bearer token (optional)")
+	flag.Parse()
+
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		log.Fatalf("[cloud] %v", err)
+	}
+	var mu sync.Mutex
+	seen := map[string]uint64{} // fog/stream -> last stored seq
+
+	authorized := func(w http.ResponseWriter, r *http.Request) bool {
+		if *token != "" && r.Header.Get("Authorization") != "Bearer "+*token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+
+	http.HandleFunc("/ingest/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorized(w, r) {
+			return
+		}
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, "invalid gzip body", http.StatusBadRequest)
+				return
+			}
+			body = zr
+		}
+		var b model.SyncBatch
+		if err := json.NewDecoder(body).Decode(&b); err != nil {
+			http.Error(w, "invalid batch", http.StatusBadRequest)
+			return
+		}
+		if b.FogID == "" || b.Stream != strings.TrimPrefix(r.URL.Path, "/ingest/") {
+			http.Error(w, "fog_id or stream mismatch", http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		key := b.FogID + "/" + b.Stream
+		f, err := os.OpenFile(filepath.Join(*dir, b.FogID+"-"+b.Stream+".jsonl"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				log.Printf("[cloud] warning: close %s: %v", key, err)
+			}
+		}()
+		stored := 0
+		for _, rec := range b.Records {
+			if rec.Seq <= seen[key] {
+				continue // resent after a lost answer
+			}
+			if _, err := f.Write(append(rec.Data, '\n')); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			seen[key] = rec.Seq
+			stored++
+		}
+		log.Printf("[cloud] %s %d-%d: stored %d of %d", key, b.FirstSeq, b.LastSeq, stored, len(b.Records))
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	http.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(w, r) {
+			return
+		}
+		if *cfgPath == "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		b, err := os.ReadFile(*cfgPath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var up model.CloudUpdate
+		if err := json.Unmarshal(b, &up); err != nil {
+			http.Error(w, "invalid config file", http.StatusInternalServerError)
+			return
+		}
+		etag := `"` + up.Version + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write(b); err != nil {
+			log.Printf("[cloud] warning: write config: %v", err)
+		}
+	})
+
+	log.Printf("[cloud] stand-in listening on %s, storing to %s", *addr, *dir)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
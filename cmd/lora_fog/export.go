@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"LoraFog/internal/export"
+	"LoraFog/internal/store"
+
+	"go.etcd.io/bbolt"
+)
+
+// runExport implements "lora_fog export": it writes one vehicle's stored
+// telemetry from the app database as GPX, KML, GeoJSON or CSV.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	dbPath := fs.String("db", "tmp/data.db", "app database")
+	vehicle := fs.String("vehicle", "", "vehicle ID (required)")
+	format := fs.String("format", export.GPX, "output format: "+strings.Join(export.Formats, ", "))
+	from := fs.String("from", "", "start time, RFC 3339 or unix seconds")
+	to := fs.String("to", "", "end time, RFC 3339 or unix seconds")
+	out := fs.String("o", "", "output file (default stdout)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if *vehicle == "" {
+		return errors.New("-vehicle is required")
+	}
+	start, err := export.ParseTime(*from)
+	if err != nil {
+		return fmt.Errorf("-from: %w", err)
+	}
+	end, err := export.ParseTime(*to)
+	if err != nil {
+		return fmt.Errorf("-to: %w", err)
+	}
+
+	// read-only, so a stopped app's database is not modified; a running app
+	// holds the file lock and must be stopped or queried over HTTP instead
+	db, err := bbolt.Open(*dbPath, 0o600, &bbolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("open %s (is the app running?): %w", *dbPath, err)
+	}
+	defer func() {
+		if cerr := db.Close(); cerr != nil {
+			fmt.Fprintf(os.Stderr, "warning: close %s: %v\n", *dbPath, cerr)
+		}
+	}()
+	st, err := store.Open(db)
+	if err != nil {
+		return err
+	}
+	// an unknown vehicle fails before the output file is created
+	if err := st.Range(*vehicle, start, end, nil, func(store.Point) bool { return false }); err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := f.Close(); cerr != nil {
+				fmt.Fprintf(os.Stderr, "warning: close %s: %v\n", *out, cerr)
+			}
+		}()
+		w = f
+	}
+	n, err := export.Write(w, *format, *vehicle, func(fn func(store.Point) bool) error {
+		return st.Range(*vehicle, start, end, nil, fn)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "exported %d points of %s as %s\n", n, *vehicle, *format)
+	return nil
+}
@@ -1,10 +1,11 @@
 // Package main is the entry point of the LoraFog system.
 // It initializes the logger, loads the configuration, constructs all components
-// (FogServer, Gateways, Vehicles) and starts them in a unified runtime.
+// (FogServer, web app, Gateways, Vehicles) and starts them in a unified runtime.
 package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
@@ -17,7 +18,16 @@ import (
 // main is the single entrypoint for the LoraFog application.
 // It loads configuration, constructs the system and starts all components.
 // The program waits for an interrupt signal and performs graceful shutdown.
+// "lora_fog export ..." exports a stored track instead (see runExport).
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		if err := runExport(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "export: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	util.SetupLogger()
 
 	// cfgPath := "configs/config.yml"
@@ -37,16 +47,6 @@ func main() {
 		log.Fatalf("failed to start system: %v", err)
 	}
 
-	// app, err := app.NewApp()
-	// if err != nil {
-	// 	log.Fatalf("[Main] failed to initialize web app: %v", err)
-	// }
-	// go func() {
-	// 	if err := app.Start(sys.Fog.AppAddr); err != nil {
-	// 		log.Printf("[App] Failed to start web server: %v", err)
-	// 	}
-	// }()
-
 	// wait for Ctrl+C or SIGTERM
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
@@ -54,6 +54,5 @@ func main() {
 
 	log.Println("[Main] Shutting down system...")
 	sys.StopAll()
-	// app.Stop()
 	log.Println("[Main] System stopped cleanly.")
 }
@@ -3,15 +3,23 @@ module LoraFog
 go 1.24.6
 
 require (
+	github.com/eclipse/paho.mqtt.golang v1.5.1
 	github.com/gorilla/websocket v1.5.3
+	github.com/mochi-mqtt/server/v2 v2.7.9
 	go.bug.st/serial v1.6.4
 	go.etcd.io/bbolt v1.4.3
+	google.golang.org/grpc v1.79.3
 	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/creack/goselect v0.1.2 // indirect
-	github.com/kr/pretty v0.1.0 // indirect
-	golang.org/x/sys v0.29.0 // indirect
+	github.com/rs/xid v1.4.0 // indirect
+	golang.org/x/net v0.48.0 // indirect
+	golang.org/x/sync v0.19.0 // indirect
+	golang.org/x/sys v0.39.0 // indirect
+	golang.org/x/text v0.32.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20251202230838-ff82c1b0f217 // indirect
+	google.golang.org/protobuf v1.36.10 // indirect
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
 )
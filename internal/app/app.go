@@ -9,20 +9,35 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"LoraFog/internal/metrics"
+	"LoraFog/internal/model"
+	"LoraFog/internal/store"
+	"LoraFog/internal/stream"
+
 	"go.etcd.io/bbolt"
 )
 
 type App struct {
 	DB     *bbolt.DB
+	Store  *store.Store // per-vehicle telemetry series in DB
 	Tmpl   *template.Template
 	Mux    *http.ServeMux
 	Server *http.Server
+	Stream *stream.Hub // stored telemetry, served on /api/stream
+
+	compactor *compactor    // retention and rollups, see SetRetention
+	sessions  *sessionStore // logins, see handleLogin
+
+	mu      sync.Mutex // guards Server and stopped between Start and Stop
+	stopped bool
 }
 
 // NewApp initializes the web app with templates, database, and routes.
-func NewApp() (*App, error) {
+// retention is app.retention from the config.
+func NewApp(retention model.RetentionConfig) (*App, error) {
 	cwd, _ := os.Getwd()
 	tmplPath := filepath.Join(cwd, "web", "templates", "*.html")
 
@@ -45,10 +60,23 @@ func NewApp() (*App, error) {
 		return nil, fmt.Errorf("[app] failed to open BoltDB: %w", err)
 	}
 
+	st, err := store.Open(db)
+	if err != nil {
+		return nil, fmt.Errorf("[app] failed to prepare telemetry store: %w", err)
+	}
+
 	app := &App{
-		DB:   db,
-		Tmpl: tmpl,
-		Mux:  http.NewServeMux(),
+		DB:       db,
+		Store:    st,
+		Tmpl:     tmpl,
+		Mux:      http.NewServeMux(),
+		Stream:   stream.NewHub(stream.DefaultHistory),
+		sessions: newSessionStore(),
+	}
+
+	app.SetRetention(retention)
+	if err := app.migrateLegacyTelemetry(); err != nil {
+		log.Printf("[app] warning: legacy telemetry not migrated: %v", err)
 	}
 
 	app.registerRoutes()
@@ -75,38 +103,56 @@ func (a *App) Start(addr string) error {
 		addr = ":" + addr
 	}
 
-	a.Server = &http.Server{
+	a.mu.Lock()
+	if a.stopped {
+		a.mu.Unlock()
+		return nil
+	}
+	srv := &http.Server{
 		Addr:    addr,
-		Handler: a.Mux,
+		Handler: metrics.Instrument("app", a.Mux),
 	}
+	a.Server = srv
+	a.compactor.start()
+	a.mu.Unlock()
 
 	log.Printf("[app] Web server listening at http://%s", addr)
 
 	// Run server until Shutdown() is called
-	if err := a.Server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		return fmt.Errorf("[app] HTTP server error: %w", err)
 	}
 	return nil
 }
 
-// Stop gracefully stops the web server and closes the DB.
+// Stop gracefully stops the web server and closes the DB. A Start still
+// to come returns at once.
 func (a *App) Stop() {
 	if a == nil {
 		return
 	}
+	a.mu.Lock()
+	a.stopped = true
+	srv := a.Server
+	a.mu.Unlock()
 
 	// Gracefully stop HTTP server
-	if a.Server != nil {
+	if srv != nil {
 		log.Println("[app] Shutting down web server...")
 		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 		defer cancel()
-		if err := a.Server.Shutdown(ctx); err != nil {
+		if err := srv.Shutdown(ctx); err != nil {
 			log.Printf("[app] HTTP server shutdown error: %v", err)
 		} else {
 			log.Println("[app] Web server stopped cleanly")
 		}
 	}
 
+	// Stop compaction before the DB closes under it
+	if a.compactor != nil {
+		a.compactor.stop()
+	}
+
 	// Close DB
 	if a.DB != nil {
 		if err := a.DB.Close(); err != nil {
@@ -0,0 +1,117 @@
+package app
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"LoraFog/internal/health"
+	"LoraFog/internal/model"
+	"LoraFog/internal/store"
+)
+
+const defaultCompactInterval = 5 * time.Minute
+
+// compactor periodically rolls raw telemetry up into 1-minute aggregates
+// and purges data past its retention.
+type compactor struct {
+	cfg    model.RetentionConfig
+	store  *store.Store
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu      sync.Mutex
+	lastRun time.Time
+	last    store.CompactStats
+	lastErr error
+}
+
+// SetRetention sets the retention policy applied by the background compactor.
+// Data is only purged for classes with a positive retention; rollups are
+// built either way.
+func (a *App) SetRetention(c model.RetentionConfig) {
+	a.compactor = &compactor{cfg: c, store: a.Store}
+}
+
+// policy returns what may be purged as of now.
+func (c *compactor) policy(now time.Time) store.Policy {
+	var p store.Policy
+	if c.cfg.RawDays > 0 {
+		p.RawBefore = now.AddDate(0, 0, -c.cfg.RawDays)
+	}
+	if c.cfg.AggregateMonths > 0 {
+		p.AggregateBefore = now.AddDate(0, -c.cfg.AggregateMonths, 0)
+	}
+	return p
+}
+
+// interval returns the compaction period.
+func (c *compactor) interval() time.Duration {
+	if c.cfg.CompactIntervalS > 0 {
+		return time.Duration(c.cfg.CompactIntervalS) * time.Second
+	}
+	return defaultCompactInterval
+}
+
+// start runs compaction now and then every interval until stop.
+func (c *compactor) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel, c.done = cancel, make(chan struct{})
+	go func() {
+		defer close(c.done)
+		ticker := time.NewTicker(c.interval())
+		defer ticker.Stop()
+		for {
+			c.run(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// stop cancels a running compaction and waits for it.
+func (c *compactor) stop() {
+	if c.cancel == nil {
+		return
+	}
+	c.cancel()
+	<-c.done
+}
+
+// run performs one compaction.
+func (c *compactor) run(ctx context.Context) {
+	start := time.Now()
+	st, err := c.store.Compact(ctx, c.policy(start))
+	if ctx.Err() != nil {
+		return
+	}
+	c.mu.Lock()
+	c.lastRun, c.last, c.lastErr = start, st, err
+	c.mu.Unlock()
+	if err != nil {
+		log.Printf("[app] compaction failed: %v", err)
+		return
+	}
+	if st != (store.CompactStats{}) {
+		log.Printf("[app] compaction: %d rollups, purged %d raw and %d aggregates in %s",
+			st.Rollups, st.RawPurged, st.AggregatesPurged, time.Since(start).Truncate(time.Millisecond))
+	}
+}
+
+// check reports the last compaction as a "compactor" health check.
+func (c *compactor) check() health.Check {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lastRun.IsZero() {
+		return health.Info("compactor", "not run yet")
+	}
+	if c.lastErr != nil {
+		return health.Check{Name: "compactor", Status: health.StatusWarn, Detail: c.lastErr.Error()}
+	}
+	return health.Info("compactor", "last run %s ago: %d rollups, purged %d raw, %d aggregates",
+		time.Since(c.lastRun).Truncate(time.Second), c.last.Rollups, c.last.RawPurged, c.last.AggregatesPurged)
+}
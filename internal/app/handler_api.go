@@ -3,15 +3,21 @@ package app
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
-	"go.etcd.io/bbolt"
+	"LoraFog/internal/model"
+	"LoraFog/internal/parser"
+	"LoraFog/internal/store"
+	"LoraFog/internal/stream"
 )
 
-// handleTelemetry stores incoming telemetry data into BoltDB.
+// handleTelemetry decodes incoming telemetry (JSON or CSV) and stores it in
+// the vehicle's time series.
 func (a *App) handleTelemetry(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
@@ -22,46 +28,79 @@ func (a *App) handleTelemetry(w http.ResponseWriter, r *http.Request) {
 		log.Printf("[app] warning: failed to close telemetry body: %v", cerr)
 	}
 
-	timestamp := time.Now().Format(time.RFC3339Nano)
-	err = a.DB.Update(func(tx *bbolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists([]byte("telemetry"))
-		if err != nil {
-			return err
-		}
-		return b.Put([]byte(timestamp), body)
-	})
+	vd, err := decodeTelemetry(body)
 	if err != nil {
+		http.Error(w, "invalid telemetry", http.StatusBadRequest)
+		return
+	}
+	if err := a.Store.Put(vd, time.Now()); err != nil {
+		log.Printf("[app] store telemetry for %s: %v", vd.VehicleID, err)
 		http.Error(w, "failed to save telemetry", http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("[app] received telemetry (%d bytes)", len(body))
+	log.Printf("[app] received telemetry for %s (%d bytes)", vd.VehicleID, len(body))
+	a.streamTelemetry(vd)
 	w.WriteHeader(http.StatusOK)
 }
 
-// handleLatest retrieves the latest telemetry entry.
-func (a *App) handleLatest(w http.ResponseWriter, r *http.Request) {
-	err := a.DB.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte("telemetry"))
-		if b == nil {
-			http.Error(w, "no telemetry data", http.StatusNotFound)
-			return nil
-		}
-		c := b.Cursor()
-		k, v := c.Last()
-		if v == nil {
-			http.Error(w, "no data available", http.StatusNotFound)
-			return nil
+// decodeTelemetry decodes the fog's wire format, JSON or CSV.
+func decodeTelemetry(body []byte) (model.VehicleData, error) {
+	var vd model.VehicleData
+	if err := json.Unmarshal(body, &vd); err != nil {
+		csvp := parser.NewCSVParser()
+		vd, err = csvp.DecodeTelemetry(strings.TrimSpace(string(body)))
+		if err != nil {
+			return vd, err
 		}
-		w.Header().Set("Content-Type", "application/json")
-		if _, werr := w.Write(v); werr != nil {
-			log.Printf("[app] warning: failed to write telemetry: %v", werr)
+	}
+	if vd.VehicleID == "" {
+		return vd, store.ErrNoVehicle
+	}
+	return vd, nil
+}
+
+// streamTelemetry publishes stored telemetry on /api/stream as JSON.
+func (a *App) streamTelemetry(vd model.VehicleData) {
+	data, err := json.Marshal(vd)
+	if err != nil {
+		log.Printf("[app] warning: encode streamed telemetry: %v", err)
+		return
+	}
+	a.Stream.Publish(stream.Item{Event: model.EnvelopeTelemetry, Vehicle: vd.VehicleID, Data: data})
+}
+
+// handleLatest returns the latest telemetry of ?vehicle=, or the most recent
+// sample across all vehicles.
+func (a *App) handleLatest(w http.ResponseWriter, r *http.Request) {
+	var (
+		p   store.Point
+		ok  bool
+		err error
+	)
+	if id := r.URL.Query().Get("vehicle"); id != "" {
+		p, ok, err = a.Store.Latest(id)
+	} else {
+		var all []store.Point
+		all, err = a.Store.LatestAll()
+		for _, q := range all {
+			if !ok || q.Time.After(p.Time) {
+				p, ok = q, true
+			}
 		}
-		log.Printf("[app] latest telemetry @ %s", string(k))
-		return nil
-	})
+	}
 	if err != nil {
+		log.Printf("[app] read latest telemetry: %v", err)
 		http.Error(w, "failed to read telemetry", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "no data available", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(p.Data); err != nil {
+		log.Printf("[app] warning: failed to write telemetry: %v", err)
 	}
 }
 
@@ -82,6 +121,7 @@ func (a *App) handleControl(w http.ResponseWriter, r *http.Request) {
 	// Forwards to FogServer (assuming it runs at :10000)
 	resp, err := http.Post("http://localhost:10000/api/control", "application/json", bytes.NewReader(body))
 	if err != nil {
+		a.audit(r, "control", body, http.StatusBadGateway)
 		http.Error(w, "failed to forward control", http.StatusBadGateway)
 		return
 	}
@@ -95,5 +135,22 @@ func (a *App) handleControl(w http.ResponseWriter, r *http.Request) {
 		log.Printf("[app] warning: failed to drain fog response body: %v", err)
 	}
 
+	a.audit(r, "control", body, resp.StatusCode)
 	w.WriteHeader(http.StatusAccepted)
 }
+
+// audit records an operator action with its JSON body and outcome.
+func (a *App) audit(r *http.Request, action string, body []byte, status int) {
+	rec := store.AuditRecord{Time: time.Now(), Action: action, Actor: r.RemoteAddr, Status: status}
+	if user, ok := a.sessionUser(r); ok {
+		rec.Actor = user
+	}
+	var ctl model.ControlData
+	if json.Unmarshal(body, &ctl) == nil {
+		rec.VehicleID = ctl.VehicleID
+		rec.Detail = body
+	}
+	if err := a.Store.AppendAudit(rec); err != nil {
+		log.Printf("[app] warning: audit %s: %v", action, err)
+	}
+}
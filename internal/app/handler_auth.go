@@ -19,12 +19,19 @@ func (a *App) handleLogin(w http.ResponseWriter, r *http.Request) {
 
 		// Simple static check (can extend to DB user check)
 		if username == "admin" && password == "1234" {
+			id, err := a.sessions.create(username)
+			if err != nil {
+				log.Printf("[auth] create session: %v", err)
+				http.Error(w, "login failed", http.StatusInternalServerError)
+				return
+			}
 			http.SetCookie(w, &http.Cookie{
 				Name:     "session_id",
-				Value:    "admin",
+				Value:    id,
 				Path:     "/",
-				Expires:  time.Now().Add(24 * time.Hour),
+				Expires:  time.Now().Add(sessionTTL),
 				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
 			})
 			http.Redirect(w, r, "/", http.StatusSeeOther)
 			return
@@ -36,8 +43,11 @@ func (a *App) handleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// handleLogout clears session cookie.
+// handleLogout ends the session and clears its cookie.
 func (a *App) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie("session_id"); err == nil {
+		a.sessions.remove(c.Value)
+	}
 	http.SetCookie(w, &http.Cookie{
 		Name:     "session_id",
 		Value:    "",
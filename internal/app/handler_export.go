@@ -0,0 +1,72 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"LoraFog/internal/export"
+	"LoraFog/internal/store"
+)
+
+// handleVehicleExport downloads a vehicle's track as ?format=gpx|kml|geojson|csv
+// (default gpx). from, to and bbox work as for telemetry. The track is
+// streamed from the store, so exports are not bounded by memory.
+func (a *App) handleVehicleExport(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = export.GPX
+	}
+	if !slices.Contains(export.Formats, format) {
+		http.Error(w, "format must be one of "+strings.Join(export.Formats, ", "), http.StatusBadRequest)
+		return
+	}
+	q, err := parseHistoryQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	src := func(fn func(store.Point) bool) error {
+		return a.Store.Range(id, q.from, q.to, q.box, fn)
+	}
+	// the first sample names the file; errors surface before the body starts
+	var first *store.Point
+	err = src(func(p store.Point) bool {
+		first = &p
+		return false
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "no telemetry for vehicle", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[app] read track for %s: %v", id, err)
+		http.Error(w, "failed to read telemetry", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, exportName(id, first), format))
+	if _, err := export.Write(w, format, id, src); err != nil {
+		log.Printf("[app] warning: export %s as %s: %v", id, format, err)
+	}
+}
+
+// exportName names an export after the vehicle and the track's start time.
+func exportName(id string, first *store.Point) string {
+	name := strings.Map(func(r rune) rune {
+		if r == '"' || r == '/' || r == '\\' || r < ' ' {
+			return '_'
+		}
+		return r
+	}, id)
+	if first != nil {
+		name += "-" + first.Time.UTC().Format("20060102T150405Z")
+	}
+	return name
+}
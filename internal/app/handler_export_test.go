@@ -0,0 +1,67 @@
+package app
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"LoraFog/internal/model"
+	"LoraFog/internal/store"
+)
+
+func TestExportStreamsBBoxAcrossChunks(t *testing.T) {
+	a := newTestApp(t)
+	at := time.Date(2025, 6, 1, 8, 0, 0, 0, time.UTC)
+	// long runs outside the box make Range yield chunks without a match
+	var points []store.Point
+	for i := range 25000 {
+		lon := 106.0
+		if i/7000%2 == 1 {
+			lon = 107.0
+		}
+		points = append(points, store.Point{
+			Time: at.Add(time.Duration(i) * time.Second),
+			Data: model.VehicleData{VehicleID: "VH01", Latitude: 10, Longitude: lon},
+		})
+	}
+	if err := a.Store.Write(points...); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		query string
+		want  int
+	}{
+		{"format=csv", 25000},
+		{"format=csv&bbox=106.5,9,107.5,11", 11000},
+		{"format=csv&bbox=106.5,9,107.5,11&to=2025-06-01T10:00:00Z", 201},
+		{"format=csv&bbox=0,0,1,1", 0},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/api/vehicles/VH01/export?"+tc.query, nil)
+		req.SetPathValue("id", "VH01")
+		rec := httptest.NewRecorder()
+		a.handleVehicleExport(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status %d: %s", tc.query, rec.Code, rec.Body)
+			continue
+		}
+		rows, err := csv.NewReader(rec.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("%s: %v", tc.query, err)
+		}
+		if got := len(rows) - 1; got != tc.want {
+			t.Errorf("%s: %d rows, want %d", tc.query, got, tc.want)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/vehicles/VH09/export", nil)
+	req.SetPathValue("id", "VH09")
+	rec := httptest.NewRecorder()
+	a.handleVehicleExport(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown vehicle: status %d", rec.Code)
+	}
+}
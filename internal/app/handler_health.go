@@ -0,0 +1,45 @@
+package app
+
+import (
+	"time"
+
+	"LoraFog/internal/health"
+
+	"go.etcd.io/bbolt"
+)
+
+// liveChecks reports that the app process is serving.
+func (a *App) liveChecks() []health.Check {
+	checks := []health.Check{
+		health.Info("http", "serving"),
+		health.Info("sse_clients", "%d", a.Stream.Clients()),
+	}
+	if a.compactor != nil {
+		checks = append(checks, a.compactor.check())
+	}
+	return checks
+}
+
+// readyChecks verifies that BoltDB accepts writes.
+func (a *App) readyChecks() []health.Check {
+	return append(a.liveChecks(), a.dbCheck())
+}
+
+// dbCheck writes a probe key into the health bucket to prove the DB is writable.
+func (a *App) dbCheck() health.Check {
+	if a.DB == nil {
+		return health.Check{Name: "boltdb", Status: health.StatusFail, Detail: "not open"}
+	}
+	start := time.Now()
+	err := a.DB.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("health"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("probe"), []byte(start.Format(time.RFC3339Nano)))
+	})
+	if err != nil {
+		return health.Check{Name: "boltdb", Status: health.StatusFail, Detail: err.Error()}
+	}
+	return health.Info("boltdb", "writable (%s)", time.Since(start).Truncate(time.Microsecond))
+}
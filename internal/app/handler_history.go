@@ -0,0 +1,255 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"LoraFog/internal/export"
+	"LoraFog/internal/model"
+	"LoraFog/internal/store"
+)
+
+const (
+	// defaultHistoryLimit is the page size when ?limit= is absent.
+	defaultHistoryLimit = 1000
+	// maxHistoryLimit caps ?limit=.
+	maxHistoryLimit = 10000
+)
+
+// telemetryFields lists the selectable fields of a telemetry sample, by JSON name.
+var telemetryFields = map[string]func(model.VehicleData) any{
+	"latitude":     func(vd model.VehicleData) any { return vd.Latitude },
+	"longitude":    func(vd model.VehicleData) any { return vd.Longitude },
+	"current_head": func(vd model.VehicleData) any { return vd.CurrentHead },
+	"target_head":  func(vd model.VehicleData) any { return vd.TargetHead },
+	"left_speed":   func(vd model.VehicleData) any { return vd.LeftSpeed },
+	"right_speed":  func(vd model.VehicleData) any { return vd.RightSpeed },
+	"pid":          func(vd model.VehicleData) any { return vd.PID },
+	"fcnt":         func(vd model.VehicleData) any { return vd.FCnt },
+}
+
+// historyQuery is a parsed telemetry history request.
+type historyQuery struct {
+	from, to time.Time
+	limit    int
+	box      *store.BBox // nil for no position filter
+	fields   []string    // nil selects every field
+}
+
+// historyPage is one page of GET /api/vehicles/{id}/telemetry.
+type historyPage struct {
+	VehicleID  string           `json:"vehicle_id"`
+	Count      int              `json:"count"`
+	Points     []map[string]any `json:"points"`
+	NextCursor string           `json:"next_cursor,omitempty"` // pass as ?cursor= for the next page
+}
+
+// handleVehicleTelemetry returns a vehicle's stored telemetry in time order:
+// ?from= and ?to= (RFC 3339 or unix seconds) bound the range,
+// ?bbox=minLon,minLat,maxLon,maxLat filters by position, ?fields= selects
+// fields, and ?limit= with ?cursor= pages through the result.
+func (a *App) handleVehicleTelemetry(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	q, err := parseHistoryQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page := historyPage{VehicleID: id, Points: []map[string]any{}}
+	var last time.Time
+	err = a.Store.Range(id, q.from, q.to, q.box, func(p store.Point) bool {
+		if len(page.Points) == q.limit {
+			page.NextCursor = encodeCursor(last)
+			return false
+		}
+		page.Points = append(page.Points, selectFields(p, q.fields))
+		last = p.Time
+		return true
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "no telemetry for vehicle", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[app] read telemetry history for %s: %v", id, err)
+		http.Error(w, "failed to read telemetry", http.StatusInternalServerError)
+		return
+	}
+	page.Count = len(page.Points)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		log.Printf("[app] warning: encode telemetry history: %v", err)
+	}
+}
+
+// parseHistoryQuery validates the query parameters of a history request.
+func parseHistoryQuery(r *http.Request) (historyQuery, error) {
+	v := r.URL.Query()
+	q := historyQuery{limit: defaultHistoryLimit}
+	var err error
+	if q.from, err = export.ParseTime(v.Get("from")); err != nil {
+		return q, fmt.Errorf("invalid from: %w", err)
+	}
+	if q.to, err = export.ParseTime(v.Get("to")); err != nil {
+		return q, fmt.Errorf("invalid to: %w", err)
+	}
+	if c := v.Get("cursor"); c != "" {
+		after, err := decodeCursor(c)
+		if err != nil {
+			return q, errors.New("invalid cursor")
+		}
+		if next := after.Add(time.Nanosecond); next.After(q.from) {
+			q.from = next
+		}
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return q, errors.New("invalid limit")
+		}
+		q.limit = min(n, maxHistoryLimit)
+	}
+	if s := v.Get("bbox"); s != "" {
+		parts := strings.Split(s, ",")
+		if len(parts) != 4 {
+			return q, errors.New("bbox must be minLon,minLat,maxLon,maxLat")
+		}
+		var f [4]float64
+		for i, p := range parts {
+			if f[i], err = strconv.ParseFloat(strings.TrimSpace(p), 64); err != nil {
+				return q, errors.New("bbox must be minLon,minLat,maxLon,maxLat")
+			}
+		}
+		q.box = &store.BBox{MinLon: f[0], MinLat: f[1], MaxLon: f[2], MaxLat: f[3]}
+	}
+	if s := v.Get("fields"); s != "" {
+		for _, name := range strings.Split(s, ",") {
+			name = strings.TrimSpace(name)
+			if _, ok := telemetryFields[name]; !ok {
+				return q, fmt.Errorf("unknown field %q", name)
+			}
+			q.fields = append(q.fields, name)
+		}
+	}
+	return q, nil
+}
+
+// selectFields renders p with its time and the selected fields.
+func selectFields(p store.Point, fields []string) map[string]any {
+	out := map[string]any{"time": p.Time}
+	if fields == nil {
+		for name, get := range telemetryFields {
+			out[name] = get(p.Data)
+		}
+		return out
+	}
+	for _, name := range fields {
+		out[name] = telemetryFields[name](p.Data)
+	}
+	return out
+}
+
+// encodeCursor makes an opaque page cursor from the last returned sample time.
+func encodeCursor(t time.Time) string {
+	b := binary.BigEndian.AppendUint64(nil, uint64(t.UnixNano()))
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor reverses encodeCursor.
+func decodeCursor(s string) (time.Time, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) != 8 {
+		return time.Time{}, errors.New("invalid cursor")
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b))), nil
+}
+
+// rollupPage is one page of GET /api/vehicles/{id}/rollups.
+type rollupPage struct {
+	VehicleID  string         `json:"vehicle_id"`
+	Count      int            `json:"count"`
+	Rollups    []store.Rollup `json:"rollups"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// handleVehicleRollups returns a vehicle's 1-minute min/max/avg/last
+// rollups; from, to, limit and cursor work as for telemetry.
+func (a *App) handleVehicleRollups(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	q, err := parseHistoryQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page := rollupPage{VehicleID: id, Rollups: []store.Rollup{}}
+	err = a.Store.Rollups(id, q.from, q.to, func(ru store.Rollup) bool {
+		if len(page.Rollups) == q.limit {
+			page.NextCursor = encodeCursor(page.Rollups[len(page.Rollups)-1].Start)
+			return false
+		}
+		page.Rollups = append(page.Rollups, ru)
+		return true
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "no telemetry for vehicle", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[app] read rollups for %s: %v", id, err)
+		http.Error(w, "failed to read rollups", http.StatusInternalServerError)
+		return
+	}
+	page.Count = len(page.Rollups)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		log.Printf("[app] warning: encode rollups: %v", err)
+	}
+}
+
+// auditPage is one page of GET /api/audit.
+type auditPage struct {
+	Count      int                 `json:"count"`
+	Records    []store.AuditRecord `json:"records"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
+// handleAudit returns audit records in time order; from, to, limit and
+// cursor work as for telemetry.
+func (a *App) handleAudit(w http.ResponseWriter, r *http.Request) {
+	q, err := parseHistoryQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page := auditPage{Records: []store.AuditRecord{}}
+	var last time.Time // storage key of the last record, see Store.Audit
+	err = a.Store.Audit(q.from, q.to, func(key time.Time, rec store.AuditRecord) bool {
+		if len(page.Records) == q.limit {
+			page.NextCursor = encodeCursor(last)
+			return false
+		}
+		page.Records = append(page.Records, rec)
+		last = key
+		return true
+	})
+	if err != nil {
+		log.Printf("[app] read audit: %v", err)
+		http.Error(w, "failed to read audit", http.StatusInternalServerError)
+		return
+	}
+	page.Count = len(page.Records)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		log.Printf("[app] warning: encode audit: %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"LoraFog/internal/store"
+
+	"go.etcd.io/bbolt"
+)
+
+// newTestApp returns an app with a fresh database and telemetry store.
+func newTestApp(t *testing.T) *App {
+	t.Helper()
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "data.db"), 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Logf("close db: %v", err)
+		}
+	})
+	st, err := store.Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &App{DB: db, Store: st}
+}
+
+func TestAuditPagesOverCollidingTimes(t *testing.T) {
+	a := newTestApp(t)
+	at := time.Date(2025, 6, 1, 8, 0, 0, 0, time.UTC)
+	actions := []string{"a", "b", "c", "d"}
+	for _, action := range actions {
+		if err := a.Store.AppendAudit(store.AuditRecord{Time: at, Action: action}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	cursor := ""
+	for page := 0; page < len(actions)+1; page++ {
+		rec := httptest.NewRecorder()
+		a.handleAudit(rec, httptest.NewRequest(http.MethodGet, "/api/audit?limit=1&cursor="+cursor, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("page %d: status %d", page, rec.Code)
+		}
+		var p auditPage
+		if err := json.NewDecoder(rec.Body).Decode(&p); err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range p.Records {
+			got = append(got, r.Action)
+		}
+		if p.NextCursor == "" {
+			break
+		}
+		cursor = p.NextCursor
+	}
+	if len(got) != len(actions) {
+		t.Fatalf("paged %v, want %v", got, actions)
+	}
+	for i := range actions {
+		if got[i] != actions[i] {
+			t.Fatalf("paged %v, want %v", got, actions)
+		}
+	}
+}
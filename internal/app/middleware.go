@@ -4,11 +4,10 @@ import (
 	"net/http"
 )
 
-// AuthMiddleware restricts access to logged-in users only.
-func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+// AuthMiddleware restricts access to users with a valid session.
+func (a *App) AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("session_id")
-		if err != nil || cookie.Value == "" {
+		if _, ok := a.sessionUser(r); !ok {
 			http.Redirect(w, r, "/login", http.StatusSeeOther)
 			return
 		}
@@ -0,0 +1,101 @@
+package app
+
+import (
+	"bytes"
+	"log"
+	"time"
+
+	"LoraFog/internal/store"
+
+	"go.etcd.io/bbolt"
+)
+
+// legacyTelemetryBucket held raw request bodies keyed by RFC3339Nano receive
+// time before telemetry moved to per-vehicle series.
+var legacyTelemetryBucket = []byte("telemetry")
+
+// unmigratedTelemetryBucket keeps legacy records the migration could not
+// decode, under their original keys, for inspection or manual recovery.
+var unmigratedTelemetryBucket = []byte("telemetry_legacy_unmigrated")
+
+// migrateChunk is the number of legacy records moved per transaction, so the
+// migration never holds the write lock for long.
+const migrateChunk = 1000
+
+// migrateLegacyTelemetry moves records from the legacy bucket into the store
+// and drops the bucket. Undecodable records are moved as they are to
+// unmigratedTelemetryBucket rather than lost. Each chunk is written and
+// removed from the legacy bucket in one transaction, so a migration
+// interrupted at any point resumes without storing a record twice.
+func (a *App) migrateLegacyTelemetry() error {
+	moved, skipped := 0, 0
+	for {
+		var n, bad int
+		var done bool
+		err := a.DB.Update(func(tx *bbolt.Tx) error {
+			var err error
+			n, bad, done, err = a.migrateLegacyChunk(tx)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		moved += n
+		skipped += bad
+		if done {
+			break
+		}
+	}
+	if moved == 0 && skipped == 0 {
+		return nil
+	}
+	log.Printf("[app] migrated %d legacy telemetry records", moved)
+	if skipped > 0 {
+		log.Printf("[app] warning: %d undecodable legacy records kept in bucket %s", skipped, unmigratedTelemetryBucket)
+	}
+	return nil
+}
+
+// migrateLegacyChunk moves up to migrateChunk legacy records within tx and
+// reports how many were stored and set aside. done is true once the legacy
+// bucket is gone.
+func (a *App) migrateLegacyChunk(tx *bbolt.Tx) (moved, skipped int, done bool, err error) {
+	b := tx.Bucket(legacyTelemetryBucket)
+	if b == nil {
+		return 0, 0, true, nil
+	}
+	var points []store.Point
+	var keys [][]byte
+	var rejects *bbolt.Bucket
+	c := b.Cursor()
+	for k, v := c.First(); k != nil && len(keys) < migrateChunk; k, v = c.Next() {
+		keys = append(keys, bytes.Clone(k))
+		t, terr := time.Parse(time.RFC3339Nano, string(k))
+		vd, derr := decodeTelemetry(v)
+		if terr == nil && derr == nil {
+			points = append(points, store.Point{Time: t, Data: vd})
+			continue
+		}
+		if rejects == nil {
+			if rejects, err = tx.CreateBucketIfNotExists(unmigratedTelemetryBucket); err != nil {
+				return 0, 0, false, err
+			}
+		}
+		if err := rejects.Put(bytes.Clone(k), bytes.Clone(v)); err != nil {
+			return 0, 0, false, err
+		}
+		skipped++
+	}
+	if len(keys) == 0 {
+		return 0, 0, true, tx.DeleteBucket(legacyTelemetryBucket)
+	}
+	if err := a.Store.WriteTx(tx, points...); err != nil {
+		return 0, 0, false, err
+	}
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return 0, 0, false, err
+		}
+	}
+	return len(points), skipped, false, nil
+}
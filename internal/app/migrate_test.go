@@ -0,0 +1,95 @@
+package app
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"LoraFog/internal/store"
+
+	"go.etcd.io/bbolt"
+)
+
+// newMigrationApp opens an app database holding n legacy records of VH01,
+// one second apart, plus two records the migration cannot decode.
+func newMigrationApp(t *testing.T, n int) *App {
+	t.Helper()
+	a := newTestApp(t)
+	base := time.Date(2025, 6, 1, 8, 0, 0, 0, time.UTC)
+	err := a.DB.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucket(legacyTelemetryBucket)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			k := base.Add(time.Duration(i) * time.Second).Format(time.RFC3339Nano)
+			if err := b.Put([]byte(k), []byte(fmt.Sprintf("VH01,10.%06d,106.5,0,0,0,0,0", i))); err != nil {
+				return err
+			}
+		}
+		if err := b.Put([]byte("not-a-time"), []byte("VH01,1,2,0,0,0,0,0")); err != nil {
+			return err
+		}
+		return b.Put([]byte(base.Add(-time.Hour).Format(time.RFC3339Nano)), []byte("garbage"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// countSamples returns the number of stored samples of vehicle id.
+func countSamples(t *testing.T, a *App, id string) int {
+	t.Helper()
+	n := 0
+	if err := a.Store.Range(id, time.Time{}, time.Time{}, nil, func(store.Point) bool { n++; return true }); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestMigrateLegacyTelemetryResumes(t *testing.T) {
+	const records = 2*migrateChunk + 500
+	cases := []struct {
+		name   string
+		chunks int // chunks migrated before the simulated crash
+	}{
+		{"uninterrupted", 0},
+		{"after one chunk", 1},
+		{"after all chunks", 3},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a := newMigrationApp(t, records)
+			for i := 0; i < c.chunks; i++ {
+				if err := a.DB.Update(func(tx *bbolt.Tx) error {
+					_, _, _, err := a.migrateLegacyChunk(tx)
+					return err
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// restart twice: the second run must find nothing left to do
+			for run := 0; run < 2; run++ {
+				if err := a.migrateLegacyTelemetry(); err != nil {
+					t.Fatalf("run %d: %v", run, err)
+				}
+			}
+			if n := countSamples(t, a, "VH01"); n != records {
+				t.Errorf("stored %d samples, want %d", n, records)
+			}
+			err := a.DB.View(func(tx *bbolt.Tx) error {
+				if tx.Bucket(legacyTelemetryBucket) != nil {
+					t.Error("legacy bucket kept")
+				}
+				if b := tx.Bucket(unmigratedTelemetryBucket); b == nil || b.Stats().KeyN != 2 {
+					t.Error("undecodable records not kept")
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
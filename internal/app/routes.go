@@ -2,6 +2,10 @@ package app
 
 import (
 	"net/http"
+
+	"LoraFog/internal/health"
+	"LoraFog/internal/metrics"
+	"LoraFog/internal/stream"
 )
 
 // registerRoutes sets up all HTTP handlers for the application.
@@ -16,9 +20,20 @@ func (a *App) registerRoutes() {
 	a.Mux.HandleFunc("/vehicles", a.handleVehicles)
 	a.Mux.HandleFunc("/login", a.handleLogin)
 	a.Mux.HandleFunc("/logout", a.handleLogout)
+	a.Mux.HandleFunc("GET /api/session", a.handleSession)
 
 	// API routes
 	a.Mux.HandleFunc("/api/telemetry", a.handleTelemetry)
 	a.Mux.HandleFunc("/api/latest", a.handleLatest)
+	a.Mux.HandleFunc("GET /api/vehicles/{id}/telemetry", a.handleVehicleTelemetry)
+	a.Mux.HandleFunc("GET /api/vehicles/{id}/rollups", a.handleVehicleRollups)
+	a.Mux.HandleFunc("GET /api/vehicles/{id}/export", a.handleVehicleExport)
+	a.Mux.HandleFunc("GET /api/audit", a.handleAudit)
 	a.Mux.HandleFunc("/api/control", a.handleControl)
+	a.Mux.HandleFunc("/api/stream", stream.Handler("app", a.Stream))
+
+	// Health routes
+	a.Mux.HandleFunc("/healthz", health.Handler("app", a.liveChecks))
+	a.Mux.HandleFunc("/readyz", health.Handler("app", a.readyChecks))
+	a.Mux.HandleFunc("/metrics", metrics.Handler())
 }
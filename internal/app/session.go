@@ -0,0 +1,96 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sessionTTL is how long a login stays valid.
+const sessionTTL = 24 * time.Hour
+
+// session is one logged-in user.
+type session struct {
+	user    string
+	expires time.Time
+}
+
+// sessionStore holds the sessions issued by handleLogin, keyed by the random
+// ID sent as the session_id cookie. Sessions live in memory and end with the
+// process.
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]session
+}
+
+// newSessionStore returns an empty store.
+func newSessionStore() *sessionStore {
+	return &sessionStore{sessions: map[string]session{}}
+}
+
+// create starts a session for user and returns its ID.
+func (s *sessionStore) create(user string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, v := range s.sessions {
+		if now.After(v.expires) {
+			delete(s.sessions, k)
+		}
+	}
+	s.sessions[id] = session{user: user, expires: now.Add(sessionTTL)}
+	return id, nil
+}
+
+// lookup returns the user of session id if it exists and has not expired.
+func (s *sessionStore) lookup(id string) (string, bool) {
+	if id == "" {
+		return "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.sessions[id]
+	if !ok || time.Now().After(v.expires) {
+		return "", false
+	}
+	return v.user, true
+}
+
+// remove ends session id.
+func (s *sessionStore) remove(id string) {
+	s.mu.Lock()
+	delete(s.sessions, id)
+	s.mu.Unlock()
+}
+
+// sessionUser returns the user of the request's session_id cookie, if valid.
+func (a *App) sessionUser(r *http.Request) (string, bool) {
+	c, err := r.Cookie("session_id")
+	if err != nil {
+		return "", false
+	}
+	return a.sessions.lookup(c.Value)
+}
+
+// handleSession answers 200 with the user of the request's session, or 401.
+// The fog calls it to authorize websocket commands carrying the same cookie.
+func (a *App) handleSession(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.sessionUser(r)
+	if !ok {
+		http.Error(w, "unauthenticated", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"user": user}); err != nil {
+		log.Printf("[app] warning: encode session: %v", err)
+	}
+}
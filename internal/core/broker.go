@@ -0,0 +1,261 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+
+	"LoraFog/internal/model"
+)
+
+// Gateway transport topics on the embedded broker, per gateway ID:
+//
+//	lorafog/gw/{id}/up        gateway → fog, JSON GatewayUplink
+//	lorafog/gw/{id}/register  gateway → fog, JSON GatewayRegistration (heartbeat)
+//	lorafog/gw/{id}/ack       fog → gateway, JSON GatewayRegistrationAck
+//	lorafog/gw/{id}/down      fog → gateway, wire-format control line
+const (
+	gwTopicPrefix = "lorafog/gw/"
+	topicUp       = "up"
+	topicRegister = "register"
+	topicAck      = "ack"
+	topicDown     = "down"
+
+	// mqttRouteScheme marks registry URLs of gateways reached through the broker.
+	mqttRouteScheme = "mqtt://"
+)
+
+// gwTopic returns the transport topic leaf for gateway id.
+func gwTopic(id, leaf string) string {
+	return gwTopicPrefix + id + "/" + leaf
+}
+
+// fogBroker is the fog's embedded MQTT broker. Gateways keep one persistent
+// session to it for uplinks and heartbeats, and receive downlinks over the
+// same connection, which also works from behind NAT.
+type fogBroker struct {
+	cfg    model.BrokerConfig
+	fog    *FogServer
+	server *mochi.Server
+}
+
+// SetBroker enables the embedded broker; an empty address leaves it disabled.
+func (f *FogServer) SetBroker(c model.BrokerConfig) Component {
+	if c.Addr == "" {
+		return nil
+	}
+	b := &fogBroker{cfg: c, fog: f}
+	f.broker = b
+	return b
+}
+
+// Name implements Component.
+func (b *fogBroker) Name() string { return "broker" }
+
+// Dependencies implements Component; uplinks are handed to the fog.
+func (b *fogBroker) Dependencies() []string { return []string{b.fog.Name()} }
+
+// Run implements Component. It serves MQTT until ctx is cancelled.
+func (b *fogBroker) Run(ctx context.Context) error {
+	if b.cfg.Username != "" && b.cfg.Password == "" {
+		return fmt.Errorf("broker: account %q has no password", b.cfg.Username)
+	}
+	srv := mochi.New(&mochi.Options{
+		InlineClient: true,
+		Logger:       slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})),
+	})
+	if err := srv.AddHook(&brokerAuth{broker: b}, nil); err != nil {
+		return fmt.Errorf("broker auth: %w", err)
+	}
+	addr := strings.TrimPrefix(b.cfg.Addr, "tcp://")
+	if err := srv.AddListener(listeners.NewTCP(listeners.Config{ID: "tcp", Address: addr})); err != nil {
+		return fmt.Errorf("broker listener: %w", err)
+	}
+	if err := srv.Subscribe(gwTopicPrefix+"+/"+topicUp, 1, b.handleUplink); err != nil {
+		return err
+	}
+	if err := srv.Subscribe(gwTopicPrefix+"+/"+topicRegister, 2, b.handleRegister); err != nil {
+		return err
+	}
+	if err := srv.Serve(); err != nil {
+		return fmt.Errorf("broker serve: %w", err)
+	}
+	b.fog.mu.Lock()
+	b.server = srv
+	b.fog.mu.Unlock()
+	log.Printf("[broker] embedded MQTT broker listening at %s", addr)
+
+	<-ctx.Done()
+	b.fog.mu.Lock()
+	b.server = nil
+	b.fog.mu.Unlock()
+	if err := srv.Close(); err != nil {
+		log.Printf("[broker] close error: %v", err)
+	}
+	return nil
+}
+
+// Ready implements readier: the broker is up once it serves.
+func (b *fogBroker) Ready() bool {
+	b.fog.mu.Lock()
+	defer b.fog.mu.Unlock()
+	return b.server != nil
+}
+
+// brokerAuth admits gateways and the fog account. A gateway connects with
+// its ID as client ID and username and its server.gateway_tokens entry as
+// password, and may only use topics under lorafog/gw/{id}/. The account in
+// server.broker (for the MQTT bridge and other consumers) may use any topic.
+// Everyone else is refused.
+type brokerAuth struct {
+	mochi.HookBase
+	broker *fogBroker
+}
+
+// ID implements mochi.Hook.
+func (h *brokerAuth) ID() string { return "lorafog-auth" }
+
+// Provides implements mochi.Hook.
+func (h *brokerAuth) Provides(b byte) bool {
+	return bytes.Contains([]byte{mochi.OnConnectAuthenticate, mochi.OnACLCheck}, []byte{b})
+}
+
+// OnConnectAuthenticate checks the client's credentials.
+func (h *brokerAuth) OnConnectAuthenticate(cl *mochi.Client, pk packets.Packet) bool {
+	user, pass := string(cl.Properties.Username), string(pk.Connect.Password)
+	switch {
+	case h.fogAccount(user):
+		if subtle.ConstantTimeCompare([]byte(pass), []byte(h.broker.cfg.Password)) == 1 {
+			return true
+		}
+	case user != "" && user == cl.ID && h.broker.fog.gatewayAuthorized(user, pass):
+		return true
+	}
+	log.Printf("[broker] client %q (user %q) from %s refused: bad credentials", cl.ID, user, cl.Net.Remote)
+	return false
+}
+
+// OnACLCheck confines gateways to their own topics.
+func (h *brokerAuth) OnACLCheck(cl *mochi.Client, topic string, _ bool) bool {
+	user := string(cl.Properties.Username)
+	if h.fogAccount(user) || strings.HasPrefix(topic, gwTopicPrefix+user+"/") {
+		return true
+	}
+	log.Printf("[broker] client %q denied topic %s", cl.ID, topic)
+	return false
+}
+
+// fogAccount reports whether user is the configured fog account; an
+// account without a password is never enabled.
+func (h *brokerAuth) fogAccount(user string) bool {
+	c := h.broker.cfg
+	return c.Username != "" && c.Password != "" && user == c.Username
+}
+
+// gatewayFromTopic extracts {id} from lorafog/gw/{id}/{leaf}.
+func gatewayFromTopic(topic string) string {
+	rest := strings.TrimPrefix(topic, gwTopicPrefix)
+	if i := strings.Index(rest, "/"); i >= 0 {
+		return rest[:i]
+	}
+	return rest
+}
+
+// handleUplink feeds a gateway's telemetry into de-duplication like POST /api/telemetry.
+func (b *fogBroker) handleUplink(_ *mochi.Client, _ packets.Subscription, pk packets.Packet) {
+	gw := gatewayFromTopic(pk.TopicName)
+	var up model.GatewayUplink
+	if err := json.Unmarshal(pk.Payload, &up); err != nil {
+		log.Printf("[broker] invalid uplink from %s: %v", gw, err)
+		return
+	}
+	vd, err := decodeTelemetry([]byte(up.Data))
+	if err != nil {
+		log.Printf("[broker] invalid telemetry from %s: %v", gw, err)
+		return
+	}
+	if err := b.fog.ingest(vd, model.UplinkMeta{GatewayID: gw, RSSI: up.RSSI, SNR: up.SNR, ReceivedAt: time.Now()}); err != nil {
+		log.Printf("[broker] uplink from %s dropped: %v", gw, err)
+	}
+}
+
+// handleRegister records a gateway heartbeat and answers on its ack topic.
+// The route points at the broker so downlinks use the gateway's session.
+func (b *fogBroker) handleRegister(_ *mochi.Client, _ packets.Subscription, pk packets.Packet) {
+	gw := gatewayFromTopic(pk.TopicName)
+	var reg model.GatewayRegistration
+	if err := json.Unmarshal(pk.Payload, &reg); err != nil {
+		log.Printf("[broker] invalid registration from %s: %v", gw, err)
+		return
+	}
+	reg.GatewayID = gw
+	reg.URL = mqttRouteScheme + gw
+	ack, err := json.Marshal(b.fog.registerGateway(reg))
+	if err != nil {
+		log.Printf("[broker] encode ack for %s: %v", gw, err)
+		return
+	}
+	if err := b.publish(gwTopic(gw, topicAck), ack); err != nil {
+		log.Printf("[broker] ack to %s failed: %v", gw, err)
+	}
+}
+
+// publish sends a QoS 1 message from the fog's inline client.
+func (b *fogBroker) publish(topic string, payload []byte) error {
+	b.fog.mu.Lock()
+	srv := b.server
+	b.fog.mu.Unlock()
+	if srv == nil {
+		return fmt.Errorf("broker not running")
+	}
+	return srv.Publish(topic, payload, false, 1)
+}
+
+// sendDown publishes a control line to a gateway. It fails when the gateway
+// has no live session so the fog can fail over to another route.
+func (b *fogBroker) sendDown(gatewayID string, payload []byte) error {
+	if !b.connected(gatewayID) {
+		return fmt.Errorf("gateway %s not connected to broker", gatewayID)
+	}
+	return b.publish(gwTopic(gatewayID, topicDown), payload)
+}
+
+// connected reports whether a client with the gateway's ID has a live session.
+func (b *fogBroker) connected(clientID string) bool {
+	b.fog.mu.Lock()
+	srv := b.server
+	b.fog.mu.Unlock()
+	if srv == nil {
+		return false
+	}
+	cl, ok := srv.Clients.Get(clientID)
+	return ok && !cl.Closed()
+}
+
+// sessions returns the number of connected broker clients, excluding the fog's own.
+func (b *fogBroker) sessions() int {
+	b.fog.mu.Lock()
+	srv := b.server
+	b.fog.mu.Unlock()
+	if srv == nil {
+		return 0
+	}
+	n := 0
+	for id, cl := range srv.Clients.GetAll() {
+		if id != mochi.InlineClientId && !cl.Closed() {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,663 @@
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	"LoraFog/internal/health"
+	"LoraFog/internal/model"
+)
+
+const (
+	defaultSyncInterval   = 10 * time.Second
+	defaultSyncBatch      = 500
+	defaultSyncConfigPull = 60 * time.Second
+	defaultSyncMaxPending = 100000
+	// syncMaxBackoff caps the delay between uploads while the cloud is unreachable.
+	syncMaxBackoff = 5 * time.Minute
+	// syncTimeout bounds each request to the cloud.
+	syncTimeout = 30 * time.Second
+	// syncQueue is the number of records buffered before the outbox writer
+	// falls behind and records are dropped.
+	syncQueue = 1024
+)
+
+var (
+	syncStreams       = []string{model.SyncTelemetry, model.SyncEvents, model.SyncAudit}
+	bucketSyncCursors = []byte("cursors")
+	bucketSyncMeta    = []byte("meta")
+	keySyncVersion    = []byte("config_version")
+	keySyncETag       = []byte("config_etag")
+	keySyncFleet      = []byte("config_fleet")
+)
+
+// syncItem is one record waiting for the outbox writer.
+type syncItem struct {
+	stream string
+	data   []byte
+}
+
+// cloudSync uploads fog data to the cloud layer. Telemetry, events and audit
+// records are appended to a BoltDB outbox keyed by sequence number and
+// uploaded in compressed batches; a per-stream cursor records the last batch
+// the cloud acknowledged, so uploads resume where they stopped after an
+// outage or restart. Configuration and mission updates are pulled back on
+// their own period.
+type cloudSync struct {
+	cfg    model.SyncConfig
+	fog    *FogServer
+	client *http.Client
+	queue  chan syncItem
+	kick   chan struct{}
+
+	dropped atomic.Uint64
+
+	mu         sync.Mutex
+	db         *bbolt.DB
+	lastUpload time.Time
+	lastErr    string
+	version    string   // applied CloudUpdate version
+	fleet      []string // fleet of the applied version
+	etag       string
+}
+
+// SetSync enables cloud synchronisation; an empty URL leaves it disabled.
+func (f *FogServer) SetSync(c model.SyncConfig) Component {
+	if c.URL == "" {
+		return nil
+	}
+	c.URL = strings.TrimRight(c.URL, "/")
+	if c.FogID == "" {
+		host, err := os.Hostname()
+		if err != nil || host == "" {
+			host = "fog"
+		}
+		c.FogID = host
+	}
+	if c.Path == "" {
+		c.Path = filepath.Join("tmp", "sync.db")
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = defaultSyncBatch
+	}
+	if c.MaxPending <= 0 {
+		c.MaxPending = defaultSyncMaxPending
+	}
+	switch strings.ToLower(c.Compression) {
+	case "", "gzip":
+		c.Compression = "gzip"
+	case "none":
+	default:
+		log.Printf("[config] unknown sync compression %q, using gzip", c.Compression)
+		c.Compression = "gzip"
+	}
+	s := &cloudSync{
+		cfg:    c,
+		fog:    f,
+		client: &http.Client{Timeout: syncTimeout},
+		queue:  make(chan syncItem, syncQueue),
+		kick:   make(chan struct{}, 1),
+	}
+	f.sync = s
+	return s
+}
+
+// Name implements Component.
+func (s *cloudSync) Name() string { return "sync" }
+
+// Dependencies implements Component; cloud updates are applied to the fog.
+func (s *cloudSync) Dependencies() []string { return []string{s.fog.Name()} }
+
+// interval returns the upload period.
+func (s *cloudSync) interval() time.Duration {
+	if s.cfg.IntervalS > 0 {
+		return time.Duration(s.cfg.IntervalS) * time.Second
+	}
+	return defaultSyncInterval
+}
+
+// pullInterval returns the config pull period; 0 disables pulls.
+func (s *cloudSync) pullInterval() time.Duration {
+	switch {
+	case s.cfg.ConfigPullS < 0:
+		return 0
+	case s.cfg.ConfigPullS > 0:
+		return time.Duration(s.cfg.ConfigPullS) * time.Second
+	default:
+		return defaultSyncConfigPull
+	}
+}
+
+// Run implements Component. It opens the outbox, writes queued records and
+// uploads them until ctx is cancelled, backing off while the cloud fails.
+func (s *cloudSync) Run(ctx context.Context) error {
+	if err := os.MkdirAll(filepath.Dir(s.cfg.Path), 0o755); err != nil {
+		return fmt.Errorf("sync outbox dir: %w", err)
+	}
+	db, err := bbolt.Open(s.cfg.Path, 0o600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("sync outbox: %w", err)
+	}
+	var version, etag string
+	var fleet []string
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range append([][]byte{bucketSyncCursors, bucketSyncMeta}, streamBuckets()...) {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		meta := tx.Bucket(bucketSyncMeta)
+		version, etag = string(meta.Get(keySyncVersion)), string(meta.Get(keySyncETag))
+		if v := meta.Get(keySyncFleet); v != nil {
+			return json.Unmarshal(v, &fleet)
+		}
+		return nil
+	})
+	if err != nil {
+		if cerr := db.Close(); cerr != nil {
+			log.Printf("[sync] warning: close outbox: %v", cerr)
+		}
+		return fmt.Errorf("sync outbox init: %w", err)
+	}
+	s.mu.Lock()
+	s.db, s.version, s.fleet, s.etag = db, version, fleet, etag
+	s.mu.Unlock()
+	// the cloud answers 304 until its next version, so restore its fleet
+	s.fog.setCloudFleet(fleet)
+	defer func() {
+		s.mu.Lock()
+		s.db = nil
+		s.mu.Unlock()
+		if err := db.Close(); err != nil {
+			log.Printf("[sync] warning: close outbox: %v", err)
+		}
+	}()
+	log.Printf("[sync] uploading to %s as %s every %s (outbox %s)", s.cfg.URL, s.cfg.FogID, s.interval(), s.cfg.Path)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.writeLoop(ctx, db)
+	}()
+	defer wg.Wait()
+
+	backoff := s.interval()
+	upload := time.NewTimer(0)
+	defer upload.Stop()
+	var pullC <-chan time.Time
+	if every := s.pullInterval(); every > 0 {
+		pull := time.NewTicker(every)
+		defer pull.Stop()
+		pullC = pull.C
+		s.pullConfig(ctx)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-pullC:
+			s.pullConfig(ctx)
+		case <-s.kick:
+			upload.Reset(0)
+		case <-upload.C:
+			next := s.interval()
+			if err := s.upload(ctx, db); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				log.Printf("[sync] upload failed, retrying in %s: %v", backoff, err)
+				s.setResult(err)
+				next = backoff
+				backoff = min(2*backoff, syncMaxBackoff)
+			} else {
+				backoff = s.interval()
+			}
+			upload.Reset(next)
+		}
+	}
+}
+
+// streamBuckets returns the outbox bucket names.
+func streamBuckets() [][]byte {
+	out := make([][]byte, len(syncStreams))
+	for i, st := range syncStreams {
+		out[i] = []byte(st)
+	}
+	return out
+}
+
+// seqKey encodes a sequence number as a big-endian key so keys sort in order.
+func seqKey(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
+
+// record queues v for upload on stream. It never blocks the fog: when the
+// outbox writer falls behind the record is dropped and counted.
+func (s *cloudSync) record(stream string, v any) {
+	if s == nil {
+		return
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("[sync] encode %s record: %v", stream, err)
+		return
+	}
+	select {
+	case s.queue <- syncItem{stream: stream, data: b}:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// recordEvent queues a fog event: command outcomes and cloud updates are
+// audit records, everything else goes to the events stream.
+func (s *cloudSync) recordEvent(ev model.Event) {
+	switch ev.Type {
+	case model.EventCommandStatus, model.EventCloudUpdate:
+		s.record(model.SyncAudit, ev)
+	default:
+		s.record(model.SyncEvents, ev)
+	}
+}
+
+// writeLoop appends queued records to the outbox, one transaction per
+// burst, until ctx is cancelled; records still queued are written first.
+func (s *cloudSync) writeLoop(ctx context.Context, db *bbolt.DB) {
+	for {
+		var items []syncItem
+		select {
+		case it := <-s.queue:
+			items = append(items, it)
+		case <-ctx.Done():
+		}
+	drain:
+		for len(items) < syncQueue {
+			select {
+			case it := <-s.queue:
+				items = append(items, it)
+			default:
+				break drain
+			}
+		}
+		if len(items) > 0 {
+			if err := s.append(db, items); err != nil {
+				log.Printf("[sync] outbox write failed, %d records lost: %v", len(items), err)
+				s.dropped.Add(uint64(len(items)))
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// append stores items under the next sequence numbers of their streams.
+func (s *cloudSync) append(db *bbolt.DB, items []syncItem) error {
+	return db.Batch(func(tx *bbolt.Tx) error {
+		for _, it := range items {
+			b := tx.Bucket([]byte(it.stream))
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			if err := b.Put(seqKey(seq), it.data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// upload sends every pending record, stream by stream, in batches of
+// BatchSize. It stops at the first failure; the unsent records stay in the
+// outbox for the next attempt.
+func (s *cloudSync) upload(ctx context.Context, db *bbolt.DB) error {
+	for _, stream := range syncStreams {
+		if err := s.trim(db, stream); err != nil {
+			return fmt.Errorf("%s: trim: %w", stream, err)
+		}
+		for {
+			batch, err := s.next(db, stream)
+			if err != nil {
+				return fmt.Errorf("%s: read: %w", stream, err)
+			}
+			if len(batch.Records) == 0 {
+				break
+			}
+			if err := s.post(ctx, batch); err != nil {
+				return fmt.Errorf("%s: %w", stream, err)
+			}
+			if err := s.ack(db, stream, batch.LastSeq); err != nil {
+				return fmt.Errorf("%s: checkpoint: %w", stream, err)
+			}
+			s.setResult(nil)
+			log.Printf("[sync] uploaded %s %d-%d", stream, batch.FirstSeq, batch.LastSeq)
+			if len(batch.Records) < s.cfg.BatchSize {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// trim drops the oldest records of stream beyond MaxPending. Records are
+// only ever removed from the front, so sequence numbers stay contiguous.
+func (s *cloudSync) trim(db *bbolt.DB, stream string) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte(stream)).Cursor()
+		first, _ := c.First()
+		last, _ := c.Last()
+		if first == nil {
+			return nil
+		}
+		n := binary.BigEndian.Uint64(last) - binary.BigEndian.Uint64(first) + 1
+		if n <= uint64(s.cfg.MaxPending) {
+			return nil
+		}
+		excess := n - uint64(s.cfg.MaxPending)
+		for k, _ := c.First(); k != nil && excess > 0; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			excess--
+			s.dropped.Add(1)
+		}
+		log.Printf("[sync] %s outbox over %d records, dropped the oldest", stream, s.cfg.MaxPending)
+		return nil
+	})
+}
+
+// next reads up to BatchSize records of stream after its cursor.
+func (s *cloudSync) next(db *bbolt.DB, stream string) (model.SyncBatch, error) {
+	batch := model.SyncBatch{FogID: s.cfg.FogID, Stream: stream}
+	err := db.View(func(tx *bbolt.Tx) error {
+		var cursor uint64
+		if v := tx.Bucket(bucketSyncCursors).Get([]byte(stream)); len(v) == 8 {
+			cursor = binary.BigEndian.Uint64(v)
+		}
+		c := tx.Bucket([]byte(stream)).Cursor()
+		for k, v := c.Seek(seqKey(cursor + 1)); k != nil && len(batch.Records) < s.cfg.BatchSize; k, v = c.Next() {
+			data := make(json.RawMessage, len(v))
+			copy(data, v)
+			batch.Records = append(batch.Records, model.SyncRecord{Seq: binary.BigEndian.Uint64(k), Data: data})
+		}
+		return nil
+	})
+	if n := len(batch.Records); n > 0 {
+		batch.FirstSeq, batch.LastSeq = batch.Records[0].Seq, batch.Records[n-1].Seq
+	}
+	return batch, err
+}
+
+// ack checkpoints stream at last and deletes the acknowledged records.
+func (s *cloudSync) ack(db *bbolt.DB, stream string, last uint64) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte(stream)).Cursor()
+		for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k) <= last; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(bucketSyncCursors).Put([]byte(stream), seqKey(last))
+	})
+}
+
+// post uploads one batch to {url}/ingest/{stream}. The Idempotency-Key lets
+// the cloud recognise a batch resent after a lost answer.
+func (s *cloudSync) post(ctx context.Context, batch model.SyncBatch) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	if s.cfg.Compression == "gzip" {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.cfg.URL+"/ingest/"+batch.Stream, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.cfg.Compression == "gzip" {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	req.Header.Set("Idempotency-Key", fmt.Sprintf("%s/%s/%d-%d", batch.FogID, batch.Stream, batch.FirstSeq, batch.LastSeq))
+	s.authorize(req)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer s.closeBody(resp)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("cloud answered %s", resp.Status)
+	}
+	return nil
+}
+
+// pullConfig fetches {url}/config and applies a new version. Failures are
+// logged and retried on the next period.
+func (s *cloudSync) pullConfig(ctx context.Context) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.cfg.URL+"/config?fog_id="+url.QueryEscape(s.cfg.FogID), nil)
+	if err != nil {
+		log.Printf("[sync] config pull: %v", err)
+		return
+	}
+	s.mu.Lock()
+	etag := s.etag
+	s.mu.Unlock()
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	s.authorize(req)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("[sync] config pull: %v", err)
+		}
+		return
+	}
+	defer s.closeBody(resp)
+	switch {
+	case resp.StatusCode == http.StatusNotModified || resp.StatusCode == http.StatusNoContent:
+		return
+	case resp.StatusCode/100 != 2:
+		log.Printf("[sync] config pull: cloud answered %s", resp.Status)
+		return
+	}
+	var up model.CloudUpdate
+	if err := json.NewDecoder(resp.Body).Decode(&up); err != nil {
+		log.Printf("[sync] config pull: decode: %v", err)
+		return
+	}
+	s.apply(up, resp.Header.Get("ETag"))
+}
+
+// apply applies a cloud update once per version. Its fleet replaces the
+// previous version's. The version is checkpointed before any command is
+// submitted, so a fog restarted part-way through a mission does not re-issue
+// its commands; the cloud publishes a new version to retry them.
+func (s *cloudSync) apply(up model.CloudUpdate, etag string) {
+	s.mu.Lock()
+	seen := up.Version == "" || up.Version == s.version
+	s.etag = etag
+	if !seen {
+		s.version, s.fleet = up.Version, up.Fleet
+	}
+	s.mu.Unlock()
+	s.saveMeta()
+	if seen {
+		return
+	}
+
+	s.fog.setCloudFleet(up.Fleet)
+	applied := model.CloudUpdateApplied{Version: up.Version, Fleet: len(up.Fleet)}
+	for _, ctl := range up.Commands {
+		id, err := s.fog.submitControl(ctl, nil, nil)
+		if err != nil {
+			applied.Errors = append(applied.Errors, fmt.Sprintf("%s: %v", ctl.VehicleID, err))
+			continue
+		}
+		applied.Commands = append(applied.Commands, id)
+	}
+	log.Printf("[sync] applied cloud update %s: fleet %d, commands %d, rejected %d",
+		up.Version, len(up.Fleet), len(applied.Commands), len(applied.Errors))
+	s.fog.emit(model.Event{Type: model.EventCloudUpdate, Data: applied})
+}
+
+// saveMeta persists the applied version and fleet and the ETag of the last pull.
+func (s *cloudSync) saveMeta() {
+	s.mu.Lock()
+	db, version, etag := s.db, s.version, s.etag
+	fleet, err := json.Marshal(s.fleet)
+	s.mu.Unlock()
+	if err != nil || db == nil {
+		return
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		meta := tx.Bucket(bucketSyncMeta)
+		if err := meta.Put(keySyncVersion, []byte(version)); err != nil {
+			return err
+		}
+		if err := meta.Put(keySyncFleet, fleet); err != nil {
+			return err
+		}
+		return meta.Put(keySyncETag, []byte(etag))
+	})
+	if err != nil {
+		log.Printf("[sync] warning: save config version: %v", err)
+	}
+}
+
+// authorize adds the bearer token, if configured.
+func (s *cloudSync) authorize(req *http.Request) {
+	if s.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.cfg.Token)
+	}
+}
+
+// closeBody drains and closes a cloud response.
+func (s *cloudSync) closeBody(resp *http.Response) {
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		log.Printf("[sync] warning: discard body: %v", err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		log.Printf("[sync] warning: close body: %v", err)
+	}
+}
+
+// setResult records the outcome of an upload attempt.
+func (s *cloudSync) setResult(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.lastErr = err.Error()
+		return
+	}
+	s.lastErr = ""
+	s.lastUpload = time.Now()
+}
+
+// status reports the outbox and the last upload.
+func (s *cloudSync) status() model.SyncStatus {
+	s.mu.Lock()
+	st := model.SyncStatus{
+		URL:           s.cfg.URL,
+		FogID:         s.cfg.FogID,
+		Streams:       map[string]model.SyncStreamStatus{},
+		LastUpload:    s.lastUpload,
+		LastError:     s.lastErr,
+		ConfigVersion: s.version,
+		Dropped:       s.dropped.Load(),
+	}
+	db := s.db
+	s.mu.Unlock()
+	if db == nil {
+		return st
+	}
+	err := db.View(func(tx *bbolt.Tx) error {
+		for _, stream := range syncStreams {
+			var ss model.SyncStreamStatus
+			if v := tx.Bucket(bucketSyncCursors).Get([]byte(stream)); len(v) == 8 {
+				ss.Cursor = binary.BigEndian.Uint64(v)
+			}
+			c := tx.Bucket([]byte(stream)).Cursor()
+			if first, _ := c.First(); first != nil {
+				last, _ := c.Last()
+				ss.Pending = int(binary.BigEndian.Uint64(last) - binary.BigEndian.Uint64(first) + 1)
+			}
+			st.Streams[stream] = ss
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("[sync] warning: read outbox status: %v", err)
+	}
+	return st
+}
+
+// health reports the sync state as a "sync" check; a failing cloud only
+// warns, as records wait in the outbox.
+func (s *cloudSync) health() health.Check {
+	st := s.status()
+	pending := 0
+	for _, ss := range st.Streams {
+		pending += ss.Pending
+	}
+	c := health.Info("sync", "%s, %d pending, %d dropped", s.cfg.URL, pending, st.Dropped)
+	if st.LastError != "" {
+		c.Status = health.StatusWarn
+		c.Detail += ", last error: " + st.LastError
+	}
+	return c
+}
+
+// handleSync reports the sync status on GET and starts an upload on POST.
+func (f *FogServer) handleSync(w http.ResponseWriter, r *http.Request) {
+	if f.sync == nil {
+		http.Error(w, "cloud sync disabled", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		select {
+		case f.sync.kick <- struct{}{}:
+		default:
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(f.sync.status()); err != nil {
+		log.Printf("[fog] warning: encode sync status: %v", err)
+	}
+}
@@ -0,0 +1,224 @@
+package core
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"LoraFog/internal/model"
+)
+
+// cloudStandIn mirrors cmd/cloud_stub: it stores batches per stream, skipping
+// records it already has, and serves one CloudUpdate with an ETag.
+type cloudStandIn struct {
+	down atomic.Bool // answer 503 to uploads
+
+	mu      sync.Mutex
+	records map[string][]uint64 // stream -> stored sequence numbers
+	update  model.CloudUpdate
+}
+
+// newCloudStandIn serves a cloudStandIn.
+func newCloudStandIn(t *testing.T) (*cloudStandIn, *httptest.Server) {
+	t.Helper()
+	c := &cloudStandIn{records: map[string][]uint64{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ingest/", c.handleIngest)
+	mux.HandleFunc("/config", c.handleConfig)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return c, srv
+}
+
+// handleIngest stores a gzip JSON batch.
+func (c *cloudStandIn) handleIngest(w http.ResponseWriter, r *http.Request) {
+	if c.down.Load() {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, "invalid gzip body", http.StatusBadRequest)
+			return
+		}
+		body = zr
+	}
+	var b model.SyncBatch
+	if err := json.NewDecoder(body).Decode(&b); err != nil || b.Stream != strings.TrimPrefix(r.URL.Path, "/ingest/") {
+		http.Error(w, "invalid batch", http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rec := range b.Records {
+		if got := c.records[b.Stream]; len(got) > 0 && rec.Seq <= got[len(got)-1] {
+			continue
+		}
+		c.records[b.Stream] = append(c.records[b.Stream], rec.Seq)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleConfig serves the current update, or 304 for its ETag.
+func (c *cloudStandIn) handleConfig(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	up := c.update
+	c.mu.Unlock()
+	etag := `"` + up.Version + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	if err := json.NewEncoder(w).Encode(up); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// stored returns the sequence numbers stored for stream.
+func (c *cloudStandIn) stored(stream string) []uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]uint64(nil), c.records[stream]...)
+}
+
+// setUpdate replaces the served update.
+func (c *cloudStandIn) setUpdate(up model.CloudUpdate) {
+	c.mu.Lock()
+	c.update = up
+	c.mu.Unlock()
+}
+
+// runSync starts a fresh sync component on path, as after a fog restart,
+// and returns it with a function stopping it.
+func runSync(t *testing.T, f *FogServer, url, path string) (*cloudSync, func()) {
+	t.Helper()
+	s := f.SetSync(model.SyncConfig{URL: url, FogID: "fog-test", Path: path, BatchSize: 2, IntervalS: 3600, ConfigPullS: 3600}).(*cloudSync)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+	waitFor(t, "outbox open", func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.db != nil
+	})
+	return s, func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("sync run: %v", err)
+		}
+	}
+}
+
+// uploadNow kicks an upload like POST /api/sync.
+func uploadNow(s *cloudSync) {
+	select {
+	case s.kick <- struct{}{}:
+	default:
+	}
+}
+
+func TestCloudSyncResumesFromCursor(t *testing.T) {
+	cloud, srv := newCloudStandIn(t)
+	path := filepath.Join(t.TempDir(), "sync.db")
+	f := NewFogServer("127.0.0.1:0", "")
+
+	// records written while the cloud is down wait in the outbox
+	cloud.down.Store(true)
+	s, stop := runSync(t, f, srv.URL, path)
+	for i := 0; i < 5; i++ {
+		s.record(model.SyncTelemetry, model.VehicleData{VehicleID: "VH01", Latitude: float64(i)})
+	}
+	waitFor(t, "records in the outbox", func() bool { return s.status().Streams[model.SyncTelemetry].Pending == 5 })
+	uploadNow(s)
+	waitFor(t, "failed upload", func() bool { return s.status().LastError != "" })
+	if st := s.status().Streams[model.SyncTelemetry]; st.Cursor != 0 || st.Pending != 5 {
+		t.Fatalf("after outage: %+v", st)
+	}
+
+	// once the cloud is back everything is uploaded in order and checkpointed
+	cloud.down.Store(false)
+	uploadNow(s)
+	waitFor(t, "upload after outage", func() bool { return len(cloud.stored(model.SyncTelemetry)) == 5 })
+	waitFor(t, "checkpoint", func() bool { return s.status().Streams[model.SyncTelemetry].Cursor == 5 })
+	if st := s.status(); st.Streams[model.SyncTelemetry].Pending != 0 || st.LastError != "" {
+		t.Fatalf("after upload: %+v", st)
+	}
+	stop()
+
+	// a restarted fog continues after the checkpoint instead of resending
+	s, stop = runSync(t, f, srv.URL, path)
+	defer stop()
+	if c := s.status().Streams[model.SyncTelemetry].Cursor; c != 5 {
+		t.Fatalf("cursor after restart = %d", c)
+	}
+	s.record(model.SyncTelemetry, model.VehicleData{VehicleID: "VH01", Latitude: 5})
+	waitFor(t, "record in the outbox", func() bool { return s.status().Streams[model.SyncTelemetry].Pending == 1 })
+	uploadNow(s)
+	waitFor(t, "upload after restart", func() bool { return len(cloud.stored(model.SyncTelemetry)) == 6 })
+	want := []uint64{1, 2, 3, 4, 5, 6}
+	for i, seq := range cloud.stored(model.SyncTelemetry) {
+		if seq != want[i] {
+			t.Fatalf("cloud stored %v, want %v", cloud.stored(model.SyncTelemetry), want)
+		}
+	}
+}
+
+func TestCloudSyncAppliesUpdatesOnce(t *testing.T) {
+	cloud, srv := newCloudStandIn(t)
+	path := filepath.Join(t.TempDir(), "sync.db")
+
+	var commands atomic.Int32
+	gw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		commands.Add(1)
+	}))
+	defer gw.Close()
+	newFog := func() *FogServer {
+		f := NewFogServer("127.0.0.1:0", "")
+		f.RegisterGateway("GW01", gw.URL, []string{"VH01"})
+		f.SetFleet([]string{"VH02"})
+		return f
+	}
+
+	cloud.setUpdate(model.CloudUpdate{
+		Version:  "v1",
+		Fleet:    []string{"VH10", "VH11"},
+		Commands: []model.ControlData{{VehicleID: "VH01", Mode: 1, Latitude: 10.1, Longitude: 106.1}},
+	})
+	f := newFog()
+	s, stop := runSync(t, f, srv.URL, path)
+	waitFor(t, "mission command", func() bool { return commands.Load() == 1 })
+	if !f.reg.inFleet("VH11") || s.status().ConfigVersion != "v1" {
+		t.Fatalf("v1 not applied: fleet %v, version %q", f.reg.fleetList(), s.status().ConfigVersion)
+	}
+
+	// the next version replaces the cloud fleet; configured vehicles stay
+	cloud.setUpdate(model.CloudUpdate{Version: "v2", Fleet: []string{"VH11"}})
+	s.pullConfig(context.Background())
+	if f.reg.inFleet("VH10") || !f.reg.inFleet("VH11") || !f.reg.inFleet("VH02") {
+		t.Fatalf("fleet after v2: %v", f.reg.fleetList())
+	}
+	stop()
+
+	// a restarted fog restores the cloud fleet and re-issues nothing
+	f = newFog()
+	s, stop = runSync(t, f, srv.URL, path)
+	defer stop()
+	if !f.reg.inFleet("VH11") || f.reg.inFleet("VH10") || s.status().ConfigVersion != "v2" {
+		t.Fatalf("after restart: fleet %v, version %q", f.reg.fleetList(), s.status().ConfigVersion)
+	}
+	s.pullConfig(context.Background())
+	if n := commands.Load(); n != 1 {
+		t.Fatalf("gateway received %d commands, want 1", n)
+	}
+}
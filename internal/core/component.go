@@ -0,0 +1,85 @@
+package core
+
+import (
+	"context"
+	"time"
+
+	"LoraFog/internal/device"
+)
+
+// Component is a long-running unit managed by the System supervisor.
+// Run blocks until ctx is cancelled (clean stop, returns nil) or the component
+// fails (returns a non-nil error), at which point its restart policy applies.
+//
+// Optional fog subsystems (MQTT bridge, broker, packet-forwarder and gRPC
+// endpoints, cloud sync, sinks) are Components of their own: the
+// FogServer.Set* method enabling one returns it for the supervisor, or nil
+// when disabled.
+type Component interface {
+	// Name returns a unique identifier such as "fog" or "gateway/GW01".
+	Name() string
+
+	// Dependencies returns the names of components that must be started
+	// before this one and stopped after it.
+	Dependencies() []string
+
+	// Run executes the component until ctx is done or a fatal error occurs.
+	Run(ctx context.Context) error
+}
+
+// readier is implemented by components that take a moment to come up, such
+// as servers binding a port. The supervisor starts a component's dependents
+// only once Ready reports true; components without it count as up as soon
+// as their Run is called.
+type readier interface {
+	Ready() bool
+}
+
+// RestartPolicy decides whether a component is restarted after Run returns.
+type RestartPolicy string
+
+const (
+	// RestartNever leaves the component stopped whatever Run returned.
+	RestartNever RestartPolicy = "never"
+	// RestartOnFailure restarts the component only when Run returned an error.
+	RestartOnFailure RestartPolicy = "on-failure"
+	// RestartAlways restarts the component whenever Run returns.
+	RestartAlways RestartPolicy = "always"
+)
+
+// ComponentState is the lifecycle state of a supervised component.
+type ComponentState string
+
+const (
+	StatePending  ComponentState = "pending"
+	StateRunning  ComponentState = "running"
+	StateBackoff  ComponentState = "backoff"
+	StateStopping ComponentState = "stopping"
+	StateStopped  ComponentState = "stopped"
+	StateFailed   ComponentState = "failed"
+)
+
+// ComponentStatus is a snapshot of a supervised component's state.
+type ComponentStatus struct {
+	Name      string         `json:"name"`
+	State     ComponentState `json:"state"`
+	Restarts  int            `json:"restarts"`
+	LastError string         `json:"last_error,omitempty"`
+	Since     time.Time      `json:"since"`
+}
+
+// arduinoComponent adapts a simulated ArduinoDevice to the Component interface.
+type arduinoComponent struct {
+	dev *device.ArduinoDevice
+}
+
+// Name returns the supervisor name of the simulated Arduino.
+func (a arduinoComponent) Name() string { return "arduino/" + a.dev.ID }
+
+// Dependencies returns nil: simulators do not depend on other components.
+func (a arduinoComponent) Dependencies() []string { return nil }
+
+// Run drives the simulation until ctx is cancelled.
+func (a arduinoComponent) Run(ctx context.Context) error {
+	return a.dev.StartSimulation(ctx.Done())
+}
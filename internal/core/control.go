@@ -0,0 +1,175 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"LoraFog/internal/model"
+	"LoraFog/internal/parser"
+)
+
+const (
+	defaultCtlPerConnection = 60
+	defaultCtlPerVehicle    = 30
+	defaultCtlBurst         = 5
+)
+
+// SetControlRate configures control command rate limits; zero values keep defaults.
+func (f *FogServer) SetControlRate(c model.ControlRateConfig) {
+	if c.PerConnectionPerMin == 0 {
+		c.PerConnectionPerMin = defaultCtlPerConnection
+	}
+	if c.PerVehiclePerMin == 0 {
+		c.PerVehiclePerMin = defaultCtlPerVehicle
+	}
+	if c.Burst <= 0 {
+		c.Burst = defaultCtlBurst
+	}
+	f.ctlRate = c
+	f.vehicleLimiter = newRateLimiter(c.PerVehiclePerMin, c.Burst)
+}
+
+// controlError is a control submission failure with the HTTP status to report.
+type controlError struct {
+	status int
+	msg    string
+}
+
+func (e *controlError) Error() string { return e.msg }
+
+// validateControl applies the checks every control path shares.
+func validateControl(ctl model.ControlData) error {
+	switch {
+	case ctl.VehicleID == "":
+		return &controlError{http.StatusBadRequest, "vehicle_id required"}
+	case ctl.Latitude < -90 || ctl.Latitude > 90:
+		return &controlError{http.StatusBadRequest, "latitude out of range"}
+	case ctl.Longitude < -180 || ctl.Longitude > 180:
+		return &controlError{http.StatusBadRequest, "longitude out of range"}
+	}
+	return nil
+}
+
+// newCommandID returns a random identifier for a submitted command.
+func newCommandID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// submitControl validates a control message, picks the downlink gateways,
+// encodes it in the fog wire format and sends it asynchronously.
+// It returns the command ID whose status is reported as command_status events;
+// origin, if set, is the websocket client that issued it and accepted is
+// called with the ID before any status is published. The per-vehicle rate
+// limit is shared by websocket and POST /api/control commands, so the HTTP
+// API answers 429 once a vehicle's budget is spent.
+func (f *FogServer) submitControl(ctl model.ControlData, origin *wsClient, accepted func(id string)) (string, error) {
+	if err := validateControl(ctl); err != nil {
+		return "", err
+	}
+	if !f.vehicleLimiter.allow(ctl.VehicleID) {
+		return "", &controlError{http.StatusTooManyRequests, "too many commands for vehicle " + ctl.VehicleID}
+	}
+
+	// Rank gateways that recently heard the vehicle; static registry is the fallback
+	routes := f.reg.candidates(ctl.VehicleID)
+	if len(routes) == 0 {
+		log.Printf("[fog] control ignored: no gateway for vehicle %s", ctl.VehicleID)
+		return "", &controlError{http.StatusNotFound, "no gateway registered for vehicle"}
+	}
+
+	// Encode control message according to configured wire format
+	var payload []byte
+	var contentType string
+	switch f.wireFmt {
+	case "csv":
+		csvp := parser.NewCSVParser()
+		line, err := csvp.EncodeControl(ctl)
+		if err != nil {
+			log.Printf("[fog] control encode csv error: %v", err)
+			return "", &controlError{http.StatusInternalServerError, "failed to encode control message (csv)"}
+		}
+		payload = []byte(line)
+		contentType = "text/plain"
+
+	default: // json
+		b, err := json.Marshal(ctl)
+		if err != nil {
+			log.Printf("[fog] control encode json error: %v", err)
+			return "", &controlError{http.StatusInternalServerError, "failed to encode control message (json)"}
+		}
+		payload = b
+		contentType = "application/json"
+	}
+
+	id := newCommandID()
+	if accepted != nil {
+		accepted(id)
+	}
+	f.commandStatus(origin, id, ctl.VehicleID, model.CommandStatus{Status: "accepted"})
+
+	// Send asynchronously, failing over to the next gateway on error
+	go f.sendControl(origin, id, ctl, routes, contentType, payload)
+	return id, nil
+}
+
+// commandStatus records a command status event. The issuing websocket client
+// always receives it, even if its subscription would filter it out.
+func (f *FogServer) commandStatus(origin *wsClient, id, vehicleID string, st model.CommandStatus) {
+	st.ID = id
+	ev := f.events.append(model.Event{
+		Type:      model.EventCommandStatus,
+		VehicleID: vehicleID,
+		GatewayID: st.Gateway,
+		Data:      st,
+	})
+	f.sync.recordEvent(ev)
+	log.Printf("[fog] command %s for %s: %s", id, vehicleID, st.Status)
+	env := eventEnvelope(ev)
+	f.publishEnvelopeExcept(env, "", origin)
+	if origin != nil {
+		f.sendTo(origin, env)
+	}
+}
+
+// handleWSControl processes a "control" message from an authenticated websocket client.
+func (f *FogServer) handleWSControl(c *wsClient, m model.WSClientMessage) {
+	reply := func(payload model.CommandAck) {
+		f.sendTo(c, newEnvelope(model.EnvelopeCommandAck, payload.Vehicle, "", time.Time{}, payload))
+	}
+	ack := model.CommandAck{RequestID: m.RequestID}
+	if m.Command != nil {
+		ack.Vehicle = m.Command.VehicleID
+	}
+
+	switch {
+	case !f.validSession(c.session):
+		ack.Error = "unauthenticated: log in first"
+	case m.Command == nil:
+		ack.Error = "command required"
+	case !c.limiter.allow(""):
+		ack.Error = "too many commands on this connection"
+	}
+	if ack.Error != "" {
+		reply(ack)
+		return
+	}
+
+	_, err := f.submitControl(*m.Command, c, func(id string) {
+		ack.ID = id
+		ack.Status = "accepted"
+		reply(ack)
+	})
+	if err != nil {
+		ack.Error = err.Error()
+		reply(ack)
+	}
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"LoraFog/internal/model"
+)
+
+// defaultDedupWindow is used when server.dedup_window_ms is unset.
+const defaultDedupWindow = 200 * time.Millisecond
+
+// dedupCache collapses copies of the same uplink heard by several gateways.
+// The first copy opens a collection window; when it closes the copy with the
+// best link quality is emitted together with the list of gateways that heard it.
+// Copies arriving after the window (until twice the window) are dropped.
+// Frames without a counter are keyed by content, so only copies from
+// different gateways are merged; a gateway hearing the same content again
+// is a new transmission, e.g. from a parked vehicle.
+type dedupCache struct {
+	window time.Duration
+	emit   func(model.Uplink)
+
+	mu      sync.Mutex
+	entries map[string]*dedupEntry
+}
+
+// dedupEntry tracks one frame during and shortly after its collection window.
+type dedupEntry struct {
+	up      model.Uplink
+	emitted bool
+}
+
+// newDedupCache creates a cache; window <= 0 disables de-duplication.
+func newDedupCache(window time.Duration, emit func(model.Uplink)) *dedupCache {
+	return &dedupCache{window: window, emit: emit, entries: map[string]*dedupEntry{}}
+}
+
+// dedupKey identifies a frame by vehicle ID plus frame counter,
+// falling back to a hash of the telemetry content when no counter is sent.
+func dedupKey(vd model.VehicleData) string {
+	if vd.FCnt != 0 {
+		return fmt.Sprintf("%s/%d", vd.VehicleID, vd.FCnt)
+	}
+	sum := sha1.Sum([]byte(fmt.Sprintf("%+v", vd)))
+	return vd.VehicleID + "#" + hex.EncodeToString(sum[:8])
+}
+
+// add records one received copy of a frame.
+func (d *dedupCache) add(vd model.VehicleData, meta model.UplinkMeta) {
+	if d.window <= 0 {
+		d.emit(model.Uplink{Data: vd, Meta: meta, HeardBy: []model.UplinkMeta{meta}})
+		return
+	}
+	key := dedupKey(vd)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e, ok := d.entries[key]; ok && !(vd.FCnt == 0 && e.heardBy(meta.GatewayID)) {
+		if e.emitted {
+			log.Printf("[fog] late duplicate %s via %s dropped", key, meta.GatewayID)
+			return
+		}
+		e.up.HeardBy = append(e.up.HeardBy, meta)
+		if meta.BetterThan(e.up.Meta) {
+			e.up.Data, e.up.Meta = vd, meta
+		}
+		return
+	}
+
+	// an entry replaced here still emits when its own timer fires
+	e := &dedupEntry{up: model.Uplink{Data: vd, Meta: meta, HeardBy: []model.UplinkMeta{meta}}}
+	d.entries[key] = e
+	time.AfterFunc(d.window, func() { d.flush(key, e) })
+}
+
+// heardBy reports whether gateway already delivered a copy of e.
+func (e *dedupEntry) heardBy(gateway string) bool {
+	for _, m := range e.up.HeardBy {
+		if m.GatewayID == gateway {
+			return true
+		}
+	}
+	return false
+}
+
+// flush emits the best copy of e and schedules its removal from key.
+func (d *dedupCache) flush(key string, e *dedupEntry) {
+	d.mu.Lock()
+	if e.emitted {
+		d.mu.Unlock()
+		return
+	}
+	e.emitted = true
+	up := e.up
+	up.HeardBy = append([]model.UplinkMeta(nil), e.up.HeardBy...)
+	d.mu.Unlock()
+
+	d.emit(up)
+
+	time.AfterFunc(d.window, func() {
+		d.mu.Lock()
+		if d.entries[key] == e {
+			delete(d.entries, key)
+		}
+		d.mu.Unlock()
+	})
+}
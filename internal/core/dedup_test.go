@@ -0,0 +1,97 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"LoraFog/internal/model"
+)
+
+func TestDedupCache(t *testing.T) {
+	const window = 100 * time.Millisecond
+	// heard is one uplink copy, received at offset at from the first one.
+	type heard struct {
+		at   time.Duration
+		gw   string
+		rssi float64
+		fcnt uint32
+		lat  float64 // stands in for the content of counterless frames
+	}
+	cases := []struct {
+		name   string
+		copies []heard
+		want   []string // emitted uplinks as "best gateway <- all gateways"
+	}{
+		{"copies in the window merge, best link wins", []heard{
+			{0, "GW01", -90, 7, 1},
+			{20 * time.Millisecond, "GW02", -60, 7, 1},
+			{40 * time.Millisecond, "GW03", -75, 7, 1},
+		}, []string{"GW02 <- GW01+GW02+GW03"}},
+		{"different counters are different frames", []heard{
+			{0, "GW01", -90, 7, 1},
+			{10 * time.Millisecond, "GW02", -60, 8, 1},
+		}, []string{"GW01 <- GW01", "GW02 <- GW02"}},
+		{"late copy after the window is dropped", []heard{
+			{0, "GW01", -90, 7, 1},
+			{window + 50*time.Millisecond, "GW02", -60, 7, 1},
+		}, []string{"GW01 <- GW01"}},
+		{"counter reused after twice the window is a new frame", []heard{
+			{0, "GW01", -90, 7, 1},
+			{2*window + 100*time.Millisecond, "GW02", -60, 7, 1},
+		}, []string{"GW01 <- GW01", "GW02 <- GW02"}},
+		{"counterless copies from other gateways merge", []heard{
+			{0, "GW01", -90, 0, 1},
+			{20 * time.Millisecond, "GW02", -60, 0, 1},
+		}, []string{"GW02 <- GW01+GW02"}},
+		{"counterless repeat from the same gateway is kept", []heard{
+			{0, "GW01", -90, 0, 1},
+			{20 * time.Millisecond, "GW01", -80, 0, 1},
+		}, []string{"GW01 <- GW01", "GW01 <- GW01"}},
+		{"counterless frames with other content stay apart", []heard{
+			{0, "GW01", -90, 0, 1},
+			{20 * time.Millisecond, "GW02", -60, 0, 2},
+		}, []string{"GW01 <- GW01", "GW02 <- GW02"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			var mu sync.Mutex
+			var got []string
+			d := newDedupCache(window, func(up model.Uplink) {
+				var gws []string
+				for _, m := range up.HeardBy {
+					gws = append(gws, m.GatewayID)
+				}
+				mu.Lock()
+				got = append(got, fmt.Sprintf("%s <- %s", up.Meta.GatewayID, strings.Join(gws, "+")))
+				mu.Unlock()
+			})
+			start := time.Now()
+			for _, c := range tc.copies {
+				time.Sleep(time.Until(start.Add(c.at)))
+				vd := model.VehicleData{VehicleID: "VH01", FCnt: c.fcnt, Latitude: c.lat}
+				d.add(vd, model.UplinkMeta{GatewayID: c.gw, RSSI: c.rssi})
+			}
+			time.Sleep(time.Until(start.Add(tc.copies[len(tc.copies)-1].at + 2*window)))
+			mu.Lock()
+			defer mu.Unlock()
+			if strings.Join(got, ", ") != strings.Join(tc.want, ", ") {
+				t.Errorf("emitted %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDedupDisabled(t *testing.T) {
+	var n int
+	d := newDedupCache(0, func(model.Uplink) { n++ })
+	vd := model.VehicleData{VehicleID: "VH01", FCnt: 7}
+	d.add(vd, model.UplinkMeta{GatewayID: "GW01"})
+	d.add(vd, model.UplinkMeta{GatewayID: "GW02"})
+	if n != 2 {
+		t.Errorf("emitted %d copies with the window off, want 2", n)
+	}
+}
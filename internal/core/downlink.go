@@ -0,0 +1,168 @@
+package core
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"LoraFog/internal/lora"
+	"LoraFog/internal/model"
+)
+
+// Downlink classes, named after their LoRaWAN counterparts.
+const (
+	// ClassA holds downlinks until the vehicle's next uplink and transmits them
+	// in a receive window right after it (battery vehicles sleep their radio).
+	ClassA = "A"
+	// ClassC transmits downlinks immediately (vehicle always listening).
+	ClassC = "C"
+)
+
+// Class A scheduling defaults.
+const (
+	defaultRxDelay     = 1 * time.Second
+	defaultRxWindow    = 1 * time.Second
+	defaultDownlinkTTL = 10 * time.Minute
+	defaultMaxPending  = 8
+)
+
+// pendingDownlink is an encoded downlink waiting for a receive window.
+type pendingDownlink struct {
+	line     string
+	queuedAt time.Time
+}
+
+// downlinkScheduler holds Class A downlinks per vehicle.
+type downlinkScheduler struct {
+	delay      time.Duration // from end of uplink to window opening
+	window     time.Duration // how long the window stays open
+	ttl        time.Duration // pending downlinks older than this are dropped
+	maxPending int           // per vehicle; the oldest is dropped on overflow
+
+	mu      sync.Mutex
+	pending map[string][]pendingDownlink
+}
+
+// newDownlinkScheduler creates a scheduler from config, applying defaults for zero values.
+func newDownlinkScheduler(c model.DownlinkConfig) *downlinkScheduler {
+	s := &downlinkScheduler{
+		delay:      time.Duration(c.RxDelayMs) * time.Millisecond,
+		window:     time.Duration(c.RxWindowMs) * time.Millisecond,
+		ttl:        time.Duration(c.TTLS) * time.Second,
+		maxPending: c.MaxPending,
+		pending:    map[string][]pendingDownlink{},
+	}
+	if s.delay <= 0 {
+		s.delay = defaultRxDelay
+	}
+	if s.window <= 0 {
+		s.window = defaultRxWindow
+	}
+	if s.ttl <= 0 {
+		s.ttl = defaultDownlinkTTL
+	}
+	if s.maxPending <= 0 {
+		s.maxPending = defaultMaxPending
+	}
+	return s
+}
+
+// enqueue stores a downlink for vehicle v and returns the queue depth.
+func (s *downlinkScheduler) enqueue(v, line string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := append(s.pending[v], pendingDownlink{line: line, queuedAt: time.Now()})
+	if len(q) > s.maxPending {
+		log.Printf("[downlink] vehicle %s queue full, dropping oldest", v)
+		q = q[len(q)-s.maxPending:]
+	}
+	s.pending[v] = q
+	return len(q)
+}
+
+// next returns the oldest unexpired downlink for v without removing it.
+func (s *downlinkScheduler) next(v string) (pendingDownlink, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := s.pending[v]
+	for len(q) > 0 && time.Since(q[0].queuedAt) > s.ttl {
+		log.Printf("[downlink] vehicle %s downlink expired: %s", v, q[0].line)
+		q = q[1:]
+	}
+	s.pending[v] = q
+	if len(q) == 0 {
+		delete(s.pending, v)
+		return pendingDownlink{}, false
+	}
+	return q[0], true
+}
+
+// done removes d from the head of v's queue after it was transmitted.
+func (s *downlinkScheduler) done(v string, d pendingDownlink) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q := s.pending[v]
+	if len(q) > 0 && q[0] == d {
+		q = q[1:]
+	}
+	if len(q) == 0 {
+		delete(s.pending, v)
+	} else {
+		s.pending[v] = q
+	}
+}
+
+// depth returns the number of pending downlinks across all vehicles.
+func (s *downlinkScheduler) depth() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, q := range s.pending {
+		n += len(q)
+	}
+	return n
+}
+
+// classA reports whether the gateway defers downlinks to receive windows.
+func (g *Gateway) classA() bool {
+	return strings.EqualFold(g.Downlink.Class, ClassA)
+}
+
+// openRxWindow schedules transmission of one pending downlink for vehicle v
+// in the receive window following an uplink received at t.
+func (g *Gateway) openRxWindow(v string, t time.Time) {
+	if _, ok := g.sched.next(v); !ok {
+		return
+	}
+	stop := g.stop
+	deadline := t.Add(g.sched.delay + g.sched.window)
+	time.AfterFunc(time.Until(t.Add(g.sched.delay)), func() {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if time.Now().After(deadline) {
+			log.Printf("[gateway %s] missed receive window for %s", g.ID, v)
+			return
+		}
+		d, ok := g.sched.next(v)
+		if !ok {
+			return
+		}
+		// a queued line would miss the window, so it stays pending instead
+		if err := transmitNow(g.Device, d.line); err != nil {
+			if errors.Is(err, lora.ErrDutyCycle) {
+				log.Printf("[gateway %s] class A downlink to %s deferred: %v", g.ID, v, err)
+			} else {
+				log.Printf("[gateway %s] class A downlink to %s failed: %v", g.ID, v, err)
+			}
+			return
+		}
+		g.sched.done(v, d)
+		mGatewayDownlinks.With(g.ID, v).Inc()
+		log.Printf("[gateway %s] class A downlink %s: %s", g.ID, v, d.line)
+	})
+}
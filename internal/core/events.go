@@ -0,0 +1,61 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"LoraFog/internal/model"
+)
+
+// defaultEventHistory is the number of recent events kept in memory.
+const defaultEventHistory = 256
+
+// eventLog is a fixed-size ring of recent fog events.
+type eventLog struct {
+	mu     sync.RWMutex
+	nextID uint64
+	buf    []model.Event
+	start  int // index of the oldest event
+	n      int // number of stored events
+}
+
+// newEventLog creates a ring holding up to size events.
+func newEventLog(size int) *eventLog {
+	if size <= 0 {
+		size = defaultEventHistory
+	}
+	return &eventLog{buf: make([]model.Event, size)}
+}
+
+// append stores ev, assigning its ID and time, and returns the stored copy.
+func (l *eventLog) append(ev model.Event) model.Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.nextID++
+	ev.ID = l.nextID
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if l.n < len(l.buf) {
+		l.buf[(l.start+l.n)%len(l.buf)] = ev
+		l.n++
+	} else {
+		l.buf[l.start] = ev
+		l.start = (l.start + 1) % len(l.buf)
+	}
+	return ev
+}
+
+// since returns stored events with ID greater than id, oldest first.
+func (l *eventLog) since(id uint64) []model.Event {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	out := []model.Event{}
+	for i := 0; i < l.n; i++ {
+		ev := l.buf[(l.start+i)%len(l.buf)]
+		if ev.ID > id {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
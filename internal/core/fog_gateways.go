@@ -0,0 +1,111 @@
+package core
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"LoraFog/internal/model"
+)
+
+// SetGatewayExpiry sets how long a registered gateway stays online without a heartbeat.
+func (f *FogServer) SetGatewayExpiry(d time.Duration) {
+	f.reg.mu.Lock()
+	f.reg.expiry = d
+	f.reg.mu.Unlock()
+}
+
+// SetGatewayPurge sets how long an expired runtime gateway stays in the
+// registry; d <= 0 keeps it forever.
+func (f *FogServer) SetGatewayPurge(d time.Duration) {
+	f.reg.mu.Lock()
+	f.reg.purge = d
+	f.reg.mu.Unlock()
+}
+
+// SetGatewayTokens sets the token each gateway must present to register.
+func (f *FogServer) SetGatewayTokens(tokens map[string]string) {
+	f.mu.Lock()
+	f.gwTokens = tokens
+	f.mu.Unlock()
+}
+
+// gatewayAuthorized reports whether token is the one configured for gateway
+// id; gateways without a configured token are never authorized.
+func (f *FogServer) gatewayAuthorized(id, token string) bool {
+	f.mu.Lock()
+	want, ok := f.gwTokens[id]
+	f.mu.Unlock()
+	return ok && want != "" && subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1
+}
+
+// LoadRegistry restores runtime gateway registrations from path and keeps
+// persisting them there. Static gateways from configuration take precedence.
+func (f *FogServer) LoadRegistry(path string) error {
+	return f.reg.load(path)
+}
+
+// Gateways returns the status of every known gateway.
+func (f *FogServer) Gateways() []model.GatewayStatus {
+	return f.reg.list()
+}
+
+// handleGatewayRegister accepts a GatewayRegistration (initial or heartbeat)
+// carrying the gateway's bearer token and answers with the registry expiry.
+func (f *FogServer) handleGatewayRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	defer func() {
+		if cerr := r.Body.Close(); cerr != nil {
+			log.Printf("[fog] warning: close register body: %v", cerr)
+		}
+	}()
+
+	var reg model.GatewayRegistration
+	if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+		http.Error(w, "invalid registration", http.StatusBadRequest)
+		return
+	}
+	if reg.GatewayID == "" {
+		http.Error(w, "gateway_id required", http.StatusBadRequest)
+		return
+	}
+	if !f.gatewayAuthorized(reg.GatewayID, bearerToken(r)) {
+		log.Printf("[fog] registration of gateway %s from %s refused: bad or missing token", reg.GatewayID, r.RemoteAddr)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	ack := f.registerGateway(reg)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ack); err != nil {
+		log.Printf("[fog] warning: encode register ack: %v", err)
+	}
+}
+
+// registerGateway records a registration or heartbeat and builds its ack.
+func (f *FogServer) registerGateway(reg model.GatewayRegistration) model.GatewayRegistrationAck {
+	if f.reg.register(reg) {
+		f.emit(model.Event{Type: model.EventGatewayRegistered, GatewayID: reg.GatewayID})
+	}
+	f.reg.mu.RLock()
+	ack := model.GatewayRegistrationAck{GatewayID: reg.GatewayID, ExpiresInS: int(f.reg.expiry / time.Second)}
+	f.reg.mu.RUnlock()
+	ack.Vehicles = f.reg.fleetList()
+	return ack
+}
+
+// handleGateways lists known gateways with status, last seen, vehicles and version.
+func (f *FogServer) handleGateways(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(f.Gateways()); err != nil {
+		log.Printf("[fog] warning: encode gateways: %v", err)
+	}
+}
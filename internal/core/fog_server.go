@@ -7,72 +7,103 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"LoraFog/internal/health"
+	"LoraFog/internal/lora"
+	"LoraFog/internal/metrics"
 	"LoraFog/internal/model"
 	"LoraFog/internal/parser"
-
-	"github.com/gorilla/websocket"
+	"LoraFog/internal/stream"
 )
 
-var upgrader = websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
-
 // FogServer implements a lightweight in-memory fog server that accepts telemetry,
 // broadcasts telemetry to websocket clients, and forwards control messages to gateways.
 type FogServer struct {
 	Addr    string
 	AppAddr string
 	reg     *registry
-	clients map[*websocket.Conn]bool
+	clients map[*wsClient]struct{}
+	wsOpts  wsOptions
 	mu      sync.Mutex
 	server  *http.Server
-	wireFmt string // wire format: "csv" or "json"
-}
-
-// registry maps vehicle IDs to gateway URLs.
-type registry struct {
-	mu         sync.RWMutex
-	vehicleMap map[string]string
-}
-
-// newRegistry creates an empty registry.
-func newRegistry() *registry {
-	return &registry{vehicleMap: map[string]string{}}
-}
-
-// set associates a vehicle ID with a gateway URL.
-func (r *registry) set(v string, gw string) { r.mu.Lock(); r.vehicleMap[v] = gw; r.mu.Unlock() }
-
-// get retrieves gateway URL for a vehicle ID.
-func (r *registry) get(v string) (string, bool) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-	x, ok := r.vehicleMap[v]
-	return x, ok
+	up      atomic.Bool // HTTP port bound, see Ready
+	wireFmt string      // wire format: "csv" or "json"
+
+	dedup      *dedupCache
+	uplinkMu   sync.RWMutex
+	lastUplink map[string]model.Uplink        // latest de-duplicated uplink per vehicle
+	serving    map[string]model.VehicleStatus // serving gateway per vehicle
+	events     *eventLog
+	sse        *stream.Hub                   // telemetry and events for /api/stream
+	mqtt       *mqttBridge                   // optional MQTT bridge, see SetMQTT
+	broker     *fogBroker                    // optional embedded broker, see SetBroker
+	gwmp       *gwmpServer                   // optional packet-forwarder endpoint, see SetGWMP
+	grpc       *grpcServer                   // optional gateway stream endpoint, see SetGRPC
+	lns        map[string]*lnsIntegration    // TTN/ChirpStack integrations by ID, see AddIntegration
+	local      map[string]func([]byte) error // downlink handlers of in-process gateways
+	sync       *cloudSync                    // optional cloud upload, see SetSync
+	sinks      sinkSet                       // optional time-series sinks, see SetSinks
+
+	gwTokens map[string]string // gateway ID → registration token, see SetGatewayTokens
+	sessMu   sync.Mutex
+	sessions map[string]time.Time // app sessions confirmed until, see validSession
+
+	ctlRate        model.ControlRateConfig
+	vehicleLimiter *rateLimiter // per-vehicle control rate across HTTP and websocket
 }
 
 // NewFogServer constructs a FogServer listening on addr.
 func NewFogServer(addr string, appAddr string) *FogServer {
-	return &FogServer{
-		Addr:    addr,
-		AppAddr: appAddr,
-		reg:     newRegistry(),
-		clients: map[*websocket.Conn]bool{},
+	f := &FogServer{
+		Addr:       addr,
+		AppAddr:    appAddr,
+		reg:        newRegistry(),
+		clients:    map[*wsClient]struct{}{},
+		wsOpts:     defaultWSOptions(),
+		lastUplink: map[string]model.Uplink{},
+		serving:    map[string]model.VehicleStatus{},
+		events:     newEventLog(defaultEventHistory),
+		sse:        stream.NewHub(stream.DefaultHistory),
+		lns:        map[string]*lnsIntegration{},
+		local:      map[string]func([]byte) error{},
+		sessions:   map[string]time.Time{},
 	}
+	f.dedup = newDedupCache(defaultDedupWindow, f.publish)
+	f.SetControlRate(model.ControlRateConfig{})
+	return f
+}
+
+// SetDedupWindow sets how long copies of one uplink are collected from
+// overlapping gateways; window <= 0 disables de-duplication.
+func (f *FogServer) SetDedupWindow(window time.Duration) {
+	f.dedup = newDedupCache(window, f.publish)
 }
 
 // RegisterGateway registers a gateway and maps its vehicle list in the registry.
 func (f *FogServer) RegisterGateway(id, url string, vehicles []string) {
+	f.reg.setGateway(id, url)
 	for _, v := range vehicles {
-		f.reg.set(v, url)
+		f.reg.set(v, id)
 	}
 }
 
+// SetLinkTTL sets how long an uplink keeps a gateway eligible for downlink routing.
+func (f *FogServer) SetLinkTTL(ttl time.Duration) {
+	f.reg.mu.Lock()
+	f.reg.linkTTL = ttl
+	f.reg.mu.Unlock()
+}
+
 // Start launches the HTTP server for telemetry, ws and control endpoints.
 // This call blocks until the server stops or fails.
 func (f *FogServer) Start() error {
@@ -80,29 +111,91 @@ func (f *FogServer) Start() error {
 		log.Println("[fog] fog server not started (empty address)")
 		return nil
 	}
+	srv := f.newServer()
+	log.Printf("[fog] listening on %s", srv.Addr)
+	return srv.ListenAndServe()
+}
+
+// newServer builds the HTTP server with all fog routes and stores it for Stop.
+func (f *FogServer) newServer() *http.Server {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/telemetry", f.handleTelemetry)
 	mux.HandleFunc("/api/control", f.handleControl)
+	mux.HandleFunc("/api/uplinks", f.handleUplinks)
+	mux.HandleFunc("/api/gateways", f.handleGateways)
+	mux.HandleFunc("/api/gateways/register", f.handleGatewayRegister)
+	mux.HandleFunc("/api/vehicles", f.handleVehicles)
+	mux.HandleFunc("/api/events", f.handleEvents)
+	mux.HandleFunc("/api/stream", stream.Handler(f.Name(), f.sse))
+	mux.HandleFunc("/api/integrations/", f.handleIntegration)
+	mux.HandleFunc("/api/sync", f.handleSync)
 	mux.HandleFunc("/ws", f.handleWS)
+	mux.HandleFunc("/api/ws/clients", f.handleWSClients)
+	mux.HandleFunc("/healthz", health.Handler(f.Name(), f.liveChecks))
+	mux.HandleFunc("/readyz", health.Handler(f.Name(), f.readyChecks))
+	mux.HandleFunc("/metrics", metrics.Handler())
+	mWSClients.Set(func() float64 { return float64(f.clientCount()) })
 	addr := f.Addr
 	addr = strings.TrimPrefix(addr, "http://")
 	addr = strings.TrimPrefix(addr, "https://")
-	f.server = &http.Server{Addr: addr, Handler: mux}
-	log.Printf("[fog] listening on %s", addr)
-	return f.server.ListenAndServe()
-	// log.Printf("FogServer is listening on %s", f.Addr)
-	// if err := f.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-	// 	log.Fatal(err)
-	// }
+	srv := &http.Server{Addr: addr, Handler: metrics.Instrument(f.Name(), mux)}
+	f.mu.Lock()
+	f.server = srv
+	f.mu.Unlock()
+	return srv
+}
+
+// Name implements Component.
+func (f *FogServer) Name() string { return "fog" }
+
+// Dependencies implements Component; the fog depends on nothing.
+func (f *FogServer) Dependencies() []string { return nil }
+
+// Run implements Component. It serves HTTP until ctx is cancelled
+// and returns the listener error if the server fails.
+func (f *FogServer) Run(ctx context.Context) error {
+	if f.Addr == "" {
+		return errors.New("fog server has no address")
+	}
+	srv := f.newServer()
+	lis, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	f.up.Store(true)
+	defer f.up.Store(false)
+	sweepCtx, stopSweep := context.WithCancel(ctx)
+	defer stopSweep()
+	go f.reg.sweep(sweepCtx)
+	errCh := make(chan error, 1)
+	go func() {
+		log.Printf("[fog] listening on %s", srv.Addr)
+		errCh <- srv.Serve(lis)
+	}()
+
+	select {
+	case <-ctx.Done():
+		f.Stop()
+		<-errCh
+		return nil
+	case err := <-errCh:
+		return err
+	}
 }
 
+// Ready implements readier: the fog is up once its HTTP port is bound.
+func (f *FogServer) Ready() bool { return f.up.Load() }
+
 // Stop shuts down the HTTP server.
 func (f *FogServer) Stop() {
-	if f.server != nil {
+	f.mu.Lock()
+	srv := f.server
+	f.mu.Unlock()
+	if srv != nil {
 		log.Println("[fog] Shutting down web server...")
 		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 		defer cancel()
-		if err := f.server.Shutdown(ctx); err != nil {
+		if err := srv.Shutdown(ctx); err != nil {
 			log.Printf("[fog] HTTP server shutdown error: %v", err)
 		} else {
 			log.Println("[fog] Web server stopped cleanly")
@@ -111,8 +204,16 @@ func (f *FogServer) Stop() {
 }
 
 // handleTelemetry accepts telemetry posted by gateways in either JSON or CSV text.
-// It decodes to VehicleData and broadcasts CSV lines to websocket clients.
+// The sender must name itself in X-Gateway-ID and carry that gateway's
+// bearer token, since its link metadata steers downlinks.
+// It decodes to VehicleData and hands it to the de-duplicator, which publishes
+// the best copy once the collection window closes.
 func (f *FogServer) handleTelemetry(w http.ResponseWriter, r *http.Request) {
+	if gw := r.Header.Get(HeaderGatewayID); !f.gatewayAuthorized(gw, bearerToken(r)) {
+		log.Printf("[fog] telemetry from %q at %s refused: bad or missing token", gw, r.RemoteAddr)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, "failed to read body", http.StatusBadRequest)
@@ -124,12 +225,26 @@ func (f *FogServer) handleTelemetry(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	line := strings.TrimSpace(string(body))
-	if line == "" {
-		http.Error(w, "empty telemetry", http.StatusBadRequest)
+	vd, err := decodeTelemetry(body)
+	if err != nil {
+		log.Printf("[fog] invalid telemetry: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	if err := f.ingest(vd, metaFromRequest(r)); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// decodeTelemetry decodes a telemetry line sent by a gateway, JSON or CSV.
+func decodeTelemetry(body []byte) (model.VehicleData, error) {
+	line := strings.TrimSpace(string(body))
+	if line == "" {
+		return model.VehicleData{}, errors.New("empty telemetry")
+	}
 	var vd model.VehicleData
 	// Try decode as JSON first
 	if err := json.Unmarshal(body, &vd); err != nil {
@@ -137,24 +252,41 @@ func (f *FogServer) handleTelemetry(w http.ResponseWriter, r *http.Request) {
 		csvp := parser.NewCSVParser()
 		vd2, err2 := csvp.DecodeTelemetry(line)
 		if err2 != nil {
-			log.Printf("[fog] invalid telemetry: cannot decode JSON or CSV: %v", err2)
-			http.Error(w, "invalid telemetry", http.StatusBadRequest)
-			return
+			mDecodeErrors.With("fog", "json+csv").Inc()
+			return model.VehicleData{}, fmt.Errorf("cannot decode JSON or CSV: %w", err2)
 		}
 		vd = vd2
 	}
+	return vd, nil
+}
 
-	// Encode to broadcast format
+// publish broadcasts a de-duplicated uplink to websocket clients and forwards it to the app.
+func (f *FogServer) publish(up model.Uplink) {
+	vd := up.Data
+	f.reg.observe(up)
+	f.trackServing(up)
+	f.uplinkMu.Lock()
+	f.lastUplink[vd.VehicleID] = up
+	f.uplinkMu.Unlock()
+	if len(up.HeardBy) > 1 {
+		ids := make([]string, 0, len(up.HeardBy))
+		for _, m := range up.HeardBy {
+			ids = append(ids, m.GatewayID)
+		}
+		log.Printf("[fog] uplink %s heard by %v, using %s", vd.VehicleID, ids, up.Meta.GatewayID)
+	}
+
+	// Encode to the wire format used for legacy clients and the app
 	var out string
 	var payload []byte
 	var contentType string
+	var err error
 	switch f.wireFmt {
 	case "json":
 		contentType = "application/json"
 		payload, err = json.Marshal(vd)
 		if err != nil {
 			log.Printf("[fog] encode json err: %v", err)
-			http.Error(w, "encode error", http.StatusInternalServerError)
 			return
 		}
 		out = string(payload)
@@ -164,19 +296,23 @@ func (f *FogServer) handleTelemetry(w http.ResponseWriter, r *http.Request) {
 		out, err = csvp.EncodeTelemetry(vd)
 		if err != nil {
 			log.Printf("[fog] encode csv err: %v", err)
-			http.Error(w, "encode error", http.StatusInternalServerError)
 			return
 		}
 		payload = []byte(out)
 	}
-	f.broadcast(out)
-	log.Printf("[fog] broadcast %s telemetry: %s", strings.ToUpper(f.wireFmt), out)
+	env := newEnvelope(model.EnvelopeTelemetry, vd.VehicleID, up.Meta.GatewayID, up.Meta.ReceivedAt, vd)
+	f.publishEnvelope(env, out)
+	f.mqtt.publishTelemetry(up.Meta.GatewayID, vd)
+	f.sync.record(model.SyncTelemetry, up)
+	f.sinks.record(up)
+	log.Printf("[fog] broadcast telemetry: %s", out)
 
 	// Forward to App Server if enabled
 	if f.AppAddr != "" {
 		go func(v model.VehicleData) {
 			resp, err := http.Post(f.AppAddr+"/api/telemetry", contentType, bytes.NewReader(payload))
 			if err != nil {
+				mFogAppForwardFailures.With().Inc()
 				log.Printf("[fog] forward to app failed: %v", err)
 				return
 			}
@@ -189,45 +325,24 @@ func (f *FogServer) handleTelemetry(w http.ResponseWriter, r *http.Request) {
 			if _, err := io.Copy(io.Discard, resp.Body); err != nil {
 				log.Printf("[fog] warning: discard control response: %v", err)
 			}
+			if resp.StatusCode/100 != 2 {
+				mFogAppForwardFailures.With().Inc()
+				log.Printf("[fog] forward to app failed: app answered %s", resp.Status)
+				return
+			}
 			log.Printf("[fog] forwarded telemetry to app (%s): %s", f.AppAddr, v.VehicleID)
 		}(vd)
 	}
-	w.WriteHeader(http.StatusOK)
 }
 
-// handleWS upgrades HTTP to websocket and registers the client for broadcasts.
-func (f *FogServer) handleWS(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		return
-	}
-	f.mu.Lock()
-	f.clients[conn] = true
-	f.mu.Unlock()
-
-	go func() {
-		defer func() {
-			f.mu.Lock()
-			delete(f.clients, conn)
-			f.mu.Unlock()
-			if err := conn.Close(); err != nil {
-				log.Printf("warning: failed to close websocket: %v", err)
-			}
-		}()
-		for {
-			if _, _, err := conn.ReadMessage(); err != nil {
-				break
-			}
-		}
-	}()
-}
-
-// broadcast sends a message to all connected websocket clients.
-func (f *FogServer) broadcast(msg string) {
-	f.mu.Lock()
-	defer f.mu.Unlock()
-	for c := range f.clients {
-		_ = c.WriteMessage(websocket.TextMessage, []byte(msg))
+// handleUplinks returns the latest de-duplicated uplink per vehicle,
+// including which gateways heard it.
+func (f *FogServer) handleUplinks(w http.ResponseWriter, r *http.Request) {
+	f.uplinkMu.RLock()
+	defer f.uplinkMu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(f.lastUplink); err != nil {
+		log.Printf("[fog] warning: encode uplinks: %v", err)
 	}
 }
 
@@ -269,63 +384,118 @@ func (f *FogServer) handleControl(w http.ResponseWriter, r *http.Request) {
 		ctl = ctl2
 	}
 
-	// Lookup gateway by vehicle ID
-	url, ok := f.reg.get(ctl.VehicleID)
-	if !ok {
-		http.Error(w, "no gateway registered for vehicle", http.StatusNotFound)
-		log.Printf("[fog] control ignored: no gateway for vehicle %s", ctl.VehicleID)
+	id, err := f.submitControl(ctl, nil, nil)
+	if err != nil {
+		var ce *controlError
+		if errors.As(err, &ce) {
+			http.Error(w, ce.msg, ce.status)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Encode control message according to configured wire format
-	var payload []byte
-	var contentType string
-	var err error
-
-	switch f.wireFmt {
-	case "csv":
-		csvp := parser.NewCSVParser()
-		line, encErr := csvp.EncodeControl(ctl)
-		if encErr != nil {
-			http.Error(w, "failed to encode control message (csv)", http.StatusInternalServerError)
-			log.Printf("[fog] control encode csv error: %v", encErr)
-			return
-		}
-		payload = []byte(line)
-		contentType = "text/plain"
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(map[string]string{"id": id}); err != nil {
+		log.Printf("[fog] warning: encode control ack: %v", err)
+	}
+}
 
-	default: // json
-		payload, err = json.Marshal(ctl)
-		if err != nil {
-			http.Error(w, "failed to encode control message (json)", http.StatusInternalServerError)
-			log.Printf("[fog] control encode json error: %v", err)
-			return
+// sendControl posts a control payload to each candidate gateway in turn
+// until one accepts it with a 2xx status, reporting progress for command id.
+func (f *FogServer) sendControl(origin *wsClient, id string, ctl model.ControlData, routes []downlinkRoute, contentType string, payload []byte) {
+	vehicleID := ctl.VehicleID
+	label := f.vehicleLabel(vehicleID)
+	for _, rt := range routes {
+		if err := f.deliverControl(rt, ctl, contentType, payload); err != nil {
+			if errors.Is(err, lora.ErrDutyCycle) {
+				// the gateway reaches the vehicle but is out of airtime; another
+				// gateway transmitting instead would only add interference
+				mFogDownlinks.With(rt.GatewayID, label, "rejected").Inc()
+				log.Printf("[fog] control via %s rejected: %v", rt.GatewayID, err)
+				f.commandStatus(origin, id, vehicleID, model.CommandStatus{Status: "rejected", Gateway: rt.GatewayID, Error: err.Error()})
+				return
+			}
+			mFogDownlinks.With(rt.GatewayID, label, "error").Inc()
+			log.Printf("[fog] control via %s (%s) failed: %v", rt.GatewayID, rt.URL, err)
+			continue
 		}
-		contentType = "application/json"
+		mFogDownlinks.With(rt.GatewayID, label, "ok").Inc()
+		log.Printf("[fog] control forwarded to %s (fmt=%s, vehicle=%s)", rt.GatewayID, f.wireFmt, vehicleID)
+		f.commandStatus(origin, id, vehicleID, model.CommandStatus{Status: "forwarded", Gateway: rt.GatewayID})
+		return
 	}
+	log.Printf("[fog] control for vehicle %s failed on all %d gateways", vehicleID, len(routes))
+	f.commandStatus(origin, id, vehicleID, model.CommandStatus{Status: "failed", Error: "no gateway accepted the command"})
+	f.emit(model.Event{
+		Type:      model.EventAlert,
+		VehicleID: vehicleID,
+		Data: model.Alert{
+			Severity: "warning",
+			Message:  fmt.Sprintf("control for %s failed on all %d gateways", vehicleID, len(routes)),
+		},
+	})
+}
 
-	// Send asynchronously to the gateway
-	go func() {
-		resp, err := http.Post(url+"/command", contentType, bytes.NewReader(payload))
-		if err != nil {
-			log.Printf("[fog] failed to send control to gateway %s: %v", url, err)
-			return
+// deliverControl sends a control payload over the route's transport:
+// the embedded broker for mqtt:// gateways, a PULL_RESP for gwmp://
+// packet forwarders, the gateway's stream for grpc:// gateways, a direct
+// call for local:// gateways in this process, the network server's
+// downlink API for lns:// integrations (re-encoded with their parser),
+// HTTP otherwise.
+func (f *FogServer) deliverControl(rt downlinkRoute, ctl model.ControlData, contentType string, payload []byte) error {
+	switch {
+	case strings.HasPrefix(rt.URL, lnsRouteScheme):
+		in, ok := f.lns[strings.TrimPrefix(rt.URL, lnsRouteScheme)]
+		if !ok {
+			return errors.New("unknown integration " + rt.URL)
 		}
+		return in.sendDown(ctl)
+	case strings.HasPrefix(rt.URL, mqttRouteScheme):
+		if f.broker == nil {
+			return errors.New("embedded broker disabled")
+		}
+		return f.broker.sendDown(strings.TrimPrefix(rt.URL, mqttRouteScheme), payload)
+	case strings.HasPrefix(rt.URL, gwmpRouteScheme):
+		if f.gwmp == nil {
+			return errors.New("packet forwarder endpoint disabled")
+		}
+		return f.gwmp.sendDown(strings.TrimPrefix(rt.URL, gwmpRouteScheme), payload)
+	case strings.HasPrefix(rt.URL, grpcRouteScheme):
+		if f.grpc == nil {
+			return errors.New("gateway stream endpoint disabled")
+		}
+		return f.grpc.sendDown(strings.TrimPrefix(rt.URL, grpcRouteScheme), payload)
+	case strings.HasPrefix(rt.URL, localRouteScheme):
+		return f.sendLocal(strings.TrimPrefix(rt.URL, localRouteScheme), payload)
+	}
+	return postCommand(rt.URL, contentType, payload)
+}
 
-		// Always close response body safely
-		defer func() {
-			if cerr := resp.Body.Close(); cerr != nil {
-				log.Printf("[fog] warning: close control response: %v", cerr)
-			}
-		}()
+// postCommand delivers a control payload to a gateway's /command endpoint.
+func postCommand(url, contentType string, payload []byte) error {
+	resp, err := http.Post(url+"/command", contentType, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
 
-		// Discard the body to complete the HTTP exchange cleanly
-		if _, err := io.Copy(io.Discard, resp.Body); err != nil {
-			log.Printf("[fog] warning: discard control response: %v", err)
+	// Always close response body safely
+	defer func() {
+		if cerr := resp.Body.Close(); cerr != nil {
+			log.Printf("[fog] warning: close control response: %v", cerr)
 		}
-
-		log.Printf("[fog] control forwarded to %s (fmt=%s, vehicle=%s)", url, f.wireFmt, ctl.VehicleID)
 	}()
 
-	w.WriteHeader(http.StatusAccepted)
+	// Discard the body to complete the HTTP exchange cleanly
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		log.Printf("[fog] warning: discard control response: %v", err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("gateway answered %s: %w", resp.Status, lora.ErrDutyCycle)
+	case resp.StatusCode/100 != 2:
+		return fmt.Errorf("gateway answered %s", resp.Status)
+	}
+	return nil
 }
@@ -3,6 +3,8 @@ package core
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
@@ -11,6 +13,9 @@ import (
 	"time"
 
 	"LoraFog/internal/device"
+	"LoraFog/internal/health"
+	"LoraFog/internal/lora"
+	"LoraFog/internal/metrics"
 	"LoraFog/internal/model"
 	"LoraFog/internal/parser"
 )
@@ -28,24 +33,43 @@ type Gateway struct {
 	WireOut    string // uplink Gateway -> Fog format
 	Vehicles   []string
 	VehicleSet map[string]struct{}
-	server     *http.Server
-	stop       chan struct{}
-	wg         sync.WaitGroup
+	DependsOn  []string          // supervisor dependencies, e.g. "fog" when run in-process
+	Radio      model.RadioConfig // modulation and duty-cycle limits of the LoRa device
+	Heartbeat  time.Duration     // registration heartbeat period to the fog; <= 0 disables
+	Admission  string            // static | allowlist | any (see AdmitStatic)
+	Downlink   model.DownlinkConfig
+
+	devPath string
+	baud    int
+	opens   int // successful openDevice calls; later ones are reconnects
+	devMu   sync.Mutex
+	devErr  error // why the LoRa device is unavailable; nil once open (see headless)
+	server  *http.Server
+	stop    chan struct{}
+	errs    chan error
+	wg      sync.WaitGroup
+
+	sched     *downlinkScheduler // class A pending downlinks
+	allowMu   sync.RWMutex
+	allowlist []string // fog-supplied fleet, refreshed by heartbeats
+
+	link UplinkTransport // how uplinks reach the fog, see SetTransport
 }
 
+// defaultHeartbeat is the default period of gateway registration heartbeats.
+const defaultHeartbeat = 30 * time.Second
+
+// maxReadFailures is the number of consecutive device read errors after which
+// the uplink loop reports the device as lost.
+const maxReadFailures = 50
+
 // NewGateway constructs a Gateway with device path and parsers.
-// If opening the serial device fails, the Device field may be nil and Start will be a no-op.
+// If opening the serial device fails, the Device field is nil and Start retries the open.
 func NewGateway(id, devPath string, baud int, URL string, fogURL string, wireIn string, wireOut string, in parser.Parser, out parser.Parser, vehicles []string) *Gateway {
-	dev, err := device.NewSerialDevice(devPath, baud)
-	if err != nil {
-		// log but continue: user may run gateway without physical device (e.g., test)
-		log.Printf("[gateway %s] open serial %s err: %v", id, devPath, err)
-	} else {
-		log.Printf("[gateway %s] open serial %s: success", id, devPath)
-	}
 	g := &Gateway{
 		ID:         id,
-		Device:     dev,
+		devPath:    devPath,
+		baud:       baud,
 		URL:        URL,
 		FogURL:     fogURL,
 		WireIn:     wireIn,
@@ -54,34 +78,127 @@ func NewGateway(id, devPath string, baud int, URL string, fogURL string, wireIn
 		OutParser:  out,
 		Vehicles:   vehicles,
 		VehicleSet: make(map[string]struct{}, len(vehicles)),
+		Heartbeat:  defaultHeartbeat,
+		link:       newHTTPTransport(id, fogURLs(model.GatewayConfig{FogURL: fogURL}), ""),
 		stop:       make(chan struct{}),
+		sched:      newDownlinkScheduler(model.DownlinkConfig{}),
 	}
 	for _, v := range vehicles {
 		g.VehicleSet[v] = struct{}{}
 	}
+	dev, err := device.NewSerialDevice(devPath, baud)
+	if err != nil {
+		// log but continue: user may run gateway without physical device (e.g., test)
+		log.Printf("[gateway %s] open serial %s err: %v", id, devPath, err)
+	} else {
+		log.Printf("[gateway %s] open serial %s: success", id, devPath)
+		g.Device = dev
+	}
 	return g
 }
 
+// openDevice (re)opens the LoRa serial device if it is absent or closed.
+// Only successful reopens count as reconnects, not failed retries of a
+// headless gateway.
+func (g *Gateway) openDevice() error {
+	if g.Device != nil {
+		if err := g.Device.Open(); err != nil {
+			return err
+		}
+	} else {
+		dev, err := device.NewSerialDevice(g.devPath, g.baud)
+		if err != nil {
+			return err
+		}
+		g.Device = wrapRadio("gateway "+g.ID, dev, g.Radio)
+	}
+	if g.opens++; g.opens > 1 {
+		mSerialReconnects.With("gateway/" + g.ID).Inc()
+	}
+	return nil
+}
+
+// SetDownlink applies the downlink class and class A receive-window settings.
+func (g *Gateway) SetDownlink(c model.DownlinkConfig) {
+	g.Downlink = c
+	g.sched = newDownlinkScheduler(c)
+}
+
+// SetRadio applies radio settings, wrapping the LoRa device with a duty-cycle limiter.
+func (g *Gateway) SetRadio(rc model.RadioConfig) {
+	g.Radio = rc
+	g.Device = wrapRadio("gateway "+g.ID, g.Device, rc)
+}
+
 // Start begins the gateway read/forward loop in a background goroutine.
-// Returns nil even if the underlying device is nil (no-op for testing).
+// A serial device that cannot be opened leaves the gateway headless: the
+// transport and HTTP endpoint still run, health reports the device, and the
+// uplink loop keeps retrying the open.
 func (g *Gateway) Start() error {
-	if g.Device == nil {
-		log.Printf("[gateway %s] no serial device; running in headless mode", g.ID)
-		return nil
+	if err := g.openDevice(); err != nil {
+		log.Printf("[gateway %s] no serial device (%v); running in headless mode", g.ID, err)
+		g.setDeviceErr(err)
+	} else {
+		g.setDeviceErr(nil)
 	}
+	g.stop = make(chan struct{})
+	g.errs = make(chan error, 2)
+	mDownlinkQueue.Set(func() float64 { return float64(g.sched.depth()) }, g.ID)
 
 	// Start uplink loop (Vehicle → Fog)
 	g.wg.Add(1)
 	go g.loop()
 
+	// Open the transport to the fog; session transports register as soon as they connect
+	err := g.link.Start(TransportHooks{
+		Downlink: g.downlink,
+		Ack:      g.applyAck,
+		Up: func() {
+			if g.Heartbeat > 0 {
+				if err := g.register(); err != nil {
+					log.Printf("[gateway %s] register err: %v", g.ID, err)
+				}
+			}
+		},
+	})
+	if err != nil {
+		// release the loop and device started above before reporting
+		close(g.stop)
+		g.closeDevice()
+		g.wg.Wait()
+		return fmt.Errorf("gateway %s: %s transport: %w", g.ID, g.link.Name(), err)
+	}
+	log.Printf("[gateway %s] %s transport to fog", g.ID, g.link.Name())
+
+	// Register with the fog and keep the registration alive
+	if g.Heartbeat > 0 {
+		g.wg.Add(1)
+		go g.heartbeat()
+	}
+
+	// Only HTTP delivers downlinks to /command; otherwise the endpoint is
+	// optional and only serves health checks
+	if g.link.Name() != TransportHTTP && g.URL == "" {
+		return nil
+	}
+
 	// Start downlink HTTP handler (Fog → Vehicle)
 	mux := http.NewServeMux()
 	mux.HandleFunc("/command", g.handleControl)
+	mux.HandleFunc("/healthz", health.Handler(g.Name(), g.liveChecks))
+	mux.HandleFunc("/readyz", health.Handler(g.Name(), g.readyChecks))
+	mux.HandleFunc("/airtime", airtimeHandler("gateway "+g.ID, func() device.Device {
+		if g.headless() != nil {
+			return nil
+		}
+		return g.Device
+	}))
+	mux.HandleFunc("/metrics", metrics.Handler())
 	// port := g.URL[strings.LastIndex(g.URL, ":"):]
 	addr := g.URL
 	addr = strings.TrimPrefix(addr, "http://")
 	addr = strings.TrimPrefix(addr, "https://")
-	g.server = &http.Server{Addr: addr, Handler: mux}
+	g.server = &http.Server{Addr: addr, Handler: metrics.Instrument(g.Name(), mux)}
 
 	g.wg.Add(1)
 	go func() {
@@ -89,15 +206,112 @@ func (g *Gateway) Start() error {
 		log.Printf("[gateway %s] HTTP listening at %s/command", g.ID, addr)
 		if err := g.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Printf("[gateway %s] HTTP error: %v", g.ID, err)
+			g.fail(fmt.Errorf("http: %w", err))
 		}
 	}()
 
 	return nil
 }
 
+// closeDevice closes the LoRa device if it is open.
+func (g *Gateway) closeDevice() {
+	g.devMu.Lock()
+	dev := g.Device
+	g.devMu.Unlock()
+	if dev != nil {
+		if err := dev.Close(); err != nil {
+			log.Printf("[gateway %s] device close err: %v", g.ID, err)
+		}
+	}
+}
+
+// deviceRetry is how often a headless gateway retries opening its serial device.
+const deviceRetry = 5 * time.Second
+
+// setDeviceErr records whether the LoRa device is open.
+func (g *Gateway) setDeviceErr(err error) {
+	g.devMu.Lock()
+	g.devErr = err
+	g.devMu.Unlock()
+}
+
+// headless returns why the LoRa device is unavailable, or nil once it is open.
+// Device may only be used after headless has returned nil.
+func (g *Gateway) headless() error {
+	g.devMu.Lock()
+	defer g.devMu.Unlock()
+	return g.devErr
+}
+
+// awaitDevice retries opening the serial device every deviceRetry while the
+// gateway is headless. It returns false if the gateway stops first.
+func (g *Gateway) awaitDevice() bool {
+	for g.headless() != nil {
+		select {
+		case <-g.stop:
+			return false
+		case <-time.After(deviceRetry):
+		}
+		if !g.retryDevice() {
+			return false
+		}
+	}
+	return true
+}
+
+// retryDevice makes one attempt to open the serial device, under devMu so
+// Stop either sees and closes the new device or this sees the gateway stopped.
+func (g *Gateway) retryDevice() bool {
+	g.devMu.Lock()
+	defer g.devMu.Unlock()
+	select {
+	case <-g.stop:
+		return false
+	default:
+	}
+	g.devErr = g.openDevice()
+	if g.devErr == nil {
+		log.Printf("[gateway %s] serial device open; leaving headless mode", g.ID)
+	}
+	return true
+}
+
+// fail reports a fatal runtime error to Run without blocking.
+func (g *Gateway) fail(err error) {
+	select {
+	case g.errs <- err:
+	default:
+	}
+}
+
+// Name implements Component.
+func (g *Gateway) Name() string { return "gateway/" + g.ID }
+
+// Dependencies implements Component.
+func (g *Gateway) Dependencies() []string { return g.DependsOn }
+
+// Run implements Component. It starts the gateway, blocks until ctx is
+// cancelled or the uplink loop/HTTP server fails, then stops it.
+func (g *Gateway) Run(ctx context.Context) error {
+	if err := g.Start(); err != nil {
+		return err
+	}
+	defer g.Stop()
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-g.errs:
+		return err
+	}
+}
+
 // loop continuously reads lines from the Device, decodes, re-encodes and posts to Fog.
 func (g *Gateway) loop() {
 	defer g.wg.Done()
+	if !g.awaitDevice() {
+		return
+	}
+	failures := 0
 	for {
 		select {
 		case <-g.stop:
@@ -108,18 +322,36 @@ func (g *Gateway) loop() {
 
 		line, err := g.Device.ReadLine(0)
 		if err != nil {
+			select {
+			case <-g.stop:
+				continue
+			default:
+			}
+			failures++
+			if failures >= maxReadFailures {
+				g.fail(fmt.Errorf("device lost: %w", err))
+				return
+			}
 			// transient error: wait and continue
 			time.Sleep(100 * time.Millisecond)
 			continue
 		}
+		failures = 0
 		line = strings.TrimSpace(line)
 		if line == "" {
 			continue
 		}
 
+		// Vehicle metrics frames are exposed here rather than forwarded
+		if parser.IsMetrics(line) {
+			g.reportMetrics(line)
+			continue
+		}
+
 		// Decode input using InParser
 		vd, err := g.InParser.DecodeTelemetry(line)
 		if err != nil {
+			mDecodeErrors.With("gateway", g.WireIn).Inc()
 			log.Printf("[gateway %s] decode %s error: %v", g.ID, g.WireIn, err)
 			continue
 		} else {
@@ -127,11 +359,19 @@ func (g *Gateway) loop() {
 		}
 
 		// check validation of packet that belong to vehicle managed by gateway
-		if _, ok := g.VehicleSet[vd.VehicleID]; !ok {
+		// (or, when roaming is enabled, authorised by the fog)
+		mGatewayRx.With(g.ID, g.vehicleLabel(vd.VehicleID)).Inc()
+		if !g.admits(vd.VehicleID) {
+			mGatewayUnmanaged.With(g.ID, g.vehicleLabel(vd.VehicleID)).Inc()
 			log.Printf("[gateway %s] skip telemetry from unmanaged vehicle %s", g.ID, vd.VehicleID)
 			continue
 		}
 
+		// Class A: the vehicle listens right after its uplink
+		if g.classA() {
+			g.openRxWindow(vd.VehicleID, time.Now())
+		}
+
 		// Encode for Fog using OutParser
 		out, err := g.OutParser.EncodeTelemetry(vd)
 		if err != nil {
@@ -147,28 +387,88 @@ func (g *Gateway) loop() {
 			contentType = "application/json"
 		}
 
-		// send to Fog server
-		resp, err := http.Post(g.FogURL+"/api/telemetry", contentType, strings.NewReader(out))
-		if err != nil {
+		// send to Fog over the configured transport
+		up := OutboundUplink{Frame: line, Data: out, ContentType: contentType, Meta: linkMeta(g.ID, g.Device), Vehicle: vd.VehicleID, FCnt: vd.FCnt}
+		if err := g.link.Send(up); err != nil {
+			mGatewayForwardFailures.With(g.ID, g.link.Name()).Inc()
 			log.Printf("[gateway %s] forward err: %v", g.ID, err)
-			continue
 		} else {
-			log.Printf("[gateway %s] uplink %s → %s : %s", g.ID, g.WireIn, g.WireOut, out)
+			mGatewayUplinks.With(g.ID, g.vehicleLabel(vd.VehicleID)).Inc()
+			log.Printf("[gateway %s] uplink %s → %s (%s): %s", g.ID, g.WireIn, g.WireOut, g.link.Name(), out)
 		}
+	}
+}
 
-		// Properly close response body (lint-safe)
-		if _, err := io.Copy(io.Discard, resp.Body); err != nil {
-			log.Printf("[gateway %s] warning: discard body: %v", g.ID, err)
+// reportMetrics exposes a metrics frame pushed by a managed vehicle.
+func (g *Gateway) reportMetrics(line string) {
+	m, err := parser.DecodeMetrics(line)
+	if err != nil {
+		mDecodeErrors.With("gateway", "metrics").Inc()
+		log.Printf("[gateway %s] decode metrics error: %v", g.ID, err)
+		return
+	}
+	if !g.admits(m.VehicleID) {
+		mGatewayUnmanaged.With(g.ID, g.vehicleLabel(m.VehicleID)).Inc()
+		log.Printf("[gateway %s] skip metrics from unmanaged vehicle %s", g.ID, m.VehicleID)
+		return
+	}
+	mReportTelemetry.With(m.VehicleID).Set(float64(m.TelemetryTx))
+	mReportControls.With(m.VehicleID).Set(float64(m.ControlRx))
+	mReportDecodeErrors.With(m.VehicleID).Set(float64(m.DecodeErrors))
+	mReportReconnects.With(m.VehicleID).Set(float64(m.Reconnects))
+	mReportAirtime.With(m.VehicleID).Set(float64(m.AirtimeMs) / 1000)
+	mReportTime.With(m.VehicleID).Set(float64(time.Now().Unix()))
+	log.Printf("[gateway %s] metrics from %s: %s", g.ID, m.VehicleID, line)
+}
+
+// heartbeat registers the gateway with the fog and repeats it every Heartbeat period.
+func (g *Gateway) heartbeat() {
+	defer g.wg.Done()
+	ticker := time.NewTicker(g.Heartbeat)
+	defer ticker.Stop()
+	for {
+		// a session transport that is down registers once it reconnects
+		if err := g.register(); err != nil && !errors.Is(err, ErrTransportDown) {
+			log.Printf("[gateway %s] heartbeat err: %v", g.ID, err)
 		}
-		if cerr := resp.Body.Close(); cerr != nil {
-			log.Printf("[gateway %s] warning: close body: %v", g.ID, cerr)
+		select {
+		case <-g.stop:
+			return
+		case <-ticker.C:
 		}
 	}
 }
 
-// handleControl receives a control message from Fog (JSON or CSV),
-// decodes into ControlData, re-encodes into wire_in format, and
-// sends it downlink to the Vehicle via LoRa.
+// SetTransport replaces the transport to the fog (HTTP to FogURL by
+// default); see newUplinkTransport. It must be called before Start.
+func (g *Gateway) SetTransport(t UplinkTransport) {
+	g.link = t
+}
+
+// register sends a GatewayRegistration to the fog. Session transports
+// deliver the ack asynchronously through applyAck.
+func (g *Gateway) register() error {
+	return g.link.Register(model.GatewayRegistration{
+		GatewayID: g.ID,
+		URL:       g.URL,
+		Vehicles:  g.Vehicles,
+		Version:   Version,
+	})
+}
+
+// applyAck applies the fog's answer to a registration.
+func (g *Gateway) applyAck(ack model.GatewayRegistrationAck) {
+	g.setAllowlist(ack.Vehicles)
+	if ack.ExpiresInS > 0 && time.Duration(ack.ExpiresInS)*time.Second <= g.Heartbeat {
+		log.Printf("[gateway %s] warning: heartbeat %s exceeds fog expiry %ds", g.ID, g.Heartbeat, ack.ExpiresInS)
+	}
+}
+
+// errInvalidControl is returned by downlink for undecodable control messages.
+var errInvalidControl = errors.New("invalid control message format")
+
+// handleControl receives a control message from Fog over HTTP and sends it
+// downlink; see downlink.
 func (g *Gateway) handleControl(w http.ResponseWriter, r *http.Request) {
 	defer func() {
 		if cerr := r.Body.Close(); cerr != nil {
@@ -181,10 +481,27 @@ func (g *Gateway) handleControl(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "failed to read request body", http.StatusBadRequest)
 		return
 	}
+	if err := g.downlink(body); err != nil {
+		switch {
+		case errors.Is(err, errInvalidControl):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, lora.ErrDutyCycle):
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		default:
+			http.Error(w, "failed to send to vehicle", http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// downlink decodes a control message from Fog (JSON or CSV) into
+// ControlData, re-encodes it into wire_in format, and sends it to the
+// Vehicle via LoRa (class C) or queues it for the next receive window (class A).
+func (g *Gateway) downlink(body []byte) error {
 	line := strings.TrimSpace(string(body))
 	if line == "" {
-		http.Error(w, "empty control message", http.StatusBadRequest)
-		return
+		return fmt.Errorf("%w: empty", errInvalidControl)
 	}
 
 	// Step 1: decode incoming control message (Fog → Gateway)
@@ -195,9 +512,8 @@ func (g *Gateway) handleControl(w http.ResponseWriter, r *http.Request) {
 		csvp := parser.NewCSVParser()
 		ctl2, err2 := csvp.DecodeControl(line)
 		if err2 != nil {
-			http.Error(w, "invalid control message format", http.StatusBadRequest)
 			log.Printf("[gateway %s] invalid control: %v", g.ID, err2)
-			return
+			return fmt.Errorf("%w: %v", errInvalidControl, err2)
 		}
 		ctl = ctl2
 	}
@@ -208,29 +524,45 @@ func (g *Gateway) handleControl(w http.ResponseWriter, r *http.Request) {
 	case "json":
 		b, err := json.Marshal(ctl)
 		if err != nil {
-			http.Error(w, "encode downlink error", http.StatusInternalServerError)
-			return
+			return fmt.Errorf("encode downlink: %w", err)
 		}
 		downlink = string(b)
 	default: // CSV
 		csvp := parser.NewCSVParser()
 		s, err := csvp.EncodeControl(ctl)
 		if err != nil {
-			http.Error(w, "encode downlink error", http.StatusInternalServerError)
-			return
+			return fmt.Errorf("encode downlink: %w", err)
 		}
 		downlink = s
 	}
 
+	// Step 3a: class A holds the downlink for the vehicle's next receive window
+	if g.classA() {
+		n := g.sched.enqueue(ctl.VehicleID, downlink)
+		log.Printf("[gateway %s] downlink queued for %s (%d pending): %s", g.ID, ctl.VehicleID, n, downlink)
+		return nil
+	}
+
 	// Step 3: send to Vehicle via LoRa
+	if err := g.headless(); err != nil {
+		return fmt.Errorf("gateway %s headless: %w", g.ID, err)
+	}
 	if err := g.Device.WriteLine(downlink); err != nil {
-		http.Error(w, "failed to send to vehicle", http.StatusInternalServerError)
-		log.Printf("[gateway %s] downlink send error: %v", g.ID, err)
-		return
+		if errors.Is(err, lora.ErrQueued) {
+			log.Printf("[gateway %s] downlink for %s queued for airtime: %s", g.ID, ctl.VehicleID, downlink)
+			return nil
+		}
+		if errors.Is(err, lora.ErrDutyCycle) {
+			log.Printf("[gateway %s] downlink rejected: %v", g.ID, err)
+		} else {
+			log.Printf("[gateway %s] downlink send error: %v", g.ID, err)
+		}
+		return err
 	}
+	mGatewayDownlinks.With(g.ID, ctl.VehicleID).Inc()
 
 	log.Printf("[gateway %s] downlink %s: %s", g.ID, g.WireIn, downlink)
-	w.WriteHeader(http.StatusAccepted)
+	return nil
 }
 
 // Stop stops the gateway background loop and closes the device if present.
@@ -256,12 +588,11 @@ func (g *Gateway) Stop() {
 		}
 	}
 
+	// Close the transport to the fog
+	g.link.Stop()
+
 	// Close device
-	if g.Device != nil {
-		if err := g.Device.Close(); err != nil {
-			log.Printf("[gateway %s] device close err: %v", g.ID, err)
-		}
-	}
+	g.closeDevice()
 
 	// Wait goroutine done
 	done := make(chan struct{})
@@ -0,0 +1,335 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"LoraFog/internal/gwstream"
+	"LoraFog/internal/model"
+)
+
+const (
+	// grpcRouteScheme marks registry URLs of gateways connected over gRPC.
+	grpcRouteScheme = "grpc://"
+	// grpcAckWait bounds the wait for a gateway to ack a downlink.
+	grpcAckWait = 5 * time.Second
+	// grpcSendQueue is the number of frames queued per stream before
+	// senders block.
+	grpcSendQueue = 64
+)
+
+// grpcServer serves gateway streams (see package gwstream) over TLS. A
+// stream is accepted only when its "authorization: Bearer <token>" metadata
+// carries the server.gateway_tokens entry of the gateway named in its hello.
+// Uplinks are fed into de-duplication like POST /api/telemetry; downlinks to
+// grpc:// routes are sent on the gateway's stream and wait for its ack.
+type grpcServer struct {
+	cfg    model.GRPCConfig
+	fog    *FogServer
+	server *grpc.Server
+
+	mu       sync.Mutex
+	sessions map[string]*grpcSession // gateway ID -> current stream
+	up       atomic.Bool             // listening, see Ready
+}
+
+// grpcSession is one connected gateway stream. Frames are written by a
+// single sender goroutine; pending downlinks wait for their ack by Seq.
+type grpcSession struct {
+	id   string
+	out  chan *gwstream.Frame
+	done chan struct{}
+
+	mu      sync.Mutex
+	seq     uint64
+	pending map[uint64]chan string // downlink Seq -> ack error
+}
+
+// SetGRPC enables the gateway stream endpoint; an empty address leaves it
+// disabled.
+func (f *FogServer) SetGRPC(c model.GRPCConfig) Component {
+	if c.Addr == "" {
+		return nil
+	}
+	s := &grpcServer{cfg: c, fog: f, sessions: map[string]*grpcSession{}}
+	f.grpc = s
+	return s
+}
+
+// Name implements Component.
+func (s *grpcServer) Name() string { return "grpc" }
+
+// Dependencies implements Component; uplinks are handed to the fog.
+func (s *grpcServer) Dependencies() []string { return []string{s.fog.Name()} }
+
+// Ready implements readier: the endpoint is up once it listens.
+func (s *grpcServer) Ready() bool { return s.up.Load() }
+
+// Run implements Component. It serves streams until ctx is cancelled.
+func (s *grpcServer) Run(ctx context.Context) error {
+	opts := []grpc.ServerOption{
+		grpc.KeepaliveParams(keepalive.ServerParameters{Time: 30 * time.Second, Timeout: 10 * time.Second}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{MinTime: 10 * time.Second, PermitWithoutStream: true}),
+	}
+	switch {
+	case s.cfg.CertFile != "" || s.cfg.KeyFile != "":
+		creds, err := credentials.NewServerTLSFromFile(s.cfg.CertFile, s.cfg.KeyFile)
+		if err != nil {
+			return fmt.Errorf("grpc tls: %w", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	case s.cfg.Insecure:
+		log.Printf("[grpc] warning: serving without TLS, gateway tokens are sent in clear text")
+	default:
+		return errors.New("grpc: cert_file and key_file required (or insecure: true for testing)")
+	}
+	lis, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("grpc listen: %w", err)
+	}
+	s.server = grpc.NewServer(opts...)
+	s.server.RegisterService(&gwstream.ServiceDesc, s)
+	log.Printf("[grpc] gateway stream endpoint listening on %s", lis.Addr())
+	s.up.Store(true)
+	defer s.up.Store(false)
+
+	// streams never end on their own, so GracefulStop would wait for the
+	// gateways; Stop cancels them and they reconnect to the next instance
+	go func() {
+		<-ctx.Done()
+		s.server.Stop()
+	}()
+	if err := s.server.Serve(lis); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("grpc serve: %w", err)
+	}
+	return nil
+}
+
+// Connect implements gwstream.LinkServer for one gateway stream.
+func (s *grpcServer) Connect(stream grpc.ServerStream) error {
+	var hello gwstream.Frame
+	if err := stream.RecvMsg(&hello); err != nil {
+		return err
+	}
+	if hello.Type != gwstream.Hello || hello.GatewayID == "" {
+		return status.Error(codes.InvalidArgument, "first frame must be a hello with gateway_id")
+	}
+	if !s.fog.gatewayAuthorized(hello.GatewayID, streamToken(stream.Context())) {
+		log.Printf("[grpc] stream for gateway %s refused: bad or missing token", hello.GatewayID)
+		return status.Error(codes.Unauthenticated, "bad or missing gateway token")
+	}
+	sess := s.attach(hello.GatewayID)
+	defer s.detach(sess)
+	log.Printf("[grpc] gateway %s connected (window %d)", sess.id, hello.Window)
+
+	ctx := stream.Context()
+	sendErr := make(chan error, 1)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sess.done:
+				return
+			case fr := <-sess.out:
+				if err := stream.SendMsg(fr); err != nil {
+					sendErr <- err
+					return
+				}
+			}
+		}
+	}()
+
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			var fr gwstream.Frame
+			if err := stream.RecvMsg(&fr); err != nil {
+				recvErr <- err
+				return
+			}
+			s.handle(ctx, sess, &fr)
+		}
+	}()
+
+	var err error
+	select {
+	case err = <-recvErr:
+	case err = <-sendErr:
+	case <-sess.done:
+		err = status.Error(codes.Aborted, "replaced by a newer stream")
+	}
+	log.Printf("[grpc] gateway %s disconnected: %v", sess.id, err)
+	return err
+}
+
+// streamToken returns the bearer token in a stream's metadata.
+func streamToken(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get("authorization") {
+		if token, ok := strings.CutPrefix(v, "Bearer "); ok {
+			return token
+		}
+	}
+	return ""
+}
+
+// handle dispatches one frame from a gateway.
+func (s *grpcServer) handle(ctx context.Context, sess *grpcSession, fr *gwstream.Frame) {
+	switch fr.Type {
+	case gwstream.Uplink:
+		ack := &gwstream.Frame{Type: gwstream.Ack, Seq: fr.Seq}
+		if err := s.handleUplink(sess.id, fr.Uplink); err != nil {
+			log.Printf("[grpc] invalid uplink from %s: %v", sess.id, err)
+			ack.Error = err.Error()
+		}
+		sess.send(ctx, ack)
+	case gwstream.Register:
+		if fr.Registration == nil {
+			return
+		}
+		reg := *fr.Registration
+		reg.GatewayID, reg.URL = sess.id, grpcRouteScheme+sess.id
+		ack := s.fog.registerGateway(reg)
+		sess.send(ctx, &gwstream.Frame{Type: gwstream.Registered, RegAck: &ack})
+	case gwstream.Ack:
+		sess.resolve(fr.Seq, fr.Error)
+	default:
+		log.Printf("[grpc] unexpected %q frame from %s", fr.Type, sess.id)
+	}
+}
+
+// handleUplink decodes one telemetry line into de-duplication.
+func (s *grpcServer) handleUplink(id string, up *model.GatewayUplink) error {
+	if up == nil {
+		return errors.New("empty uplink")
+	}
+	vd, err := decodeTelemetry([]byte(up.Data))
+	if err != nil {
+		return err
+	}
+	return s.fog.ingest(vd, model.UplinkMeta{GatewayID: id, RSSI: up.RSSI, SNR: up.SNR, ReceivedAt: time.Now()})
+}
+
+// attach records a new stream for id, closing the one it replaces.
+func (s *grpcServer) attach(id string) *grpcSession {
+	sess := &grpcSession{
+		id:      id,
+		out:     make(chan *gwstream.Frame, grpcSendQueue),
+		done:    make(chan struct{}),
+		pending: map[uint64]chan string{},
+	}
+	s.mu.Lock()
+	old := s.sessions[id]
+	s.sessions[id] = sess
+	s.mu.Unlock()
+	if old != nil {
+		old.close()
+	}
+	return sess
+}
+
+// detach forgets sess unless a newer stream already replaced it.
+func (s *grpcServer) detach(sess *grpcSession) {
+	s.mu.Lock()
+	if s.sessions[sess.id] == sess {
+		delete(s.sessions, sess.id)
+	}
+	s.mu.Unlock()
+	sess.close()
+}
+
+// close ends the session once; pending downlinks fail.
+func (sess *grpcSession) close() {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	select {
+	case <-sess.done:
+		return
+	default:
+	}
+	close(sess.done)
+	for seq, ch := range sess.pending {
+		ch <- "stream closed"
+		delete(sess.pending, seq)
+	}
+}
+
+// send queues a frame, blocking while the queue is full (flow control
+// towards the reader of the stream) until the stream ends.
+func (sess *grpcSession) send(ctx context.Context, fr *gwstream.Frame) bool {
+	select {
+	case sess.out <- fr:
+		return true
+	case <-sess.done:
+	case <-ctx.Done():
+	}
+	return false
+}
+
+// resolve delivers a downlink ack to its waiter.
+func (sess *grpcSession) resolve(seq uint64, errText string) {
+	sess.mu.Lock()
+	ch, ok := sess.pending[seq]
+	delete(sess.pending, seq)
+	sess.mu.Unlock()
+	if ok {
+		ch <- errText
+	}
+}
+
+// sendDown sends a control line on the gateway's stream and waits for the
+// gateway to report the transmission.
+func (s *grpcServer) sendDown(id string, payload []byte) error {
+	s.mu.Lock()
+	sess, ok := s.sessions[id]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("gateway %s has no open stream", id)
+	}
+
+	ch := make(chan string, 1)
+	sess.mu.Lock()
+	sess.seq++
+	seq := sess.seq
+	sess.pending[seq] = ch
+	sess.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), grpcAckWait)
+	defer cancel()
+	if !sess.send(ctx, &gwstream.Frame{Type: gwstream.Downlink, Seq: seq, Data: payload}) {
+		sess.resolve(seq, "")
+		return fmt.Errorf("gateway %s: stream closed or congested", id)
+	}
+	select {
+	case e := <-ch:
+		if e != "" {
+			return errors.New("gateway rejected downlink: " + e)
+		}
+		return nil
+	case <-ctx.Done():
+		sess.resolve(seq, "")
+		return fmt.Errorf("gateway %s: no downlink ack within %s", id, grpcAckWait)
+	}
+}
+
+// streams returns the number of connected gateway streams.
+func (s *grpcServer) streams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
@@ -0,0 +1,305 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"LoraFog/internal/gwmp"
+	"LoraFog/internal/model"
+)
+
+const (
+	// gwmpRouteScheme marks registry URLs of packet-forwarder gateways.
+	gwmpRouteScheme = "gwmp://"
+	// gwmpTxAckWait bounds the wait for a TX_ACK after a PULL_RESP.
+	gwmpTxAckWait = 2 * time.Second
+	// gwmpMaxDatagram is the largest datagram read from a forwarder.
+	gwmpMaxDatagram = 65507
+
+	defaultGWMPFreqMHz  = 869.525
+	defaultGWMPDataRate = "SF9BW125"
+	defaultGWMPCodeRate = "4/5"
+	defaultGWMPPower    = 14
+)
+
+// gwmpServer accepts Semtech UDP packet forwarders whose EUI is listed in
+// server.gwmp.names. Received frames are fed into de-duplication like POST
+// /api/telemetry; PULL_DATA keepalives register the gateway and open its
+// downlink path for PULL_RESP.
+//
+// Frames must carry wire-format telemetry (CSV or JSON) as rxpk.data, which
+// is what the Go gateway's gwmp transport sends without gwmp.lorawan
+// sessions; with them it frames LoRaWAN data messages for a network server
+// such as ChirpStack instead.
+type gwmpServer struct {
+	cfg   model.GWMPConfig
+	fog   *FogServer
+	names map[string]string // EUI -> gateway ID, the allowlist
+
+	mu      sync.Mutex
+	conn    *net.UDPConn
+	pull    map[string]*net.UDPAddr // gateway ID -> source of its last PULL_DATA
+	version map[string]byte         // gateway ID -> protocol version it speaks
+	pending map[uint16]gwmpTx       // PULL_RESP token -> waiting downlink
+	token   uint16
+}
+
+// gwmpTx is a PULL_RESP waiting for its TX_ACK, which must come from the
+// address the PULL_RESP was sent to.
+type gwmpTx struct {
+	to  *net.UDPAddr
+	ack chan string
+}
+
+// SetGWMP enables the packet-forwarder endpoint; an empty address leaves it disabled.
+func (f *FogServer) SetGWMP(c model.GWMPConfig) Component {
+	if c.Addr == "" {
+		return nil
+	}
+	if c.FreqMHz == 0 {
+		c.FreqMHz = defaultGWMPFreqMHz
+	}
+	if c.DataRate == "" {
+		c.DataRate = defaultGWMPDataRate
+	}
+	if c.CodeRate == "" {
+		c.CodeRate = defaultGWMPCodeRate
+	}
+	if c.Power == 0 {
+		c.Power = defaultGWMPPower
+	}
+	names := map[string]string{}
+	for k, id := range c.Names {
+		eui, err := gwmp.ParseEUI(k)
+		if err != nil {
+			log.Printf("[config] gwmp names: %v, ignored", err)
+			continue
+		}
+		if id == "" {
+			id = eui.String()
+		}
+		names[eui.String()] = id
+	}
+	if len(names) == 0 {
+		log.Printf("[config] gwmp names is empty: every packet forwarder will be refused")
+	}
+	s := &gwmpServer{
+		cfg:     c,
+		fog:     f,
+		names:   names,
+		pull:    map[string]*net.UDPAddr{},
+		version: map[string]byte{},
+		pending: map[uint16]gwmpTx{},
+	}
+	f.gwmp = s
+	return s
+}
+
+// Name implements Component.
+func (s *gwmpServer) Name() string { return "gwmp" }
+
+// Dependencies implements Component; frames are handed to the fog.
+func (s *gwmpServer) Dependencies() []string { return []string{s.fog.Name()} }
+
+// Run implements Component. It serves UDP until ctx is cancelled.
+func (s *gwmpServer) Run(ctx context.Context) error {
+	addr, err := net.ResolveUDPAddr("udp", s.cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("gwmp addr: %w", err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return fmt.Errorf("gwmp listen: %w", err)
+	}
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+	log.Printf("[gwmp] packet forwarder endpoint listening on udp %s", conn.LocalAddr())
+
+	go func() {
+		<-ctx.Done()
+		if err := conn.Close(); err != nil {
+			log.Printf("[gwmp] close error: %v", err)
+		}
+	}()
+
+	buf := make([]byte, gwmpMaxDatagram)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("gwmp read: %w", err)
+		}
+		pkt, err := gwmp.Unmarshal(buf[:n])
+		if err != nil {
+			log.Printf("[gwmp] invalid datagram from %s: %v", from, err)
+			continue
+		}
+		s.handle(pkt, from)
+	}
+}
+
+// gatewayID names a forwarder by its configured name; unlisted EUIs are
+// not accepted.
+func (s *gwmpServer) gatewayID(eui gwmp.EUI) (string, bool) {
+	id, ok := s.names[eui.String()]
+	return id, ok
+}
+
+// reply sends an acknowledgement mirroring the request's version and token.
+func (s *gwmpServer) reply(req gwmp.Packet, typ gwmp.Type, to *net.UDPAddr) {
+	s.send(gwmp.Packet{Version: req.Version, Token: req.Token, Type: typ}, to)
+}
+
+// send writes one datagram.
+func (s *gwmpServer) send(p gwmp.Packet, to *net.UDPAddr) {
+	s.mu.Lock()
+	conn := s.conn
+	s.mu.Unlock()
+	if conn == nil {
+		return
+	}
+	if _, err := conn.WriteToUDP(p.Marshal(), to); err != nil {
+		log.Printf("[gwmp] send %s to %s: %v", p.Type, to, err)
+	}
+}
+
+// handle dispatches one datagram from a forwarder.
+func (s *gwmpServer) handle(pkt gwmp.Packet, from *net.UDPAddr) {
+	if pkt.Type == gwmp.TxAck {
+		s.handleTxAck(pkt, from)
+		return
+	}
+	id, ok := s.gatewayID(pkt.EUI)
+	if !ok {
+		log.Printf("[gwmp] %s from unknown EUI %s (%s) refused; list it in server.gwmp.names", pkt.Type, pkt.EUI, from)
+		return
+	}
+	switch pkt.Type {
+	case gwmp.PushData:
+		s.reply(pkt, gwmp.PushAck, from)
+		s.handlePush(id, pkt.Payload)
+	case gwmp.PullData:
+		s.reply(pkt, gwmp.PullAck, from)
+		s.mu.Lock()
+		s.pull[id] = from
+		s.version[id] = pkt.Version
+		s.mu.Unlock()
+		s.fog.registerGateway(model.GatewayRegistration{GatewayID: id, URL: gwmpRouteScheme + id})
+	default:
+		log.Printf("[gwmp] unexpected %s from %s", pkt.Type, from)
+	}
+}
+
+// handleTxAck resolves the PULL_RESP with the ack's token. Acks from any
+// address other than the one the PULL_RESP went to are ignored, so another
+// host cannot confirm or fail a downlink by guessing tokens.
+func (s *gwmpServer) handleTxAck(pkt gwmp.Packet, from *net.UDPAddr) {
+	s.mu.Lock()
+	tx, ok := s.pending[pkt.Token]
+	if ok && !(tx.to.IP.Equal(from.IP) && tx.to.Port == from.Port) {
+		s.mu.Unlock()
+		log.Printf("[gwmp] TX_ACK %d from %s ignored: PULL_RESP went to %s", pkt.Token, from, tx.to)
+		return
+	}
+	delete(s.pending, pkt.Token)
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	var ack gwmp.TxAckPayload
+	if len(pkt.Payload) > 0 {
+		if err := json.Unmarshal(pkt.Payload, &ack); err != nil {
+			log.Printf("[gwmp] invalid TX_ACK from %s: %v", from, err)
+		}
+	}
+	tx.ack <- ack.TxPkAck.Error
+}
+
+// handlePush decodes the frames of a PUSH_DATA into telemetry.
+func (s *gwmpServer) handlePush(id string, payload []byte) {
+	var push gwmp.PushDataPayload
+	if err := json.Unmarshal(payload, &push); err != nil {
+		log.Printf("[gwmp] invalid PUSH_DATA from %s: %v", id, err)
+		return
+	}
+	if push.Stat != nil {
+		log.Printf("[gwmp] stat from %s: rx=%d ok=%d fwd=%d tx=%d", id, push.Stat.RXNb, push.Stat.RXOK, push.Stat.RXFW, push.Stat.TXNb)
+	}
+	for _, rx := range push.RXPK {
+		if rx.Stat != 1 {
+			continue // CRC error or no CRC
+		}
+		vd, err := decodeTelemetry(rx.Data)
+		if err != nil {
+			log.Printf("[gwmp] undecodable frame from %s: %v", id, err)
+			continue
+		}
+		if err := s.fog.ingest(vd, model.UplinkMeta{GatewayID: id, RSSI: rx.RSSI, SNR: rx.LSNR, ReceivedAt: time.Now()}); err != nil {
+			log.Printf("[gwmp] frame from %s dropped: %v", id, err)
+		}
+	}
+}
+
+// sendDown transmits a control line through the forwarder as an immediate
+// PULL_RESP and waits for its TX_ACK. Forwarders that do not send TX_ACK
+// (protocol v1) are assumed to have transmitted.
+func (s *gwmpServer) sendDown(id string, payload []byte) error {
+	s.mu.Lock()
+	to, ok := s.pull[id]
+	version := s.version[id]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("gateway %s has not sent PULL_DATA", id)
+	}
+	body, err := json.Marshal(gwmp.PullRespPayload{TXPK: gwmp.TXPK{
+		Imme: true,
+		Freq: s.cfg.FreqMHz,
+		Powe: s.cfg.Power,
+		Modu: "LORA",
+		DatR: s.cfg.DataRate,
+		CodR: s.cfg.CodeRate,
+		Size: len(payload),
+		Data: payload,
+	}})
+	if err != nil {
+		return err
+	}
+
+	ch := make(chan string, 1)
+	s.mu.Lock()
+	s.token++
+	token := s.token
+	s.pending[token] = gwmpTx{to: to, ack: ch}
+	s.mu.Unlock()
+	s.send(gwmp.Packet{Version: version, Token: token, Type: gwmp.PullResp, Payload: body}, to)
+
+	select {
+	case e := <-ch:
+		if e != "" && e != gwmp.TxAckNone {
+			return errors.New("forwarder rejected downlink: " + e)
+		}
+		return nil
+	case <-time.After(gwmpTxAckWait):
+		s.mu.Lock()
+		delete(s.pending, token)
+		s.mu.Unlock()
+		log.Printf("[gwmp] no TX_ACK from %s, assuming sent", id)
+		return nil
+	}
+}
+
+// forwarders returns the number of gateways that have opened a downlink path.
+func (s *gwmpServer) forwarders() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pull)
+}
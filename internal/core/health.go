@@ -0,0 +1,155 @@
+package core
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"LoraFog/internal/device"
+	"LoraFog/internal/health"
+	"LoraFog/internal/metrics"
+)
+
+const (
+	// deviceIdleWarn is the last-read age after which a serial device is reported stale.
+	deviceIdleWarn = 5 * time.Minute
+	// probeTimeout bounds upstream reachability probes made by readiness checks.
+	probeTimeout = 2 * time.Second
+)
+
+// clientCount returns the number of connected websocket clients.
+func (f *FogServer) clientCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.clients)
+}
+
+// liveChecks reports process-local fog health.
+func (f *FogServer) liveChecks() []health.Check {
+	checks := []health.Check{
+		health.Info("http", "listening on %s", f.Addr),
+		health.Info("websocket_clients", "%d", f.clientCount()),
+		health.Info("sse_clients", "%d", f.sse.Clients()),
+	}
+	if f.broker != nil {
+		checks = append(checks, health.Info("broker", "%s, %d sessions", f.broker.cfg.Addr, f.broker.sessions()))
+	}
+	if f.gwmp != nil {
+		checks = append(checks, health.Info("gwmp", "udp %s, %d forwarders", f.gwmp.cfg.Addr, f.gwmp.forwarders()))
+	}
+	if f.grpc != nil {
+		checks = append(checks, health.Info("grpc", "%s, %d gateway streams", f.grpc.cfg.Addr, f.grpc.streams()))
+	}
+	if f.sync != nil {
+		checks = append(checks, f.sync.health())
+	}
+	for _, s := range f.sinks {
+		checks = append(checks, s.health())
+	}
+	if f.mqtt != nil {
+		c := health.Info("mqtt", "connected to %s, published %d, dropped %d",
+			f.mqtt.cfg.Broker, f.mqtt.published.Load(), f.mqtt.dropped.Load())
+		if !f.mqtt.connected() {
+			c.Status, c.Detail = health.StatusWarn, "disconnected from "+f.mqtt.cfg.Broker
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+// readyChecks extends liveChecks with reachability of the app server.
+// An unreachable app only degrades readiness: telemetry still reaches websocket clients.
+func (f *FogServer) readyChecks() []health.Check {
+	checks := f.liveChecks()
+	if f.AppAddr != "" {
+		c := health.HTTPCheck("app", f.AppAddr+"/healthz", probeTimeout)
+		if c.Status == health.StatusFail {
+			c.Status = health.StatusWarn
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+// liveChecks reports the gateway's LoRa device state; a headless gateway
+// only warns, since it keeps serving its transport and endpoints.
+func (g *Gateway) liveChecks() []health.Check {
+	var checks []health.Check
+	if err := g.headless(); err != nil {
+		checks = append(checks, health.Check{Name: "lora_device", Status: health.StatusWarn, Detail: "headless: " + err.Error()})
+	} else {
+		checks = append(checks, health.DeviceCheck("lora_device", g.Device, deviceIdleWarn))
+		if c, ok := airtimeCheck(g.Device); ok {
+			checks = append(checks, c)
+		}
+	}
+	if g.classA() {
+		checks = append(checks, health.Info("downlink_queue", "class A, %d pending", g.sched.depth()))
+	}
+	return checks
+}
+
+// readyChecks extends liveChecks with reachability of the fog server as
+// reported by the gateway's transport. A headless gateway is not ready.
+func (g *Gateway) readyChecks() []health.Check {
+	checks := g.liveChecks()
+	if g.headless() != nil {
+		checks[0].Status = health.StatusFail
+	}
+	return append(checks, g.link.Health())
+}
+
+// liveChecks reports the vehicle's LoRa device and Arduino data freshness.
+func (v *Vehicle) liveChecks() []health.Check {
+	checks := []health.Check{health.DeviceCheck("lora_device", v.Device, 0)}
+	if c, ok := airtimeCheck(v.Device); ok {
+		checks = append(checks, c)
+	}
+	if v.ArduinoDevice != nil {
+		last := v.lastUpdateTime()
+		c := health.Check{Name: "arduino", Status: health.StatusOK}
+		switch {
+		case v.ArduinoDevice.Serial == nil:
+			c.Status, c.Detail = health.StatusFail, "closed"
+		case last.IsZero():
+			c.Detail = "open, no data read yet"
+		default:
+			age := time.Since(last).Truncate(time.Millisecond)
+			c.Detail = fmt.Sprintf("open, last read %s ago", age)
+			if age > deviceIdleWarn {
+				c.Status = health.StatusWarn
+			}
+		}
+		checks = append(checks, c)
+	}
+	return checks
+}
+
+// readyChecks reports whether the vehicle can currently transmit telemetry.
+func (v *Vehicle) readyChecks() []health.Check {
+	return v.liveChecks()
+}
+
+// serveStatus starts the vehicle's local status endpoint on StatusAddr.
+// It returns the server so Stop can shut it down.
+func (v *Vehicle) serveStatus() *http.Server {
+	if v.StatusAddr == "" {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", health.Handler(v.Name(), v.liveChecks))
+	mux.HandleFunc("/readyz", health.Handler(v.Name(), v.readyChecks))
+	mux.HandleFunc("/airtime", airtimeHandler("vehicle "+v.ID, func() device.Device { return v.Device }))
+	mux.HandleFunc("/metrics", metrics.Handler())
+	addr := strings.TrimPrefix(strings.TrimPrefix(v.StatusAddr, "http://"), "https://")
+	srv := &http.Server{Addr: addr, Handler: metrics.Instrument(v.Name(), mux)}
+	go func() {
+		log.Printf("[vehicle %s] status endpoint at %s", v.ID, addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("[vehicle %s] status endpoint error: %v", v.ID, err)
+		}
+	}()
+	return srv
+}
@@ -0,0 +1,369 @@
+package core
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"LoraFog/internal/model"
+	"LoraFog/internal/parser"
+)
+
+// LoRaWAN network server kinds supported by integrations.
+const (
+	LNSTheThingsStack = "ttn"
+	LNSChirpStack     = "chirpstack"
+
+	// lnsRouteScheme marks registry URLs of network server integrations.
+	lnsRouteScheme = "lns://"
+	// lnsTimeout bounds downlink API calls.
+	lnsTimeout = 10 * time.Second
+)
+
+// lnsIntegration receives uplinks from a LoRaWAN network server's HTTP
+// integration and queues downlinks through its API.
+type lnsIntegration struct {
+	cfg    model.IntegrationConfig
+	parser parser.Parser
+	client *http.Client
+	api    *url.URL        // parsed cfg.APIURL, nil when unset
+	mapped map[string]bool // vehicles reserved to devices in cfg.Devices
+
+	mu      sync.Mutex
+	devices map[string]lnsDevice // vehicle ID -> device last seen carrying it
+}
+
+// lnsDevice identifies the network server device of a vehicle.
+type lnsDevice struct {
+	DeviceID string
+	DevEUI   string
+	AppID    string
+	PushURL  string // ttn: X-Downlink-Push sent with the webhook, if on the API host
+	PushKey  string // ttn: X-Downlink-Apikey sent with the webhook
+}
+
+// lnsUplink is an uplink normalised from either network server's JSON.
+type lnsUplink struct {
+	dev     lnsDevice
+	fcnt    uint32
+	payload []byte
+	rssi    float64
+	snr     float64
+}
+
+// ttnUplink is the subset of a The Things Stack v3 uplink message used here.
+type ttnUplink struct {
+	EndDeviceIDs struct {
+		DeviceID       string `json:"device_id"`
+		DevEUI         string `json:"dev_eui"`
+		ApplicationIDs struct {
+			ApplicationID string `json:"application_id"`
+		} `json:"application_ids"`
+	} `json:"end_device_ids"`
+	UplinkMessage *struct {
+		FCnt       uint32 `json:"f_cnt"`
+		FRMPayload []byte `json:"frm_payload"`
+		RxMetadata []struct {
+			RSSI float64 `json:"rssi"`
+			SNR  float64 `json:"snr"`
+		} `json:"rx_metadata"`
+	} `json:"uplink_message"`
+}
+
+// chirpUplink is the subset of a ChirpStack v4 "up" event used here.
+type chirpUplink struct {
+	DeviceInfo struct {
+		ApplicationID string `json:"applicationId"`
+		DeviceName    string `json:"deviceName"`
+		DevEUI        string `json:"devEui"`
+	} `json:"deviceInfo"`
+	FCnt   uint32 `json:"fCnt"`
+	Data   []byte `json:"data"`
+	RxInfo []struct {
+		RSSI float64 `json:"rssi"`
+		SNR  float64 `json:"snr"`
+	} `json:"rxInfo"`
+}
+
+// AddIntegration registers a TTN or ChirpStack integration. Its uplinks are
+// decoded with p and appear as heard by a gateway named after the integration,
+// so downlinks to its vehicles are routed back through the network server.
+// Webhooks must carry the integration's token.
+func (f *FogServer) AddIntegration(c model.IntegrationConfig, p parser.Parser) error {
+	c.Kind = strings.ToLower(c.Kind)
+	switch {
+	case c.ID == "":
+		return errors.New("integration id required")
+	case c.Kind != LNSTheThingsStack && c.Kind != LNSChirpStack:
+		return fmt.Errorf("integration %s: unknown kind %q", c.ID, c.Kind)
+	case p == nil:
+		return fmt.Errorf("integration %s: unknown format %q", c.ID, c.Format)
+	case c.Token == "":
+		return fmt.Errorf("integration %s: token required", c.ID)
+	}
+	if c.FPort == 0 {
+		c.FPort = 1
+	}
+	in := &lnsIntegration{
+		cfg:     c,
+		parser:  p,
+		client:  &http.Client{Timeout: lnsTimeout},
+		mapped:  map[string]bool{},
+		devices: map[string]lnsDevice{},
+	}
+	if c.APIURL != "" {
+		u, err := url.Parse(c.APIURL)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("integration %s: invalid api_url %q", c.ID, c.APIURL)
+		}
+		in.api = u
+	}
+	for _, v := range c.Devices {
+		in.mapped[v] = true
+	}
+	f.lns[c.ID] = in
+	f.reg.setGateway(c.ID, lnsRouteScheme+c.ID)
+	log.Printf("[fog] %s integration %s at /api/integrations/%s", c.Kind, c.ID, c.ID)
+	return nil
+}
+
+// handleIntegration accepts webhook uplinks on /api/integrations/{id}.
+// Other event types (joins, acks, status) are acknowledged and ignored.
+func (f *FogServer) handleIntegration(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if cerr := r.Body.Close(); cerr != nil {
+			log.Printf("[fog] warning: close integration body: %v", cerr)
+		}
+	}()
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/integrations/"), "/")
+	in, ok := f.lns[id]
+	if !ok {
+		http.Error(w, "unknown integration", http.StatusNotFound)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+in.cfg.Token)) != 1 {
+		log.Printf("[fog] integration %s: webhook from %s refused: bad or missing token", id, r.RemoteAddr)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	up, ok, err := in.parseUplink(r, body)
+	if err != nil {
+		log.Printf("[fog] integration %s: invalid uplink: %v", id, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	vd, err := in.parser.DecodeTelemetry(strings.TrimSpace(string(up.payload)))
+	if err != nil {
+		mDecodeErrors.With("fog", in.cfg.Format).Inc()
+		log.Printf("[fog] integration %s: decode %s payload: %v", id, up.dev.DeviceID, err)
+		http.Error(w, "undecodable payload", http.StatusUnprocessableEntity)
+		return
+	}
+	mapped := in.vehicleFor(up.dev)
+	if mapped != "" {
+		vd.VehicleID = mapped
+	}
+	if vd.VehicleID == "" {
+		vd.VehicleID = up.dev.DeviceID
+	}
+	if vd.FCnt == 0 {
+		vd.FCnt = up.fcnt
+	}
+	meta := model.UplinkMeta{GatewayID: id, RSSI: up.rssi, SNR: up.snr, ReceivedAt: time.Now()}
+	if mapped == "" && (vd.VehicleID != up.dev.DeviceID || in.mapped[vd.VehicleID]) {
+		// an unmapped device speaks only for the vehicle named like it;
+		// otherwise it would take over another vehicle's downlinks
+		log.Printf("[fog] integration %s: unmapped device %s claims vehicle %s", id, up.dev.DeviceID, vd.VehicleID)
+		http.Error(w, "device may not report this vehicle", http.StatusForbidden)
+		return
+	}
+	if mapped != "" {
+		// devices mapped in the integration's configuration are trusted
+		f.collect(vd, meta)
+	} else if err := f.ingest(vd, meta); err != nil {
+		log.Printf("[fog] integration %s: uplink from %s dropped: %v", id, up.dev.DeviceID, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	in.mu.Lock()
+	in.devices[vd.VehicleID] = up.dev
+	in.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+// parseUplink normalises the network server's JSON. ok is false for
+// messages that are not uplinks.
+func (in *lnsIntegration) parseUplink(r *http.Request, body []byte) (up lnsUplink, ok bool, err error) {
+	switch in.cfg.Kind {
+	case LNSTheThingsStack:
+		var m ttnUplink
+		if err := json.Unmarshal(body, &m); err != nil {
+			return up, false, err
+		}
+		if m.UplinkMessage == nil {
+			return up, false, nil
+		}
+		up.dev = lnsDevice{
+			DeviceID: m.EndDeviceIDs.DeviceID,
+			DevEUI:   m.EndDeviceIDs.DevEUI,
+			AppID:    m.EndDeviceIDs.ApplicationIDs.ApplicationID,
+		}
+		if push := r.Header.Get("X-Downlink-Push"); push != "" {
+			if in.onAPIHost(push) {
+				up.dev.PushURL, up.dev.PushKey = push, r.Header.Get("X-Downlink-Apikey")
+			} else {
+				log.Printf("[fog] integration %s: ignoring downlink push URL %q outside api_url", in.cfg.ID, push)
+			}
+		}
+		up.fcnt = m.UplinkMessage.FCnt
+		up.payload = m.UplinkMessage.FRMPayload
+		for i, rx := range m.UplinkMessage.RxMetadata {
+			if i == 0 || rx.RSSI > up.rssi {
+				up.rssi, up.snr = rx.RSSI, rx.SNR
+			}
+		}
+	case LNSChirpStack:
+		if ev := r.URL.Query().Get("event"); ev != "" && ev != "up" {
+			return up, false, nil
+		}
+		var m chirpUplink
+		if err := json.Unmarshal(body, &m); err != nil {
+			return up, false, err
+		}
+		up.dev = lnsDevice{
+			DeviceID: m.DeviceInfo.DeviceName,
+			DevEUI:   m.DeviceInfo.DevEUI,
+			AppID:    m.DeviceInfo.ApplicationID,
+		}
+		up.fcnt = m.FCnt
+		up.payload = m.Data
+		for i, rx := range m.RxInfo {
+			if i == 0 || rx.RSSI > up.rssi {
+				up.rssi, up.snr = rx.RSSI, rx.SNR
+			}
+		}
+	}
+	if len(up.payload) == 0 {
+		return up, false, errors.New("empty payload")
+	}
+	return up, true, nil
+}
+
+// onAPIHost reports whether target has the scheme and host of api_url, so a
+// webhook cannot redirect downlinks and their API key elsewhere.
+func (in *lnsIntegration) onAPIHost(target string) bool {
+	u, err := url.Parse(target)
+	return err == nil && in.api != nil &&
+		strings.EqualFold(u.Scheme, in.api.Scheme) && strings.EqualFold(u.Host, in.api.Host)
+}
+
+// vehicleFor maps a device to a configured vehicle ID by device ID or DevEUI.
+func (in *lnsIntegration) vehicleFor(d lnsDevice) string {
+	if v, ok := in.cfg.Devices[d.DeviceID]; ok {
+		return v
+	}
+	return in.cfg.Devices[d.DevEUI]
+}
+
+// sendDown encodes ctl with the integration's parser and queues it on the
+// network server for the vehicle's device.
+func (in *lnsIntegration) sendDown(ctl model.ControlData) error {
+	in.mu.Lock()
+	dev, ok := in.devices[ctl.VehicleID]
+	in.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("integration %s has not heard vehicle %s", in.cfg.ID, ctl.VehicleID)
+	}
+	line, err := in.parser.EncodeControl(ctl)
+	if err != nil {
+		return fmt.Errorf("encode downlink: %w", err)
+	}
+
+	var target, key string
+	var body any
+	switch in.cfg.Kind {
+	case LNSTheThingsStack:
+		target, key = dev.PushURL, dev.PushKey
+		if target == "" || key == "" {
+			target = fmt.Sprintf("%s/api/v3/as/applications/%s/webhooks/%s/devices/%s/down/push",
+				strings.TrimRight(in.cfg.APIURL, "/"), url.PathEscape(dev.AppID),
+				url.PathEscape(in.cfg.WebhookID), url.PathEscape(dev.DeviceID))
+			key = in.cfg.APIKey
+		}
+		type ttnDownlink struct {
+			FPort      int    `json:"f_port"`
+			FRMPayload []byte `json:"frm_payload"`
+			Priority   string `json:"priority"`
+		}
+		body = map[string][]ttnDownlink{"downlinks": {{FPort: in.cfg.FPort, FRMPayload: []byte(line), Priority: "NORMAL"}}}
+	case LNSChirpStack:
+		target = fmt.Sprintf("%s/api/devices/%s/queue", strings.TrimRight(in.cfg.APIURL, "/"), url.PathEscape(dev.DevEUI))
+		key = in.cfg.APIKey
+		type chirpQueueItem struct {
+			Confirmed bool   `json:"confirmed"`
+			Data      []byte `json:"data"`
+			FPort     int    `json:"fPort"`
+		}
+		body = map[string]chirpQueueItem{"queueItem": {Data: []byte(line), FPort: in.cfg.FPort}}
+	}
+	if !strings.HasPrefix(target, "http") {
+		return fmt.Errorf("integration %s: no downlink API configured", in.cfg.ID)
+	}
+	return in.post(target, key, body)
+}
+
+// post sends a JSON request with a bearer key and fails on non-2xx answers.
+func (in *lnsIntegration) post(target, key string, body any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	resp, err := in.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := resp.Body.Close(); cerr != nil {
+			log.Printf("[fog] warning: close integration response: %v", cerr)
+		}
+	}()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		log.Printf("[fog] warning: discard integration response: %v", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s answered %s", in.cfg.Kind, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"LoraFog/internal/model"
+	"LoraFog/internal/parser"
+)
+
+// lnsCall is one downlink API request received by the network server stand-in.
+type lnsCall struct {
+	path string
+	auth string
+	body []byte
+}
+
+// newLNSStandIn serves a network server downlink API that records every call.
+func newLNSStandIn(t *testing.T) (*httptest.Server, chan lnsCall) {
+	t.Helper()
+	calls := make(chan lnsCall, 8)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		calls <- lnsCall{path: r.URL.Path, auth: r.Header.Get("Authorization"), body: body}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, calls
+}
+
+// postWebhook delivers one webhook to the fog and returns the status code.
+func postWebhook(f *FogServer, target, token string, header map[string]string, body string) int {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	f.handleIntegration(rec, req)
+	return rec.Code
+}
+
+// ttnWebhook builds a The Things Stack uplink message carrying line.
+func ttnWebhook(device, line string) string {
+	return fmt.Sprintf(`{"end_device_ids":{"device_id":%q,"dev_eui":"70B3D57ED0000001","application_ids":{"application_id":"app1"}},`+
+		`"uplink_message":{"f_cnt":7,"frm_payload":%q,"rx_metadata":[{"rssi":-110,"snr":2},{"rssi":-80,"snr":9.5}]}}`,
+		device, base64.StdEncoding.EncodeToString([]byte(line)))
+}
+
+// chirpWebhook builds a ChirpStack "up" event carrying line.
+func chirpWebhook(device, devEUI, line string) string {
+	return fmt.Sprintf(`{"deviceInfo":{"applicationId":"app1","deviceName":%q,"devEui":%q},"fCnt":3,"data":%q,"rxInfo":[{"rssi":-95,"snr":4}]}`,
+		device, devEUI, base64.StdEncoding.EncodeToString([]byte(line)))
+}
+
+// lastUplinkOf returns the last de-duplicated uplink of vehicle.
+func lastUplinkOf(f *FogServer, vehicle string) (model.Uplink, bool) {
+	f.uplinkMu.RLock()
+	defer f.uplinkMu.RUnlock()
+	up, ok := f.lastUplink[vehicle]
+	return up, ok
+}
+
+// receiveCall waits for the next downlink API call.
+func receiveCall(t *testing.T, calls chan lnsCall) lnsCall {
+	t.Helper()
+	select {
+	case c := <-calls:
+		return c
+	default:
+		t.Fatal("no downlink API call")
+		return lnsCall{}
+	}
+}
+
+func TestLNSTheThingsStack(t *testing.T) {
+	api, calls := newLNSStandIn(t)
+	f := NewFogServer("127.0.0.1:0", "")
+	f.SetDedupWindow(0)
+	if err := f.AddIntegration(model.IntegrationConfig{ID: "TTN-SITE1", Kind: "ttn"}, parser.NewCSVParser()); err == nil {
+		t.Fatal("integration without token accepted")
+	}
+	err := f.AddIntegration(model.IntegrationConfig{
+		ID: "TTN-SITE1", Kind: "ttn", Format: "csv", Token: "secret",
+		APIURL: api.URL, APIKey: "NNSXS.key", WebhookID: "lorafog",
+		Devices: map[string]string{"rover-1": "VH05"},
+	}, parser.NewCSVParser())
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := "/api/integrations/TTN-SITE1"
+	line := "VH99,10.100000,106.200000,90,95,40,42,1"
+
+	if code := postWebhook(f, target, "", nil, ttnWebhook("rover-1", line)); code != http.StatusUnauthorized {
+		t.Fatalf("webhook without token: %d", code)
+	}
+	if code := postWebhook(f, target, "wrong", nil, ttnWebhook("rover-1", line)); code != http.StatusUnauthorized {
+		t.Fatalf("webhook with wrong token: %d", code)
+	}
+
+	// a push URL off the API host is ignored, the API URL is used instead
+	evil := map[string]string{"X-Downlink-Push": "http://evil.example/push", "X-Downlink-Apikey": "stolen"}
+	if code := postWebhook(f, target, "secret", evil, ttnWebhook("rover-1", line)); code != http.StatusOK {
+		t.Fatalf("mapped uplink: %d", code)
+	}
+	up, ok := lastUplinkOf(f, "VH05")
+	if !ok {
+		t.Fatal("mapped uplink not published as VH05")
+	}
+	if up.Data.Latitude != 10.1 || up.Data.LeftSpeed != 40 || up.Data.FCnt != 7 {
+		t.Errorf("decoded %+v", up.Data)
+	}
+	if up.Meta.GatewayID != "TTN-SITE1" || up.Meta.RSSI != -80 || up.Meta.SNR != 9.5 {
+		t.Errorf("meta %+v", up.Meta)
+	}
+
+	ctl := model.ControlData{VehicleID: "VH05", Mode: 2, Speed: 30, Latitude: 10.2, Longitude: 106.3}
+	if err := f.lns["TTN-SITE1"].sendDown(ctl); err != nil {
+		t.Fatal(err)
+	}
+	c := receiveCall(t, calls)
+	if c.path != "/api/v3/as/applications/app1/webhooks/lorafog/devices/rover-1/down/push" || c.auth != "Bearer NNSXS.key" {
+		t.Errorf("downlink to %s with %q", c.path, c.auth)
+	}
+	var push struct {
+		Downlinks []struct {
+			FPort      int    `json:"f_port"`
+			FRMPayload []byte `json:"frm_payload"`
+		} `json:"downlinks"`
+	}
+	if err := json.Unmarshal(c.body, &push); err != nil || len(push.Downlinks) != 1 {
+		t.Fatalf("downlink body %s: %v", c.body, err)
+	}
+	want, _ := parser.NewCSVParser().EncodeControl(ctl)
+	if got := push.Downlinks[0]; got.FPort != 1 || string(got.FRMPayload) != want {
+		t.Errorf("downlink f_port %d payload %q, want %q", got.FPort, got.FRMPayload, want)
+	}
+
+	// a push URL on the API host is used with its own key
+	own := map[string]string{"X-Downlink-Push": api.URL + "/custom/push", "X-Downlink-Apikey": "push-key"}
+	if code := postWebhook(f, target, "secret", own, ttnWebhook("rover-1", line)); code != http.StatusOK {
+		t.Fatalf("mapped uplink: %d", code)
+	}
+	if err := f.lns["TTN-SITE1"].sendDown(ctl); err != nil {
+		t.Fatal(err)
+	}
+	if c := receiveCall(t, calls); c.path != "/custom/push" || c.auth != "Bearer push-key" {
+		t.Errorf("downlink to %s with %q", c.path, c.auth)
+	}
+
+	// another device cannot take over a mapped vehicle
+	if code := postWebhook(f, target, "secret", nil, ttnWebhook("intruder", "VH05,1,2,0,0,0,0,0")); code != http.StatusForbidden {
+		t.Errorf("unmapped device claiming VH05: %d", code)
+	}
+}
+
+func TestLNSChirpStack(t *testing.T) {
+	api, calls := newLNSStandIn(t)
+	f := NewFogServer("127.0.0.1:0", "")
+	f.SetDedupWindow(0)
+	f.SetFleet([]string{"VH07"})
+	f.RegisterGateway("GW01", "http://127.0.0.1:1", []string{"VH01"})
+	err := f.AddIntegration(model.IntegrationConfig{
+		ID: "CS-SITE2", Kind: "chirpstack", Format: "csv", Token: "secret",
+		APIURL: api.URL + "/", APIKey: "cs-key", FPort: 5,
+		Devices: map[string]string{"0102030405060708": "VH06"},
+	}, parser.NewCSVParser())
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := "/api/integrations/CS-SITE2?event=up"
+
+	if code := postWebhook(f, "/api/integrations/CS-SITE2?event=join", "secret", nil, `{}`); code != http.StatusNoContent {
+		t.Errorf("join event: %d", code)
+	}
+	if code := postWebhook(f, target, "secret", nil, chirpWebhook("rover-6", "0102030405060708", "XX,10.3,106.4,0,0,0,0,0")); code != http.StatusOK {
+		t.Fatalf("mapped uplink: %d", code)
+	}
+	if up, ok := lastUplinkOf(f, "VH06"); !ok || up.Data.Latitude != 10.3 || up.Meta.RSSI != -95 {
+		t.Fatalf("mapped uplink %+v (%v)", up, ok)
+	}
+
+	// unmapped devices report only the fleet vehicle named like them
+	unmapped := []struct {
+		name, device, line string
+		want               int
+	}{
+		{"own fleet vehicle", "VH07", "VH07,10.5,106.5,0,0,0,0,0", http.StatusOK},
+		{"another fleet vehicle", "rover-7", "VH07,10.5,106.5,0,0,0,0,0", http.StatusForbidden},
+		{"vehicle of a radio gateway", "rover-9", "VH01,10.5,106.5,0,0,0,0,0", http.StatusForbidden},
+		{"vehicle outside the fleet", "VH08", "VH08,10.5,106.5,0,0,0,0,0", http.StatusForbidden},
+		{"mapped vehicle", "VH06", "VH06,10.5,106.5,0,0,0,0,0", http.StatusForbidden},
+	}
+	for i, c := range unmapped {
+		devEUI := fmt.Sprintf("0A0B0C0D0E0F10%02X", i)
+		if code := postWebhook(f, target, "secret", nil, chirpWebhook(c.device, devEUI, c.line)); code != c.want {
+			t.Errorf("%s: %d, want %d", c.name, code, c.want)
+		}
+	}
+	if up, ok := lastUplinkOf(f, "VH01"); ok {
+		t.Errorf("VH01 taken over by %+v", up.Meta)
+	}
+
+	ctl := model.ControlData{VehicleID: "VH06", Mode: 1, Latitude: 10.4, Longitude: 106.5}
+	if err := f.lns["CS-SITE2"].sendDown(ctl); err != nil {
+		t.Fatal(err)
+	}
+	c := receiveCall(t, calls)
+	if c.path != "/api/devices/0102030405060708/queue" || c.auth != "Bearer cs-key" {
+		t.Errorf("downlink to %s with %q", c.path, c.auth)
+	}
+	var queue struct {
+		QueueItem struct {
+			Data  []byte `json:"data"`
+			FPort int    `json:"fPort"`
+		} `json:"queueItem"`
+	}
+	if err := json.Unmarshal(c.body, &queue); err != nil {
+		t.Fatalf("downlink body %s: %v", c.body, err)
+	}
+	want, _ := parser.NewCSVParser().EncodeControl(ctl)
+	if queue.QueueItem.FPort != 5 || string(queue.QueueItem.Data) != want {
+		t.Errorf("queue item fPort %d data %q, want %q", queue.QueueItem.FPort, queue.QueueItem.Data, want)
+	}
+}
@@ -0,0 +1,139 @@
+package core
+
+import (
+	"slices"
+	"strings"
+
+	"LoraFog/internal/device"
+	"LoraFog/internal/lora"
+	"LoraFog/internal/metrics"
+)
+
+// Fog, gateway and vehicle metrics, served on /metrics by every HTTP
+// endpoint of the process.
+var (
+	mFogUplinks = metrics.NewCounter("lorafog_fog_uplinks_total",
+		"Uplink copies received by the fog, before de-duplication.", "gateway", "vehicle")
+	mFogDownlinks = metrics.NewCounter("lorafog_fog_downlinks_total",
+		"Control deliveries attempted by the fog, by result (ok, error, or rejected when the gateway is out of airtime).", "gateway", "vehicle", "result")
+	mFogRejected = metrics.NewCounter("lorafog_fog_uplinks_rejected_total",
+		"Uplink copies dropped by the fog because the vehicle is not in its fleet.", "gateway")
+	mFogAppForwardFailures = metrics.NewCounter("lorafog_fog_app_forward_failures_total",
+		"Telemetry forwards from the fog to the app that failed.")
+	mWSClients = metrics.NewGaugeFunc("lorafog_fog_websocket_clients",
+		"Connected fog websocket clients.")
+
+	mGatewayRx = metrics.NewCounter("lorafog_gateway_rx_frames_total",
+		"Telemetry frames decoded from the radio by a gateway.", "gateway", "vehicle")
+	mGatewayUplinks = metrics.NewCounter("lorafog_gateway_uplinks_total",
+		"Uplinks forwarded by a gateway to the fog.", "gateway", "vehicle")
+	mGatewayForwardFailures = metrics.NewCounter("lorafog_gateway_forward_failures_total",
+		"Uplinks a gateway failed to forward to the fog, by transport.", "gateway", "transport")
+	mGatewayUnmanaged = metrics.NewCounter("lorafog_gateway_unmanaged_drops_total",
+		"Frames dropped by a gateway because it does not manage the vehicle.", "gateway", "vehicle")
+	mGatewayDownlinks = metrics.NewCounter("lorafog_gateway_downlinks_total",
+		"Downlinks transmitted by a gateway to a vehicle.", "gateway", "vehicle")
+	mDownlinkQueue = metrics.NewGaugeFunc("lorafog_gateway_downlink_queue_depth",
+		"Class A downlinks waiting for a receive window.", "gateway")
+
+	mVehicleTelemetry = metrics.NewCounter("lorafog_vehicle_telemetry_sent_total",
+		"Telemetry frames transmitted by a vehicle.", "vehicle")
+	mVehicleControls = metrics.NewCounter("lorafog_vehicle_controls_received_total",
+		"Control frames received and decoded by a vehicle.", "vehicle")
+
+	// Counters pushed by vehicles over LoRa and exposed by the gateway that heard them
+	mReportTelemetry = metrics.NewCounter("lorafog_vehicle_reported_telemetry_sent_total",
+		"Telemetry frames transmitted, as last reported by the vehicle.", "vehicle")
+	mReportControls = metrics.NewCounter("lorafog_vehicle_reported_controls_received_total",
+		"Control frames received, as last reported by the vehicle.", "vehicle")
+	mReportDecodeErrors = metrics.NewCounter("lorafog_vehicle_reported_decode_errors_total",
+		"Control frames that failed to decode, as last reported by the vehicle.", "vehicle")
+	mReportReconnects = metrics.NewCounter("lorafog_vehicle_reported_serial_reconnects_total",
+		"LoRa serial reopens, as last reported by the vehicle.", "vehicle")
+	mReportAirtime = metrics.NewCounter("lorafog_vehicle_reported_airtime_seconds_total",
+		"LoRa airtime used, as last reported by the vehicle.", "vehicle")
+	mReportTime = metrics.NewGauge("lorafog_vehicle_report_timestamp_seconds",
+		"Unix time of the last metrics frame received from the vehicle.", "vehicle")
+
+	mSinkWritten = metrics.NewCounter("lorafog_sink_points_written_total",
+		"Uplinks written by a telemetry sink.", "sink")
+	mSinkDropped = metrics.NewCounter("lorafog_sink_points_dropped_total",
+		"Uplinks a telemetry sink dropped: queue full, rejected, or unwritten at shutdown.", "sink")
+	mSinkFailures = metrics.NewCounter("lorafog_sink_write_failures_total",
+		"Failed telemetry sink writes, retried or rejected.", "sink")
+	mSinkQueue = metrics.NewGaugeFunc("lorafog_sink_queue_depth",
+		"Uplinks waiting in a telemetry sink queue.", "sink")
+
+	mDecodeErrors = metrics.NewCounter("lorafog_decode_errors_total",
+		"Frames that failed to decode, by component and parser.", "component", "parser")
+	mSerialReconnects = metrics.NewCounter("lorafog_serial_reconnects_total",
+		"Serial device reopens after the first open.", "node")
+	mAirtime = metrics.NewCounterFunc("lorafog_airtime_seconds_total",
+		"LoRa airtime used by a duty-cycled radio.", "node")
+	mAirtimeAvailable = metrics.NewGaugeFunc("lorafog_airtime_available_seconds",
+		"Airtime left in the current duty-cycle window.", "node")
+)
+
+// otherVehicle labels the series of vehicles outside the fleet, so unknown
+// or spoofed vehicle IDs cannot grow the metric set without bound.
+const otherVehicle = "other"
+
+// otherGateway labels the series of gateway IDs the fog does not know, for
+// the same reason.
+const otherGateway = "other"
+
+// vehicleLabel returns v as a metric label if the gateway manages it or the
+// fog lists it in the fleet, and otherVehicle otherwise.
+func (g *Gateway) vehicleLabel(v string) string {
+	if _, ok := g.VehicleSet[v]; ok {
+		return v
+	}
+	g.allowMu.RLock()
+	defer g.allowMu.RUnlock()
+	if slices.Contains(g.allowlist, v) {
+		return v
+	}
+	return otherVehicle
+}
+
+// vehicleLabel returns v as a metric label if it is in the fleet, and
+// otherVehicle otherwise.
+func (f *FogServer) vehicleLabel(v string) string {
+	if f.reg.inFleet(v) {
+		return v
+	}
+	return otherVehicle
+}
+
+// gatewayLabel returns id as a metric label if the gateway is in the
+// registry (configured, an integration or registered with its token) or has
+// a token, and otherGateway otherwise.
+func (f *FogServer) gatewayLabel(id string) string {
+	f.reg.mu.RLock()
+	_, ok := f.reg.gateways[id]
+	f.reg.mu.RUnlock()
+	if !ok {
+		f.mu.Lock()
+		_, ok = f.gwTokens[id]
+		f.mu.Unlock()
+	}
+	if ok {
+		return id
+	}
+	return otherGateway
+}
+
+// metricNode turns a log owner such as "gateway GW01" into a node label.
+func metricNode(owner string) string {
+	return strings.Replace(owner, " ", "/", 1)
+}
+
+// trackAirtime exports the airtime accounting of dev under node, if it is duty-cycled.
+func trackAirtime(node string, dev device.Device) {
+	d, ok := dev.(*lora.DutyCycledDevice)
+	if !ok {
+		return
+	}
+	mAirtime.Set(func() float64 { return d.Usage().Airtime.Seconds() }, node)
+	mAirtimeAvailable.Set(func() float64 { return d.Usage().Available.Seconds() }, node)
+}
@@ -0,0 +1,23 @@
+package core
+
+import "testing"
+
+func TestMetricLabelsOnlyKnownIDs(t *testing.T) {
+	f := NewFogServer("127.0.0.1:0", "")
+	f.SetFleet([]string{"VH01"})
+	f.SetGatewayTokens(map[string]string{"GW02": "secret"})
+	f.reg.setGateway("GW01", "http://127.0.0.1:1")
+
+	gateways := map[string]string{"GW01": "GW01", "GW02": "GW02", "GW99": otherGateway, "": otherGateway}
+	for id, want := range gateways {
+		if got := f.gatewayLabel(id); got != want {
+			t.Errorf("gatewayLabel(%q) = %q, want %q", id, got, want)
+		}
+	}
+	vehicles := map[string]string{"VH01": "VH01", "VH99": otherVehicle}
+	for id, want := range vehicles {
+		if got := f.vehicleLabel(id); got != want {
+			t.Errorf("vehicleLabel(%q) = %q, want %q", id, got, want)
+		}
+	}
+}
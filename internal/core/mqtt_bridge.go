@@ -0,0 +1,210 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"LoraFog/internal/model"
+	"LoraFog/internal/parser"
+)
+
+const (
+	defaultMQTTPrefix   = "lorafog"
+	defaultMQTTClientID = "lorafog-fog"
+	mqttTimeout         = 5 * time.Second
+)
+
+// mqttBridge publishes fog telemetry to an MQTT broker and feeds control
+// messages from the broker into the fog's control path.
+//
+// Topics, with the default prefix:
+//
+//	lorafog/{gateway}/{vehicle}/telemetry  published, JSON VehicleData
+//	lorafog/{vehicle}/control              subscribed, JSON or CSV ControlData
+type mqttBridge struct {
+	cfg    model.MQTTConfig
+	fog    *FogServer
+	client atomic.Pointer[mqtt.Client]
+
+	published atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+// SetMQTT enables the MQTT bridge; an empty broker leaves it disabled.
+func (f *FogServer) SetMQTT(c model.MQTTConfig) Component {
+	if c.Broker == "" {
+		return nil
+	}
+	if c.TopicPrefix == "" {
+		c.TopicPrefix = defaultMQTTPrefix
+	}
+	if c.ClientID == "" {
+		c.ClientID = defaultMQTTClientID
+	}
+	if c.QoS > 2 {
+		log.Printf("[config] invalid mqtt qos %d, using 0", c.QoS)
+		c.QoS = 0
+	}
+	b := &mqttBridge{cfg: c, fog: f}
+	f.mqtt = b
+	return b
+}
+
+// Name implements Component.
+func (b *mqttBridge) Name() string { return "mqtt" }
+
+// Dependencies implements Component; control messages need the fog.
+func (b *mqttBridge) Dependencies() []string { return []string{b.fog.Name()} }
+
+// Run implements Component. It keeps a broker session open, reconnecting
+// in the background, until ctx is cancelled.
+func (b *mqttBridge) Run(ctx context.Context) error {
+	opts := mqtt.NewClientOptions().
+		AddBroker(b.cfg.Broker).
+		SetClientID(b.cfg.ClientID).
+		SetUsername(b.cfg.Username).
+		SetPassword(b.cfg.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(2 * time.Second).
+		SetMaxReconnectInterval(30 * time.Second).
+		SetOnConnectHandler(b.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("[mqtt] connection lost: %v", err)
+		})
+	if strings.HasPrefix(b.cfg.Broker, "ssl://") || strings.HasPrefix(b.cfg.Broker, "tls://") ||
+		strings.HasPrefix(b.cfg.Broker, "wss://") || b.cfg.TLS.CAFile != "" || b.cfg.TLS.CertFile != "" {
+		tc, err := mqttTLS(b.cfg.TLS)
+		if err != nil {
+			return fmt.Errorf("mqtt tls: %w", err)
+		}
+		opts.SetTLSConfig(tc)
+	}
+
+	client := mqtt.NewClient(opts)
+	b.client.Store(&client)
+	defer b.client.Store(nil)
+	log.Printf("[mqtt] connecting to %s as %s", b.cfg.Broker, b.cfg.ClientID)
+	client.Connect() // retries in the background until connected
+
+	<-ctx.Done()
+	client.Disconnect(250)
+	log.Printf("[mqtt] disconnected (published=%d dropped=%d)", b.published.Load(), b.dropped.Load())
+	return nil
+}
+
+// mqttTLS builds the TLS configuration from files.
+func mqttTLS(c model.MQTTTLSConfig) (*tls.Config, error) {
+	tc := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", c.CAFile)
+		}
+		tc.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
+
+// onConnect (re)subscribes to control topics after every connect.
+func (b *mqttBridge) onConnect(c mqtt.Client) {
+	topic := b.cfg.TopicPrefix + "/+/control"
+	tok := c.Subscribe(topic, b.cfg.QoS, b.handleControl)
+	if !tok.WaitTimeout(mqttTimeout) || tok.Error() != nil {
+		log.Printf("[mqtt] subscribe %s failed: %v", topic, tok.Error())
+		return
+	}
+	log.Printf("[mqtt] connected to %s, subscribed to %s", b.cfg.Broker, topic)
+}
+
+// topicSegment makes an ID safe to use as a single topic level.
+func topicSegment(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(s)
+}
+
+// publishTelemetry publishes one de-duplicated uplink. It never blocks the
+// fog: while the broker is unreachable messages are dropped and counted.
+func (b *mqttBridge) publishTelemetry(gatewayID string, vd model.VehicleData) {
+	if b == nil {
+		return
+	}
+	cp := b.client.Load()
+	if cp == nil || !(*cp).IsConnectionOpen() {
+		b.dropped.Add(1)
+		return
+	}
+	payload, err := json.Marshal(vd)
+	if err != nil {
+		log.Printf("[mqtt] encode telemetry err: %v", err)
+		return
+	}
+	topic := fmt.Sprintf("%s/%s/%s/telemetry", b.cfg.TopicPrefix, topicSegment(gatewayID), topicSegment(vd.VehicleID))
+	tok := (*cp).Publish(topic, b.cfg.QoS, b.cfg.Retain, payload)
+	go func() {
+		if !tok.WaitTimeout(mqttTimeout) || tok.Error() != nil {
+			b.dropped.Add(1)
+			log.Printf("[mqtt] publish %s failed: %v", topic, tok.Error())
+			return
+		}
+		b.published.Add(1)
+	}()
+}
+
+// handleControl decodes a control message and submits it like POST /api/control.
+// The vehicle in the topic wins over the payload's vehicle_id.
+func (b *mqttBridge) handleControl(_ mqtt.Client, msg mqtt.Message) {
+	parts := strings.Split(msg.Topic(), "/")
+	if len(parts) < 2 {
+		return
+	}
+	vehicleID := parts[len(parts)-2]
+
+	var ctl model.ControlData
+	if err := json.Unmarshal(msg.Payload(), &ctl); err != nil {
+		csvp := parser.NewCSVParser()
+		ctl2, err2 := csvp.DecodeControl(strings.TrimSpace(string(msg.Payload())))
+		if err2 != nil {
+			log.Printf("[mqtt] invalid control on %s: %v", msg.Topic(), err2)
+			return
+		}
+		ctl = ctl2
+	}
+	ctl.VehicleID = vehicleID
+
+	id, err := b.fog.submitControl(ctl, nil, nil)
+	if err != nil {
+		log.Printf("[mqtt] control for %s rejected: %v", vehicleID, err)
+		return
+	}
+	log.Printf("[mqtt] control for %s accepted as %s", vehicleID, id)
+}
+
+// connected reports whether the bridge currently has a broker session.
+func (b *mqttBridge) connected() bool {
+	cp := b.client.Load()
+	return cp != nil && (*cp).IsConnectionOpen()
+}
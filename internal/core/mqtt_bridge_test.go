@@ -0,0 +1,124 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+
+	"LoraFog/internal/model"
+)
+
+// startTestBroker serves an open mochi broker on a loopback port and
+// returns it with its tcp:// address.
+func startTestBroker(t *testing.T) (*mochi.Server, string) {
+	t.Helper()
+	srv := mochi.New(&mochi.Options{InlineClient: true})
+	if err := srv.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+	tcp := listeners.NewTCP(listeners.Config{ID: "test", Address: "127.0.0.1:0"})
+	if err := srv.AddListener(tcp); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Serve(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := srv.Close(); err != nil {
+			t.Logf("close broker: %v", err)
+		}
+	})
+	return srv, "tcp://" + tcp.Address()
+}
+
+// waitFor polls cond until it holds or the test times out.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMQTTBridgeTelemetryAndControl(t *testing.T) {
+	srv, addr := startTestBroker(t)
+
+	// stand-in gateway receiving the control the bridge submits
+	commands := make(chan model.ControlData, 1)
+	gw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var ctl model.ControlData
+		if r.URL.Path != "/command" || json.Unmarshal(body, &ctl) != nil {
+			http.Error(w, "bad command", http.StatusBadRequest)
+			return
+		}
+		commands <- ctl
+	}))
+	defer gw.Close()
+
+	f := NewFogServer("127.0.0.1:0", "")
+	f.RegisterGateway("GW01", gw.URL, []string{"VH01"})
+	b := f.SetMQTT(model.MQTTConfig{Broker: addr, QoS: 1}).(*mqttBridge)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- b.Run(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("bridge run: %v", err)
+		}
+	}()
+
+	waitFor(t, "control subscription", func() bool {
+		return len(srv.Topics.Subscribers("lorafog/VH01/control").Subscriptions) > 0
+	})
+
+	// telemetry published by the fog lands on the gateway/vehicle topic
+	telemetry := make(chan packets.Packet, 1)
+	if err := srv.Subscribe("lorafog/+/+/telemetry", 1, func(_ *mochi.Client, _ packets.Subscription, pk packets.Packet) {
+		telemetry <- pk
+	}); err != nil {
+		t.Fatal(err)
+	}
+	b.publishTelemetry("GW01", model.VehicleData{VehicleID: "VH01", Latitude: 10.5, Longitude: 106.7})
+	select {
+	case pk := <-telemetry:
+		if pk.TopicName != "lorafog/GW01/VH01/telemetry" {
+			t.Errorf("telemetry topic = %q", pk.TopicName)
+		}
+		var vd model.VehicleData
+		if err := json.Unmarshal(pk.Payload, &vd); err != nil || vd.VehicleID != "VH01" || vd.Latitude != 10.5 {
+			t.Errorf("telemetry payload %s: %v", pk.Payload, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no telemetry published")
+	}
+
+	// control on the vehicle's topic is routed to its gateway; the topic
+	// overrides the payload's vehicle_id
+	payload := []byte(`{"vehicle_id":"VH99","mode":1,"latitude":10.8,"longitude":106.6}`)
+	if err := srv.Publish("lorafog/VH01/control", payload, false, 1); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case ctl := <-commands:
+		if ctl.VehicleID != "VH01" || ctl.Mode != 1 || ctl.Latitude != 10.8 {
+			t.Errorf("gateway got %+v", ctl)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("control not delivered to the gateway")
+	}
+}
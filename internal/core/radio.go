@@ -0,0 +1,111 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"LoraFog/internal/device"
+	"LoraFog/internal/health"
+	"LoraFog/internal/lora"
+	"LoraFog/internal/model"
+)
+
+// wrapRadio wraps dev with a duty-cycle limiter built from rc.
+// It returns dev unchanged when duty-cycle enforcement is disabled.
+func wrapRadio(owner string, dev device.Device, rc model.RadioConfig) device.Device {
+	if dev == nil || rc.DutyCycle <= 0 {
+		return dev
+	}
+	if _, ok := dev.(*lora.DutyCycledDevice); ok {
+		return dev
+	}
+	policy, err := lora.ParsePolicy(rc.Policy)
+	if err != nil {
+		log.Printf("[%s] %v; using %s", owner, err, lora.PolicyQueue)
+		policy = lora.PolicyQueue
+	}
+	params := lora.Params{
+		SpreadingFactor: rc.SpreadingFactor,
+		BandwidthHz:     rc.BandwidthKHz * 1000,
+		CodingRate:      rc.CodingRate,
+		Preamble:        rc.Preamble,
+	}
+	window := time.Duration(rc.DutyWindowS) * time.Second
+	log.Printf("[%s] duty cycle %.2f%% (policy=%s)", owner, rc.DutyCycle*100, policy)
+	d := lora.NewDutyCycledDevice(dev, params, rc.DutyCycle, window, policy, rc.QueueSize)
+	d.SetKeyFunc(lineVehicle)
+	trackAirtime(metricNode(owner), d)
+	return d
+}
+
+// lineVehicle returns the vehicle ID of a CSV or JSON telemetry or control
+// line, so coalescing only replaces lines for the same vehicle.
+func lineVehicle(line string) string {
+	if strings.HasPrefix(strings.TrimSpace(line), "{") {
+		var v struct {
+			VehicleID string `json:"vehicle_id"`
+		}
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			return ""
+		}
+		return v.VehicleID
+	}
+	id, _, _ := strings.Cut(line, ",")
+	return strings.TrimSpace(id)
+}
+
+// transmitNow writes line only if it goes out immediately: a duty-cycled
+// device that would queue it answers ErrDutyCycle instead.
+func transmitNow(dev device.Device, line string) error {
+	if d, ok := dev.(*lora.DutyCycledDevice); ok {
+		return d.TransmitNow(line)
+	}
+	return dev.WriteLine(line)
+}
+
+// airtimeUsage returns the airtime accounting of dev, if it is duty-cycled.
+func airtimeUsage(dev device.Device) (lora.Usage, bool) {
+	if d, ok := dev.(*lora.DutyCycledDevice); ok {
+		return d.Usage(), true
+	}
+	return lora.Usage{}, false
+}
+
+// airtimeCheck reports airtime usage as a health check; it warns while lines are queued.
+func airtimeCheck(dev device.Device) (health.Check, bool) {
+	u, ok := airtimeUsage(dev)
+	if !ok {
+		return health.Check{}, false
+	}
+	c := health.Check{
+		Name:   "airtime",
+		Status: health.StatusOK,
+		Detail: fmt.Sprintf("used %s, available %s of %s, sent %d, queued %d, coalesced %d, rejected %d, dropped %d",
+			u.Airtime.Truncate(time.Millisecond), u.Available.Truncate(time.Millisecond), u.Budget,
+			u.Sent, u.Queued, u.Coalesced, u.Rejected, u.Dropped),
+	}
+	if u.Queued > 0 {
+		c.Status = health.StatusWarn
+	}
+	return c, true
+}
+
+// airtimeHandler serves the airtime accounting of the device returned by dev
+// as JSON, or 404 when it is not duty-cycled.
+func airtimeHandler(owner string, dev func() device.Device) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, ok := airtimeUsage(dev())
+		if !ok {
+			http.Error(w, "duty cycle not enabled", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(u); err != nil {
+			log.Printf("[%s] warning: encode airtime: %v", owner, err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a keyed token bucket: each key may spend burst tokens at
+// once and earns perMinute tokens per minute. Buckets idle long enough to
+// have refilled are evicted, so arbitrary keys do not grow it without bound.
+type rateLimiter struct {
+	perMinute float64
+	burst     float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time // last eviction pass
+}
+
+// limiterSweep is the minimum time between eviction passes.
+const limiterSweep = time.Minute
+
+// bucket is the token state of one key.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter creates a limiter; perMinute <= 0 disables limiting.
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	if burst <= 0 {
+		burst = 1
+	}
+	return &rateLimiter{perMinute: float64(perMinute), burst: float64(burst), buckets: map[string]*bucket{}}
+}
+
+// allow consumes one token for key and reports whether it was available.
+func (l *rateLimiter) allow(key string) bool {
+	if l == nil || l.perMinute <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Sub(l.swept) >= limiterSweep {
+		l.evictLocked(now)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Minutes() * l.perMinute
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// evictLocked drops buckets that have refilled to burst since their last use;
+// a new bucket for the key starts full, so this changes no decision. Caller
+// holds mu.
+func (l *rateLimiter) evictLocked(now time.Time) {
+	full := time.Duration(l.burst / l.perMinute * float64(time.Minute))
+	for k, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, k)
+		}
+	}
+	l.swept = now
+}
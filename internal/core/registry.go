@@ -0,0 +1,365 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"LoraFog/internal/model"
+)
+
+const (
+	// defaultLinkTTL is how long an uplink keeps a gateway eligible for downlinks.
+	defaultLinkTTL = 5 * time.Minute
+	// defaultRecentWindow is how far behind a vehicle's latest uplink a gateway
+	// may have heard it and still rank as currently in range.
+	defaultRecentWindow = 30 * time.Second
+	// defaultGatewayExpiry is how long a registered gateway stays online without a heartbeat.
+	defaultGatewayExpiry = 90 * time.Second
+	// defaultGatewayPurge is how long an expired runtime gateway stays listed.
+	defaultGatewayPurge = 24 * time.Hour
+	// registrySweep is how often expired gateways are purged.
+	registrySweep = time.Minute
+)
+
+// Gateway registry status values.
+const (
+	GatewayStatic  = "static"
+	GatewayOnline  = "online"
+	GatewayExpired = "expired"
+)
+
+// registry tracks gateways (static from configuration or registered at runtime),
+// the static vehicle→gateway map, and which gateways recently heard each vehicle.
+type registry struct {
+	mu         sync.RWMutex
+	gateways   map[string]*model.GatewayStatus        // gateway ID → record
+	vehicleMap map[string]string                      // vehicle ID → static gateway ID
+	fleet      map[string]struct{}                    // configured roaming allowlist
+	cloudFleet map[string]struct{}                    // roaming allowlist of the last cloud update
+	links      map[string]map[string]model.UplinkMeta // vehicle ID → gateway ID → last uplink
+	linkTTL    time.Duration
+	recent     time.Duration // see defaultRecentWindow
+	expiry     time.Duration
+	purge      time.Duration // <= 0 keeps expired gateways
+	path       string        // JSON file persisting dynamic registrations; empty disables
+	saveMu     sync.Mutex
+}
+
+// newRegistry creates an empty registry.
+func newRegistry() *registry {
+	return &registry{
+		gateways:   map[string]*model.GatewayStatus{},
+		vehicleMap: map[string]string{},
+		fleet:      map[string]struct{}{},
+		cloudFleet: map[string]struct{}{},
+		links:      map[string]map[string]model.UplinkMeta{},
+		linkTTL:    defaultLinkTTL,
+		recent:     defaultRecentWindow,
+		expiry:     defaultGatewayExpiry,
+		purge:      defaultGatewayPurge,
+	}
+}
+
+// setGateway records a statically configured gateway.
+func (r *registry) setGateway(id, url string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	g, ok := r.gateways[id]
+	if !ok {
+		g = &model.GatewayStatus{GatewayID: id, RegisteredAt: time.Now()}
+		r.gateways[id] = g
+	}
+	g.URL = url
+	g.Static = true
+}
+
+// set associates a vehicle ID with its statically configured gateway ID.
+func (r *registry) set(v string, gw string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.vehicleMap[v] = gw
+	if g, ok := r.gateways[gw]; ok && !slices.Contains(g.Vehicles, v) {
+		g.Vehicles = append(g.Vehicles, v)
+	}
+}
+
+// register upserts a runtime registration or heartbeat. The registry is
+// persisted only when an entry changes, not on every heartbeat, so the file's
+// last_seen is that of the last change. It reports whether the gateway was
+// new or came back after expiring.
+func (r *registry) register(reg model.GatewayRegistration) bool {
+	now := time.Now()
+	r.mu.Lock()
+	g, ok := r.gateways[reg.GatewayID]
+	fresh := !ok
+	if !ok {
+		g = &model.GatewayStatus{GatewayID: reg.GatewayID, RegisteredAt: now}
+		r.gateways[reg.GatewayID] = g
+		log.Printf("[fog] gateway %s registered (%s)", reg.GatewayID, reg.URL)
+	} else if r.statusLocked(g, now) == GatewayExpired {
+		log.Printf("[fog] gateway %s back online", reg.GatewayID)
+		fresh = true
+	}
+	changed := fresh || (reg.URL != "" && reg.URL != g.URL) || !slices.Equal(g.Vehicles, reg.Vehicles) || g.Version != reg.Version
+	if reg.URL != "" {
+		g.URL = reg.URL
+	}
+	g.Vehicles = append([]string(nil), reg.Vehicles...)
+	g.Version = reg.Version
+	g.LastSeen = now
+	r.mu.Unlock()
+
+	if changed {
+		if err := r.save(); err != nil {
+			log.Printf("[fog] warning: persist gateway registry: %v", err)
+		}
+	}
+	return fresh
+}
+
+// sweep purges runtime gateways that have been expired for longer than the
+// purge period, every registrySweep until ctx is done.
+func (r *registry) sweep(ctx context.Context) {
+	t := time.NewTicker(registrySweep)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if r.purgeExpired(time.Now()) > 0 {
+			if err := r.save(); err != nil {
+				log.Printf("[fog] warning: persist gateway registry: %v", err)
+			}
+		}
+	}
+}
+
+// purgeExpired removes runtime gateways last seen more than expiry+purge
+// ago, with their links, and returns how many it removed. Static gateways
+// from configuration are kept.
+func (r *registry) purgeExpired(now time.Time) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.purge <= 0 {
+		return 0
+	}
+	n := 0
+	for id, g := range r.gateways {
+		if g.Static || now.Sub(g.LastSeen) <= r.expiry+r.purge {
+			continue
+		}
+		delete(r.gateways, id)
+		for _, m := range r.links {
+			delete(m, id)
+		}
+		log.Printf("[fog] gateway %s purged (last seen %s)", id, g.LastSeen.Format(time.RFC3339))
+		n++
+	}
+	return n
+}
+
+// statusLocked computes the current status of g. Caller holds mu.
+func (r *registry) statusLocked(g *model.GatewayStatus, now time.Time) string {
+	switch {
+	case !g.LastSeen.IsZero() && now.Sub(g.LastSeen) <= r.expiry:
+		return GatewayOnline
+	case g.Static:
+		return GatewayStatic
+	default:
+		return GatewayExpired
+	}
+}
+
+// usableLocked reports whether downlinks may be routed through gateway id. Caller holds mu.
+func (r *registry) usableLocked(id string, now time.Time) (*model.GatewayStatus, bool) {
+	g, ok := r.gateways[id]
+	if !ok || g.URL == "" {
+		return nil, false
+	}
+	return g, r.statusLocked(g, now) != GatewayExpired
+}
+
+// list returns a snapshot of all gateways sorted by ID.
+func (r *registry) list() []model.GatewayStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	now := time.Now()
+	out := make([]model.GatewayStatus, 0, len(r.gateways))
+	for _, g := range r.gateways {
+		c := *g
+		c.Vehicles = append([]string{}, g.Vehicles...)
+		c.Status = r.statusLocked(g, now)
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].GatewayID < out[j].GatewayID })
+	return out
+}
+
+// observe records every gateway that heard an uplink.
+func (r *registry) observe(up model.Uplink) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.links[up.Data.VehicleID]
+	if !ok {
+		m = map[string]model.UplinkMeta{}
+		r.links[up.Data.VehicleID] = m
+	}
+	for _, meta := range up.HeardBy {
+		m[meta.GatewayID] = meta
+	}
+}
+
+// downlinkRoute is one candidate gateway for a downlink.
+type downlinkRoute struct {
+	GatewayID string
+	URL       string
+}
+
+// candidates returns gateways able to reach vehicle v, best first.
+// Gateways that heard v within the recent window of its latest uplink come
+// first, ranked by link quality; older links within linkTTL follow, most
+// recent first, so a vehicle that drove away from a strong gateway is not
+// still routed through it. The static gateway from configuration and gateways
+// that list v as managed are appended as fallbacks. Expired gateways are
+// never returned.
+func (r *registry) candidates(v string) []downlinkRoute {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	heard := make([]model.UplinkMeta, 0, len(r.links[v]))
+	for _, meta := range r.links[v] {
+		if now.Sub(meta.ReceivedAt) > r.linkTTL {
+			continue
+		}
+		if _, ok := r.usableLocked(meta.GatewayID, now); !ok {
+			continue
+		}
+		heard = append(heard, meta)
+	}
+	var latest time.Time
+	for _, meta := range heard {
+		if meta.ReceivedAt.After(latest) {
+			latest = meta.ReceivedAt
+		}
+	}
+	current := func(m model.UplinkMeta) bool { return latest.Sub(m.ReceivedAt) <= r.recent }
+	sort.Slice(heard, func(i, j int) bool {
+		a, b := heard[i], heard[j]
+		if current(a) != current(b) {
+			return current(a)
+		}
+		if current(a) && (a.BetterThan(b) || b.BetterThan(a)) {
+			return a.BetterThan(b)
+		}
+		return a.ReceivedAt.After(b.ReceivedAt)
+	})
+
+	out := make([]downlinkRoute, 0, len(heard)+1)
+	seen := map[string]bool{}
+	for _, meta := range heard {
+		g, _ := r.usableLocked(meta.GatewayID, now)
+		out = append(out, downlinkRoute{GatewayID: meta.GatewayID, URL: g.URL})
+		seen[meta.GatewayID] = true
+	}
+	// fallbacks: the configured gateway, then any gateway that manages v
+	fallback := []string{}
+	if id, ok := r.vehicleMap[v]; ok {
+		fallback = append(fallback, id)
+	}
+	ids := make([]string, 0, len(r.gateways))
+	for id, g := range r.gateways {
+		if slices.Contains(g.Vehicles, v) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range append(fallback, ids...) {
+		if seen[id] {
+			continue
+		}
+		if g, ok := r.usableLocked(id, now); ok {
+			out = append(out, downlinkRoute{GatewayID: id, URL: g.URL})
+			seen[id] = true
+		}
+	}
+	return out
+}
+
+// load restores dynamic registrations from path. A missing file is not an error.
+func (r *registry) load(path string) error {
+	r.mu.Lock()
+	r.path = path
+	r.mu.Unlock()
+	if path == "" {
+		return nil
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved []model.GatewayStatus
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return fmt.Errorf("decode %s: %w", path, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range saved {
+		g := saved[i]
+		if cur, ok := r.gateways[g.GatewayID]; ok && cur.Static {
+			// static configuration wins; keep the last heartbeat only
+			cur.LastSeen, cur.Version = g.LastSeen, g.Version
+			continue
+		}
+		g.Static = false
+		r.gateways[g.GatewayID] = &g
+	}
+	log.Printf("[fog] restored %d gateways from %s", len(saved), path)
+	return nil
+}
+
+// save writes dynamic registrations to the registry file atomically.
+func (r *registry) save() error {
+	r.mu.RLock()
+	path := r.path
+	saved := make([]model.GatewayStatus, 0, len(r.gateways))
+	for _, g := range r.gateways {
+		if !g.LastSeen.IsZero() {
+			saved = append(saved, *g)
+		}
+	}
+	r.mu.RUnlock()
+	if path == "" {
+		return nil
+	}
+	r.saveMu.Lock()
+	defer r.saveMu.Unlock()
+	sort.Slice(saved, func(i, j int) bool { return saved[i].GatewayID < saved[j].GatewayID })
+
+	b, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
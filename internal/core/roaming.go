@@ -0,0 +1,224 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"LoraFog/internal/model"
+)
+
+// handoverMarginDB is the RSSI advantage a new gateway needs before a vehicle
+// that is still heard by its serving gateway is handed over.
+const handoverMarginDB = 3.0
+
+// Gateway admission modes for uplink frames.
+const (
+	// AdmitStatic forwards only vehicles listed in the gateway's own configuration.
+	AdmitStatic = "static"
+	// AdmitAllowlist also forwards vehicles in the fog-supplied fleet allowlist (default).
+	AdmitAllowlist = "allowlist"
+	// AdmitAny forwards every decodable frame; the fog still drops vehicles
+	// outside its fleet.
+	AdmitAny = "any"
+)
+
+// errNotInFleet is returned for uplinks from vehicles the fog does not know.
+var errNotInFleet = errors.New("vehicle not in fleet")
+
+// ingest hands a decoded uplink copy to de-duplication if its vehicle is in
+// the fleet (see fleetList). Other vehicles' frames, e.g. forwarded by a
+// gateway with admission any, are counted and dropped with errNotInFleet.
+func (f *FogServer) ingest(vd model.VehicleData, meta model.UplinkMeta) error {
+	if !f.reg.inFleet(vd.VehicleID) {
+		mFogRejected.With(f.gatewayLabel(meta.GatewayID)).Inc()
+		return errNotInFleet
+	}
+	f.collect(vd, meta)
+	return nil
+}
+
+// collect counts an uplink copy and hands it to de-duplication.
+func (f *FogServer) collect(vd model.VehicleData, meta model.UplinkMeta) {
+	mFogUplinks.With(f.gatewayLabel(meta.GatewayID), f.vehicleLabel(vd.VehicleID)).Inc()
+	f.dedup.add(vd, meta)
+}
+
+// trackServing updates the serving gateway of the uplink's vehicle and emits
+// a handover event when it changes.
+func (f *FogServer) trackServing(up model.Uplink) {
+	v := up.Data.VehicleID
+	heard := make([]string, 0, len(up.HeardBy))
+	for _, m := range up.HeardBy {
+		heard = append(heard, m.GatewayID)
+	}
+
+	f.uplinkMu.Lock()
+	prev, known := f.serving[v]
+	next := up.Meta.GatewayID
+	if known && prev.ServingGateway != next {
+		// stay on the serving gateway while it still hears the vehicle well enough
+		for _, m := range up.HeardBy {
+			if m.GatewayID == prev.ServingGateway && up.Meta.RSSI-m.RSSI < handoverMarginDB {
+				next = prev.ServingGateway
+				break
+			}
+		}
+	}
+	f.serving[v] = model.VehicleStatus{
+		VehicleID:      v,
+		ServingGateway: next,
+		LastSeen:       up.Meta.ReceivedAt,
+		HeardBy:        heard,
+	}
+	f.uplinkMu.Unlock()
+
+	if known && prev.ServingGateway != next {
+		f.emit(model.Event{
+			Type:      model.EventHandover,
+			VehicleID: v,
+			GatewayID: next,
+			Data:      model.Handover{From: prev.ServingGateway, To: next},
+		})
+	}
+}
+
+// emit records an event in the recent-events log and pushes it to websocket subscribers.
+func (f *FogServer) emit(ev model.Event) {
+	ev = f.events.append(ev)
+	f.sync.recordEvent(ev)
+	log.Printf("[fog] event %s vehicle=%s gateway=%s", ev.Type, ev.VehicleID, ev.GatewayID)
+	f.publishEnvelope(eventEnvelope(ev), "")
+}
+
+// SetFleet sets the vehicles authorised to roam onto any gateway.
+func (f *FogServer) SetFleet(vehicles []string) {
+	f.reg.mu.Lock()
+	defer f.reg.mu.Unlock()
+	for _, v := range vehicles {
+		f.reg.fleet[v] = struct{}{}
+	}
+}
+
+// setCloudFleet replaces the vehicles the cloud adds to the fleet, so
+// vehicles dropped from a cloud update lose fleet access again.
+func (f *FogServer) setCloudFleet(vehicles []string) {
+	set := make(map[string]struct{}, len(vehicles))
+	for _, v := range vehicles {
+		set[v] = struct{}{}
+	}
+	f.reg.mu.Lock()
+	f.reg.cloudFleet = set
+	f.reg.mu.Unlock()
+}
+
+// fleetList returns the fog-authorised vehicles: the configured and cloud
+// fleets, the static registry and every vehicle a registered gateway manages.
+func (r *registry) fleetList() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	set := map[string]struct{}{}
+	for v := range r.fleet {
+		set[v] = struct{}{}
+	}
+	for v := range r.cloudFleet {
+		set[v] = struct{}{}
+	}
+	for v := range r.vehicleMap {
+		set[v] = struct{}{}
+	}
+	for _, g := range r.gateways {
+		for _, v := range g.Vehicles {
+			set[v] = struct{}{}
+		}
+	}
+	out := make([]string, 0, len(set))
+	for v := range set {
+		out = append(out, v)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// inFleet reports whether v is one of the vehicles fleetList returns.
+func (r *registry) inFleet(v string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, ok := r.fleet[v]; ok {
+		return true
+	}
+	if _, ok := r.cloudFleet[v]; ok {
+		return true
+	}
+	if _, ok := r.vehicleMap[v]; ok {
+		return true
+	}
+	for _, g := range r.gateways {
+		if slices.Contains(g.Vehicles, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleVehicles lists the serving gateway and last uplink time of every vehicle.
+func (f *FogServer) handleVehicles(w http.ResponseWriter, r *http.Request) {
+	f.uplinkMu.RLock()
+	out := make([]model.VehicleStatus, 0, len(f.serving))
+	for _, st := range f.serving {
+		out = append(out, st)
+	}
+	f.uplinkMu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].VehicleID < out[j].VehicleID })
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(out); err != nil {
+		log.Printf("[fog] warning: encode vehicles: %v", err)
+	}
+}
+
+// handleEvents returns recent events, optionally after ?since=<id>.
+func (f *FogServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	var since uint64
+	if s := r.URL.Query().Get("since"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid since", http.StatusBadRequest)
+			return
+		}
+		since = n
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(f.events.since(since)); err != nil {
+		log.Printf("[fog] warning: encode events: %v", err)
+	}
+}
+
+// admits reports whether the gateway forwards frames from vehicle v.
+func (g *Gateway) admits(v string) bool {
+	if _, ok := g.VehicleSet[v]; ok {
+		return true
+	}
+	switch strings.ToLower(g.Admission) {
+	case AdmitAny:
+		return true
+	case AdmitStatic:
+		return false
+	default:
+		g.allowMu.RLock()
+		defer g.allowMu.RUnlock()
+		return slices.Contains(g.allowlist, v)
+	}
+}
+
+// setAllowlist replaces the fog-supplied fleet allowlist.
+func (g *Gateway) setAllowlist(vehicles []string) {
+	g.allowMu.Lock()
+	g.allowlist = vehicles
+	g.allowMu.Unlock()
+}
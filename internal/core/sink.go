@@ -0,0 +1,362 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"LoraFog/internal/health"
+	"LoraFog/internal/model"
+)
+
+// Telemetry sink kinds.
+const (
+	SinkInflux = "influx"
+	SinkFile   = "file"
+)
+
+const (
+	defaultSinkBatch       = 500
+	defaultSinkFlush       = time.Second
+	defaultSinkQueue       = 10000
+	defaultSinkMeasurement = "telemetry"
+	// sinkMinBackoff and sinkMaxBackoff bound the delay between retries of a failed write.
+	sinkMinBackoff = time.Second
+	sinkMaxBackoff = time.Minute
+	// sinkTimeout bounds each write, and the final flush on shutdown.
+	sinkTimeout = 10 * time.Second
+)
+
+// TelemetrySink stores de-duplicated uplinks outside the fog, e.g. in a
+// time-series database. Write is only called from the sink's own component
+// goroutine, one batch at a time; a failed batch is retried unless the
+// error is marked permanent.
+type TelemetrySink interface {
+	// Write stores batch, in arrival order.
+	Write(ctx context.Context, batch []model.Uplink) error
+	// Close releases the sink's resources when its component stops.
+	Close() error
+}
+
+// permanentError marks a write failure that retrying cannot fix, such as
+// a database rejecting the points; the batch is dropped.
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// permanent marks err as not worth retrying.
+func permanent(err error) error { return permanentError{err} }
+
+// newSink builds the TelemetrySink for c.
+func newSink(c model.SinkConfig) (TelemetrySink, error) {
+	switch c.Kind {
+	case SinkInflux:
+		if c.URL == "" {
+			return nil, errors.New("influx sink needs url")
+		}
+		return newInfluxSink(c), nil
+	case SinkFile:
+		return newFileSink(c), nil
+	default:
+		return nil, fmt.Errorf("unknown sink kind %q (want %s or %s)", c.Kind, SinkInflux, SinkFile)
+	}
+}
+
+// sinkSet is the fog's configured sinks; recording on an empty set is a no-op.
+type sinkSet []*sinkRunner
+
+// record queues up on every sink.
+func (ss sinkSet) record(up model.Uplink) {
+	for _, s := range ss {
+		s.record(up)
+	}
+}
+
+// SetSinks enables the configured telemetry sinks. Each sink runs as its
+// own Component, returned for the supervisor, so a slow or unreachable
+// database never holds up the fog; invalid entries are logged and skipped.
+func (f *FogServer) SetSinks(cfgs []model.SinkConfig) []Component {
+	var comps []Component
+	names := map[string]bool{}
+	for i, c := range cfgs {
+		c.Kind = strings.ToLower(c.Kind)
+		if c.Measurement == "" {
+			c.Measurement = defaultSinkMeasurement
+		}
+		sink, err := newSink(c)
+		if err != nil {
+			log.Printf("[config] sink %d: %v", i+1, err)
+			continue
+		}
+		if c.Name == "" {
+			c.Name = "sink/" + c.Kind
+		}
+		if names[c.Name] {
+			c.Name = fmt.Sprintf("%s-%d", c.Name, i+1)
+		}
+		names[c.Name] = true
+		if c.BatchSize <= 0 {
+			c.BatchSize = defaultSinkBatch
+		}
+		if c.QueueSize <= 0 {
+			c.QueueSize = defaultSinkQueue
+		}
+		r := &sinkRunner{cfg: c, sink: sink, queue: make(chan model.Uplink, c.QueueSize)}
+		mSinkQueue.Set(func() float64 { return float64(len(r.queue)) }, c.Name)
+		f.sinks = append(f.sinks, r)
+		comps = append(comps, r)
+	}
+	return comps
+}
+
+// sinkRunner batches uplinks for one TelemetrySink and retries failed
+// writes with exponential backoff. Uplinks arriving while the queue is full
+// are dropped and counted.
+type sinkRunner struct {
+	cfg   model.SinkConfig
+	sink  TelemetrySink
+	queue chan model.Uplink
+
+	written atomic.Uint64
+	dropped atomic.Uint64
+
+	mu        sync.Mutex
+	lastWrite time.Time
+	lastErr   string
+	failing   bool
+}
+
+// Name implements Component.
+func (s *sinkRunner) Name() string { return s.cfg.Name }
+
+// Dependencies implements Component; sinks only consume fog uplinks.
+func (s *sinkRunner) Dependencies() []string { return nil }
+
+// flushEvery returns the longest a partial batch waits.
+func (s *sinkRunner) flushEvery() time.Duration {
+	if s.cfg.FlushMs > 0 {
+		return time.Duration(s.cfg.FlushMs) * time.Millisecond
+	}
+	return defaultSinkFlush
+}
+
+// record queues up without blocking the fog.
+func (s *sinkRunner) record(up model.Uplink) {
+	select {
+	case s.queue <- up:
+	default:
+		s.drop(1)
+	}
+}
+
+// drop counts n uplinks that will never reach the sink.
+func (s *sinkRunner) drop(n int) {
+	s.dropped.Add(uint64(n))
+	mSinkDropped.With(s.cfg.Name).Add(float64(n))
+}
+
+// Run implements Component. It writes a batch when BatchSize uplinks are
+// pending or FlushMs has passed, and keeps a failed batch until the sink
+// recovers; on stop it makes one last attempt to write what is pending.
+func (s *sinkRunner) Run(ctx context.Context) error {
+	defer func() {
+		if err := s.sink.Close(); err != nil {
+			log.Printf("[%s] warning: close: %v", s.cfg.Name, err)
+		}
+	}()
+	log.Printf("[%s] writing %s batches of %d every %s", s.cfg.Name, s.cfg.Measurement, s.cfg.BatchSize, s.flushEvery())
+
+	tick := time.NewTicker(s.flushEvery())
+	defer tick.Stop()
+	var (
+		batch   []model.Uplink
+		retryAt time.Time
+		backoff = sinkMinBackoff
+	)
+	for {
+		// A full batch stops draining the queue, so an outage fills the
+		// queue and drops new uplinks instead of growing without bound
+		in := s.queue
+		if len(batch) >= s.cfg.BatchSize {
+			in = nil
+		}
+		select {
+		case <-ctx.Done():
+			s.shutdown(batch)
+			return nil
+		case up := <-in:
+			batch = append(batch, up)
+			if len(batch) < s.cfg.BatchSize {
+				continue
+			}
+		case <-tick.C:
+		}
+		if len(batch) == 0 || time.Now().Before(retryAt) {
+			continue
+		}
+		var err error
+		batch, err = s.flush(ctx, batch)
+		if err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			retryAt = time.Now().Add(backoff)
+			log.Printf("[%s] write failed, %d pending, retrying in %s: %v", s.cfg.Name, len(batch), backoff, err)
+			backoff = min(2*backoff, sinkMaxBackoff)
+			continue
+		}
+		retryAt, backoff = time.Time{}, sinkMinBackoff
+	}
+}
+
+// flush writes batch in chunks of BatchSize and returns what is left: nil
+// on success, the unwritten tail after a retryable failure. Chunks the sink
+// rejects permanently are dropped.
+func (s *sinkRunner) flush(ctx context.Context, batch []model.Uplink) ([]model.Uplink, error) {
+	for len(batch) > 0 {
+		n := min(len(batch), s.cfg.BatchSize)
+		wctx, cancel := context.WithTimeout(ctx, sinkTimeout)
+		err := s.sink.Write(wctx, batch[:n])
+		cancel()
+		var perm permanentError
+		switch {
+		case err == nil:
+			s.written.Add(uint64(n))
+			mSinkWritten.With(s.cfg.Name).Add(float64(n))
+			s.setResult(nil)
+		case errors.As(err, &perm):
+			log.Printf("[%s] %d points rejected, dropped: %v", s.cfg.Name, n, err)
+			s.drop(n)
+			mSinkFailures.With(s.cfg.Name).Inc()
+			s.setResult(err)
+		default:
+			mSinkFailures.With(s.cfg.Name).Inc()
+			s.setResult(err)
+			return batch, err
+		}
+		batch = batch[n:]
+	}
+	return nil, nil
+}
+
+// shutdown drains the queue and makes one attempt to write everything pending.
+func (s *sinkRunner) shutdown(batch []model.Uplink) {
+drain:
+	for {
+		select {
+		case up := <-s.queue:
+			batch = append(batch, up)
+		default:
+			break drain
+		}
+	}
+	if len(batch) == 0 {
+		return
+	}
+	left, err := s.flush(context.Background(), batch)
+	if err != nil {
+		log.Printf("[%s] stopping with %d points unwritten: %v", s.cfg.Name, len(left), err)
+		s.drop(len(left))
+	}
+}
+
+// setResult records the outcome of the latest write.
+func (s *sinkRunner) setResult(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.lastErr, s.failing = err.Error(), true
+		return
+	}
+	s.lastWrite, s.failing = time.Now(), false
+}
+
+// health reports the sink as a check named after it; a failing sink only
+// warns, since the fog keeps working without it.
+func (s *sinkRunner) health() health.Check {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := health.Info(s.cfg.Name, "written %d, pending %d, dropped %d", s.written.Load(), len(s.queue), s.dropped.Load())
+	if !s.lastWrite.IsZero() {
+		c.Detail += fmt.Sprintf(", last write %s ago", time.Since(s.lastWrite).Truncate(time.Second))
+	}
+	if s.failing {
+		c.Status = health.StatusWarn
+		c.Detail += ", failing: " + s.lastErr
+	}
+	return c
+}
+
+// lineEscaper escapes tag keys and values; measurementEscaper escapes measurement names.
+var (
+	lineEscaper        = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+)
+
+// appendLineProtocol appends up as one InfluxDB line-protocol point: tagged
+// by vehicle and serving gateway, with the telemetry, frame counter, number
+// of gateways that heard it and, when known, RSSI and SNR as fields, at the
+// fog receive time in nanoseconds.
+func appendLineProtocol(b []byte, measurement string, up model.Uplink) []byte {
+	vd := up.Data
+	b = append(b, measurementEscaper.Replace(measurement)...)
+	if vd.VehicleID != "" {
+		b = append(b, ",vehicle="...)
+		b = append(b, lineEscaper.Replace(vd.VehicleID)...)
+	}
+	if up.Meta.GatewayID != "" {
+		b = append(b, ",gateway="...)
+		b = append(b, lineEscaper.Replace(up.Meta.GatewayID)...)
+	}
+	b = append(b, " latitude="...)
+	b = strconv.AppendFloat(b, vd.Latitude, 'f', -1, 64)
+	b = append(b, ",longitude="...)
+	b = strconv.AppendFloat(b, vd.Longitude, 'f', -1, 64)
+	for _, f := range []struct {
+		name string
+		v    int64
+	}{
+		{"current_head", int64(vd.CurrentHead)},
+		{"target_head", int64(vd.TargetHead)},
+		{"left_speed", int64(vd.LeftSpeed)},
+		{"right_speed", int64(vd.RightSpeed)},
+		{"pid", int64(vd.PID)},
+		{"fcnt", int64(vd.FCnt)},
+		{"gateways", int64(max(len(up.HeardBy), 1))},
+	} {
+		b = append(b, ',')
+		b = append(b, f.name...)
+		b = append(b, '=')
+		b = strconv.AppendInt(b, f.v, 10)
+		b = append(b, 'i')
+	}
+	if up.Meta.HasLink() {
+		b = append(b, ",rssi="...)
+		b = strconv.AppendFloat(b, up.Meta.RSSI, 'f', -1, 64)
+		b = append(b, ",snr="...)
+		b = strconv.AppendFloat(b, up.Meta.SNR, 'f', -1, 64)
+	}
+	ts := up.Meta.ReceivedAt
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, ts.UnixNano(), 10)
+	return append(b, '\n')
+}
+
+// encodeLineProtocol renders batch as line protocol.
+func encodeLineProtocol(measurement string, batch []model.Uplink) []byte {
+	b := make([]byte, 0, len(batch)*160)
+	for _, up := range batch {
+		b = appendLineProtocol(b, measurement, up)
+	}
+	return b
+}
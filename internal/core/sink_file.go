@@ -0,0 +1,101 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"LoraFog/internal/model"
+)
+
+// fileSink appends batches as line protocol to one file per UTC day,
+// <dir>/<measurement>-YYYYMMDD.lp, for offline import with
+// `influx write --file` where the fog has no database to reach.
+type fileSink struct {
+	dir         string
+	measurement string
+
+	f    *os.File
+	name string
+	// cutName is a file still holding part of a failed batch, to be cut back
+	// to cutSize before the next append; empty when no file is.
+	cutName string
+	cutSize int64
+}
+
+// newFileSink builds a line-protocol file sink from c.
+func newFileSink(c model.SinkConfig) *fileSink {
+	dir := c.Path
+	if dir == "" {
+		dir = filepath.Join("tmp", "lineproto")
+	}
+	return &fileSink{dir: dir, measurement: c.Measurement}
+}
+
+// Write implements TelemetrySink. Each batch is synced to disk before it
+// counts as written; on error the file is cut back to its size before the
+// batch, so the retry does not leave a partial line behind, and reopened on
+// the next attempt.
+func (s *fileSink) Write(_ context.Context, batch []model.Uplink) error {
+	if s.cutName != "" {
+		if err := os.Truncate(s.cutName, s.cutSize); err != nil {
+			return err
+		}
+		s.cutName = ""
+	}
+	name := filepath.Join(s.dir, fmt.Sprintf("%s-%s.lp", s.measurement, time.Now().UTC().Format("20060102")))
+	if s.f == nil || s.name != name {
+		if err := s.Close(); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(s.dir, 0o755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(name, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		s.f, s.name = f, name
+	}
+	fi, err := s.f.Stat()
+	if err != nil {
+		s.discard(-1)
+		return err
+	}
+	if _, err := s.f.Write(encodeLineProtocol(s.measurement, batch)); err != nil {
+		s.discard(fi.Size())
+		return err
+	}
+	if err := s.f.Sync(); err != nil {
+		s.discard(fi.Size())
+		return err
+	}
+	return nil
+}
+
+// discard closes the file after a failed write, ignoring the close error.
+// A size >= 0 first cuts the file back to it; if that fails too, the next
+// Write retries the cut before appending.
+func (s *fileSink) discard(size int64) {
+	if size >= 0 {
+		if err := s.f.Truncate(size); err != nil {
+			log.Printf("[sink] warning: truncate %s after failed write: %v", s.name, err)
+			s.cutName, s.cutSize = s.name, size
+		}
+	}
+	_ = s.f.Close()
+	s.f = nil
+}
+
+// Close implements TelemetrySink.
+func (s *fileSink) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"LoraFog/internal/model"
+)
+
+// influxSink writes batches as line protocol to an InfluxDB HTTP write
+// endpoint: /api/v2/write?org=..&bucket=.. on InfluxDB 2.x and 3.x, or
+// /write?db=.. on 1.x. Timestamps are in nanoseconds, the default precision
+// of both.
+type influxSink struct {
+	url         string
+	token       string
+	measurement string
+	client      *http.Client
+}
+
+// newInfluxSink builds an InfluxDB sink from c.
+func newInfluxSink(c model.SinkConfig) *influxSink {
+	return &influxSink{
+		url:         c.URL,
+		token:       c.Token,
+		measurement: c.Measurement,
+		client:      &http.Client{Timeout: sinkTimeout},
+	}
+}
+
+// Write implements TelemetrySink. Network errors, 429 and 5xx answers are
+// retried; other 4xx answers mean InfluxDB rejected the points and are permanent.
+func (s *influxSink) Write(ctx context.Context, batch []model.Uplink) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(encodeLineProtocol(s.measurement, batch)))
+	if err != nil {
+		return permanent(err)
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Token "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := resp.Body.Close(); cerr != nil {
+			log.Printf("[sink] warning: close influx response: %v", cerr)
+		}
+	}()
+	// Keep the start of the body: InfluxDB explains rejected writes there
+	msg, err := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if err != nil {
+		log.Printf("[sink] warning: read influx response: %v", err)
+	}
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("influx answered %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	default:
+		return permanent(fmt.Errorf("influx answered %s: %s", resp.Status, strings.TrimSpace(string(msg))))
+	}
+}
+
+// Close implements TelemetrySink.
+func (s *influxSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,317 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// supervisor runs Components in dependency order and restarts them
+// according to their RestartPolicy with exponential backoff.
+type supervisor struct {
+	policy     RestartPolicy
+	overrides  map[string]RestartPolicy
+	backoffMin time.Duration
+	backoffMax time.Duration
+	stopWait   time.Duration
+	depPoll    time.Duration // how often a waiting component re-checks its dependencies
+
+	mu    sync.Mutex
+	units map[string]*unit
+	order []string // topological start order
+}
+
+// unit holds the runtime state of one supervised Component.
+type unit struct {
+	comp   Component
+	status ComponentStatus
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newSupervisor creates a supervisor with the given default policy and backoff bounds.
+func newSupervisor(policy RestartPolicy, backoffMin, backoffMax time.Duration) *supervisor {
+	if backoffMin <= 0 {
+		backoffMin = 500 * time.Millisecond
+	}
+	if backoffMax < backoffMin {
+		backoffMax = 30 * time.Second
+	}
+	return &supervisor{
+		policy:     policy,
+		overrides:  map[string]RestartPolicy{},
+		backoffMin: backoffMin,
+		backoffMax: backoffMax,
+		stopWait:   5 * time.Second,
+		depPoll:    50 * time.Millisecond,
+		units:      map[string]*unit{},
+	}
+}
+
+// add registers a component. It must be called before start.
+func (s *supervisor) add(c Component) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.units[c.Name()] = &unit{
+		comp:   c,
+		status: ComponentStatus{Name: c.Name(), State: StatePending, Since: time.Now()},
+	}
+}
+
+// policyFor returns the restart policy configured for a component.
+func (s *supervisor) policyFor(name string) RestartPolicy {
+	if p, ok := s.overrides[name]; ok {
+		return p
+	}
+	return s.policy
+}
+
+// resolveOrder computes a topological order of registered components.
+// Dependencies on components that are not registered are ignored.
+func (s *supervisor) resolveOrder() ([]string, error) {
+	names := make([]string, 0, len(s.units))
+	for n := range s.units {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	mark := make(map[string]int, len(names))
+	order := make([]string, 0, len(names))
+	var visit func(n string) error
+	visit = func(n string) error {
+		switch mark[n] {
+		case visiting:
+			return fmt.Errorf("dependency cycle at %s", n)
+		case visited:
+			return nil
+		}
+		mark[n] = visiting
+		for _, d := range s.units[n].comp.Dependencies() {
+			if _, ok := s.units[d]; !ok {
+				continue
+			}
+			if err := visit(d); err != nil {
+				return err
+			}
+		}
+		mark[n] = visited
+		order = append(order, n)
+		return nil
+	}
+	for _, n := range names {
+		if err := visit(n); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// start launches every registered component in dependency order; each
+// one waits in run until its dependencies are up.
+func (s *supervisor) start() error {
+	s.mu.Lock()
+	order, err := s.resolveOrder()
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	s.order = order
+	s.mu.Unlock()
+
+	for _, name := range order {
+		s.mu.Lock()
+		u := s.units[name]
+		ctx, cancel := context.WithCancel(context.Background())
+		u.cancel = cancel
+		u.done = make(chan struct{})
+		s.mu.Unlock()
+
+		log.Printf("[supervisor] starting %s (restart=%s)", name, s.policyFor(name))
+		go s.run(ctx, u)
+	}
+	return nil
+}
+
+// run executes a component and applies its restart policy until ctx is done.
+func (s *supervisor) run(ctx context.Context, u *unit) {
+	defer close(u.done)
+	name := u.comp.Name()
+	policy := s.policyFor(name)
+	backoff := s.backoffMin
+
+	for {
+		if !s.awaitDependencies(ctx, u) {
+			s.setState(u, StateStopped, nil)
+			return
+		}
+		s.setState(u, StateRunning, nil)
+		started := time.Now()
+		err := runSafe(ctx, u.comp)
+
+		if ctx.Err() != nil {
+			s.setState(u, StateStopped, err)
+			return
+		}
+		if err != nil {
+			log.Printf("[supervisor] %s exited with error: %v", name, err)
+		} else {
+			log.Printf("[supervisor] %s exited", name)
+		}
+
+		restart := policy == RestartAlways || (policy == RestartOnFailure && err != nil)
+		if !restart {
+			if err != nil {
+				s.setState(u, StateFailed, err)
+			} else {
+				s.setState(u, StateStopped, nil)
+			}
+			return
+		}
+
+		// a run that stayed up longer than the max backoff counts as healthy
+		if time.Since(started) > s.backoffMax {
+			backoff = s.backoffMin
+		}
+		s.setState(u, StateBackoff, err)
+		s.mu.Lock()
+		u.status.Restarts++
+		s.mu.Unlock()
+		log.Printf("[supervisor] restarting %s in %s", name, backoff)
+
+		select {
+		case <-ctx.Done():
+			s.setState(u, StateStopped, err)
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > s.backoffMax {
+			backoff = s.backoffMax
+		}
+	}
+}
+
+// up reports whether the named component is running and, if it implements
+// readier, ready. Unregistered dependencies count as up.
+func (s *supervisor) up(name string) bool {
+	s.mu.Lock()
+	u, ok := s.units[name]
+	running := ok && u.status.State == StateRunning
+	s.mu.Unlock()
+	if !ok {
+		return true
+	}
+	if !running {
+		return false
+	}
+	if r, ok := u.comp.(readier); ok {
+		return r.Ready()
+	}
+	return true
+}
+
+// awaitDependencies blocks until every dependency of u is up, logging what
+// it waits for every depWaitLog. It returns false if ctx is cancelled first.
+func (s *supervisor) awaitDependencies(ctx context.Context, u *unit) bool {
+	deps := u.comp.Dependencies()
+	if len(deps) == 0 {
+		return true
+	}
+	tick := time.NewTicker(s.depPoll)
+	defer tick.Stop()
+	lastLog := time.Now()
+	for {
+		var waiting []string
+		for _, d := range deps {
+			if !s.up(d) {
+				waiting = append(waiting, d)
+			}
+		}
+		if len(waiting) == 0 {
+			return true
+		}
+		if time.Since(lastLog) >= depWaitLog {
+			log.Printf("[supervisor] %s waiting for %v", u.comp.Name(), waiting)
+			lastLog = time.Now()
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-tick.C:
+		}
+	}
+}
+
+// depWaitLog is how often a component still waiting for its dependencies is logged.
+const depWaitLog = 10 * time.Second
+
+// runSafe calls c.Run and converts a panic into an error.
+func runSafe(ctx context.Context, c Component) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return c.Run(ctx)
+}
+
+// setState records a state transition for u.
+func (s *supervisor) setState(u *unit, st ComponentState, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u.status.State = st
+	u.status.Since = time.Now()
+	if err != nil {
+		u.status.LastError = err.Error()
+	}
+}
+
+// stop cancels components in reverse dependency order, waiting for each to exit.
+func (s *supervisor) stop() {
+	s.mu.Lock()
+	order := append([]string(nil), s.order...)
+	s.mu.Unlock()
+
+	for i := len(order) - 1; i >= 0; i-- {
+		s.mu.Lock()
+		u := s.units[order[i]]
+		s.mu.Unlock()
+		if u.cancel == nil {
+			continue
+		}
+		s.setState(u, StateStopping, nil)
+		u.cancel()
+		select {
+		case <-u.done:
+			log.Printf("[supervisor] %s stopped", order[i])
+		case <-time.After(s.stopWait):
+			log.Printf("[supervisor] %s stop timeout", order[i])
+		}
+	}
+}
+
+// statuses returns a snapshot of all component states in start order.
+func (s *supervisor) statuses() []ComponentStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := s.order
+	if len(names) == 0 {
+		for n := range s.units {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+	}
+	out := make([]ComponentStatus, 0, len(names))
+	for _, n := range names {
+		out = append(out, s.units[n].status)
+	}
+	return out
+}
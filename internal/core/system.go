@@ -5,6 +5,7 @@ package core
 import (
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -17,6 +18,10 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+// Version is the LoraFog build version reported by gateways on registration.
+// Override at build time with -ldflags "-X LoraFog/internal/core.Version=v1.2.3".
+var Version = "dev"
+
 // System manages lifecycle of the main components (FogServer, Gateways, Vehicles).
 // It loads configuration from a YAML file and constructs objects accordingly.
 type System struct {
@@ -29,8 +34,7 @@ type System struct {
 	Arduinos []*device.ArduinoDevice
 	SocatMgr *util.SocatManager
 
-	stop      chan struct{}
-	wg        sync.WaitGroup
+	sup       *supervisor
 	started   bool
 	startLock sync.Mutex
 }
@@ -72,12 +76,48 @@ func NewSystem(cfgPath string) (*System, error) {
 		// s.Fog = NewFogServer(cfg.Server.FogAddr)
 		s.Fog = NewFogServer(cfg.Server.FogAddr, cfg.Server.AppAddr)
 		s.Fog.wireFmt = strings.ToLower(cfg.Global.WireFormat)
+		if cfg.Server.DedupWindowMs != 0 {
+			s.Fog.SetDedupWindow(time.Duration(cfg.Server.DedupWindowMs) * time.Millisecond)
+		}
+		if cfg.Server.LinkTTLS > 0 {
+			s.Fog.SetLinkTTL(time.Duration(cfg.Server.LinkTTLS) * time.Second)
+		}
+		if cfg.Server.GatewayExpiryS > 0 {
+			s.Fog.SetGatewayExpiry(time.Duration(cfg.Server.GatewayExpiryS) * time.Second)
+		}
+		switch {
+		case cfg.Server.GatewayPurgeS > 0:
+			s.Fog.SetGatewayPurge(time.Duration(cfg.Server.GatewayPurgeS) * time.Second)
+		case cfg.Server.GatewayPurgeS < 0:
+			s.Fog.SetGatewayPurge(0)
+		}
+		s.Fog.SetGatewayTokens(cfg.Server.GatewayTokens)
 
 		for _, gw := range cfg.Server.Gateways {
 			s.Fog.RegisterGateway(gw.ID, gw.URL, gw.Vehicles)
 			log.Printf("[config] Registered gateway %s (%s) vehicles=%v",
 				gw.ID, gw.URL, gw.Vehicles)
 		}
+		regPath := cfg.Server.RegistryPath
+		if regPath == "" {
+			regPath = filepath.Join("tmp", "gateways.json")
+		}
+		s.Fog.SetFleet(cfg.Server.Vehicles)
+		s.Fog.SetWebsocketOptions(cfg.Server.Websocket)
+		s.Fog.SetControlRate(cfg.Server.ControlRate)
+		for _, ic := range cfg.Server.Integrations {
+			format := ic.Format
+			if format == "" {
+				format = cfg.Global.WireFormat
+			}
+			ic.Format = strings.ToLower(format)
+			if err := s.Fog.AddIntegration(ic, s.parsers[ic.Format]); err != nil {
+				log.Printf("[config] %v", err)
+			}
+		}
+		if err := s.Fog.LoadRegistry(regPath); err != nil {
+			log.Printf("[config] failed to load gateway registry: %v", err)
+		}
 	} else {
 		log.Println("[config] Fog server disabled (no fog_addr configured)")
 	}
@@ -104,6 +144,13 @@ func NewSystem(cfgPath string) (*System, error) {
 			s.parsers[outFmt],
 			gcfg.Vehicles,
 		)
+		gw.SetRadio(gcfg.Radio)
+		gw.Admission = gcfg.Admission
+		gw.SetDownlink(gcfg.Downlink)
+		gw.SetTransport(newUplinkTransport(gcfg, s.Fog))
+		if gcfg.HeartbeatS != 0 {
+			gw.Heartbeat = time.Duration(gcfg.HeartbeatS) * time.Second
+		}
 		s.Gateways = append(s.Gateways, gw)
 	}
 
@@ -124,6 +171,12 @@ func NewSystem(cfgPath string) (*System, error) {
 			time.Duration(vcfg.TelemetryIntervalMs)*time.Millisecond,
 			p,
 		)
+		veh.StatusAddr = vcfg.StatusAddr
+		veh.FrameCounter = vcfg.FrameCounter
+		if vcfg.MetricsIntervalS > 0 {
+			veh.MetricsEvery = time.Duration(vcfg.MetricsIntervalS) * time.Second
+		}
+		veh.SetRadio(vcfg.Radio)
 		s.Vehicles = append(s.Vehicles, veh)
 	}
 
@@ -132,99 +185,121 @@ func NewSystem(cfgPath string) (*System, error) {
 		arduino := device.NewArduinoDevice(arduinoCfg.ID, arduinoCfg.Dev, arduinoCfg.Baud)
 		s.Arduinos = append(s.Arduinos, arduino)
 	}
+
+	s.sup = newSupervisorFromConfig(cfg.Supervisor)
+	if cfg.App.Enabled {
+		if cfg.Server.AppAddr != "" {
+			s.sup.add(newWebApp(cfg.Server.AppAddr, cfg.App))
+		} else {
+			log.Println("[config] app enabled without server.app_addr; not started")
+		}
+	}
+	if s.Fog != nil {
+		s.sup.add(s.Fog)
+		if bridge := s.Fog.SetMQTT(cfg.Server.MQTT); bridge != nil {
+			s.sup.add(bridge)
+		}
+		if broker := s.Fog.SetBroker(cfg.Server.Broker); broker != nil {
+			s.sup.add(broker)
+		}
+		if fwd := s.Fog.SetGWMP(cfg.Server.GWMP); fwd != nil {
+			s.sup.add(fwd)
+		}
+		if streams := s.Fog.SetGRPC(cfg.Server.GRPC); streams != nil {
+			s.sup.add(streams)
+		}
+		if up := s.Fog.SetSync(cfg.Server.Sync); up != nil {
+			s.sup.add(up)
+		}
+		for _, sink := range s.Fog.SetSinks(cfg.Server.Sinks) {
+			s.sup.add(sink)
+		}
+	}
+	for _, g := range s.Gateways {
+		if s.Fog != nil {
+			g.DependsOn = append(g.DependsOn, s.Fog.Name())
+			switch g.link.Name() {
+			case TransportMQTT:
+				if s.Fog.broker != nil {
+					g.DependsOn = append(g.DependsOn, s.Fog.broker.Name())
+				}
+			case TransportGRPC:
+				if s.Fog.grpc != nil {
+					g.DependsOn = append(g.DependsOn, s.Fog.grpc.Name())
+				}
+			}
+		}
+		s.sup.add(g)
+	}
+	for _, v := range s.Vehicles {
+		s.sup.add(v)
+	}
+	for _, a := range s.Arduinos {
+		s.sup.add(arduinoComponent{dev: a})
+	}
 	return s, nil
 }
 
-// StartAll starts the FogServer, all Gateways and all Vehicles concurrently.
-// It registers gateways to the FogServer registry when a gateway is successfully started.
+// newSupervisorFromConfig builds a supervisor from the YAML supervisor section.
+func newSupervisorFromConfig(c model.SupervisorConfig) *supervisor {
+	policy := parseRestartPolicy(c.RestartPolicy, RestartOnFailure)
+	sup := newSupervisor(policy,
+		time.Duration(c.BackoffMinMs)*time.Millisecond,
+		time.Duration(c.BackoffMaxMs)*time.Millisecond)
+	for name, p := range c.Overrides {
+		sup.overrides[name] = parseRestartPolicy(p, policy)
+	}
+	return sup
+}
+
+// parseRestartPolicy converts a config string into a RestartPolicy, using def when unknown.
+func parseRestartPolicy(v string, def RestartPolicy) RestartPolicy {
+	switch p := RestartPolicy(strings.ToLower(strings.TrimSpace(v))); p {
+	case RestartNever, RestartOnFailure, RestartAlways:
+		return p
+	case "":
+		return def
+	default:
+		log.Printf("[config] unknown restart policy %q, using %s", v, def)
+		return def
+	}
+}
+
+// StartAll starts the FogServer, all Gateways, Vehicles and simulated Arduinos
+// under the supervisor, in dependency order.
 func (s *System) StartAll() error {
 	s.startLock.Lock()
 	defer s.startLock.Unlock()
 	if s.started {
 		return nil
 	}
-	s.started = true
-	s.stop = make(chan struct{})
-
-	// start fog server
-	// go s.Fog.Start()
-	if s.Fog != nil {
-		log.Printf("[system] Starting fog server at %s ...", s.Fog.Addr)
-		go func() {
-			if err := s.Fog.Start(); err != nil {
-				log.Printf("[system] Fog server error: %v", err)
-			}
-		}()
-	} else {
+	if s.Fog == nil {
 		log.Println("[system] Fog server is disabled; skipping startup")
 	}
-
-	// start gateways and register them to fog registry
-	for _, g := range s.Gateways {
-		if err := g.Start(); err != nil {
-			log.Printf("[gateway %s] start err: %v", g.ID, err)
-		} else {
-			log.Printf("[gateway %s] start: Success", g.ID)
-			// s.Fog.RegisterGateway(g.ID, g.FogURL, g.Vehicles)
-		}
-	}
-
-	// start vehicle agents
-	for _, v := range s.Vehicles {
-		if err := v.Start(); err != nil {
-			log.Printf("[vehicle %s] start err: %v", v.ID, err)
-		} else {
-			log.Printf("[vehicle %s] start: Success", v.ID)
-		}
-	}
-
-	// start arduino simulation
-	for _, arduino := range s.Arduinos {
-		s.wg.Add(1)
-		go func(arduino *device.ArduinoDevice) {
-			defer s.wg.Done()
-			log.Printf("[system] starting arduino %s device %s (baud %d)", arduino.ID, arduino.Device, arduino.Baud)
-			stop := make(chan struct{})
-			go func() {
-				<-s.stop
-				close(stop)
-			}()
-
-			if err := arduino.StartSimulation(stop); err != nil {
-				log.Printf("[arduino %s] simulate failed: %v", arduino.ID, err)
-			} else {
-				log.Printf("[arduino %s] simulation stopped", arduino.ID)
-			}
-		}(arduino)
+	if err := s.sup.start(); err != nil {
+		return err
 	}
+	s.started = true
 	return nil
 }
 
-// StopAll stops all running components gracefully.
+// StopAll stops all running components in reverse dependency order.
 func (s *System) StopAll() {
 	s.startLock.Lock()
 	defer s.startLock.Unlock()
 	if !s.started {
 		return
 	}
-	for _, g := range s.Gateways {
-		g.Stop()
-	}
-	for _, v := range s.Vehicles {
-		v.Stop()
-	}
-	for _, a := range s.Arduinos {
-		if err := a.Close(); err != nil {
-			log.Printf("[warning] failed to close arduino %s: %v", a.ID, err)
-		}
-	}
+	log.Println("[system] stopping all components...")
+	s.sup.stop()
 	if s.SocatMgr != nil {
 		s.SocatMgr.Cleanup()
 	}
-	s.Fog.Stop()
-	log.Println("[system] stopping all components...")
-	close(s.stop)
-	s.wg.Wait()
 	s.started = false
 	log.Println("[system] all components stopped.")
 }
+
+// ComponentStates returns the current supervisor state of every component.
+func (s *System) ComponentStates() []ComponentStatus {
+	return s.sup.statuses()
+}
@@ -0,0 +1,295 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"LoraFog/internal/health"
+	"LoraFog/internal/model"
+)
+
+// Gateway transports to the fog.
+const (
+	TransportHTTP  = "http"
+	TransportMQTT  = "mqtt"
+	TransportGWMP  = "gwmp"
+	TransportGRPC  = "grpc"
+	TransportLocal = "local"
+)
+
+// ErrTransportDown is returned by session transports while they are
+// disconnected; the gateway retries on the next uplink or heartbeat.
+var ErrTransportDown = errors.New("transport to fog is down")
+
+// UplinkTransport carries a gateway's traffic to the fog: telemetry and
+// registration heartbeats up, control lines down. Implementations keep
+// their own sessions and reconnect on their own.
+type UplinkTransport interface {
+	// Name returns the transport kind, e.g. "http" or "grpc".
+	Name() string
+	// Start opens the transport; it reports back to the gateway through h.
+	Start(h TransportHooks) error
+	// Send forwards one uplink.
+	Send(up OutboundUplink) error
+	// Register sends a registration heartbeat; the ack is delivered through
+	// TransportHooks.Ack, synchronously or when the fog answers.
+	Register(reg model.GatewayRegistration) error
+	// Health reports the fog's reachability as a "fog" check.
+	Health() health.Check
+	// Stop closes the transport and waits for its goroutines.
+	Stop()
+}
+
+// TransportHooks are the gateway callbacks a transport reports to.
+type TransportHooks struct {
+	Downlink func(payload []byte) error         // a control line from the fog
+	Ack      func(model.GatewayRegistrationAck) // the fog's answer to Register
+	Up       func()                             // a session was (re)established
+}
+
+// OutboundUplink is one frame forwarded by a gateway. Frame is the line as
+// received from the radio; Data is its re-encoding in the gateway's wire_out.
+type OutboundUplink struct {
+	Frame       string
+	Data        string
+	ContentType string
+	Meta        model.UplinkMeta
+	Vehicle     string // decoded vehicle ID
+	FCnt        uint32 // the vehicle's frame counter, 0 if it sends none
+}
+
+// newUplinkTransport builds the transport selected in c, falling back to
+// HTTP when its settings are incomplete. fog is the fog running in the same
+// System, used by the local transport; nil when there is none.
+func newUplinkTransport(c model.GatewayConfig, fog *FogServer) UplinkTransport {
+	fallback := func(why string) UplinkTransport {
+		log.Printf("[config] gateway %s: %s, using http", c.ID, why)
+		return newHTTPTransport(c.ID, fogURLs(c), c.Token)
+	}
+	switch t := strings.ToLower(c.Transport); t {
+	case "", TransportHTTP:
+		return newHTTPTransport(c.ID, fogURLs(c), c.Token)
+	case TransportMQTT:
+		if c.MQTT.Broker == "" {
+			return fallback("mqtt transport without broker")
+		}
+		return newMQTTTransport(c.ID, c.MQTT, c.Token)
+	case TransportGWMP:
+		if c.GWMP.Server == "" {
+			return fallback("gwmp transport without server")
+		}
+		return newGWMPTransport(c.ID, c.GWMP, c.Radio)
+	case TransportGRPC:
+		if c.GRPC.Server == "" {
+			return fallback("grpc transport without server")
+		}
+		return newGRPCTransport(c.ID, c.GRPC, c.Token)
+	case TransportLocal:
+		if fog == nil {
+			return fallback("local transport without an in-process fog")
+		}
+		return newLocalTransport(c.ID, fog)
+	default:
+		return fallback(fmt.Sprintf("unknown transport %q", c.Transport))
+	}
+}
+
+// fogURLs returns the gateway's fog URLs in priority order: fog_url first,
+// then fog_urls.
+func fogURLs(c model.GatewayConfig) []string {
+	var urls []string
+	for _, u := range append([]string{c.FogURL}, c.FogURLs...) {
+		if u = strings.TrimRight(u, "/"); u != "" && !slices.Contains(urls, u) {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+const (
+	// httpTransportTimeout bounds each request to a fog.
+	httpTransportTimeout = 5 * time.Second
+	// httpFailback is how long a gateway stays on a lower-priority fog
+	// before trying the preferred ones again.
+	httpFailback = 30 * time.Second
+)
+
+// httpTransport posts uplinks to /api/telemetry and registrations to
+// /api/gateways/register. With several fog URLs it uses the first that
+// answers, in priority order, and periodically fails back to the preferred
+// ones. Downlinks arrive on the gateway's own /command endpoint.
+type httpTransport struct {
+	id     string
+	urls   []string // priority order
+	token  string   // sent as a bearer token on uplinks and registrations
+	client *http.Client
+	hooks  TransportHooks
+
+	mu      sync.Mutex
+	active  int       // index of the fog in use
+	retryAt time.Time // when to try fogs preferred over active again
+}
+
+// newHTTPTransport returns an HTTP transport to urls, in priority order,
+// authenticating with token.
+func newHTTPTransport(id string, urls []string, token string) *httpTransport {
+	return &httpTransport{id: id, urls: urls, token: token, client: &http.Client{Timeout: httpTransportTimeout}}
+}
+
+// Name implements UplinkTransport.
+func (t *httpTransport) Name() string { return TransportHTTP }
+
+// Start implements UplinkTransport.
+func (t *httpTransport) Start(h TransportHooks) error {
+	t.hooks = h
+	if len(t.urls) > 1 {
+		log.Printf("[gateway %s] fog failover order: %s", t.id, strings.Join(t.urls, ", "))
+	}
+	return nil
+}
+
+// Stop implements UplinkTransport.
+func (t *httpTransport) Stop() {}
+
+// Send implements UplinkTransport.
+func (t *httpTransport) Send(up OutboundUplink) error {
+	return t.post("/api/telemetry", up.ContentType, []byte(up.Data), func(h http.Header) {
+		setMetaHeaders(h, up.Meta)
+		t.authorize(h)
+	}, nil)
+}
+
+// authorize adds the gateway's bearer token to a request.
+func (t *httpTransport) authorize(h http.Header) {
+	if t.token != "" {
+		h.Set("Authorization", "Bearer "+t.token)
+	}
+}
+
+// Register implements UplinkTransport.
+func (t *httpTransport) Register(reg model.GatewayRegistration) error {
+	body, err := json.Marshal(reg)
+	if err != nil {
+		return err
+	}
+	return t.post("/api/gateways/register", "application/json", body, t.authorize, func(resp *http.Response) error {
+		var ack model.GatewayRegistrationAck
+		if err := json.NewDecoder(resp.Body).Decode(&ack); err != nil {
+			return fmt.Errorf("decode ack: %w", err)
+		}
+		if t.hooks.Ack != nil {
+			t.hooks.Ack(ack)
+		}
+		return nil
+	})
+}
+
+// Health implements UplinkTransport by probing the fog in use.
+func (t *httpTransport) Health() health.Check {
+	if len(t.urls) == 0 {
+		return health.Check{Name: "fog", Status: health.StatusFail, Detail: "no fog_url"}
+	}
+	t.mu.Lock()
+	u := t.urls[t.active]
+	t.mu.Unlock()
+	return health.HTTPCheck("fog", u+"/healthz", probeTimeout)
+}
+
+// order returns the fog indices to try: from the active fog onwards, or
+// from the preferred one once the failback delay has passed.
+func (t *httpTransport) order() []int {
+	t.mu.Lock()
+	start := t.active
+	if start > 0 && !time.Now().Before(t.retryAt) {
+		start = 0
+	}
+	t.mu.Unlock()
+	idx := make([]int, 0, len(t.urls))
+	for i := start; i < len(t.urls); i++ {
+		idx = append(idx, i)
+	}
+	for i := 0; i < start; i++ {
+		idx = append(idx, i)
+	}
+	return idx
+}
+
+// use records that fog i answered.
+func (t *httpTransport) use(i int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	// on a lower-priority fog, wait again after switching or after the
+	// preferred ones were just retried and failed
+	if i > 0 && (i != t.active || !now.Before(t.retryAt)) {
+		t.retryAt = now.Add(httpFailback)
+	}
+	if i != t.active {
+		log.Printf("[gateway %s] switching fog %s → %s", t.id, t.urls[t.active], t.urls[i])
+		t.active = i
+	}
+}
+
+// post sends body to path on the first fog that answers. Connection errors
+// and 5xx answers move on to the next fog; other non-2xx answers are
+// returned as they are the request's fault. read, if set, consumes a
+// successful response.
+func (t *httpTransport) post(path, contentType string, body []byte, header func(http.Header), read func(*http.Response) error) error {
+	if len(t.urls) == 0 {
+		return ErrTransportDown
+	}
+	var errs []error
+	for _, i := range t.order() {
+		retry, err := t.postTo(t.urls[i]+path, contentType, body, header, read)
+		if err == nil {
+			t.use(i)
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", t.urls[i], err))
+		if !retry {
+			t.use(i)
+			break
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// postTo sends one request; retry reports whether another fog may succeed.
+func (t *httpTransport) postTo(url, contentType string, body []byte, header func(http.Header), read func(*http.Response) error) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if header != nil {
+		header(req.Header)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer func() {
+		if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+			log.Printf("[gateway %s] warning: discard body: %v", t.id, err)
+		}
+		if cerr := resp.Body.Close(); cerr != nil {
+			log.Printf("[gateway %s] warning: close body: %v", t.id, cerr)
+		}
+	}()
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode >= 500, fmt.Errorf("fog answered %s", resp.Status)
+	}
+	if read != nil {
+		return false, read(resp)
+	}
+	return false, nil
+}
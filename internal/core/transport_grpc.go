@@ -0,0 +1,315 @@
+package core
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+
+	"LoraFog/internal/gwstream"
+	"LoraFog/internal/health"
+	"LoraFog/internal/model"
+)
+
+const (
+	defaultGRPCWindow = 32
+	// grpcSendWait bounds how long an uplink waits for window or queue space.
+	grpcSendWait = 5 * time.Second
+	// grpcMaxBackoff caps the delay between stream reconnects.
+	grpcMaxBackoff = 30 * time.Second
+)
+
+// grpcTransport keeps one bidirectional stream to the fog (see package
+// gwstream), reconnecting with backoff. At most Window uplinks may be
+// unacknowledged; further uplinks wait for the fog's acks.
+type grpcTransport struct {
+	id    string
+	cfg   model.GatewayGRPCConfig
+	token string // gateway token, sent as bearer metadata on every stream
+	hooks TransportHooks
+	conn  *grpc.ClientConn
+
+	mu       sync.Mutex
+	out      chan *gwstream.Frame // send queue of the open stream; nil while disconnected
+	inflight map[uint64]struct{}  // unacknowledged uplink sequence numbers
+	credit   chan struct{}        // uplink window, one slot per unacknowledged uplink
+	seq      atomic.Uint64
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newGRPCTransport returns a stream transport for gateway id that
+// authenticates with token.
+func newGRPCTransport(id string, c model.GatewayGRPCConfig, token string) *grpcTransport {
+	if c.Window <= 0 {
+		c.Window = defaultGRPCWindow
+	}
+	return &grpcTransport{
+		id:       id,
+		cfg:      c,
+		token:    token,
+		inflight: map[uint64]struct{}{},
+		credit:   make(chan struct{}, c.Window),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Name implements UplinkTransport.
+func (t *grpcTransport) Name() string { return TransportGRPC }
+
+// Start implements UplinkTransport. It keeps one stream to the fog open,
+// reconnecting with backoff until Stop.
+func (t *grpcTransport) Start(h TransportHooks) error {
+	t.hooks = h
+	t.stop = make(chan struct{})
+	creds := insecure.NewCredentials()
+	if !t.cfg.Insecure {
+		var err error
+		if creds, err = t.tlsCredentials(); err != nil {
+			return fmt.Errorf("grpc tls: %w", err)
+		}
+	}
+	cc, err := grpc.NewClient(t.cfg.Server,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{Time: 30 * time.Second, Timeout: 10 * time.Second, PermitWithoutStream: true}),
+	)
+	if err != nil {
+		return fmt.Errorf("grpc client: %w", err)
+	}
+	t.conn = cc
+	ctx, cancel := context.WithCancel(context.Background())
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		defer cancel()
+		backoff := time.Second
+		for {
+			started := time.Now()
+			err := t.session(ctx)
+			select {
+			case <-t.stop:
+				return
+			default:
+			}
+			if time.Since(started) > grpcMaxBackoff {
+				backoff = time.Second
+			}
+			log.Printf("[gateway %s] stream to %s ended: %v; reconnecting in %s", t.id, t.cfg.Server, err, backoff)
+			select {
+			case <-t.stop:
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, grpcMaxBackoff)
+		}
+	}()
+	go func() {
+		<-t.stop
+		cancel()
+	}()
+	return nil
+}
+
+// tlsCredentials verifies the fog against ca_file, or the system roots.
+func (t *grpcTransport) tlsCredentials() (credentials.TransportCredentials, error) {
+	if t.cfg.CAFile == "" {
+		return credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12}), nil
+	}
+	return credentials.NewClientTLSFromFile(t.cfg.CAFile, "")
+}
+
+// Stop implements UplinkTransport.
+func (t *grpcTransport) Stop() {
+	select {
+	case <-t.stop:
+		return
+	default:
+		close(t.stop)
+	}
+	t.wg.Wait()
+	if t.conn != nil {
+		if err := t.conn.Close(); err != nil {
+			log.Printf("[gateway %s] grpc close error: %v", t.id, err)
+		}
+	}
+}
+
+// session runs one stream: it announces the gateway, reports the session up,
+// then sends queued frames while a reader handles acks and downlinks.
+func (t *grpcTransport) session(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+t.token)
+	stream, err := gwstream.Connect(ctx, t.conn)
+	if err != nil {
+		return err
+	}
+	if err := stream.SendMsg(&gwstream.Frame{Type: gwstream.Hello, GatewayID: t.id, Window: t.cfg.Window}); err != nil {
+		return err
+	}
+
+	out := make(chan *gwstream.Frame, 2*t.cfg.Window)
+	t.mu.Lock()
+	t.out = out
+	t.mu.Unlock()
+	defer t.reset()
+	log.Printf("[gateway %s] stream open to %s", t.id, t.cfg.Server)
+
+	if t.hooks.Up != nil {
+		t.hooks.Up()
+	}
+
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			var fr gwstream.Frame
+			if err := stream.RecvMsg(&fr); err != nil {
+				recvErr <- err
+				return
+			}
+			t.handle(ctx, out, &fr)
+		}
+	}()
+
+	for {
+		select {
+		case fr := <-out:
+			if err := stream.SendMsg(fr); err != nil {
+				return err
+			}
+		case err := <-recvErr:
+			return err
+		case <-ctx.Done():
+			return stream.CloseSend()
+		}
+	}
+}
+
+// handle dispatches one frame from the fog.
+func (t *grpcTransport) handle(ctx context.Context, out chan<- *gwstream.Frame, fr *gwstream.Frame) {
+	switch fr.Type {
+	case gwstream.Ack:
+		if fr.Error != "" {
+			log.Printf("[gateway %s] fog rejected uplink %d: %s", t.id, fr.Seq, fr.Error)
+		}
+		t.acked(fr.Seq)
+	case gwstream.Registered:
+		if fr.RegAck != nil {
+			t.hooks.Ack(*fr.RegAck)
+		}
+	case gwstream.Downlink:
+		ack := &gwstream.Frame{Type: gwstream.Ack, Seq: fr.Seq}
+		if err := t.hooks.Downlink(fr.Data); err != nil {
+			log.Printf("[gateway %s] stream downlink err: %v", t.id, err)
+			ack.Error = err.Error()
+		}
+		select {
+		case out <- ack:
+		case <-ctx.Done():
+		}
+	default:
+		log.Printf("[gateway %s] unexpected %q frame", t.id, fr.Type)
+	}
+}
+
+// reset marks the stream closed and returns the window held by uplinks
+// that were never acknowledged; those uplinks are lost.
+func (t *grpcTransport) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.out = nil
+	if n := len(t.inflight); n > 0 {
+		log.Printf("[gateway %s] %d uplinks unacknowledged at disconnect", t.id, n)
+	}
+	for seq := range t.inflight {
+		delete(t.inflight, seq)
+		<-t.credit
+	}
+}
+
+// acked releases the window slot of an acknowledged uplink.
+func (t *grpcTransport) acked(seq uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.inflight[seq]; ok {
+		delete(t.inflight, seq)
+		<-t.credit
+	}
+}
+
+// sendFrame queues a frame on the open stream.
+func (t *grpcTransport) sendFrame(fr *gwstream.Frame) error {
+	t.mu.Lock()
+	out := t.out
+	t.mu.Unlock()
+	if out == nil {
+		return ErrTransportDown
+	}
+	select {
+	case out <- fr:
+		return nil
+	case <-t.stop:
+		return ErrTransportDown
+	case <-time.After(grpcSendWait):
+		return errors.New("stream send queue full")
+	}
+}
+
+// Send implements UplinkTransport. It waits for room in the window; the slot
+// is held until the fog acks the uplink.
+func (t *grpcTransport) Send(up OutboundUplink) error {
+	select {
+	case t.credit <- struct{}{}:
+	case <-t.stop:
+		return ErrTransportDown
+	case <-time.After(grpcSendWait):
+		return fmt.Errorf("uplink window of %d full", t.cfg.Window)
+	}
+	t.mu.Lock()
+	if t.out == nil {
+		<-t.credit
+		t.mu.Unlock()
+		return ErrTransportDown
+	}
+	seq := t.seq.Add(1)
+	t.inflight[seq] = struct{}{}
+	t.mu.Unlock()
+
+	err := t.sendFrame(&gwstream.Frame{
+		Type:   gwstream.Uplink,
+		Seq:    seq,
+		Uplink: &model.GatewayUplink{Data: up.Data, RSSI: up.Meta.RSSI, SNR: up.Meta.SNR},
+	})
+	if err != nil {
+		t.acked(seq)
+	}
+	return err
+}
+
+// Register implements UplinkTransport; the ack arrives on the stream.
+func (t *grpcTransport) Register(reg model.GatewayRegistration) error {
+	return t.sendFrame(&gwstream.Frame{Type: gwstream.Register, Registration: &reg})
+}
+
+// Health implements UplinkTransport with the stream state.
+func (t *grpcTransport) Health() health.Check {
+	t.mu.Lock()
+	open, inflight := t.out != nil, len(t.inflight)
+	t.mu.Unlock()
+	if !open {
+		return health.Check{Name: "fog", Status: health.StatusFail, Detail: "no stream to " + t.cfg.Server}
+	}
+	return health.Info("fog", "stream open to %s, %d/%d uplinks in flight", t.cfg.Server, inflight, t.cfg.Window)
+}
@@ -0,0 +1,344 @@
+package core
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"LoraFog/internal/gwmp"
+	"LoraFog/internal/health"
+	"LoraFog/internal/lora"
+	"LoraFog/internal/lorawan"
+	"LoraFog/internal/model"
+)
+
+const (
+	defaultGWMPPull      = 10 * time.Second
+	defaultGWMPUplinkMHz = 868.1
+)
+
+// gwmpTransport makes the gateway act as a Semtech UDP packet forwarder
+// towards the fog or a LoRaWAN network server. PULL_DATA keepalives open the
+// downlink path and stand in for registration. Frames carry wire-format
+// lines as the fog expects them, or, with gwmp.lorawan sessions configured,
+// LoRaWAN data messages as ChirpStack expects them.
+type gwmpTransport struct {
+	id    string
+	cfg   model.GatewayGWMPConfig
+	radio model.RadioConfig
+	hooks TransportHooks
+
+	devices map[string]*lorawanDevice // vehicle ID -> session; nil sends raw lines
+	byAddr  map[uint32]*lorawanDevice // DevAddr -> session, for downlinks
+
+	conn  *net.UDPConn
+	eui   gwmp.EUI
+	token atomic.Uint32
+	ack   atomic.Int64 // unix nanos of the last PUSH_ACK/PULL_ACK
+	stop  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// lorawanDevice is the ABP session of one vehicle whose frames are
+// forwarded as LoRaWAN data messages.
+type lorawanDevice struct {
+	vehicle string
+	session lorawan.Session
+	fport   uint8
+
+	mu       sync.Mutex
+	fcntUp   uint32 // next uplink counter, for vehicles sending none
+	fcntDown uint32 // last downlink counter accepted
+}
+
+// newGWMPTransport returns a packet-forwarder transport for gateway id;
+// radio supplies the modulation reported with uplinks.
+func newGWMPTransport(id string, c model.GatewayGWMPConfig, radio model.RadioConfig) *gwmpTransport {
+	t := &gwmpTransport{id: id, cfg: c, radio: radio, stop: make(chan struct{})}
+	if len(c.LoRaWAN) > 0 {
+		t.devices = make(map[string]*lorawanDevice, len(c.LoRaWAN))
+		t.byAddr = make(map[uint32]*lorawanDevice, len(c.LoRaWAN))
+	}
+	for vehicle, dc := range c.LoRaWAN {
+		s, err := lorawan.ParseSession(dc.DevAddr, dc.NwkSKey, dc.AppSKey)
+		if err != nil {
+			log.Printf("[config] gateway %s: vehicle %s: %v; its frames are dropped", id, vehicle, err)
+			continue
+		}
+		fport := dc.FPort
+		if fport <= 0 || fport > 223 {
+			fport = 1
+		}
+		d := &lorawanDevice{vehicle: vehicle, session: s, fport: uint8(fport)}
+		t.devices[vehicle] = d
+		t.byAddr[s.DevAddr] = d
+	}
+	return t
+}
+
+// Name implements UplinkTransport.
+func (t *gwmpTransport) Name() string { return TransportGWMP }
+
+// gatewayEUI returns the configured gateway EUI, or one derived from the gateway ID.
+func (t *gwmpTransport) gatewayEUI() gwmp.EUI {
+	if t.cfg.EUI != "" {
+		eui, err := gwmp.ParseEUI(t.cfg.EUI)
+		if err == nil {
+			return eui
+		}
+		log.Printf("[gateway %s] %v; deriving EUI from ID", t.id, err)
+	}
+	var eui gwmp.EUI
+	sum := sha1.Sum([]byte(t.id))
+	copy(eui[:], sum[:])
+	return eui
+}
+
+// Start implements UplinkTransport: PULL_DATA keepalives open the downlink
+// path and a reader handles acks and PULL_RESP.
+func (t *gwmpTransport) Start(h TransportHooks) error {
+	t.hooks = h
+	t.stop = make(chan struct{})
+	addr, err := net.ResolveUDPAddr("udp", t.cfg.Server)
+	if err != nil {
+		return fmt.Errorf("gwmp server: %w", err)
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return fmt.Errorf("gwmp dial: %w", err)
+	}
+	t.conn = conn
+	t.eui = t.gatewayEUI()
+	log.Printf("[gateway %s] forwarding to %s as EUI %s", t.id, t.cfg.Server, t.eui)
+
+	interval := t.pullInterval()
+
+	t.wg.Add(2)
+	go t.readLoop()
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			t.send(gwmp.Packet{Type: gwmp.PullData, EUI: t.eui})
+			select {
+			case <-t.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop implements UplinkTransport.
+func (t *gwmpTransport) Stop() {
+	select {
+	case <-t.stop:
+		return
+	default:
+		close(t.stop)
+	}
+	if t.conn != nil {
+		if err := t.conn.Close(); err != nil {
+			log.Printf("[gateway %s] gwmp close error: %v", t.id, err)
+		}
+	}
+	t.wg.Wait()
+}
+
+// pullInterval returns the PULL_DATA keepalive period.
+func (t *gwmpTransport) pullInterval() time.Duration {
+	if t.cfg.PullIntervalS > 0 {
+		return time.Duration(t.cfg.PullIntervalS) * time.Second
+	}
+	return defaultGWMPPull
+}
+
+// send writes one datagram with a fresh token.
+func (t *gwmpTransport) send(p gwmp.Packet) {
+	if p.Token == 0 {
+		p.Token = uint16(t.token.Add(1))
+	}
+	if _, err := t.conn.Write(p.Marshal()); err != nil {
+		log.Printf("[gateway %s] gwmp send %s: %v", t.id, p.Type, err)
+	}
+}
+
+// readLoop handles acks and downlinks from the network server until the
+// connection is closed by Stop.
+func (t *gwmpTransport) readLoop() {
+	defer t.wg.Done()
+	buf := make([]byte, gwmpMaxDatagram)
+	for {
+		n, err := t.conn.Read(buf)
+		if err != nil {
+			select {
+			case <-t.stop:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("[gateway %s] gwmp read: %v", t.id, err)
+			continue
+		}
+		pkt, err := gwmp.Unmarshal(buf[:n])
+		if err != nil {
+			log.Printf("[gateway %s] gwmp invalid datagram: %v", t.id, err)
+			continue
+		}
+		switch pkt.Type {
+		case gwmp.PushAck, gwmp.PullAck:
+			t.ack.Store(time.Now().UnixNano())
+		case gwmp.PullResp:
+			t.tx(pkt)
+		}
+	}
+}
+
+// tx transmits a PULL_RESP downlink and answers with TX_ACK. Duty-cycle
+// rejections are reported as TOO_EARLY so the server may retry later.
+func (t *gwmpTransport) tx(pkt gwmp.Packet) {
+	var resp gwmp.PullRespPayload
+	ack := gwmp.TxAckPayload{}
+	ack.TxPkAck.Error = gwmp.TxAckNone
+	if err := json.Unmarshal(pkt.Payload, &resp); err != nil {
+		log.Printf("[gateway %s] gwmp invalid PULL_RESP: %v", t.id, err)
+		ack.TxPkAck.Error = gwmp.TxAckTooLate
+	} else if line, ok := t.downlinkLine(resp.TXPK.Data); !ok {
+		ack.TxPkAck.Error = gwmp.TxAckTooLate
+	} else if line != nil {
+		if err := t.hooks.Downlink(line); err != nil {
+			log.Printf("[gateway %s] gwmp downlink err: %v", t.id, err)
+			ack.TxPkAck.Error = gwmp.TxAckTooLate
+			if errors.Is(err, lora.ErrDutyCycle) {
+				ack.TxPkAck.Error = gwmp.TxAckTooEarly
+			}
+		}
+	}
+	body, err := json.Marshal(ack)
+	if err != nil {
+		return
+	}
+	t.send(gwmp.Packet{Version: pkt.Version, Token: pkt.Token, Type: gwmp.TxAck, EUI: t.eui, Payload: body})
+}
+
+// downlinkLine returns the control line carried by a PULL_RESP payload:
+// the payload itself, or with LoRaWAN sessions the decrypted FRMPayload of
+// the data down message (nil when it carries no application data). ok is
+// false for frames that cannot be accepted.
+func (t *gwmpTransport) downlinkLine(data []byte) (line []byte, ok bool) {
+	if t.devices == nil {
+		return data, true
+	}
+	if len(data) < 5 {
+		log.Printf("[gateway %s] gwmp downlink of %d bytes is not a LoRaWAN frame", t.id, len(data))
+		return nil, false
+	}
+	d := t.byAddr[binary.LittleEndian.Uint32(data[1:5])]
+	if d == nil {
+		log.Printf("[gateway %s] gwmp downlink for unknown DevAddr %X", t.id, data[1:5])
+		return nil, false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f, err := d.session.DecodeDown(data, d.fcntDown)
+	if err != nil {
+		log.Printf("[gateway %s] gwmp downlink for %s: %v", t.id, d.vehicle, err)
+		return nil, false
+	}
+	d.fcntDown = f.FCnt
+	if f.FPort <= 0 || len(f.Payload) == 0 {
+		return nil, true
+	}
+	return f.Payload, true
+}
+
+// phyPayload returns what goes in rxpk.data for up: the frame as received
+// from the radio, or with LoRaWAN sessions an unconfirmed data up message
+// of the vehicle's session. The vehicle's own frame counter is used when
+// it sends one; otherwise the gateway counts from 0 since its start.
+func (t *gwmpTransport) phyPayload(up OutboundUplink) ([]byte, error) {
+	if t.devices == nil {
+		return []byte(up.Frame), nil
+	}
+	d := t.devices[up.Vehicle]
+	if d == nil {
+		return nil, fmt.Errorf("no LoRaWAN session for vehicle %s", up.Vehicle)
+	}
+	d.mu.Lock()
+	fcnt := up.FCnt
+	if fcnt == 0 {
+		fcnt = d.fcntUp
+		d.fcntUp++
+	}
+	d.mu.Unlock()
+	return d.session.EncodeUp(fcnt, d.fport, []byte(up.Frame))
+}
+
+// Send implements UplinkTransport with one PUSH_DATA carrying phyPayload.
+func (t *gwmpTransport) Send(up OutboundUplink) error {
+	data, err := t.phyPayload(up)
+	if err != nil {
+		return err
+	}
+	freq := t.cfg.FreqMHz
+	if freq == 0 {
+		freq = defaultGWMPUplinkMHz
+	}
+	sf, bw := t.radio.SpreadingFactor, t.radio.BandwidthKHz
+	if sf == 0 {
+		sf = 7
+	}
+	if bw == 0 {
+		bw = 125
+	}
+	now := gwmp.CompactTime(time.Now())
+	body, err := json.Marshal(gwmp.PushDataPayload{RXPK: []gwmp.RXPK{{
+		Time: &now,
+		Tmst: uint32(time.Now().UnixMicro()),
+		Freq: freq,
+		Stat: 1,
+		Modu: "LORA",
+		DatR: gwmp.DataRate(sf, bw),
+		CodR: gwmp.CodingRate(t.radio.CodingRate),
+		RSSI: up.Meta.RSSI,
+		LSNR: up.Meta.SNR,
+		Size: len(data),
+		Data: data,
+	}}})
+	if err != nil {
+		return err
+	}
+	t.send(gwmp.Packet{Type: gwmp.PushData, EUI: t.eui, Payload: body})
+	return nil
+}
+
+// Register implements UplinkTransport. PULL_DATA keepalives register the
+// gateway with the server, so heartbeats have nothing to send.
+func (t *gwmpTransport) Register(model.GatewayRegistration) error { return nil }
+
+// Health implements UplinkTransport with the age of the last PUSH/PULL ack.
+func (t *gwmpTransport) Health() health.Check {
+	c := health.Check{Name: "fog", Status: health.StatusOK}
+	ns := t.ack.Load()
+	if ns == 0 {
+		c.Status, c.Detail = health.StatusFail, "no ack from "+t.cfg.Server
+		return c
+	}
+	age := time.Since(time.Unix(0, ns)).Truncate(time.Millisecond)
+	c.Detail = fmt.Sprintf("%s acked %s ago", t.cfg.Server, age)
+	if age > 3*t.pullInterval() {
+		c.Status = health.StatusFail
+	}
+	return c
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"LoraFog/internal/gwmp"
+	"LoraFog/internal/lorawan"
+	"LoraFog/internal/model"
+)
+
+// readGWMP reads datagrams from conn until one of type want arrives.
+func readGWMP(t *testing.T, conn *net.UDPConn, want gwmp.Type) (gwmp.Packet, *net.UDPAddr) {
+	t.Helper()
+	buf := make([]byte, gwmpMaxDatagram)
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("waiting for %s: %v", want, err)
+		}
+		pkt, err := gwmp.Unmarshal(buf[:n])
+		if err == nil && pkt.Type == want {
+			return pkt, from
+		}
+	}
+}
+
+func TestGWMPTransportLoRaWAN(t *testing.T) {
+	// network server stand-in
+	lns, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lns.Close()
+
+	const nwk, app = "44024241ed4ce9a68c6a8bc055233fd3", "ec925802ae430ca77fd3dd73cb2cc588"
+	session, err := lorawan.ParseSession("260B1234", nwk, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := newGWMPTransport("GW01", model.GatewayGWMPConfig{
+		Server:  lns.LocalAddr().String(),
+		LoRaWAN: map[string]model.LoRaWANDeviceConfig{"VH01": {DevAddr: "260B1234", NwkSKey: nwk, AppSKey: app, FPort: 2}},
+	}, model.RadioConfig{})
+	downlinks := make(chan string, 1)
+	if err := tr.Start(TransportHooks{Downlink: func(p []byte) error {
+		downlinks <- string(p)
+		return nil
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Stop()
+	_, gwAddr := readGWMP(t, lns, gwmp.PullData)
+
+	// uplinks are unconfirmed data up frames of the vehicle's session
+	line := "VH01,10.100000,106.200000,90,95,40,42,1"
+	for i, fcnt := range []uint32{0, 1} {
+		if err := tr.Send(OutboundUplink{Frame: line, Vehicle: "VH01"}); err != nil {
+			t.Fatal(err)
+		}
+		pkt, _ := readGWMP(t, lns, gwmp.PushData)
+		var push gwmp.PushDataPayload
+		if err := json.Unmarshal(pkt.Payload, &push); err != nil || len(push.RXPK) != 1 {
+			t.Fatalf("PUSH_DATA %s: %v", pkt.Payload, err)
+		}
+		want, _ := session.EncodeUp(fcnt, 2, []byte(line))
+		if got := push.RXPK[0]; string(got.Data) != string(want) || got.Size != len(want) {
+			t.Errorf("uplink %d: rxpk.data %x, want %x", i, got.Data, want)
+		}
+	}
+	if err := tr.Send(OutboundUplink{Frame: "VH09,1,2,0,0,0,0,0", Vehicle: "VH09"}); err == nil {
+		t.Error("uplink of a vehicle without session sent")
+	}
+
+	// a data down frame is checked and decrypted into the control line
+	pullResp := func(token uint16, phy []byte) string {
+		body, _ := json.Marshal(gwmp.PullRespPayload{TXPK: gwmp.TXPK{Imme: true, Data: phy, Size: len(phy)}})
+		if _, err := lns.WriteToUDP(gwmp.Packet{Token: token, Type: gwmp.PullResp, Payload: body}.Marshal(), gwAddr); err != nil {
+			t.Fatal(err)
+		}
+		pkt, _ := readGWMP(t, lns, gwmp.TxAck)
+		var ack gwmp.TxAckPayload
+		if err := json.Unmarshal(pkt.Payload, &ack); err != nil || pkt.Token != token {
+			t.Fatalf("TX_ACK %s (token %d): %v", pkt.Payload, pkt.Token, err)
+		}
+		return ack.TxPkAck.Error
+	}
+	control := "VH01,1,30,10.762622,106.660172"
+	phy, _ := session.EncodeDown(5, 2, []byte(control))
+	if e := pullResp(1, phy); e != gwmp.TxAckNone {
+		t.Fatalf("TX_ACK error %s", e)
+	}
+	select {
+	case got := <-downlinks:
+		if got != control {
+			t.Errorf("downlink %q, want %q", got, control)
+		}
+	default:
+		t.Fatal("downlink not delivered")
+	}
+	phy[len(phy)-1] ^= 1
+	if e := pullResp(2, phy); e == gwmp.TxAckNone {
+		t.Error("frame with a bad MIC acknowledged")
+	}
+	if len(downlinks) != 0 {
+		t.Error("frame with a bad MIC delivered")
+	}
+}
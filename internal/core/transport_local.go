@@ -0,0 +1,90 @@
+package core
+
+import (
+	"fmt"
+	"time"
+
+	"LoraFog/internal/health"
+	"LoraFog/internal/model"
+)
+
+// localRouteScheme marks registry URLs of gateways running in the fog's process.
+const localRouteScheme = "local://"
+
+// localTransport hands uplinks straight to a fog running in the same System
+// and receives downlinks as function calls, without any network hop.
+type localTransport struct {
+	id    string
+	fog   *FogServer
+	hooks TransportHooks
+}
+
+// newLocalTransport returns an in-process transport to fog.
+func newLocalTransport(id string, fog *FogServer) *localTransport {
+	return &localTransport{id: id, fog: fog}
+}
+
+// Name implements UplinkTransport.
+func (t *localTransport) Name() string { return TransportLocal }
+
+// Start implements UplinkTransport by attaching the gateway's downlink
+// handler to the fog.
+func (t *localTransport) Start(h TransportHooks) error {
+	t.hooks = h
+	t.fog.attachLocal(t.id, h.Downlink)
+	if h.Up != nil {
+		h.Up()
+	}
+	return nil
+}
+
+// Stop implements UplinkTransport.
+func (t *localTransport) Stop() { t.fog.detachLocal(t.id) }
+
+// Send implements UplinkTransport, feeding de-duplication like POST /api/telemetry.
+func (t *localTransport) Send(up OutboundUplink) error {
+	vd, err := decodeTelemetry([]byte(up.Data))
+	if err != nil {
+		return fmt.Errorf("decode: %w", err)
+	}
+	meta := up.Meta
+	meta.ReceivedAt = time.Now()
+	return t.fog.ingest(vd, meta)
+}
+
+// Register implements UplinkTransport; the route points back at this process.
+func (t *localTransport) Register(reg model.GatewayRegistration) error {
+	reg.URL = localRouteScheme + t.id
+	t.hooks.Ack(t.fog.registerGateway(reg))
+	return nil
+}
+
+// Health implements UplinkTransport.
+func (t *localTransport) Health() health.Check {
+	return health.Info("fog", "in-process")
+}
+
+// attachLocal routes downlinks for local://id to down.
+func (f *FogServer) attachLocal(id string, down func([]byte) error) {
+	f.mu.Lock()
+	f.local[id] = down
+	f.mu.Unlock()
+}
+
+// detachLocal removes an in-process gateway's downlink handler.
+func (f *FogServer) detachLocal(id string) {
+	f.mu.Lock()
+	delete(f.local, id)
+	f.mu.Unlock()
+}
+
+// sendLocal hands a control payload to an in-process gateway.
+func (f *FogServer) sendLocal(id string, payload []byte) error {
+	f.mu.Lock()
+	down, ok := f.local[id]
+	f.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("gateway %s is not running in this process", id)
+	}
+	return down(payload)
+}
@@ -0,0 +1,147 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"LoraFog/internal/health"
+	"LoraFog/internal/model"
+)
+
+// mqttTransport keeps one persistent session to the fog's embedded broker
+// for uplinks, heartbeats and downlinks instead of one HTTP request per packet.
+type mqttTransport struct {
+	id     string
+	cfg    model.GatewayMQTTConfig
+	client mqtt.Client
+	hooks  TransportHooks
+}
+
+// newMQTTTransport returns a broker transport for gateway id. Unless set
+// otherwise, it logs in with the gateway ID and registration token, which
+// is what the embedded broker expects.
+func newMQTTTransport(id string, c model.GatewayMQTTConfig, token string) *mqttTransport {
+	if c.Username == "" {
+		c.Username = id
+	}
+	if c.Password == "" {
+		c.Password = token
+	}
+	return &mqttTransport{id: id, cfg: c}
+}
+
+// Name implements UplinkTransport.
+func (t *mqttTransport) Name() string { return TransportMQTT }
+
+// Start implements UplinkTransport. It opens the gateway's persistent broker
+// session. The client ID is the gateway ID, so the broker keeps QoS 1
+// downlinks across reconnects.
+func (t *mqttTransport) Start(h TransportHooks) error {
+	t.hooks = h
+	opts := mqtt.NewClientOptions().
+		AddBroker(t.cfg.Broker).
+		SetClientID(t.id).
+		SetUsername(t.cfg.Username).
+		SetPassword(t.cfg.Password).
+		SetCleanSession(false).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(2 * time.Second).
+		SetMaxReconnectInterval(30 * time.Second).
+		SetOnConnectHandler(t.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("[gateway %s] broker connection lost: %v", t.id, err)
+		})
+	t.client = mqtt.NewClient(opts)
+	log.Printf("[gateway %s] connecting to broker %s", t.id, t.cfg.Broker)
+	t.client.Connect() // retries in the background until connected
+	return nil
+}
+
+// Stop implements UplinkTransport.
+func (t *mqttTransport) Stop() {
+	if t.client != nil {
+		t.client.Disconnect(250)
+	}
+}
+
+// onConnect subscribes to downlinks and acks, then reports the session up
+// so the gateway registers right away.
+func (t *mqttTransport) onConnect(c mqtt.Client) {
+	subs := map[string]byte{gwTopic(t.id, topicDown): 1, gwTopic(t.id, topicAck): 1}
+	tok := c.SubscribeMultiple(subs, t.handleMessage)
+	if !tok.WaitTimeout(mqttTimeout) || tok.Error() != nil {
+		log.Printf("[gateway %s] broker subscribe failed: %v", t.id, tok.Error())
+		return
+	}
+	log.Printf("[gateway %s] connected to broker %s", t.id, t.cfg.Broker)
+	if t.hooks.Up != nil {
+		t.hooks.Up()
+	}
+}
+
+// handleMessage dispatches downlinks and registration acks from the fog.
+func (t *mqttTransport) handleMessage(_ mqtt.Client, msg mqtt.Message) {
+	switch {
+	case strings.HasSuffix(msg.Topic(), "/"+topicDown):
+		if err := t.hooks.Downlink(msg.Payload()); err != nil {
+			log.Printf("[gateway %s] broker downlink err: %v", t.id, err)
+		}
+	case strings.HasSuffix(msg.Topic(), "/"+topicAck):
+		var ack model.GatewayRegistrationAck
+		if err := json.Unmarshal(msg.Payload(), &ack); err != nil {
+			log.Printf("[gateway %s] invalid ack: %v", t.id, err)
+			return
+		}
+		t.hooks.Ack(ack)
+	}
+}
+
+// publish publishes a QoS 1 message and waits for the broker's ack.
+func (t *mqttTransport) publish(topic string, payload []byte) error {
+	if !t.connected() {
+		return ErrTransportDown
+	}
+	tok := t.client.Publish(topic, 1, false, payload)
+	if !tok.WaitTimeout(mqttTimeout) {
+		return fmt.Errorf("publish %s: timeout", topic)
+	}
+	return tok.Error()
+}
+
+// Send implements UplinkTransport; the encoded line is published with its
+// link quality.
+func (t *mqttTransport) Send(up OutboundUplink) error {
+	b, err := json.Marshal(model.GatewayUplink{Data: up.Data, RSSI: up.Meta.RSSI, SNR: up.Meta.SNR})
+	if err != nil {
+		return err
+	}
+	return t.publish(gwTopic(t.id, topicUp), b)
+}
+
+// Register implements UplinkTransport; the ack arrives on the gateway's ack topic.
+func (t *mqttTransport) Register(reg model.GatewayRegistration) error {
+	b, err := json.Marshal(reg)
+	if err != nil {
+		return err
+	}
+	return t.publish(gwTopic(t.id, topicRegister), b)
+}
+
+// Health implements UplinkTransport with the broker session state.
+func (t *mqttTransport) Health() health.Check {
+	if !t.connected() {
+		return health.Check{Name: "fog", Status: health.StatusFail, Detail: "disconnected from " + t.cfg.Broker}
+	}
+	return health.Info("fog", "connected to %s", t.cfg.Broker)
+}
+
+// connected reports whether the broker session is open.
+func (t *mqttTransport) connected() bool {
+	return t.client != nil && t.client.IsConnectionOpen()
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"LoraFog/internal/device"
+	"LoraFog/internal/model"
+)
+
+// HTTP headers carrying uplink metadata from gateways to the fog.
+const (
+	HeaderGatewayID = "X-Gateway-ID"
+	HeaderRSSI      = "X-LoRa-RSSI"
+	HeaderSNR       = "X-LoRa-SNR"
+)
+
+// setMetaHeaders writes uplink metadata onto an outgoing gateway request.
+func setMetaHeaders(h http.Header, m model.UplinkMeta) {
+	h.Set(HeaderGatewayID, m.GatewayID)
+	if m.HasLink() {
+		h.Set(HeaderRSSI, strconv.FormatFloat(m.RSSI, 'f', 1, 64))
+		h.Set(HeaderSNR, strconv.FormatFloat(m.SNR, 'f', 1, 64))
+	}
+}
+
+// metaFromRequest reads uplink metadata from an incoming telemetry request
+// whose X-Gateway-ID has been authorized.
+func metaFromRequest(r *http.Request) model.UplinkMeta {
+	m := model.UplinkMeta{
+		GatewayID:  r.Header.Get(HeaderGatewayID),
+		ReceivedAt: time.Now(),
+	}
+	m.RSSI, _ = strconv.ParseFloat(r.Header.Get(HeaderRSSI), 64)
+	m.SNR, _ = strconv.ParseFloat(r.Header.Get(HeaderSNR), 64)
+	return m
+}
+
+// bearerToken returns the bearer token of a gateway request.
+func bearerToken(r *http.Request) string {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token
+}
+
+// linkMeta builds uplink metadata for a frame just read from dev by gateway id.
+func linkMeta(id string, dev device.Device) model.UplinkMeta {
+	m := model.UplinkMeta{GatewayID: id, ReceivedAt: time.Now()}
+	if lq, ok := dev.(device.LinkQualityReporter); ok {
+		if rssi, snr, ok := lq.LinkQuality(); ok {
+			m.RSSI, m.SNR = rssi, snr
+		}
+	}
+	return m
+}
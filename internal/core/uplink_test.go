@@ -0,0 +1,67 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"LoraFog/internal/model"
+)
+
+func TestTelemetryRequiresGatewayToken(t *testing.T) {
+	f := NewFogServer("127.0.0.1:0", "")
+	f.SetDedupWindow(0)
+	f.SetFleet([]string{"VH01"})
+	f.SetGatewayTokens(map[string]string{"GW01": "secret"})
+	line := "VH01,10.1,106.2,0,0,0,0,0"
+
+	cases := []struct {
+		name    string
+		gateway string
+		token   string
+		want    int
+	}{
+		{"no gateway id", "", "secret", http.StatusUnauthorized},
+		{"no token", "GW01", "", http.StatusUnauthorized},
+		{"wrong token", "GW01", "guess", http.StatusUnauthorized},
+		{"unknown gateway", "GW99", "secret", http.StatusUnauthorized},
+		{"authorized", "GW01", "secret", http.StatusOK},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodPost, "/api/telemetry", strings.NewReader(line))
+		req.Header.Set(HeaderGatewayID, c.gateway)
+		req.Header.Set(HeaderRSSI, "-30")
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		rec := httptest.NewRecorder()
+		f.handleTelemetry(rec, req)
+		if rec.Code != c.want {
+			t.Errorf("%s: status %d, want %d", c.name, rec.Code, c.want)
+		}
+	}
+	if up, ok := lastUplinkOf(f, "VH01"); !ok || up.Meta.GatewayID != "GW01" {
+		t.Fatalf("authorized uplink %+v (%v)", up, ok)
+	}
+}
+
+func TestHTTPTransportSendsToken(t *testing.T) {
+	f := NewFogServer("127.0.0.1:0", "")
+	f.SetDedupWindow(0)
+	f.SetFleet([]string{"VH01"})
+	f.SetGatewayTokens(map[string]string{"GW01": "secret"})
+	fog := httptest.NewServer(http.HandlerFunc(f.handleTelemetry))
+	defer fog.Close()
+
+	up := OutboundUplink{Data: "VH01,10.1,106.2,0,0,0,0,0", ContentType: "text/plain", Meta: model.UplinkMeta{GatewayID: "GW01"}}
+	if err := newHTTPTransport("GW01", []string{fog.URL}, "wrong").Send(up); err == nil {
+		t.Fatal("uplink with a wrong token accepted")
+	}
+	if err := newHTTPTransport("GW01", []string{fog.URL}, "secret").Send(up); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := lastUplinkOf(f, "VH01"); !ok {
+		t.Fatal("uplink not published")
+	}
+}
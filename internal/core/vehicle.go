@@ -1,13 +1,18 @@
 package core
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"LoraFog/internal/device"
+	"LoraFog/internal/lora"
 	"LoraFog/internal/model"
 	"LoraFog/internal/parser"
 )
@@ -20,18 +25,37 @@ type Vehicle struct {
 	ArduinoDevice *device.ArduinoDevice
 	Parser        parser.Parser
 	Interval      time.Duration
+	StatusAddr    string            // optional local /healthz, /readyz and /metrics listener
+	Radio         model.RadioConfig // modulation and duty-cycle limits of the LoRa device
+	MetricsEvery  time.Duration     // period of metrics frames pushed over LoRa; <= 0 disables
+	FrameCounter  bool              // number uplinks (9th CSV field); off for gateways that only accept 8 fields
 
+	loraDev       string
+	loraBaud      int
 	stop          chan struct{}
+	errs          chan error
 	wg            sync.WaitGroup
+	statusSrv     *http.Server
+	mu            sync.Mutex
 	lastTelemetry model.ArduinoData
 	lastUpdate    time.Time
+	fcnt          atomic.Uint32 // uplink frame counter
+	opens         int           // successful openDevice calls; later ones are reconnects
+	telemetryTx   atomic.Uint64
+	controlRx     atomic.Uint64
+	decodeErrs    atomic.Uint64
+	reconnects    atomic.Uint64
 	arduinoFn     func()
 }
 
 // NewVehicle constructs a Vehicle with given identifiers, device paths and parser.
 func NewVehicle(id, loraDev string, loraBaud int, arduinoID string, arduinoDev string, arduinoBaud int, interval time.Duration, p parser.Parser) *Vehicle {
-	dev, _ := device.NewSerialDevice(loraDev, loraBaud)
-	v := &Vehicle{ID: id, Device: dev, Parser: p, Interval: interval, stop: make(chan struct{})}
+	v := &Vehicle{ID: id, Parser: p, Interval: interval, loraDev: loraDev, loraBaud: loraBaud, stop: make(chan struct{})}
+	if dev, err := device.NewSerialDevice(loraDev, loraBaud); err == nil {
+		v.Device = dev
+	} else {
+		log.Printf("[vehicle %s] open serial %s err: %v", id, loraDev, err)
+	}
 	if arduinoDev != "" {
 		v.ArduinoDevice = device.NewArduinoDevice(arduinoID, arduinoDev, arduinoBaud)
 	}
@@ -42,6 +66,14 @@ func NewVehicle(id, loraDev string, loraBaud int, arduinoID string, arduinoDev s
 // It starts reading Arduino data and immediately sends telemetry upon new data arrival.
 // Optionally, it may still include a periodic heartbeat if needed.
 func (v *Vehicle) Start() error {
+	v.stop = make(chan struct{})
+	v.errs = make(chan error, 2)
+	if err := v.openDevice(); err != nil {
+		log.Printf("[vehicle %s] LoRa device unavailable: %v", v.ID, err)
+	}
+
+	v.statusSrv = v.serveStatus()
+
 	// --- 1. Start Arduino telemetry reader ---
 	if v.ArduinoDevice != nil {
 		ch := make(chan model.ArduinoData, 5)
@@ -49,6 +81,7 @@ func (v *Vehicle) Start() error {
 		// Start reading Arduino asynchronously
 		stop, err := v.ArduinoDevice.Read(ch)
 		if err != nil {
+			// keep sending heartbeats without sensor data; health reports the Arduino
 			log.Printf("[vehicle %s] Arduino start err: %v", v.ID, err)
 		} else {
 			log.Printf("[vehicle %s] Arduino start: success", v.ID)
@@ -64,12 +97,15 @@ func (v *Vehicle) Start() error {
 					case arduinoData, ok := <-ch:
 						if !ok {
 							log.Printf("[vehicle %s] Arduino channel closed", v.ID)
+							v.fail(fmt.Errorf("arduino channel closed"))
 							return
 						}
 						// Update last Arduino reading
 						log.Printf("[vehicle %s] received telemetry", v.ID)
+						v.mu.Lock()
 						v.lastTelemetry = arduinoData
 						v.lastUpdate = time.Now()
+						v.mu.Unlock()
 						v.sendTelemetry()
 						log.Printf("[vehicle %s] sended telemetry", v.ID)
 					}
@@ -92,7 +128,7 @@ func (v *Vehicle) Start() error {
 					return
 				case <-ticker.C:
 					// Only send heartbeat if no Arduino data for a while
-					if time.Since(v.lastUpdate) > v.Interval {
+					if time.Since(v.lastUpdateTime()) > v.Interval {
 						log.Printf("[vehicle %s] sending heartbeat", v.ID)
 						v.sendTelemetry()
 					}
@@ -101,6 +137,24 @@ func (v *Vehicle) Start() error {
 		}()
 	}
 
+	// metrics ticker – push own counters through the gateway
+	if v.MetricsEvery > 0 {
+		v.wg.Add(1)
+		go func() {
+			defer v.wg.Done()
+			ticker := time.NewTicker(v.MetricsEvery)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-v.stop:
+					return
+				case <-ticker.C:
+					v.sendMetrics()
+				}
+			}
+		}()
+	}
+
 	// --- 2. Start LoRa control listener ---
 	if v.Device != nil && v.ArduinoDevice != nil {
 		v.wg.Add(1)
@@ -128,6 +182,8 @@ func (v *Vehicle) Start() error {
 				// Parse control packet
 				control, err := v.Parser.DecodeControl(dataIn)
 				if err != nil {
+					v.decodeErrs.Add(1)
+					mDecodeErrors.With("vehicle", v.wireFormat()).Inc()
 					log.Printf("[vehicle %s] invalid control packet: %v (%s)", v.ID, err, dataIn)
 					continue
 				}
@@ -135,6 +191,8 @@ func (v *Vehicle) Start() error {
 					log.Printf("[vehicle %s] Reject control: %s", v.ID, dataIn)
 					continue
 				} else {
+					v.controlRx.Add(1)
+					mVehicleControls.With(v.ID).Inc()
 					log.Printf("[vehicle %s] Receive control packet: %s", v.ID, dataIn)
 				}
 
@@ -169,6 +227,69 @@ func (v *Vehicle) Start() error {
 	return nil
 }
 
+// lastUpdateTime returns when Arduino data was last received.
+func (v *Vehicle) lastUpdateTime() time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.lastUpdate
+}
+
+// openDevice (re)opens the LoRa serial device if it is absent or closed.
+// Only successful reopens count as reconnects.
+func (v *Vehicle) openDevice() error {
+	if v.Device != nil {
+		if err := v.Device.Open(); err != nil {
+			return err
+		}
+	} else {
+		dev, err := device.NewSerialDevice(v.loraDev, v.loraBaud)
+		if err != nil {
+			return err
+		}
+		v.Device = wrapRadio("vehicle "+v.ID, dev, v.Radio)
+	}
+	if v.opens++; v.opens > 1 {
+		v.reconnects.Add(1)
+		mSerialReconnects.With(v.Name()).Inc()
+	}
+	return nil
+}
+
+// SetRadio applies radio settings, wrapping the LoRa device with a duty-cycle limiter.
+func (v *Vehicle) SetRadio(rc model.RadioConfig) {
+	v.Radio = rc
+	v.Device = wrapRadio("vehicle "+v.ID, v.Device, rc)
+}
+
+// fail reports a fatal runtime error to Run without blocking.
+func (v *Vehicle) fail(err error) {
+	select {
+	case v.errs <- err:
+	default:
+	}
+}
+
+// Name implements Component.
+func (v *Vehicle) Name() string { return "vehicle/" + v.ID }
+
+// Dependencies implements Component; vehicles talk to gateways over LoRa only.
+func (v *Vehicle) Dependencies() []string { return nil }
+
+// Run implements Component. It starts the vehicle and blocks until ctx is
+// cancelled or the Arduino reader fails, then stops it.
+func (v *Vehicle) Run(ctx context.Context) error {
+	if err := v.Start(); err != nil {
+		return err
+	}
+	defer v.Stop()
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-v.errs:
+		return err
+	}
+}
+
 // Stop stops the vehicle goroutines, Arduino provider and closes the device.
 func (v *Vehicle) Stop() {
 	// close stop channel (idempotent)
@@ -180,17 +301,27 @@ func (v *Vehicle) Stop() {
 	}
 	if v.arduinoFn != nil {
 		v.arduinoFn()
+		v.arduinoFn = nil
 	}
 
-	v.wg.Wait()
+	if v.statusSrv != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		if err := v.statusSrv.Shutdown(ctx); err != nil {
+			log.Printf("[vehicle %s] status endpoint shutdown error: %v", v.ID, err)
+		}
+		cancel()
+		v.statusSrv = nil
+	}
 
-	// close LoRa serial
+	// close LoRa serial first so a blocked ReadLine returns
 	if v.Device != nil {
 		if err := v.Device.Close(); err != nil {
 			log.Printf("[vehicle %s] device close err: %v", v.ID, err)
 		}
 	}
 
+	v.wg.Wait()
+
 	// close Arduino serial
 	if v.ArduinoDevice != nil {
 		if err := v.ArduinoDevice.Close(); err != nil {
@@ -201,7 +332,10 @@ func (v *Vehicle) Stop() {
 
 // sendTelemetry builds a VehicleData from last data/fallback values and writes it to the Device.
 func (v *Vehicle) sendTelemetry() {
-	latitude, longitude := v.lastTelemetry.Latitude, v.lastTelemetry.Longitude
+	v.mu.Lock()
+	last := v.lastTelemetry
+	v.mu.Unlock()
+	latitude, longitude := last.Latitude, last.Longitude
 	if latitude == 0 && longitude == 0 {
 		// fallback coordinate (Hanoi)
 		latitude, longitude = 21.0285, 105.8048
@@ -210,12 +344,15 @@ func (v *Vehicle) sendTelemetry() {
 		VehicleID:   v.ID,
 		Latitude:    latitude,
 		Longitude:   longitude,
-		CurrentHead: v.lastTelemetry.CurrentHead,
-		TargetHead:  v.lastTelemetry.TargetHead,
-		LeftSpeed:   v.lastTelemetry.LeftSpeed,
-		RightSpeed:  v.lastTelemetry.RightSpeed,
+		CurrentHead: last.CurrentHead,
+		TargetHead:  last.TargetHead,
+		LeftSpeed:   last.LeftSpeed,
+		RightSpeed:  last.RightSpeed,
 		PID:         1,
 	}
+	if v.FrameCounter {
+		vd.FCnt = v.fcnt.Add(1)
+	}
 	line, err := v.Parser.EncodeTelemetry(vd)
 	if err != nil {
 		log.Printf("[vehicle %s] encode telemetry err: %v", v.ID, err)
@@ -225,7 +362,11 @@ func (v *Vehicle) sendTelemetry() {
 	}
 	if v.Device != nil {
 		if err := v.Device.WriteLine(line); err == nil {
+			v.telemetryTx.Add(1)
+			mVehicleTelemetry.With(v.ID).Inc()
 			log.Printf("[vehicle %s] sent telemetry: %s", v.ID, line)
+		} else if errors.Is(err, lora.ErrQueued) {
+			log.Printf("[vehicle %s] telemetry queued for airtime: %s", v.ID, line)
 		} else {
 			log.Printf("[vehicle %s] lora write err: %v", v.ID, err)
 		}
@@ -234,6 +375,42 @@ func (v *Vehicle) sendTelemetry() {
 	}
 }
 
+// wireFormat names the vehicle's parser for decode error labels.
+func (v *Vehicle) wireFormat() string {
+	if _, ok := v.Parser.(*parser.JSONParser); ok {
+		return "json"
+	}
+	return "csv"
+}
+
+// sendMetrics writes the vehicle's counters as a metrics frame, so gateways
+// can expose them for vehicles without a status endpoint.
+func (v *Vehicle) sendMetrics() {
+	if v.Device == nil {
+		return
+	}
+	m := model.VehicleMetrics{
+		VehicleID:    v.ID,
+		TelemetryTx:  v.telemetryTx.Load(),
+		ControlRx:    v.controlRx.Load(),
+		DecodeErrors: v.decodeErrs.Load(),
+		Reconnects:   v.reconnects.Load(),
+	}
+	if u, ok := airtimeUsage(v.Device); ok {
+		m.AirtimeMs = uint64(u.Airtime.Milliseconds())
+	}
+	line := parser.EncodeMetrics(m)
+	if err := v.Device.WriteLine(line); err != nil {
+		if errors.Is(err, lora.ErrQueued) {
+			log.Printf("[vehicle %s] metrics queued for airtime: %s", v.ID, line)
+			return
+		}
+		log.Printf("[vehicle %s] metrics write err: %v", v.ID, err)
+		return
+	}
+	log.Printf("[vehicle %s] sent metrics: %s", v.ID, line)
+}
+
 // func calculateBearing(currentLatitude, currentLongitude, targetLatitude, targetLongitude float64) float64 {
 // 	// Convert degrees to radians
 // 	currentLatitudeRadian := currentLatitude * math.Pi / 180.0
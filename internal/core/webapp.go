@@ -0,0 +1,46 @@
+package core
+
+import (
+	"context"
+
+	"LoraFog/internal/app"
+	"LoraFog/internal/model"
+)
+
+// webApp runs the web app (internal/app) under the supervisor, listening on
+// server.app_addr where the fog forwards telemetry. It owns tmp/data.db and
+// the retention compactor configured under app.retention.
+type webApp struct {
+	addr string
+	cfg  model.AppConfig
+}
+
+// newWebApp returns the app component serving on addr.
+func newWebApp(addr string, c model.AppConfig) *webApp {
+	return &webApp{addr: addr, cfg: c}
+}
+
+// Name implements Component.
+func (w *webApp) Name() string { return "app" }
+
+// Dependencies implements Component; the app stands alone.
+func (w *webApp) Dependencies() []string { return nil }
+
+// Run implements Component. It serves the app until ctx is cancelled and
+// closes its database on the way out.
+func (w *webApp) Run(ctx context.Context) error {
+	a, err := app.NewApp(w.cfg.Retention)
+	if err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- a.Start(w.addr) }()
+	select {
+	case <-ctx.Done():
+		a.Stop()
+		return <-done
+	case err := <-done:
+		a.Stop()
+		return err
+	}
+}
@@ -0,0 +1,334 @@
+package core
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"LoraFog/internal/model"
+
+	"github.com/gorilla/websocket"
+)
+
+// Websocket slow-client policies.
+const (
+	// WSDropOldest discards the oldest queued message when a client's queue is full.
+	WSDropOldest = "drop-oldest"
+	// WSDisconnect closes a client whose queue is full.
+	WSDisconnect = "disconnect"
+)
+
+// wsOptions controls per-client queueing and keepalive.
+type wsOptions struct {
+	queueSize    int
+	policy       string
+	writeTimeout time.Duration
+	pingInterval time.Duration
+	origins      []string // extra allowed Origin headers, see checkOrigin
+}
+
+// defaultWSOptions returns the options used when the config leaves them unset.
+func defaultWSOptions() wsOptions {
+	return wsOptions{
+		queueSize:    64,
+		policy:       WSDropOldest,
+		writeTimeout: 5 * time.Second,
+		pingInterval: 30 * time.Second,
+	}
+}
+
+// wsMessage is a queued outbound message stamped with its enqueue time.
+type wsMessage struct {
+	data []byte
+	at   time.Time
+}
+
+// wsClient is one websocket connection with its own bounded send queue
+// and writer goroutine, so a stalled client never blocks the others.
+type wsClient struct {
+	conn      *websocket.Conn
+	send      chan wsMessage
+	done      chan struct{}
+	closeOnce sync.Once
+	remote    string
+	since     time.Time
+	legacy    bool // receives raw wire-format telemetry instead of envelopes
+	sub       subscription
+	session   string       // session_id cookie presented on upgrade; required for control
+	limiter   *rateLimiter // per-connection control rate
+
+	sent    atomic.Uint64
+	dropped atomic.Uint64
+	lagNs   atomic.Int64 // enqueue-to-write delay of the last delivered message
+}
+
+// newWSClient wraps conn with a queue of the given size.
+func newWSClient(conn *websocket.Conn, remote string, queueSize int) *wsClient {
+	return &wsClient{
+		conn:   conn,
+		send:   make(chan wsMessage, queueSize),
+		done:   make(chan struct{}),
+		remote: remote,
+		since:  time.Now(),
+	}
+}
+
+// close stops the client's goroutines and closes the connection once.
+func (c *wsClient) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		if err := c.conn.Close(); err != nil {
+			log.Printf("warning: failed to close websocket: %v", err)
+		}
+	})
+}
+
+// enqueue queues msg without blocking. When the queue is full it applies the
+// policy and reports false if the client must be disconnected.
+func (c *wsClient) enqueue(msg wsMessage, policy string) bool {
+	for {
+		select {
+		case c.send <- msg:
+			return true
+		default:
+		}
+		if policy == WSDisconnect {
+			return false
+		}
+		select {
+		case <-c.send:
+			c.dropped.Add(1)
+		default:
+		}
+	}
+}
+
+// writeLoop delivers queued messages and pings until the client closes.
+func (c *wsClient) writeLoop(opts wsOptions) {
+	ping := time.NewTicker(opts.pingInterval)
+	defer ping.Stop()
+	defer c.close()
+	for {
+		select {
+		case <-c.done:
+			return
+		case msg := <-c.send:
+			if err := c.conn.SetWriteDeadline(time.Now().Add(opts.writeTimeout)); err != nil {
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg.data); err != nil {
+				log.Printf("[fog] websocket %s write failed: %v", c.remote, err)
+				return
+			}
+			c.sent.Add(1)
+			c.lagNs.Store(int64(time.Since(msg.at)))
+		case <-ping.C:
+			deadline := time.Now().Add(opts.writeTimeout)
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+				log.Printf("[fog] websocket %s ping failed: %v", c.remote, err)
+				return
+			}
+		}
+	}
+}
+
+// readLoop consumes client frames, extending the read deadline on every pong.
+// onMessage receives text frames; the loop ends when the connection fails.
+func (c *wsClient) readLoop(opts wsOptions, onMessage func([]byte)) {
+	defer c.close()
+	wait := 2 * opts.pingInterval
+	c.conn.SetReadLimit(64 << 10)
+	_ = c.conn.SetReadDeadline(time.Now().Add(wait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wait))
+	})
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		_ = c.conn.SetReadDeadline(time.Now().Add(wait))
+		if onMessage != nil {
+			onMessage(data)
+		}
+	}
+}
+
+// stats returns a snapshot of the client's delivery counters.
+func (c *wsClient) stats() model.WSClientStats {
+	return model.WSClientStats{
+		Remote:      c.remote,
+		ConnectedAt: c.since,
+		Queued:      len(c.send),
+		QueueSize:   cap(c.send),
+		Sent:        c.sent.Load(),
+		Dropped:     c.dropped.Load(),
+		LagMs:       float64(c.lagNs.Load()) / float64(time.Millisecond),
+	}
+}
+
+// SetWebsocketOptions configures per-client queues; zero values keep defaults.
+func (f *FogServer) SetWebsocketOptions(c model.WebsocketConfig) {
+	o := defaultWSOptions()
+	if c.QueueSize > 0 {
+		o.queueSize = c.QueueSize
+	}
+	switch p := strings.ToLower(c.Policy); p {
+	case WSDropOldest, WSDisconnect:
+		o.policy = p
+	case "":
+	default:
+		log.Printf("[config] unknown websocket policy %q, using %s", c.Policy, o.policy)
+	}
+	if c.WriteTimeoutMs > 0 {
+		o.writeTimeout = time.Duration(c.WriteTimeoutMs) * time.Millisecond
+	}
+	if c.PingS > 0 {
+		o.pingInterval = time.Duration(c.PingS) * time.Second
+	}
+	for _, origin := range c.AllowedOrigins {
+		o.origins = append(o.origins, strings.TrimRight(origin, "/"))
+	}
+	f.mu.Lock()
+	f.wsOpts = o
+	f.mu.Unlock()
+}
+
+// checkOrigin admits websocket upgrades without an Origin header (non-browser
+// clients) and from the fog's own host, app_addr or websocket.allowed_origins,
+// so other sites cannot open /ws with a logged-in user's cookie.
+func (f *FogServer) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) || strings.EqualFold(origin, strings.TrimRight(f.AppAddr, "/")) {
+		return true
+	}
+	f.mu.Lock()
+	allowed := f.wsOpts.origins
+	f.mu.Unlock()
+	for _, o := range allowed {
+		if strings.EqualFold(origin, o) {
+			return true
+		}
+	}
+	log.Printf("[fog] websocket from %s refused: origin %s not allowed", r.RemoteAddr, origin)
+	return false
+}
+
+// sessionCacheTTL is how long the fog trusts a session the app confirmed.
+const sessionCacheTTL = 30 * time.Second
+
+// validSession asks the app's GET /api/session whether id is a logged-in
+// session, caching confirmations for sessionCacheTTL. Without app_addr no
+// session is valid.
+func (f *FogServer) validSession(id string) bool {
+	if id == "" || f.AppAddr == "" {
+		return false
+	}
+	now := time.Now()
+	f.sessMu.Lock()
+	until, ok := f.sessions[id]
+	f.sessMu.Unlock()
+	if ok && now.Before(until) {
+		return true
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(f.AppAddr, "/")+"/api/session", nil)
+	if err != nil {
+		return false
+	}
+	req.AddCookie(&http.Cookie{Name: "session_id", Value: id})
+	resp, err := sessionClient.Do(req)
+	if err != nil {
+		log.Printf("[fog] warning: check session with app: %v", err)
+		return false
+	}
+	defer func() {
+		if cerr := resp.Body.Close(); cerr != nil {
+			log.Printf("[fog] warning: close session response: %v", cerr)
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+
+	f.sessMu.Lock()
+	defer f.sessMu.Unlock()
+	for k, t := range f.sessions {
+		if now.After(t) {
+			delete(f.sessions, k)
+		}
+	}
+	f.sessions[id] = now.Add(sessionCacheTTL)
+	return true
+}
+
+// sessionClient checks sessions with the app.
+var sessionClient = &http.Client{Timeout: probeTimeout}
+
+// handleWS upgrades HTTP to websocket and registers the client for broadcasts.
+// Clients receive JSON envelopes and may send subscribe/unsubscribe messages,
+// or control messages when they present a session_id cookie the app
+// confirms; ?legacy=1 keeps the old behaviour of raw wire-format telemetry
+// lines. Browser pages from other origins are refused, see checkOrigin.
+func (f *FogServer) handleWS(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{CheckOrigin: f.checkOrigin}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	f.mu.Lock()
+	opts := f.wsOpts
+	c := newWSClient(conn, r.RemoteAddr, opts.queueSize)
+	c.legacy = r.URL.Query().Get("legacy") != ""
+	if ck, err := r.Cookie("session_id"); err == nil {
+		c.session = ck.Value
+	}
+	c.limiter = newRateLimiter(f.ctlRate.PerConnectionPerMin, f.ctlRate.Burst)
+	f.clients[c] = struct{}{}
+	f.mu.Unlock()
+
+	onMessage := func(data []byte) { f.handleWSMessage(c, data) }
+	if c.legacy {
+		onMessage = nil
+	}
+	go c.writeLoop(opts)
+	go func() {
+		c.readLoop(opts, onMessage)
+		f.mu.Lock()
+		delete(f.clients, c)
+		f.mu.Unlock()
+	}()
+}
+
+// wsStats returns delivery stats for every connected client.
+func (f *FogServer) wsStats() []model.WSClientStats {
+	f.mu.Lock()
+	out := make([]model.WSClientStats, 0, len(f.clients))
+	for c := range f.clients {
+		out = append(out, c.stats())
+	}
+	f.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].ConnectedAt.Before(out[j].ConnectedAt) })
+	return out
+}
+
+// handleWSClients lists connected websocket clients with queue depth and lag.
+func (f *FogServer) handleWSClients(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(f.wsStats()); err != nil {
+		log.Printf("[fog] warning: encode websocket stats: %v", err)
+	}
+}
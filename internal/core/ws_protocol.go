@@ -0,0 +1,227 @@
+package core
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"LoraFog/internal/model"
+	"LoraFog/internal/stream"
+)
+
+// subscription filters envelopes for one websocket client.
+// A nil set matches everything on that dimension.
+type subscription struct {
+	mu       sync.RWMutex
+	vehicles map[string]struct{}
+	gateways map[string]struct{}
+	types    map[string]struct{}
+}
+
+// toSet converts a list into a set; an empty list yields nil (match all).
+func toSet(list []string) map[string]struct{} {
+	if len(list) == 0 {
+		return nil
+	}
+	s := make(map[string]struct{}, len(list))
+	for _, v := range list {
+		s[v] = struct{}{}
+	}
+	return s
+}
+
+// fromSet converts a set back into a list for acknowledgements.
+func fromSet(s map[string]struct{}) []string {
+	out := make([]string, 0, len(s))
+	for v := range s {
+		out = append(out, v)
+	}
+	return out
+}
+
+// subscribe adds the message filters to the subscription.
+func (s *subscription) subscribe(m model.WSClientMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vehicles = addAll(s.vehicles, m.Vehicles)
+	s.gateways = addAll(s.gateways, m.Gateways)
+	s.types = addAll(s.types, m.Events)
+}
+
+// unsubscribe removes the message filters; an empty message resets to match all.
+func (s *subscription) unsubscribe(m model.WSClientMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(m.Vehicles) == 0 && len(m.Gateways) == 0 && len(m.Events) == 0 {
+		s.vehicles, s.gateways, s.types = nil, nil, nil
+		return
+	}
+	s.vehicles = removeAll(s.vehicles, m.Vehicles)
+	s.gateways = removeAll(s.gateways, m.Gateways)
+	s.types = removeAll(s.types, m.Events)
+}
+
+// addAll merges list into set, keeping nil (match all) when list is empty.
+func addAll(set map[string]struct{}, list []string) map[string]struct{} {
+	if len(list) == 0 {
+		return set
+	}
+	if set == nil {
+		set = map[string]struct{}{}
+	}
+	for _, v := range list {
+		set[v] = struct{}{}
+	}
+	return set
+}
+
+// removeAll deletes list from an explicit set. Removing from a nil set
+// (match all) is a no-op; an explicit set emptied this way matches nothing.
+func removeAll(set map[string]struct{}, list []string) map[string]struct{} {
+	for _, v := range list {
+		delete(set, v)
+	}
+	return set
+}
+
+// matches reports whether env passes the client's filters.
+func (s *subscription) matches(env model.Envelope) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if !inSet(s.types, env.Type) {
+		return false
+	}
+	if env.Vehicle != "" && !inSet(s.vehicles, env.Vehicle) {
+		return false
+	}
+	if env.Gateway != "" && !inSet(s.gateways, env.Gateway) {
+		return false
+	}
+	return true
+}
+
+// inSet reports whether v is in set; a nil set contains everything.
+func inSet(set map[string]struct{}, v string) bool {
+	if set == nil {
+		return true
+	}
+	_, ok := set[v]
+	return ok
+}
+
+// snapshot returns the subscription as a client message for acknowledgements.
+func (s *subscription) snapshot() model.WSClientMessage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return model.WSClientMessage{
+		Type:     model.EnvelopeSubscribed,
+		Vehicles: fromSet(s.vehicles),
+		Gateways: fromSet(s.gateways),
+		Events:   fromSet(s.types),
+	}
+}
+
+// newEnvelope builds a versioned envelope.
+func newEnvelope(typ, vehicle, gateway string, ts time.Time, payload any) model.Envelope {
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	return model.Envelope{
+		V:         model.WSProtocolVersion,
+		Type:      typ,
+		Vehicle:   vehicle,
+		Gateway:   gateway,
+		Timestamp: ts,
+		Payload:   payload,
+	}
+}
+
+// eventEnvelope converts a fog event into a websocket envelope.
+func eventEnvelope(ev model.Event) model.Envelope {
+	return newEnvelope(ev.Type, ev.VehicleID, ev.GatewayID, ev.Time, ev)
+}
+
+// publishEnvelope queues env for every subscribed client and the SSE stream.
+// Legacy clients only receive telemetry, encoded as the raw wire-format line in legacy.
+func (f *FogServer) publishEnvelope(env model.Envelope, legacy string) {
+	f.publishEnvelopeExcept(env, legacy, nil)
+}
+
+// publishEnvelopeExcept is publishEnvelope skipping one client, used when
+// that client receives env directly.
+func (f *FogServer) publishEnvelopeExcept(env model.Envelope, legacy string, skip *wsClient) {
+	b, err := json.Marshal(env)
+	if err != nil {
+		log.Printf("[fog] encode envelope err: %v", err)
+		return
+	}
+	f.sse.Publish(stream.Item{Event: env.Type, Vehicle: env.Vehicle, Data: b})
+	msg := wsMessage{data: b, at: time.Now()}
+	raw := wsMessage{data: []byte(legacy), at: msg.at}
+
+	f.mu.Lock()
+	policy := f.wsOpts.policy
+	var slow []*wsClient
+	for c := range f.clients {
+		if c == skip {
+			continue
+		}
+		m := msg
+		if c.legacy {
+			if env.Type != model.EnvelopeTelemetry || legacy == "" {
+				continue
+			}
+			m = raw
+		} else if !c.sub.matches(env) {
+			continue
+		}
+		if !c.enqueue(m, policy) {
+			slow = append(slow, c)
+			delete(f.clients, c)
+		}
+	}
+	f.mu.Unlock()
+	for _, c := range slow {
+		log.Printf("[fog] websocket %s disconnected: send queue full", c.remote)
+		c.close()
+	}
+}
+
+// sendTo queues env for a single client.
+func (f *FogServer) sendTo(c *wsClient, env model.Envelope) {
+	b, err := json.Marshal(env)
+	if err != nil {
+		log.Printf("[fog] encode envelope err: %v", err)
+		return
+	}
+	f.mu.Lock()
+	policy := f.wsOpts.policy
+	f.mu.Unlock()
+	if !c.enqueue(wsMessage{data: b, at: time.Now()}, policy) {
+		c.close()
+	}
+}
+
+// handleWSMessage processes one client→server frame.
+func (f *FogServer) handleWSMessage(c *wsClient, data []byte) {
+	var m model.WSClientMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		f.sendTo(c, newEnvelope(model.EnvelopeError, "", "", time.Time{}, "invalid message: "+err.Error()))
+		return
+	}
+	switch strings.ToLower(m.Type) {
+	case "subscribe":
+		c.sub.subscribe(m)
+	case "unsubscribe":
+		c.sub.unsubscribe(m)
+	case "control":
+		f.handleWSControl(c, m)
+		return
+	default:
+		f.sendTo(c, newEnvelope(model.EnvelopeError, "", "", time.Time{}, "unknown message type "+m.Type))
+		return
+	}
+	f.sendTo(c, newEnvelope(model.EnvelopeSubscribed, "", "", time.Time{}, c.sub.snapshot()))
+}
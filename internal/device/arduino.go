@@ -86,7 +86,7 @@ func (arduino *ArduinoDevice) Read(out chan<- model.ArduinoData) (func(), error)
 			close(out)
 		}()
 
-		reader := bufio.NewReader(arduino.Serial.port)
+		reader := bufio.NewReader(arduino.Serial.currentPort())
 		for {
 			select {
 			case <-stop:
@@ -29,3 +29,20 @@ type Simulatable interface {
 	// Simulate generates mock output continuously until stop is closed.
 	StartSimulation(stop <-chan struct{}) error
 }
+
+// StatusReporter is implemented by devices that can report their link state.
+// It is used by health checks to expose serial port status.
+type StatusReporter interface {
+	// IsOpen reports whether the underlying port is currently open.
+	IsOpen() bool
+
+	// LastRead returns the time of the last successful ReadLine (zero if none).
+	LastRead() time.Time
+}
+
+// LinkQualityReporter is implemented by radio devices that report the
+// signal quality of the last received frame.
+type LinkQualityReporter interface {
+	// LinkQuality returns RSSI (dBm) and SNR (dB) of the last frame; ok is false if unknown.
+	LinkQuality() (rssi, snr float64, ok bool)
+}
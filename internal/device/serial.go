@@ -6,6 +6,8 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	serial "go.bug.st/serial"
@@ -13,10 +15,14 @@ import (
 
 // SerialDevice implements Device using go.bug.st/serial.
 type SerialDevice struct {
-	port serial.Port
-	r    *bufio.Reader
 	dev  string
 	baud int
+
+	mu   sync.Mutex // guards port and r; reads and writes run outside it
+	port serial.Port
+	r    *bufio.Reader
+
+	lastRead atomic.Int64 // unix nanos of last successful read
 }
 
 // NewSerialDevice creates and opens a serial device with the given path and baudrate.
@@ -30,6 +36,8 @@ func NewSerialDevice(dev string, baud int) (*SerialDevice, error) {
 
 // Open ensures that the serial port is ready for use.
 func (s *SerialDevice) Open() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.port != nil {
 		return nil
 	}
@@ -44,6 +52,8 @@ func (s *SerialDevice) Open() error {
 
 // Close closes the underlying serial connection.
 func (s *SerialDevice) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	if s.port == nil {
 		return nil
 	}
@@ -54,7 +64,10 @@ func (s *SerialDevice) Close() error {
 
 // ReadLine reads a single line from the serial port, blocking until newline or timeout.
 func (s *SerialDevice) ReadLine(timeout time.Duration) (string, error) {
-	if s.port == nil {
+	s.mu.Lock()
+	port, r := s.port, s.r
+	s.mu.Unlock()
+	if port == nil {
 		return "", errors.New("serial port not open")
 	}
 
@@ -64,7 +77,7 @@ func (s *SerialDevice) ReadLine(timeout time.Duration) (string, error) {
 	}, 1)
 
 	go func() {
-		line, err := s.r.ReadString('\n')
+		line, err := r.ReadString('\n')
 		ch <- struct {
 			line string
 			err  error
@@ -73,11 +86,13 @@ func (s *SerialDevice) ReadLine(timeout time.Duration) (string, error) {
 
 	if timeout <= 0 {
 		res := <-ch
+		s.markRead(res.err)
 		return res.line, res.err
 	}
 
 	select {
 	case res := <-ch:
+		s.markRead(res.err)
 		return res.line, res.err
 	case <-time.After(timeout):
 		return "", errors.New("read timeout")
@@ -86,9 +101,42 @@ func (s *SerialDevice) ReadLine(timeout time.Duration) (string, error) {
 
 // WriteLine writes a single line followed by '\n' to the serial port.
 func (s *SerialDevice) WriteLine(line string) error {
-	if s.port == nil {
+	s.mu.Lock()
+	port := s.port
+	s.mu.Unlock()
+	if port == nil {
 		return errors.New("serial port not open")
 	}
-	_, err := s.port.Write(append([]byte(line), '\n'))
+	_, err := port.Write(append([]byte(line), '\n'))
 	return err
 }
+
+// markRead records the time of a successful read.
+func (s *SerialDevice) markRead(err error) {
+	if err == nil {
+		s.lastRead.Store(time.Now().UnixNano())
+	}
+}
+
+// currentPort returns the open port, or nil.
+func (s *SerialDevice) currentPort() serial.Port {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.port
+}
+
+// IsOpen reports whether the serial port is open.
+func (s *SerialDevice) IsOpen() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.port != nil
+}
+
+// LastRead returns the time of the last successful ReadLine.
+func (s *SerialDevice) LastRead() time.Time {
+	n := s.lastRead.Load()
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
@@ -0,0 +1,377 @@
+// Package export renders a vehicle's stored telemetry as GPX, KML, GeoJSON
+// or CSV for analysis tools. Headings, target headings, motor speeds and the
+// PID output travel with every point: as GPX extensions, KML gx:Track
+// extended data, GeoJSON properties or CSV columns.
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"LoraFog/internal/store"
+)
+
+// Supported formats.
+const (
+	GPX     = "gpx"
+	KML     = "kml"
+	GeoJSON = "geojson"
+	CSV     = "csv"
+)
+
+// Formats lists the supported formats.
+var Formats = []string{GPX, KML, GeoJSON, CSV}
+
+// extNS is the XML namespace of the GPX point extensions.
+const extNS = "urn:lorafog:gpx:1"
+
+// ContentType returns the MIME type of format.
+func ContentType(format string) string {
+	switch format {
+	case GPX:
+		return "application/gpx+xml"
+	case KML:
+		return "application/vnd.google-earth.kml+xml"
+	case GeoJSON:
+		return "application/geo+json"
+	default:
+		return "text/csv"
+	}
+}
+
+// ParseTime parses a from/to bound: RFC 3339 or unix seconds; empty is the
+// zero time.
+func ParseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if sec, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(0, int64(sec*1e9)), nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// Source calls fn for the points of a track in time order until fn returns
+// false. Formats that list a track several times (KML, GeoJSON) call it
+// once per section, so it must yield the same points each time.
+type Source func(fn func(store.Point) bool) error
+
+// Points returns a Source over points held in memory.
+func Points(points []store.Point) Source {
+	return func(fn func(store.Point) bool) error {
+		for _, p := range points {
+			if !fn(p) {
+				break
+			}
+		}
+		return nil
+	}
+}
+
+// errChanged is returned when a Source yields fewer points on a later pass.
+var errChanged = errors.New("export: track changed during export")
+
+// Write streams the track of vehicle from src to w in format and returns
+// the number of points written.
+func Write(w io.Writer, format, vehicle string, src Source) (int, error) {
+	bw := bufio.NewWriter(w)
+	var n int
+	var err error
+	switch strings.ToLower(format) {
+	case GPX:
+		n, err = writeGPX(bw, vehicle, src)
+	case KML:
+		n, err = writeKML(bw, vehicle, src)
+	case GeoJSON:
+		n, err = writeGeoJSON(bw, vehicle, src)
+	case CSV:
+		n, err = writeCSV(bw, src)
+	default:
+		return 0, fmt.Errorf("unknown export format %q (want %s)", format, strings.Join(Formats, ", "))
+	}
+	if err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
+
+// each calls fn for every point of src until fn fails, and counts them.
+func each(src Source, fn func(store.Point) error) (n int, err error) {
+	serr := src(func(p store.Point) bool {
+		if err = fn(p); err != nil {
+			return false
+		}
+		n++
+		return true
+	})
+	if err == nil {
+		err = serr
+	}
+	return n, err
+}
+
+// again calls fn for the first n points of src, a later pass over a track
+// whose first pass yielded n points. Points added since are left out; a
+// track that lost points cannot be completed.
+func again(src Source, n int, fn func(store.Point) error) error {
+	i := 0
+	var err error
+	serr := src(func(p store.Point) bool {
+		if i == n {
+			return false
+		}
+		if err = fn(p); err != nil {
+			return false
+		}
+		i++
+		return true
+	})
+	switch {
+	case err != nil:
+		return err
+	case serr != nil:
+		return serr
+	case i < n:
+		return errChanged
+	}
+	return nil
+}
+
+// writeGPX writes a GPX 1.1 track, one trkpt per point; the vehicle fields
+// GPX has no element for go in lorafog: extensions.
+func writeGPX(w io.Writer, vehicle string, src Source) (int, error) {
+	head := xml.Header +
+		`<gpx version="1.1" creator="LoraFog" xmlns="http://www.topografix.com/GPX/1/1" xmlns:lorafog="` + extNS + `">` + "\n" +
+		"  <trk>\n    <name>" + xmlEscape(vehicle) + "</name>\n    <trkseg>\n"
+	if _, err := io.WriteString(w, head); err != nil {
+		return 0, err
+	}
+	n, err := each(src, func(p store.Point) error {
+		vd := p.Data
+		_, err := fmt.Fprintf(w, `      <trkpt lat="%s" lon="%s">`+"\n"+
+			"        <time>%s</time>\n"+
+			"        <extensions>\n"+
+			"          <lorafog:heading>%d</lorafog:heading>\n"+
+			"          <lorafog:target_heading>%d</lorafog:target_heading>\n"+
+			"          <lorafog:left_speed>%d</lorafog:left_speed>\n"+
+			"          <lorafog:right_speed>%d</lorafog:right_speed>\n"+
+			"          <lorafog:pid>%d</lorafog:pid>\n"+
+			"        </extensions>\n"+
+			"      </trkpt>\n",
+			formatFloat(vd.Latitude), formatFloat(vd.Longitude), p.Time.UTC().Format(time.RFC3339Nano),
+			vd.CurrentHead, vd.TargetHead, vd.LeftSpeed, vd.RightSpeed, vd.PID)
+		return err
+	})
+	if err != nil {
+		return n, err
+	}
+	_, err = io.WriteString(w, "    </trkseg>\n  </trk>\n</gpx>\n")
+	return n, err
+}
+
+// kmlFields are the per-point values written as gx:SimpleArrayData.
+var kmlFields = []struct {
+	name string
+	get  func(store.Point) int
+}{
+	{"heading", func(p store.Point) int { return p.Data.CurrentHead }},
+	{"target_heading", func(p store.Point) int { return p.Data.TargetHead }},
+	{"left_speed", func(p store.Point) int { return p.Data.LeftSpeed }},
+	{"right_speed", func(p store.Point) int { return p.Data.RightSpeed }},
+	{"pid", func(p store.Point) int { return p.Data.PID }},
+}
+
+// writeKML writes a KML document with one gx:Track placemark. A gx:Track
+// lists all times, then all coordinates, then each field's values, so the
+// track is read once per section.
+func writeKML(w io.Writer, vehicle string, src Source) (int, error) {
+	name := xmlEscape(vehicle)
+	head := xml.Header +
+		`<kml xmlns="http://www.opengis.net/kml/2.2" xmlns:gx="http://www.google.com/kml/ext/2.2">` + "\n" +
+		"<Document>\n  <name>" + name + "</name>\n" +
+		`  <Schema id="lorafog">` + "\n"
+	for _, f := range kmlFields {
+		head += `    <gx:SimpleArrayField name="` + f.name + `" type="int"/>` + "\n"
+	}
+	head += "  </Schema>\n  <Placemark>\n    <name>" + name + "</name>\n    <gx:Track>\n"
+	if _, err := io.WriteString(w, head); err != nil {
+		return 0, err
+	}
+	n, err := each(src, func(p store.Point) error {
+		_, err := io.WriteString(w, "      <when>"+p.Time.UTC().Format(time.RFC3339Nano)+"</when>\n")
+		return err
+	})
+	if err != nil {
+		return n, err
+	}
+	err = again(src, n, func(p store.Point) error {
+		_, err := fmt.Fprintf(w, "      <gx:coord>%s %s 0</gx:coord>\n", formatFloat(p.Data.Longitude), formatFloat(p.Data.Latitude))
+		return err
+	})
+	if err != nil {
+		return n, err
+	}
+	if _, err := io.WriteString(w, `      <ExtendedData><SchemaData schemaUrl="#lorafog">`+"\n"); err != nil {
+		return n, err
+	}
+	for _, f := range kmlFields {
+		if _, err := fmt.Fprintf(w, `        <gx:SimpleArrayData name="%s">`+"\n", f.name); err != nil {
+			return n, err
+		}
+		err := again(src, n, func(p store.Point) error {
+			_, err := fmt.Fprintf(w, "          <gx:value>%d</gx:value>\n", f.get(p))
+			return err
+		})
+		if err != nil {
+			return n, err
+		}
+		if _, err := io.WriteString(w, "        </gx:SimpleArrayData>\n"); err != nil {
+			return n, err
+		}
+	}
+	_, err = io.WriteString(w, "      </SchemaData></ExtendedData>\n    </gx:Track>\n  </Placemark>\n</Document>\n</kml>\n")
+	return n, err
+}
+
+// geoFeature is a GeoJSON feature.
+type geoFeature struct {
+	Type       string         `json:"type"`
+	Geometry   geoGeometry    `json:"geometry"`
+	Properties map[string]any `json:"properties"`
+}
+
+type geoGeometry struct {
+	Type        string `json:"type"`
+	Coordinates any    `json:"coordinates"`
+}
+
+// writeGeoJSON writes a FeatureCollection: the track as a LineString
+// feature when it has two or more samples, then one Point feature per
+// sample with its fields as properties. The LineString's coordinates and
+// times are each written from their own pass over the track.
+func writeGeoJSON(w io.Writer, vehicle string, src Source) (int, error) {
+	var start, end time.Time
+	n, err := each(src, func(p store.Point) error {
+		if start.IsZero() {
+			start = p.Time.UTC()
+		}
+		end = p.Time.UTC()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	// jsonList writes one JSON value per point, comma separated.
+	jsonList := func(value func(store.Point) any) error {
+		sep := ""
+		return again(src, n, func(p store.Point) error {
+			b, err := json.Marshal(value(p))
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(w, sep+string(b))
+			sep = ","
+			return err
+		})
+	}
+	if _, err := io.WriteString(w, `{"features":[`); err != nil {
+		return 0, err
+	}
+	// a LineString needs two positions; shorter tracks are just their points
+	if n >= 2 {
+		if _, err := io.WriteString(w, `{"type":"Feature","geometry":{"type":"LineString","coordinates":[`); err != nil {
+			return 0, err
+		}
+		if err := jsonList(func(p store.Point) any { return [2]float64{p.Data.Longitude, p.Data.Latitude} }); err != nil {
+			return 0, err
+		}
+		if _, err := io.WriteString(w, `]},"properties":{"coordTimes":[`); err != nil {
+			return 0, err
+		}
+		if err := jsonList(func(p store.Point) any { return p.Time.UTC() }); err != nil {
+			return 0, err
+		}
+		rest, err := json.Marshal(map[string]any{"count": n, "end": end, "start": start, "vehicle_id": vehicle})
+		if err != nil {
+			return 0, err
+		}
+		// rest is {"count":...}; splice its members into the open properties
+		if _, err := io.WriteString(w, "],"+string(rest[1:])+"},"); err != nil {
+			return 0, err
+		}
+	}
+	err = jsonList(func(p store.Point) any {
+		vd := p.Data
+		return geoFeature{
+			Type:     "Feature",
+			Geometry: geoGeometry{Type: "Point", Coordinates: [2]float64{vd.Longitude, vd.Latitude}},
+			Properties: map[string]any{
+				"vehicle_id":     vehicle,
+				"time":           p.Time.UTC(),
+				"heading":        vd.CurrentHead,
+				"target_heading": vd.TargetHead,
+				"left_speed":     vd.LeftSpeed,
+				"right_speed":    vd.RightSpeed,
+				"pid":            vd.PID,
+			},
+		}
+	})
+	if err != nil {
+		return n, err
+	}
+	_, err = io.WriteString(w, `],"type":"FeatureCollection"}`+"\n")
+	return n, err
+}
+
+// csvHeader is the column order of CSV exports.
+var csvHeader = []string{"time", "vehicle_id", "latitude", "longitude", "current_head", "target_head", "left_speed", "right_speed", "pid", "fcnt"}
+
+// writeCSV writes one row per sample.
+func writeCSV(w io.Writer, src Source) (int, error) {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return 0, err
+	}
+	n, err := each(src, func(p store.Point) error {
+		vd := p.Data
+		return cw.Write([]string{
+			p.Time.UTC().Format(time.RFC3339Nano),
+			vd.VehicleID,
+			formatFloat(vd.Latitude),
+			formatFloat(vd.Longitude),
+			strconv.Itoa(vd.CurrentHead),
+			strconv.Itoa(vd.TargetHead),
+			strconv.Itoa(vd.LeftSpeed),
+			strconv.Itoa(vd.RightSpeed),
+			strconv.Itoa(vd.PID),
+			strconv.FormatUint(uint64(vd.FCnt), 10),
+		})
+	})
+	if err != nil {
+		return n, err
+	}
+	cw.Flush()
+	return n, cw.Error()
+}
+
+// formatFloat formats a coordinate without exponent or trailing zeros.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// xmlEscape escapes s for XML character data.
+func xmlEscape(s string) string {
+	var b strings.Builder
+	if err := xml.EscapeText(&b, []byte(s)); err != nil {
+		return ""
+	}
+	return b.String()
+}
@@ -0,0 +1,80 @@
+package export
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"LoraFog/internal/model"
+	"LoraFog/internal/store"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// track is two samples of a vehicle whose ID needs escaping in XML and JSON.
+func track() []store.Point {
+	at := time.Date(2025, 6, 1, 8, 0, 0, 0, time.UTC)
+	return []store.Point{
+		{Time: at, Data: model.VehicleData{VehicleID: "VH<1>", Latitude: 10.762622, Longitude: 106.660172, CurrentHead: 90, TargetHead: 95, LeftSpeed: 40, RightSpeed: 42, PID: -3, FCnt: 7}},
+		{Time: at.Add(1500 * time.Millisecond), Data: model.VehicleData{VehicleID: "VH<1>", Latitude: 10.7627, Longitude: 106.6603, CurrentHead: 91, TargetHead: 95, LeftSpeed: 41, RightSpeed: 42, PID: 2, FCnt: 8}},
+	}
+}
+
+func TestWriteGolden(t *testing.T) {
+	sizes := []struct {
+		name string
+		n    int
+	}{{"empty", 0}, {"single", 1}, {"track", 2}}
+	for _, format := range Formats {
+		for _, size := range sizes {
+			var buf bytes.Buffer
+			n, err := Write(&buf, format, "VH<1>", Points(track()[:size.n]))
+			if err != nil {
+				t.Fatalf("%s %s: %v", format, size.name, err)
+			}
+			if n != size.n {
+				t.Errorf("%s %s: wrote %d points, want %d", format, size.name, n, size.n)
+			}
+			path := filepath.Join("testdata", size.name+"."+format)
+			if *update {
+				if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Errorf("%s %s differs from %s:\n%s", format, size.name, path, buf.Bytes())
+			}
+		}
+	}
+}
+
+func TestWriteTrackChangedBetweenPasses(t *testing.T) {
+	points := track()
+	passes := 0
+	// the second pass comes back one point short, as after a purge
+	src := func(fn func(store.Point) bool) error {
+		passes++
+		if passes > 1 {
+			return Points(points[:1])(fn)
+		}
+		return Points(points)(fn)
+	}
+	for _, format := range []string{KML, GeoJSON} {
+		passes = 0
+		if _, err := Write(&bytes.Buffer{}, format, "VH01", src); !errors.Is(err, errChanged) {
+			t.Errorf("%s: err %v, want %v", format, err, errChanged)
+		}
+	}
+	if _, err := Write(&bytes.Buffer{}, "shp", "VH01", Points(points)); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
@@ -0,0 +1,234 @@
+// Package gwmp implements the Semtech Gateway Messaging Protocol (GWMP), the
+// UDP protocol spoken by the LoRa packet forwarder (protocol version 2).
+//
+// A gateway sends PUSH_DATA (received frames and stats) and PULL_DATA
+// (keepalive that opens the downlink path); the network server answers with
+// PUSH_ACK and PULL_ACK, sends downlinks as PULL_RESP, and the gateway
+// reports their outcome with TX_ACK.
+package gwmp
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ProtocolVersion is the GWMP version implemented here.
+const ProtocolVersion = 2
+
+// Type identifies a GWMP packet.
+type Type byte
+
+const (
+	PushData Type = 0x00
+	PushAck  Type = 0x01
+	PullData Type = 0x02
+	PullResp Type = 0x03
+	PullAck  Type = 0x04
+	TxAck    Type = 0x05
+)
+
+// String returns the protocol name of t.
+func (t Type) String() string {
+	switch t {
+	case PushData:
+		return "PUSH_DATA"
+	case PushAck:
+		return "PUSH_ACK"
+	case PullData:
+		return "PULL_DATA"
+	case PullResp:
+		return "PULL_RESP"
+	case PullAck:
+		return "PULL_ACK"
+	case TxAck:
+		return "TX_ACK"
+	}
+	return fmt.Sprintf("0x%02x", byte(t))
+}
+
+// hasEUI reports whether packets of type t carry the gateway EUI.
+func (t Type) hasEUI() bool { return t == PushData || t == PullData || t == TxAck }
+
+// EUI is a 64-bit gateway identifier.
+type EUI [8]byte
+
+// String returns the EUI as 16 upper-case hex digits.
+func (e EUI) String() string { return strings.ToUpper(hex.EncodeToString(e[:])) }
+
+// ParseEUI parses 16 hex digits, optionally separated by '-' or ':'.
+func ParseEUI(s string) (EUI, error) {
+	var e EUI
+	s = strings.NewReplacer("-", "", ":", "").Replace(s)
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != len(e) {
+		return e, fmt.Errorf("invalid gateway EUI %q", s)
+	}
+	copy(e[:], b)
+	return e, nil
+}
+
+// Packet is one GWMP datagram. Payload is the JSON object that follows the
+// header, if any.
+type Packet struct {
+	Version byte
+	Token   uint16
+	Type    Type
+	EUI     EUI
+	Payload []byte
+}
+
+// ErrShortPacket is returned for datagrams smaller than their header.
+var ErrShortPacket = errors.New("gwmp: short packet")
+
+// Marshal encodes p into a datagram.
+func (p Packet) Marshal() []byte {
+	b := make([]byte, 4, 12+len(p.Payload))
+	b[0] = p.Version
+	if b[0] == 0 {
+		b[0] = ProtocolVersion
+	}
+	binary.BigEndian.PutUint16(b[1:3], p.Token)
+	b[3] = byte(p.Type)
+	if p.Type.hasEUI() {
+		b = append(b, p.EUI[:]...)
+	}
+	return append(b, p.Payload...)
+}
+
+// Unmarshal decodes a datagram.
+func Unmarshal(b []byte) (Packet, error) {
+	if len(b) < 4 {
+		return Packet{}, ErrShortPacket
+	}
+	p := Packet{Version: b[0], Token: binary.BigEndian.Uint16(b[1:3]), Type: Type(b[3])}
+	if p.Type > TxAck {
+		return p, fmt.Errorf("gwmp: unknown packet type 0x%02x", b[3])
+	}
+	rest := b[4:]
+	if p.Type.hasEUI() {
+		if len(rest) < 8 {
+			return p, ErrShortPacket
+		}
+		copy(p.EUI[:], rest[:8])
+		rest = rest[8:]
+	}
+	if len(rest) > 0 {
+		p.Payload = append([]byte(nil), rest...)
+	}
+	return p, nil
+}
+
+// RXPK is one received frame in a PUSH_DATA payload.
+type RXPK struct {
+	Time *CompactTime `json:"time,omitempty"`
+	Tmst uint32       `json:"tmst"`
+	Chan int          `json:"chan"`
+	RFCh int          `json:"rfch"`
+	Freq float64      `json:"freq"` // MHz
+	Stat int          `json:"stat"` // 1 = CRC ok
+	Modu string       `json:"modu"` // LORA
+	DatR string       `json:"datr"` // e.g. SF7BW125
+	CodR string       `json:"codr"` // e.g. 4/5
+	RSSI float64      `json:"rssi"`
+	LSNR float64      `json:"lsnr"`
+	Size int          `json:"size"`
+	Data []byte       `json:"data"` // base64 in JSON
+}
+
+// Stat is the gateway status in a PUSH_DATA payload.
+type Stat struct {
+	Time string  `json:"time"` // "2006-01-02 15:04:05 MST"
+	RXNb int     `json:"rxnb"`
+	RXOK int     `json:"rxok"`
+	RXFW int     `json:"rxfw"`
+	ACKR float64 `json:"ackr"`
+	DWNb int     `json:"dwnb"`
+	TXNb int     `json:"txnb"`
+}
+
+// StatTimeFormat is the layout of Stat.Time.
+const StatTimeFormat = "2006-01-02 15:04:05 MST"
+
+// PushDataPayload is the JSON body of PUSH_DATA.
+type PushDataPayload struct {
+	RXPK []RXPK `json:"rxpk,omitempty"`
+	Stat *Stat  `json:"stat,omitempty"`
+}
+
+// TXPK is a downlink in a PULL_RESP payload.
+type TXPK struct {
+	Imme bool    `json:"imme,omitempty"` // send immediately
+	Tmst uint32  `json:"tmst,omitempty"` // or at this concentrator time
+	Freq float64 `json:"freq"`
+	RFCh int     `json:"rfch"`
+	Powe int     `json:"powe"`
+	Modu string  `json:"modu"`
+	DatR string  `json:"datr"`
+	CodR string  `json:"codr"`
+	IPol bool    `json:"ipol"`
+	Size int     `json:"size"`
+	Data []byte  `json:"data"`
+}
+
+// PullRespPayload is the JSON body of PULL_RESP.
+type PullRespPayload struct {
+	TXPK TXPK `json:"txpk"`
+}
+
+// TxAckError values reported in TX_ACK; TxAckNone means success.
+const (
+	TxAckNone        = "NONE"
+	TxAckTooLate     = "TOO_LATE"
+	TxAckTooEarly    = "TOO_EARLY"
+	TxAckCollision   = "COLLISION_PACKET"
+	TxAckTxFreq      = "TX_FREQ"
+	TxAckTxPower     = "TX_POWER"
+	TxAckGPSUnlocked = "GPS_UNLOCKED"
+)
+
+// TxAckPayload is the optional JSON body of TX_ACK.
+type TxAckPayload struct {
+	TxPkAck struct {
+		Error string `json:"error"`
+	} `json:"txpk_ack"`
+}
+
+// CompactTime is an RFC 3339 UTC timestamp as used in rxpk.time.
+type CompactTime time.Time
+
+// MarshalJSON encodes t in RFC 3339 with nanoseconds.
+func (t CompactTime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Time(t).UTC().Format(time.RFC3339Nano))
+}
+
+// UnmarshalJSON decodes an RFC 3339 timestamp.
+func (t *CompactTime) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return err
+	}
+	*t = CompactTime(v)
+	return nil
+}
+
+// DataRate formats a LoRa data rate such as "SF7BW125".
+func DataRate(sf int, bandwidthKHz float64) string {
+	return fmt.Sprintf("SF%dBW%g", sf, bandwidthKHz)
+}
+
+// CodingRate formats a LoRa coding rate denominator (5..8) as "4/5".."4/8".
+func CodingRate(cr int) string {
+	if cr < 5 || cr > 8 {
+		cr = 5
+	}
+	return fmt.Sprintf("4/%d", cr)
+}
@@ -0,0 +1,99 @@
+// Package gwstream defines the gateway link: one bidirectional gRPC stream
+// per gateway carrying uplink telemetry and registrations to the fog, and
+// downlink commands back, each answered with an ack frame.
+//
+// The service is described by hand and messages are JSON-encoded through a
+// registered "json" codec, so no protobuf code generation is needed:
+//
+//	service GatewayLink { rpc Connect(stream Frame) returns (stream Frame); }
+//
+// A gateway opens the stream with a Hello naming itself and the number of
+// unacknowledged uplinks it may have in flight (its window). The fog acks
+// every uplink by sequence number; the gateway stops sending when its window
+// is full. Downlinks are acked by the gateway with the outcome of the
+// transmission.
+package gwstream
+
+import (
+	"context"
+	"encoding/json"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+
+	"LoraFog/internal/model"
+)
+
+// Frame types.
+const (
+	Hello      = "hello"      // gateway → fog, first frame of a stream
+	Uplink     = "uplink"     // gateway → fog, telemetry line
+	Register   = "register"   // gateway → fog, registration heartbeat
+	Registered = "registered" // fog → gateway, registration ack
+	Downlink   = "downlink"   // fog → gateway, wire-format control line
+	Ack        = "ack"        // either way, acknowledges Seq
+)
+
+// Frame is the single message type of the stream; Type selects the used fields.
+type Frame struct {
+	Type      string `json:"type"`
+	Seq       uint64 `json:"seq,omitempty"`        // uplink/downlink sequence, echoed by Ack
+	GatewayID string `json:"gateway_id,omitempty"` // Hello
+	Window    int    `json:"window,omitempty"`     // Hello: max unacknowledged uplinks
+	Error     string `json:"error,omitempty"`      // Ack: empty on success
+
+	Uplink       *model.GatewayUplink          `json:"uplink,omitempty"`
+	Registration *model.GatewayRegistration    `json:"registration,omitempty"`
+	RegAck       *model.GatewayRegistrationAck `json:"reg_ack,omitempty"`
+	Data         []byte                        `json:"data,omitempty"` // Downlink payload
+}
+
+// ServiceName and ConnectMethod name the gRPC service and its stream.
+const (
+	ServiceName   = "lorafog.GatewayLink"
+	ConnectMethod = "/" + ServiceName + "/Connect"
+)
+
+// LinkServer is implemented by the fog to serve gateway streams.
+type LinkServer interface {
+	Connect(stream grpc.ServerStream) error
+}
+
+// ServiceDesc describes GatewayLink for grpc.Server.RegisterService.
+var ServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*LinkServer)(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Connect",
+		ServerStreams: true,
+		ClientStreams: true,
+		Handler: func(srv any, stream grpc.ServerStream) error {
+			return srv.(LinkServer).Connect(stream)
+		},
+	}},
+	Metadata: "gwstream",
+}
+
+// Connect opens a gateway stream on cc.
+func Connect(ctx context.Context, cc grpc.ClientConnInterface) (grpc.ClientStream, error) {
+	return cc.NewStream(ctx, &ServiceDesc.Streams[0], ConnectMethod, grpc.CallContentSubtype(codecName))
+}
+
+// codecName is the gRPC content subtype of JSON frames.
+const codecName = "json"
+
+// codec encodes frames as JSON.
+type codec struct{}
+
+// Marshal implements encoding.Codec.
+func (codec) Marshal(v any) ([]byte, error) { return json.Marshal(v) }
+
+// Unmarshal implements encoding.Codec.
+func (codec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+// Name implements encoding.Codec.
+func (codec) Name() string { return codecName }
+
+func init() {
+	encoding.RegisterCodec(codec{})
+}
@@ -0,0 +1,127 @@
+// Package health provides liveness/readiness reporting shared by the fog server,
+// gateways, vehicles and the web app. Components build a list of Checks and
+// expose them through Handler on /healthz and /readyz.
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"LoraFog/internal/device"
+)
+
+// Status is the outcome of a single check or of a whole report.
+type Status string
+
+const (
+	// StatusOK means the check passed.
+	StatusOK Status = "ok"
+	// StatusWarn means the check passed but something looks stale or degraded.
+	StatusWarn Status = "warn"
+	// StatusFail means the check failed; the endpoint answers 503.
+	StatusFail Status = "fail"
+)
+
+// Check is the result of one health probe.
+type Check struct {
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Report aggregates checks for one component.
+type Report struct {
+	Component string    `json:"component"`
+	Status    Status    `json:"status"`
+	Checks    []Check   `json:"checks"`
+	Time      time.Time `json:"time"`
+}
+
+// NewReport builds a Report whose Status is the worst status among checks.
+func NewReport(component string, checks []Check) Report {
+	st := StatusOK
+	for _, c := range checks {
+		switch {
+		case c.Status == StatusFail:
+			st = StatusFail
+		case c.Status == StatusWarn && st == StatusOK:
+			st = StatusWarn
+		}
+	}
+	if checks == nil {
+		checks = []Check{}
+	}
+	return Report{Component: component, Status: st, Checks: checks, Time: time.Now()}
+}
+
+// Handler serves the Report produced by fn as JSON.
+// It answers 503 Service Unavailable when any check fails.
+func Handler(component string, fn func() []Check) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rep := NewReport(component, fn())
+		w.Header().Set("Content-Type", "application/json")
+		if rep.Status == StatusFail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(rep); err != nil {
+			log.Printf("[health] warning: encode report: %v", err)
+		}
+	}
+}
+
+// Info returns an always-passing check carrying an informational detail.
+func Info(name, format string, args ...any) Check {
+	return Check{Name: name, Status: StatusOK, Detail: fmt.Sprintf(format, args...)}
+}
+
+// DeviceCheck reports whether dev is open and how long ago it last delivered a line.
+// A read older than maxIdle is reported as a warning; maxIdle <= 0 disables that.
+func DeviceCheck(name string, dev device.Device, maxIdle time.Duration) Check {
+	if dev == nil {
+		return Check{Name: name, Status: StatusFail, Detail: "no device"}
+	}
+	sr, ok := dev.(device.StatusReporter)
+	if !ok {
+		return Check{Name: name, Status: StatusOK, Detail: "status not reported"}
+	}
+	if !sr.IsOpen() {
+		return Check{Name: name, Status: StatusFail, Detail: "closed"}
+	}
+	last := sr.LastRead()
+	if last.IsZero() {
+		return Check{Name: name, Status: StatusOK, Detail: "open, no data read yet"}
+	}
+	age := time.Since(last).Truncate(time.Millisecond)
+	c := Check{Name: name, Status: StatusOK, Detail: fmt.Sprintf("open, last read %s ago", age)}
+	if maxIdle > 0 && age > maxIdle {
+		c.Status = StatusWarn
+	}
+	return c
+}
+
+// HTTPCheck issues a GET to url and fails unless it answers 2xx within timeout.
+func HTTPCheck(name, url string, timeout time.Duration) Check {
+	client := http.Client{Timeout: timeout}
+	start := time.Now()
+	resp, err := client.Get(url)
+	if err != nil {
+		return Check{Name: name, Status: StatusFail, Detail: err.Error()}
+	}
+	defer func() {
+		if cerr := resp.Body.Close(); cerr != nil {
+			log.Printf("[health] warning: close response: %v", cerr)
+		}
+	}()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		log.Printf("[health] warning: discard response: %v", err)
+	}
+	rtt := time.Since(start).Truncate(time.Millisecond)
+	if resp.StatusCode/100 != 2 {
+		return Check{Name: name, Status: StatusFail, Detail: fmt.Sprintf("%s (%s)", resp.Status, rtt)}
+	}
+	return Check{Name: name, Status: StatusOK, Detail: fmt.Sprintf("reachable (%s)", rtt)}
+}
@@ -0,0 +1,79 @@
+// Package lora implements LoRa radio helpers: time-on-air calculation and
+// duty-cycle enforcement for devices that transmit over a regulated band.
+package lora
+
+import (
+	"math"
+	"time"
+)
+
+// Params describes the modulation settings that determine time-on-air.
+type Params struct {
+	SpreadingFactor int     // 6..12
+	BandwidthHz     float64 // e.g. 125000
+	CodingRate      int     // denominator of 4/CR: 5..8
+	Preamble        int     // programmed preamble symbols (usually 8)
+	ImplicitHeader  bool    // true disables the explicit PHY header
+	NoCRC           bool    // true disables the payload CRC
+}
+
+// DefaultParams returns EU868 defaults: SF7, 125 kHz, CR 4/5, 8 preamble symbols.
+func DefaultParams() Params {
+	return Params{SpreadingFactor: 7, BandwidthHz: 125000, CodingRate: 5, Preamble: 8}
+}
+
+// withDefaults fills zero fields with DefaultParams values.
+func (p Params) withDefaults() Params {
+	d := DefaultParams()
+	if p.SpreadingFactor == 0 {
+		p.SpreadingFactor = d.SpreadingFactor
+	}
+	if p.BandwidthHz == 0 {
+		p.BandwidthHz = d.BandwidthHz
+	}
+	if p.CodingRate == 0 {
+		p.CodingRate = d.CodingRate
+	}
+	if p.Preamble == 0 {
+		p.Preamble = d.Preamble
+	}
+	return p
+}
+
+// SymbolTime returns the duration of one LoRa symbol (2^SF / BW).
+func (p Params) SymbolTime() time.Duration {
+	p = p.withDefaults()
+	sec := math.Pow(2, float64(p.SpreadingFactor)) / p.BandwidthHz
+	return time.Duration(sec * float64(time.Second))
+}
+
+// Airtime returns the time-on-air of a packet carrying payloadLen bytes,
+// following the formula in the Semtech SX127x datasheet (section 4.1.1.7).
+func (p Params) Airtime(payloadLen int) time.Duration {
+	p = p.withDefaults()
+	tSym := math.Pow(2, float64(p.SpreadingFactor)) / p.BandwidthHz
+
+	// low data rate optimisation is mandated when a symbol exceeds 16 ms
+	de := 0.0
+	if tSym > 0.016 {
+		de = 1
+	}
+	ih := 0.0
+	if p.ImplicitHeader {
+		ih = 1
+	}
+	crc := 1.0
+	if p.NoCRC {
+		crc = 0
+	}
+	cr := float64(p.CodingRate - 4)
+	sf := float64(p.SpreadingFactor)
+
+	num := 8*float64(payloadLen) - 4*sf + 28 + 16*crc - 20*ih
+	den := 4 * (sf - 2*de)
+	nPayload := 8 + math.Max(math.Ceil(num/den)*(cr+4), 0)
+
+	tPreamble := (float64(p.Preamble) + 4.25) * tSym
+	tPayload := nPayload * tSym
+	return time.Duration((tPreamble + tPayload) * float64(time.Second))
+}
@@ -0,0 +1,41 @@
+package lora
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAirtimeMatchesSemtechCalculator(t *testing.T) {
+	// Semtech LoRa Calculator, 125 kHz, CR 4/5, 8 preamble symbols,
+	// explicit header and CRC on; low data rate optimisation from SF11
+	cases := []struct {
+		sf      int
+		payload int
+		want    time.Duration
+	}{
+		{7, 13, 46336 * time.Microsecond},
+		{8, 13, 82432 * time.Microsecond},
+		{9, 13, 164864 * time.Microsecond},
+		{10, 13, 288768 * time.Microsecond},
+		{11, 13, 577536 * time.Microsecond},
+		{12, 13, 1155072 * time.Microsecond},
+		{7, 51, 102656 * time.Microsecond},
+		{12, 51, 2465792 * time.Microsecond},
+	}
+	for _, tc := range cases {
+		p := Params{SpreadingFactor: tc.sf, BandwidthHz: 125000, CodingRate: 5, Preamble: 8}
+		got := p.Airtime(tc.payload)
+		if d := got - tc.want; d < -time.Microsecond || d > time.Microsecond {
+			t.Errorf("SF%d, %d bytes: airtime %v, want %v", tc.sf, tc.payload, got, tc.want)
+		}
+	}
+}
+
+func TestAirtimeDefaults(t *testing.T) {
+	if got, want := (Params{}).Airtime(13), DefaultParams().Airtime(13); got != want {
+		t.Errorf("zero Params airtime %v, want the SF7 default %v", got, want)
+	}
+	if got, want := (Params{SpreadingFactor: 9}).SymbolTime(), 4096*time.Microsecond; got != want {
+		t.Errorf("SF9 symbol time %v, want %v", got, want)
+	}
+}
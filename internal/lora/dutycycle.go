@@ -0,0 +1,368 @@
+package lora
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"LoraFog/internal/device"
+)
+
+// ErrDutyCycle is returned by WriteLine when a transmission exceeds the
+// airtime budget and the policy rejects it.
+var ErrDutyCycle = errors.New("duty-cycle budget exceeded")
+
+// ErrQueued is returned by WriteLine when the policy holds the line until
+// airtime is available; it has not been transmitted, and a coalescing or
+// full queue may still replace or drop it.
+var ErrQueued = errors.New("queued for airtime")
+
+// Policy selects what happens to a transmission that does not fit the budget.
+type Policy string
+
+const (
+	// PolicyQueue holds over-budget lines in a bounded FIFO until airtime is available.
+	PolicyQueue Policy = "queue"
+	// PolicyCoalesce keeps only the most recent over-budget line per key, e.g.
+	// per vehicle (latest telemetry or command wins), see SetKeyFunc.
+	PolicyCoalesce Policy = "coalesce"
+	// PolicyReject returns ErrDutyCycle for over-budget lines.
+	PolicyReject Policy = "reject"
+)
+
+// ParsePolicy converts a config string into a Policy, defaulting to PolicyQueue.
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(strings.ToLower(strings.TrimSpace(s))); p {
+	case "":
+		return PolicyQueue, nil
+	case PolicyQueue, PolicyCoalesce, PolicyReject:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown duty-cycle policy %q", s)
+	}
+}
+
+// Limiter is a token bucket measured in airtime. It refills at dutyCycle
+// seconds of airtime per second, up to dutyCycle*window.
+type Limiter struct {
+	mu       sync.Mutex
+	rate     float64       // airtime seconds earned per wall-clock second
+	capacity time.Duration // maximum accumulated airtime
+	tokens   time.Duration
+	last     time.Time
+}
+
+// NewLimiter creates a full bucket allowing dutyCycle (0..1] of airtime over window.
+func NewLimiter(dutyCycle float64, window time.Duration) *Limiter {
+	if window <= 0 {
+		window = time.Hour
+	}
+	capacity := time.Duration(dutyCycle * float64(window))
+	return &Limiter{rate: dutyCycle, capacity: capacity, tokens: capacity, last: time.Now()}
+}
+
+// refill adds tokens earned since the last call. Caller holds mu.
+func (l *Limiter) refill(now time.Time) {
+	l.tokens += time.Duration(float64(now.Sub(l.last)) * l.rate)
+	if l.tokens > l.capacity {
+		l.tokens = l.capacity
+	}
+	l.last = now
+}
+
+// Reserve consumes d of airtime if available and reports success.
+// When it fails it returns how long to wait until d becomes available.
+func (l *Limiter) Reserve(d time.Duration) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	if d <= l.tokens {
+		l.tokens -= d
+		return true, 0
+	}
+	if l.rate <= 0 || d > l.capacity {
+		return false, -1
+	}
+	return false, time.Duration(float64(d-l.tokens) / l.rate)
+}
+
+// Available returns the airtime that can be spent right now.
+func (l *Limiter) Available() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	return l.tokens
+}
+
+// Usage reports airtime accounting for one duty-cycled device.
+type Usage struct {
+	DutyCycle  float64       `json:"duty_cycle"`
+	Budget     time.Duration `json:"budget_ns"`
+	Available  time.Duration `json:"available_ns"`
+	Airtime    time.Duration `json:"airtime_ns"` // total airtime transmitted
+	Sent       uint64        `json:"sent"`
+	Queued     int           `json:"queued"` // lines currently waiting
+	Coalesced  uint64        `json:"coalesced"`
+	Rejected   uint64        `json:"rejected"`
+	Dropped    uint64        `json:"dropped"` // queue overflow or never-fitting lines
+	LastSentAt time.Time     `json:"last_sent_at"`
+}
+
+// queued is a line waiting for airtime, with the key it coalesces under.
+type queued struct {
+	key, line string
+}
+
+// DutyCycledDevice wraps a device.Device and enforces an airtime budget on WriteLine.
+type DutyCycledDevice struct {
+	device.Device
+	params    Params
+	limiter   *Limiter
+	policy    Policy
+	queueSize int
+	dutyCycle float64
+
+	mu      sync.Mutex
+	keyFn   func(line string) string
+	queue   []queued
+	usage   Usage
+	wake    chan struct{}
+	done    chan struct{}
+	running bool
+}
+
+// NewDutyCycledDevice wraps dev. queueSize bounds PolicyQueue (default 16).
+func NewDutyCycledDevice(dev device.Device, params Params, dutyCycle float64, window time.Duration, policy Policy, queueSize int) *DutyCycledDevice {
+	if queueSize <= 0 {
+		queueSize = 16
+	}
+	d := &DutyCycledDevice{
+		Device:    dev,
+		params:    params.withDefaults(),
+		limiter:   NewLimiter(dutyCycle, window),
+		policy:    policy,
+		queueSize: queueSize,
+		dutyCycle: dutyCycle,
+		wake:      make(chan struct{}, 1),
+	}
+	d.startDrain()
+	return d
+}
+
+// Open reopens the inner device and restarts the queue drainer.
+func (d *DutyCycledDevice) Open() error {
+	if err := d.Device.Open(); err != nil {
+		return err
+	}
+	d.startDrain()
+	return nil
+}
+
+// Close stops the queue drainer and closes the inner device.
+// Queued lines are kept and sent after the next Open.
+func (d *DutyCycledDevice) Close() error {
+	d.mu.Lock()
+	if d.running {
+		close(d.done)
+		d.running = false
+	}
+	d.mu.Unlock()
+	return d.Device.Close()
+}
+
+// IsOpen forwards to the inner device when it reports status.
+func (d *DutyCycledDevice) IsOpen() bool {
+	if sr, ok := d.Device.(device.StatusReporter); ok {
+		return sr.IsOpen()
+	}
+	return true
+}
+
+// LastRead forwards to the inner device when it reports status.
+func (d *DutyCycledDevice) LastRead() time.Time {
+	if sr, ok := d.Device.(device.StatusReporter); ok {
+		return sr.LastRead()
+	}
+	return time.Time{}
+}
+
+// LinkQuality forwards to the inner device when it reports link quality.
+func (d *DutyCycledDevice) LinkQuality() (float64, float64, bool) {
+	if lq, ok := d.Device.(device.LinkQualityReporter); ok {
+		return lq.LinkQuality()
+	}
+	return 0, 0, false
+}
+
+// SetKeyFunc sets how PolicyCoalesce groups lines: a queued line is only
+// replaced by a newer one with the same key. Without it all lines share one key.
+func (d *DutyCycledDevice) SetKeyFunc(fn func(line string) string) {
+	d.mu.Lock()
+	d.keyFn = fn
+	d.mu.Unlock()
+}
+
+// Airtime returns the time-on-air of line including its trailing newline.
+func (d *DutyCycledDevice) Airtime(line string) time.Duration {
+	return d.params.Airtime(len(line) + 1)
+}
+
+// WriteLine transmits line if the budget allows, otherwise applies the policy.
+// Queued and coalesced lines return ErrQueued; they are sent once airtime is
+// available. nil means the line was transmitted.
+func (d *DutyCycledDevice) WriteLine(line string) error {
+	at := d.Airtime(line)
+
+	d.mu.Lock()
+	// keep FIFO order: only bypass the queue when it is empty
+	if len(d.queue) == 0 {
+		if ok, _ := d.limiter.Reserve(at); ok {
+			d.mu.Unlock()
+			return d.transmit(line, at)
+		}
+	}
+	defer d.mu.Unlock()
+	q := queued{line: line}
+	if d.keyFn != nil {
+		q.key = d.keyFn(line)
+	}
+	switch d.policy {
+	case PolicyReject:
+		d.usage.Rejected++
+		return fmt.Errorf("%w: need %s, have %s", ErrDutyCycle, at, d.limiter.Available())
+	case PolicyCoalesce:
+		for i := range d.queue {
+			if d.queue[i].key == q.key {
+				// replace in place so the key keeps its turn in the queue
+				d.queue[i] = q
+				d.usage.Coalesced++
+				return ErrQueued
+			}
+		}
+		d.queue = append(d.queue, q)
+	default:
+		if len(d.queue) >= d.queueSize {
+			d.queue = d.queue[1:]
+			d.usage.Dropped++
+		}
+		d.queue = append(d.queue, q)
+	}
+	d.signal()
+	return ErrQueued
+}
+
+// TransmitNow transmits line only if it can go out immediately, whatever the
+// policy: behind queued lines or over budget it returns ErrDutyCycle and
+// leaves line to the caller, e.g. for a receive window that will close.
+func (d *DutyCycledDevice) TransmitNow(line string) error {
+	at := d.Airtime(line)
+	d.mu.Lock()
+	if len(d.queue) > 0 {
+		n := len(d.queue)
+		d.mu.Unlock()
+		return fmt.Errorf("%w: %d lines queued", ErrDutyCycle, n)
+	}
+	ok, _ := d.limiter.Reserve(at)
+	d.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: need %s, have %s", ErrDutyCycle, at, d.limiter.Available())
+	}
+	return d.transmit(line, at)
+}
+
+// transmit writes line to the inner device and records airtime usage.
+func (d *DutyCycledDevice) transmit(line string, at time.Duration) error {
+	if err := d.Device.WriteLine(line); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.usage.Sent++
+	d.usage.Airtime += at
+	d.usage.LastSentAt = time.Now()
+	d.mu.Unlock()
+	return nil
+}
+
+// signal wakes the drainer without blocking. Caller may hold mu.
+func (d *DutyCycledDevice) signal() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// startDrain launches the queue drainer if it is not running.
+func (d *DutyCycledDevice) startDrain() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.running {
+		return
+	}
+	d.running = true
+	d.done = make(chan struct{})
+	go d.drain(d.done)
+	d.signal()
+}
+
+// drain sends queued lines as airtime becomes available. The head is
+// reserved and removed under mu, so a coalescing write either replaces it
+// before it is taken or queues a new line behind it.
+func (d *DutyCycledDevice) drain(done <-chan struct{}) {
+	for {
+		line, at, ok, wait := d.take()
+		if ok {
+			if err := d.transmit(line, at); err != nil {
+				log.Printf("[lora] queued write failed: %v", err)
+			}
+			continue
+		}
+
+		select {
+		case <-done:
+			return
+		case <-d.wake:
+		case <-wait:
+		}
+	}
+}
+
+// take removes the queue head if its airtime can be reserved now and
+// returns it with that airtime. Otherwise ok is false and, if the queue is
+// not empty, wait fires once the head fits.
+func (d *DutyCycledDevice) take() (line string, at time.Duration, ok bool, wait <-chan time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for len(d.queue) > 0 {
+		head := d.queue[0]
+		at = d.Airtime(head.line)
+		fits, retry := d.limiter.Reserve(at)
+		switch {
+		case fits:
+			d.queue = d.queue[1:]
+			return head.line, at, true, nil
+		case retry < 0:
+			// can never fit the budget: drop it rather than block the queue
+			d.queue = d.queue[1:]
+			d.usage.Dropped++
+			log.Printf("[lora] dropped line exceeding total budget (%s airtime)", at)
+		default:
+			return "", 0, false, time.After(retry)
+		}
+	}
+	return "", 0, false, nil
+}
+
+// Usage returns a snapshot of the device's airtime accounting.
+func (d *DutyCycledDevice) Usage() Usage {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	u := d.usage
+	u.DutyCycle = d.dutyCycle
+	u.Budget = d.limiter.capacity
+	u.Available = d.limiter.Available()
+	u.Queued = len(d.queue)
+	return u
+}
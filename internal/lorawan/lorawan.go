@@ -0,0 +1,252 @@
+// Package lorawan frames LoRaWAN 1.0.x data messages for an ABP session:
+// unconfirmed data up from the gateway's vehicles towards a network server
+// such as ChirpStack, and data down back from it. FRMPayload is encrypted
+// with the AppSKey and every frame carries a MIC computed with the NwkSKey.
+package lorawan
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Message types (MHDR MType, LoRaWAN major version R1).
+const (
+	UnconfirmedDataUp   byte = 0x40
+	UnconfirmedDataDown byte = 0x60
+	ConfirmedDataUp     byte = 0x80
+	ConfirmedDataDown   byte = 0xA0
+)
+
+// Link directions used in the encryption and MIC blocks.
+const (
+	dirUp   = 0
+	dirDown = 1
+)
+
+var (
+	// ErrMIC is returned for a frame whose MIC does not match the session.
+	ErrMIC = errors.New("lorawan: MIC mismatch")
+	// errShort is returned for a frame too short to be a data message.
+	errShort = errors.New("lorawan: frame too short")
+)
+
+// Session holds the ABP keys of one end device.
+type Session struct {
+	DevAddr uint32
+	NwkSKey [16]byte
+	AppSKey [16]byte
+}
+
+// ParseSession parses a device address (8 hex digits, most significant
+// byte first as network servers display it) and two 32-digit hex keys.
+func ParseSession(devAddr, nwkSKey, appSKey string) (Session, error) {
+	var s Session
+	b, err := hex.DecodeString(strings.TrimSpace(devAddr))
+	if err != nil || len(b) != 4 {
+		return s, fmt.Errorf("lorawan: dev_addr %q: want 8 hex digits", devAddr)
+	}
+	s.DevAddr = binary.BigEndian.Uint32(b)
+	for _, k := range []struct {
+		name, hex string
+		dst       *[16]byte
+	}{{"nwk_s_key", nwkSKey, &s.NwkSKey}, {"app_s_key", appSKey, &s.AppSKey}} {
+		b, err := hex.DecodeString(strings.TrimSpace(k.hex))
+		if err != nil || len(b) != 16 {
+			return s, fmt.Errorf("lorawan: %s: want 32 hex digits", k.name)
+		}
+		copy(k.dst[:], b)
+	}
+	return s, nil
+}
+
+// Frame is a decoded data message.
+type Frame struct {
+	MType   byte
+	DevAddr uint32
+	FCtrl   byte
+	FCnt    uint32 // full counter, see DecodeDown
+	FOpts   []byte
+	FPort   int // -1 when the frame has no port and payload
+	Payload []byte
+}
+
+// EncodeUp builds an unconfirmed data up PHYPayload carrying payload on
+// fport with frame counter fcnt (its 16 low bits are transmitted).
+func (s Session) EncodeUp(fcnt uint32, fport uint8, payload []byte) ([]byte, error) {
+	enc, err := cryptWith(s.AppSKey, s.DevAddr, dirUp, fcnt, payload)
+	if err != nil {
+		return nil, err
+	}
+	return s.frame(UnconfirmedDataUp, dirUp, fcnt, fport, enc)
+}
+
+// EncodeDown builds an unconfirmed data down PHYPayload as a network
+// server sends it; fport 0 carries MAC commands, encrypted with the NwkSKey.
+func (s Session) EncodeDown(fcnt uint32, fport uint8, payload []byte) ([]byte, error) {
+	key := s.AppSKey
+	if fport == 0 {
+		key = s.NwkSKey
+	}
+	enc, err := cryptWith(key, s.DevAddr, dirDown, fcnt, payload)
+	if err != nil {
+		return nil, err
+	}
+	return s.frame(UnconfirmedDataDown, dirDown, fcnt, fport, enc)
+}
+
+// frame assembles MHDR, FHDR, port and encrypted payload and appends the MIC.
+func (s Session) frame(mtype, dir byte, fcnt uint32, fport uint8, enc []byte) ([]byte, error) {
+	msg := make([]byte, 0, 13+len(enc))
+	msg = append(msg, mtype)
+	msg = binary.LittleEndian.AppendUint32(msg, s.DevAddr)
+	msg = append(msg, 0) // FCtrl: no ADR, no ACK, no FOpts
+	msg = binary.LittleEndian.AppendUint16(msg, uint16(fcnt))
+	msg = append(msg, fport)
+	msg = append(msg, enc...)
+	mic, err := s.mic(dir, fcnt, msg)
+	if err != nil {
+		return nil, err
+	}
+	return append(msg, mic[:]...), nil
+}
+
+// DecodeDown verifies and decrypts a data down PHYPayload for the session.
+// last is the previous downlink counter; the 16 transmitted bits are
+// extended to the nearest counter not below it.
+func (s Session) DecodeDown(phy []byte, last uint32) (Frame, error) {
+	var f Frame
+	if len(phy) < 12 {
+		return f, errShort
+	}
+	f.MType = phy[0] & 0xE0
+	if f.MType != UnconfirmedDataDown && f.MType != ConfirmedDataDown {
+		return f, fmt.Errorf("lorawan: not a data down frame (MHDR %#02x)", phy[0])
+	}
+	f.DevAddr = binary.LittleEndian.Uint32(phy[1:5])
+	if f.DevAddr != s.DevAddr {
+		return f, fmt.Errorf("lorawan: frame for %08X, session is %08X", f.DevAddr, s.DevAddr)
+	}
+	f.FCtrl = phy[5]
+	f.FCnt = extendFCnt(last, binary.LittleEndian.Uint16(phy[6:8]))
+	msg, mic := phy[:len(phy)-4], phy[len(phy)-4:]
+	want, err := s.mic(dirDown, f.FCnt, msg)
+	if err != nil {
+		return f, err
+	}
+	if subtle.ConstantTimeCompare(mic, want[:]) != 1 {
+		return f, ErrMIC
+	}
+	rest := msg[8:]
+	nOpts := int(f.FCtrl & 0x0F)
+	if len(rest) < nOpts {
+		return f, errShort
+	}
+	f.FOpts, rest = rest[:nOpts], rest[nOpts:]
+	f.FPort = -1
+	if len(rest) == 0 {
+		return f, nil
+	}
+	f.FPort = int(rest[0])
+	key := s.AppSKey
+	if f.FPort == 0 {
+		key = s.NwkSKey // MAC commands
+	}
+	f.Payload, err = cryptWith(key, s.DevAddr, dirDown, f.FCnt, rest[1:])
+	return f, err
+}
+
+// extendFCnt returns the smallest counter >= last whose low 16 bits are fc.
+func extendFCnt(last uint32, fc uint16) uint32 {
+	n := last&^0xFFFF | uint32(fc)
+	if n < last {
+		n += 0x10000
+	}
+	return n
+}
+
+// cryptWith XORs payload with the keystream blocks A_i of the LoRaWAN spec
+// (section 4.3.3); the operation is its own inverse.
+func cryptWith(key [16]byte, devAddr uint32, dir byte, fcnt uint32, payload []byte) ([]byte, error) {
+	c, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(payload))
+	var a, ks [16]byte
+	a[0] = 0x01
+	a[5] = dir
+	binary.LittleEndian.PutUint32(a[6:10], devAddr)
+	binary.LittleEndian.PutUint32(a[10:14], fcnt)
+	for i := 0; i < len(payload); i += 16 {
+		a[15] = byte(i/16 + 1)
+		c.Encrypt(ks[:], a[:])
+		for j := i; j < len(payload) && j < i+16; j++ {
+			out[j] = payload[j] ^ ks[j-i]
+		}
+	}
+	return out, nil
+}
+
+// mic computes the message integrity code of msg (MHDR to FRMPayload).
+func (s Session) mic(dir byte, fcnt uint32, msg []byte) ([4]byte, error) {
+	var mic [4]byte
+	c, err := aes.NewCipher(s.NwkSKey[:])
+	if err != nil {
+		return mic, err
+	}
+	b0 := make([]byte, 16, 16+len(msg))
+	b0[0] = 0x49
+	b0[5] = dir
+	binary.LittleEndian.PutUint32(b0[6:10], s.DevAddr)
+	binary.LittleEndian.PutUint32(b0[10:14], fcnt)
+	b0[15] = byte(len(msg))
+	sum := cmac(c, append(b0, msg...))
+	copy(mic[:], sum[:4])
+	return mic, nil
+}
+
+// cmac computes AES-CMAC (RFC 4493) of msg.
+func cmac(c cipher.Block, msg []byte) [16]byte {
+	var l, k1, k2 [16]byte
+	c.Encrypt(l[:], l[:])
+	subkey := func(dst, src *[16]byte) {
+		var carry byte
+		for i := 15; i >= 0; i-- {
+			dst[i] = src[i]<<1 | carry
+			carry = src[i] >> 7
+		}
+		if src[0]&0x80 != 0 {
+			dst[15] ^= 0x87
+		}
+	}
+	subkey(&k1, &l)
+	subkey(&k2, &k1)
+
+	n := (len(msg) + 15) / 16
+	var last [16]byte
+	if n == 0 || len(msg)%16 != 0 {
+		if n == 0 {
+			n = 1
+		}
+		rem := msg[(n-1)*16:]
+		copy(last[:], rem)
+		last[len(rem)] = 0x80
+		subtle.XORBytes(last[:], last[:], k2[:])
+	} else {
+		subtle.XORBytes(last[:], msg[(n-1)*16:], k1[:])
+	}
+	var x [16]byte
+	for i := 0; i < n-1; i++ {
+		subtle.XORBytes(x[:], x[:], msg[i*16:(i+1)*16])
+		c.Encrypt(x[:], x[:])
+	}
+	subtle.XORBytes(x[:], x[:], last[:])
+	c.Encrypt(x[:], x[:])
+	return x
+}
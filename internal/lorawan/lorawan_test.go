@@ -0,0 +1,149 @@
+package lorawan
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestCMACRFC4493(t *testing.T) {
+	c, err := aes.NewCipher(unhex(t, "2b7e151628aed2a6abf7158809cf4f3c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := unhex(t, "6bc1bee22e409f96e93d7e117393172aae2d8a571e03ac9c9eb76fac45af8e5130c81c46a35ce411e5fbc1191a0a52eff69f2445df4f9b17ad2b417be66c3710")
+	cases := []struct {
+		n    int
+		want string
+	}{
+		{0, "bb1d6929e95937287fa37d129b756746"},
+		{16, "070a16b46b4d4144f79bdd9dd04a287c"},
+		{40, "dfa66747de9ae63030ca32611497c827"},
+		{64, "51f0bebf7e3b9d92fc49741779363cfe"},
+	}
+	for _, tc := range cases {
+		got := cmac(c, msg[:tc.n])
+		if hex.EncodeToString(got[:]) != tc.want {
+			t.Errorf("cmac of %d bytes = %x, want %s", tc.n, got, tc.want)
+		}
+	}
+}
+
+// testSession is the ABP device of the lora-packet README example.
+func testSession(t *testing.T) Session {
+	t.Helper()
+	s, err := ParseSession("49be7df1", "44024241ed4ce9a68c6a8bc055233fd3", "ec925802ae430ca77fd3dd73cb2cc588")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestEncodeUp(t *testing.T) {
+	phy, err := testSession(t).EncodeUp(2, 1, []byte("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hex.EncodeToString(phy), "40f17dbe4900020001954378762b11ff0d"; got != want {
+		t.Errorf("PHYPayload = %s, want %s", got, want)
+	}
+}
+
+// encodeDown builds a data down frame; fport -1 leaves out port and payload.
+func encodeDown(t *testing.T, s Session, fcnt uint32, fport int, payload []byte) []byte {
+	t.Helper()
+	if fport < 0 {
+		phy, err := s.frame(UnconfirmedDataDown, dirDown, fcnt, 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// drop the port byte and recompute the MIC over the shorter message
+		msg := phy[:len(phy)-5]
+		mic, err := s.mic(dirDown, fcnt, msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(msg, mic[:]...)
+	}
+	phy, err := s.EncodeDown(fcnt, uint8(fport), payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return phy
+}
+
+func TestDecodeDown(t *testing.T) {
+	s := testSession(t)
+	line := []byte("VH01,1,30,10.762622,106.660172") // longer than one keystream block
+	cases := []struct {
+		name    string
+		fcnt    uint32
+		last    uint32
+		fport   int
+		payload []byte
+	}{
+		{"first", 0, 0, 1, line},
+		{"counter rollover", 0x10002, 0xFFF0, 1, line},
+		{"mac commands", 7, 5, 0, []byte{0x06}},
+		{"no payload", 9, 8, -1, nil},
+	}
+	for _, tc := range cases {
+		f, err := s.DecodeDown(encodeDown(t, s, tc.fcnt, tc.fport, tc.payload), tc.last)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if f.FCnt != tc.fcnt || f.FPort != tc.fport || !bytes.Equal(f.Payload, tc.payload) {
+			t.Errorf("%s: got fcnt %d port %d payload %q", tc.name, f.FCnt, f.FPort, f.Payload)
+		}
+	}
+
+	phy := encodeDown(t, s, 3, 1, line)
+	phy[len(phy)-5] ^= 1
+	if _, err := s.DecodeDown(phy, 0); !errors.Is(err, ErrMIC) {
+		t.Errorf("tampered frame: %v, want ErrMIC", err)
+	}
+	other, err := ParseSession("01020304", "44024241ed4ce9a68c6a8bc055233fd3", "ec925802ae430ca77fd3dd73cb2cc588")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.DecodeDown(encodeDown(t, s, 3, 1, line), 0); err == nil {
+		t.Error("frame for another device accepted")
+	}
+	if _, err := s.DecodeDown([]byte{UnconfirmedDataDown, 1, 2}, 0); err == nil {
+		t.Error("short frame accepted")
+	}
+}
+
+func TestParseSession(t *testing.T) {
+	key := "44024241ed4ce9a68c6a8bc055233fd3"
+	cases := []struct {
+		addr, nwk, app string
+		ok             bool
+	}{
+		{"49BE7DF1", key, key, true},
+		{"49be7d", key, key, false},
+		{"49be7df1", key[:30], key, false},
+		{"49be7df1", key, "zz", false},
+	}
+	for _, tc := range cases {
+		s, err := ParseSession(tc.addr, tc.nwk, tc.app)
+		if (err == nil) != tc.ok {
+			t.Errorf("ParseSession(%q, ...) err = %v", tc.addr, err)
+		}
+		if tc.ok && s.DevAddr != 0x49be7df1 {
+			t.Errorf("DevAddr = %08X", s.DevAddr)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// httpDuration is the latency of every HTTP request served through Instrument.
+var httpDuration = NewHistogram("lorafog_http_request_duration_seconds",
+	"HTTP request latency by server, route pattern and status code.", nil, "server", "route", "code")
+
+// Instrument wraps h, usually a ServeMux, to record request latencies under
+// server. Routes are labelled by the matched mux pattern so path parameters
+// do not explode the series count. Websocket upgrades and event streams are
+// long-lived and passed through unmeasured.
+func Instrument(server string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "" || r.Header.Get("Accept") == "text/event-stream" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		h.ServeHTTP(sw, r)
+		route := r.Pattern // set in place by ServeMux
+		if route == "" {
+			route = "unmatched"
+		}
+		httpDuration.With(server, route, strconv.Itoa(sw.code)).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusWriter records the status code written by a handler.
+type statusWriter struct {
+	http.ResponseWriter
+	code  int
+	wrote bool
+}
+
+// WriteHeader records code before passing it on.
+func (s *statusWriter) WriteHeader(code int) {
+	if !s.wrote {
+		s.code, s.wrote = code, true
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// Write marks the header as written.
+func (s *statusWriter) Write(b []byte) (int, error) {
+	s.wrote = true
+	return s.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (s *statusWriter) Unwrap() http.ResponseWriter { return s.ResponseWriter }
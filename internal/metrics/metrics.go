@@ -0,0 +1,416 @@
+// Package metrics is a small Prometheus-compatible registry shared by the fog
+// server, gateways, vehicles and the web app. Components declare counters,
+// gauges and histograms at package level and expose Default through Handler
+// on /metrics, in the Prometheus text exposition format (version 0.0.4).
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Kind is the Prometheus metric type.
+type Kind string
+
+const (
+	// KindCounter is a monotonically increasing value.
+	KindCounter Kind = "counter"
+	// KindGauge is a value that can go up and down.
+	KindGauge Kind = "gauge"
+	// KindHistogram is a bucketed distribution of observations.
+	KindHistogram Kind = "histogram"
+)
+
+// collector is one metric family.
+type collector interface {
+	desc() (name, help string, kind Kind)
+	write(w io.Writer, name string) error
+}
+
+// Registry holds metric families by name.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]collector
+}
+
+// Default is the process-wide registry served on /metrics.
+var Default = NewRegistry()
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]collector)}
+}
+
+// register adds c; a second family under the same name is a programming error.
+func (r *Registry) register(c collector) {
+	name, _, _ := c.desc()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, dup := r.families[name]; dup {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.families[name] = c
+}
+
+// WriteText writes every family in the text exposition format, sorted by name.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	fams := make([]collector, 0, len(names))
+	sort.Strings(names)
+	for _, name := range names {
+		fams = append(fams, r.families[name])
+	}
+	r.mu.Unlock()
+
+	for _, c := range fams {
+		name, help, kind := c.desc()
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, kind); err != nil {
+			return err
+		}
+		if err := c.write(w, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handler serves Default as Prometheus text.
+func Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := Default.WriteText(w); err != nil {
+			log.Printf("[metrics] warning: write metrics: %v", err)
+		}
+	}
+}
+
+// family is the label bookkeeping shared by every vector type.
+type family[T any] struct {
+	name, help string
+	kind       Kind
+	labels     []string
+
+	mu     sync.Mutex
+	series map[string]*T
+	values map[string][]string
+	newT   func() *T
+}
+
+// desc implements collector.
+func (f *family[T]) desc() (string, string, Kind) { return f.name, f.help, f.kind }
+
+// with returns the series for values, creating it on first use.
+func (f *family[T]) with(values []string) *T {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = f.newT()
+		f.series[key] = s
+		f.values[key] = append([]string(nil), values...)
+	}
+	return s
+}
+
+// delete drops the series for values.
+func (f *family[T]) delete(values []string) {
+	key := strings.Join(values, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.series, key)
+	delete(f.values, key)
+}
+
+// each calls fn for every series sorted by label values.
+func (f *family[T]) each(fn func(labels string, s *T) error) error {
+	f.mu.Lock()
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	type entry struct {
+		labels string
+		s      *T
+	}
+	entries := make([]entry, 0, len(keys))
+	for _, k := range keys {
+		entries = append(entries, entry{formatLabels(f.labels, f.values[k]), f.series[k]})
+	}
+	f.mu.Unlock()
+	for _, e := range entries {
+		if err := fn(e.labels, e.s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newFamily builds an empty family; the caller registers it.
+func newFamily[T any](name, help string, kind Kind, labels []string, newT func() *T) *family[T] {
+	f := &family[T]{
+		name: name, help: help, kind: kind, labels: labels,
+		series: make(map[string]*T),
+		values: make(map[string][]string),
+		newT:   newT,
+	}
+	return f
+}
+
+// Value is a float64 counter or gauge safe for concurrent use.
+type Value struct {
+	mu sync.Mutex
+	v  float64
+}
+
+// Add adds d to the value.
+func (v *Value) Add(d float64) {
+	v.mu.Lock()
+	v.v += d
+	v.mu.Unlock()
+}
+
+// Inc adds one to the value.
+func (v *Value) Inc() { v.Add(1) }
+
+// Set overwrites the value. On a counter this is only meant for mirroring a
+// counter kept by another process, such as one reported by a vehicle.
+func (v *Value) Set(x float64) {
+	v.mu.Lock()
+	v.v = x
+	v.mu.Unlock()
+}
+
+// get returns the current value.
+func (v *Value) get() float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.v
+}
+
+// Vec is a counter or gauge family partitioned by labels.
+type Vec struct {
+	*family[Value]
+}
+
+// NewCounter registers a counter family in Default.
+func NewCounter(name, help string, labels ...string) *Vec {
+	return newVec(name, help, KindCounter, labels)
+}
+
+// NewGauge registers a gauge family in Default.
+func NewGauge(name, help string, labels ...string) *Vec {
+	return newVec(name, help, KindGauge, labels)
+}
+
+// newVec builds and registers a Vec.
+func newVec(name, help string, kind Kind, labels []string) *Vec {
+	v := &Vec{newFamily(name, help, kind, labels, func() *Value { return &Value{} })}
+	Default.register(v)
+	return v
+}
+
+// With returns the series for the given label values, in declaration order.
+func (v *Vec) With(values ...string) *Value { return v.with(values) }
+
+// Delete drops the series for the given label values.
+func (v *Vec) Delete(values ...string) { v.delete(values) }
+
+// write implements collector.
+func (v *Vec) write(w io.Writer, name string) error {
+	return v.each(func(labels string, s *Value) error {
+		_, err := fmt.Fprintf(w, "%s%s %s\n", name, labels, formatValue(s.get()))
+		return err
+	})
+}
+
+// funcValue holds the callback of one FuncVec series.
+type funcValue struct {
+	mu sync.Mutex
+	fn func() float64
+}
+
+// FuncVec is a counter or gauge family whose values are read at scrape time,
+// for state that already lives elsewhere such as queue depths.
+type FuncVec struct {
+	*family[funcValue]
+}
+
+// NewCounterFunc registers a callback-backed counter family in Default.
+func NewCounterFunc(name, help string, labels ...string) *FuncVec {
+	return newFuncVec(name, help, KindCounter, labels)
+}
+
+// NewGaugeFunc registers a callback-backed gauge family in Default.
+func NewGaugeFunc(name, help string, labels ...string) *FuncVec {
+	return newFuncVec(name, help, KindGauge, labels)
+}
+
+// newFuncVec builds and registers a FuncVec.
+func newFuncVec(name, help string, kind Kind, labels []string) *FuncVec {
+	v := &FuncVec{newFamily(name, help, kind, labels, func() *funcValue { return &funcValue{} })}
+	Default.register(v)
+	return v
+}
+
+// Set installs fn as the source of the series for the given label values,
+// replacing any earlier callback (e.g. from before a component restart).
+func (v *FuncVec) Set(fn func() float64, values ...string) {
+	s := v.with(values)
+	s.mu.Lock()
+	s.fn = fn
+	s.mu.Unlock()
+}
+
+// Delete drops the series for the given label values.
+func (v *FuncVec) Delete(values ...string) { v.delete(values) }
+
+// write implements collector.
+func (v *FuncVec) write(w io.Writer, name string) error {
+	return v.each(func(labels string, s *funcValue) error {
+		s.mu.Lock()
+		fn := s.fn
+		s.mu.Unlock()
+		if fn == nil {
+			return nil
+		}
+		_, err := fmt.Fprintf(w, "%s%s %s\n", name, labels, formatValue(fn()))
+		return err
+	})
+}
+
+// DefBuckets are the default latency buckets, in seconds.
+var DefBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Observer accumulates histogram observations.
+type Observer struct {
+	mu      sync.Mutex
+	bounds  []float64
+	buckets []uint64 // non-cumulative counts per bound
+	count   uint64
+	sum     float64
+}
+
+// Observe records x.
+func (o *Observer) Observe(x float64) {
+	i := sort.SearchFloat64s(o.bounds, x)
+	o.mu.Lock()
+	if i < len(o.buckets) {
+		o.buckets[i]++
+	}
+	o.count++
+	o.sum += x
+	o.mu.Unlock()
+}
+
+// HistogramVec is a histogram family partitioned by labels.
+type HistogramVec struct {
+	*family[Observer]
+}
+
+// NewHistogram registers a histogram family in Default; nil buckets selects DefBuckets.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	bounds := append([]float64(nil), buckets...)
+	sort.Float64s(bounds)
+	h := &HistogramVec{newFamily(name, help, KindHistogram, labels, func() *Observer {
+		return &Observer{bounds: bounds, buckets: make([]uint64, len(bounds))}
+	})}
+	Default.register(h)
+	return h
+}
+
+// With returns the observer for the given label values.
+func (h *HistogramVec) With(values ...string) *Observer { return h.with(values) }
+
+// write implements collector.
+func (h *HistogramVec) write(w io.Writer, name string) error {
+	return h.each(func(labels string, o *Observer) error {
+		o.mu.Lock()
+		counts := append([]uint64(nil), o.buckets...)
+		count, sum := o.count, o.sum
+		o.mu.Unlock()
+		var cum uint64
+		for i, le := range o.bounds {
+			cum += counts[i]
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", name, withLabel(labels, "le", formatValue(le)), cum); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", name, withLabel(labels, "le", "+Inf"), count); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n", name, labels, formatValue(sum), name, labels, count)
+		return err
+	})
+}
+
+// formatLabels renders {a="x",b="y"}, or "" without labels.
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, n := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(n)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(values[i]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// withLabel appends one more label to a rendered label set.
+func withLabel(labels, name, value string) string {
+	pair := name + `="` + escapeLabel(value) + `"`
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+// labelEscaper escapes label values per the exposition format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel escapes a label value.
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+// escapeHelp escapes HELP text, which keeps quotes verbatim.
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+// formatValue renders a sample value.
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
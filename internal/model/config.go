@@ -7,10 +7,12 @@ package model
 type Config struct {
 	Global         GlobalConfig        `yaml:"global"`
 	Server         ServerConfig        `yaml:"server"`
+	App            AppConfig           `yaml:"app"`
 	Gateways       []GatewayConfig     `yaml:"gateways"`
 	Vehicles       []VehicleConfig     `yaml:"vehicles"`
 	Arduinos       []ArduinoConfig     `yaml:"arduinos"`
 	VirtualSerials VirtualSerialConfig `yaml:"virtual_serials"`
+	Supervisor     SupervisorConfig    `yaml:"supervisor"`
 }
 
 // GlobalConfig defines shared defaults across the system.
@@ -23,6 +25,166 @@ type ServerConfig struct {
 	FogAddr  string            `yaml:"fog_addr"` // address for FogServer (e.g. ":10000") if blank server will not work
 	AppAddr  string            `yaml:"app_addr"` // address for FogServer (e.g. ":10000") if blank server will not work
 	Gateways []GatewayRegistry `yaml:"gateway_registry"`
+	// DedupWindowMs is how long copies of one uplink are collected from
+	// overlapping gateways (default 200, -1 disables de-duplication).
+	DedupWindowMs int `yaml:"dedup_window_ms"`
+	// LinkTTLS is how long (seconds) an uplink keeps a gateway eligible as a
+	// downlink route (default 300). gateway_registry is the fallback route.
+	LinkTTLS int `yaml:"link_ttl_s"`
+	// GatewayExpiryS is how long (seconds) a registered gateway stays online
+	// without a heartbeat (default 90).
+	GatewayExpiryS int `yaml:"gateway_expiry_s"`
+	// GatewayPurgeS is how long (seconds) an expired runtime gateway is kept
+	// in the registry before it is removed (default 86400, -1 keeps it).
+	GatewayPurgeS int `yaml:"gateway_purge_s"`
+	// RegistryPath persists runtime gateway registrations (default tmp/gateways.json).
+	RegistryPath string `yaml:"registry_path"`
+	// GatewayTokens maps gateway ID to the token it must present to register and
+	// post uplinks (as "Authorization: Bearer <token>"); gateways without one
+	// are refused.
+	GatewayTokens map[string]string `yaml:"gateway_tokens"`
+	// Vehicles is the fleet allowlist sent to gateways for roaming admission.
+	Vehicles []string `yaml:"vehicles"`
+	// Websocket tunes per-client send queues on /ws.
+	Websocket WebsocketConfig `yaml:"websocket"`
+	// ControlRate limits control commands from the API and websocket.
+	ControlRate ControlRateConfig `yaml:"control_rate"`
+	// MQTT bridges telemetry and control to an external broker; empty broker disables it.
+	MQTT MQTTConfig `yaml:"mqtt"`
+	// Broker runs an embedded MQTT broker that gateways can use instead of HTTP.
+	Broker BrokerConfig `yaml:"broker"`
+	// GWMP accepts Semtech UDP packet forwarders (SX1301/SX1302 gateways).
+	GWMP GWMPConfig `yaml:"gwmp"`
+	// GRPC accepts one bidirectional stream per gateway for uplinks and downlinks.
+	GRPC GRPCConfig `yaml:"grpc"`
+	// Integrations accept uplinks from The Things Stack or ChirpStack
+	// webhooks on /api/integrations/{id} and send downlinks through their APIs.
+	Integrations []IntegrationConfig `yaml:"integrations"`
+	// Sync uploads telemetry, events and audit records to the cloud layer
+	// and pulls configuration and missions back; empty url disables it.
+	Sync SyncConfig `yaml:"sync"`
+	// Sinks receive every de-duplicated uplink, e.g. InfluxDB for Grafana.
+	Sinks []SinkConfig `yaml:"sinks"`
+}
+
+// AppConfig defines the web app that stores the telemetry forwarded by the
+// fog and serves the dashboard and history APIs.
+type AppConfig struct {
+	Enabled bool `yaml:"enabled"` // run the app in this process, listening on server.app_addr
+	// Retention limits how long the app keeps stored telemetry.
+	Retention RetentionConfig `yaml:"retention"`
+}
+
+// RetentionConfig defines how long the app keeps each class of stored data.
+// Audit records are kept forever.
+type RetentionConfig struct {
+	RawDays          int `yaml:"raw_days"`           // raw telemetry samples; 0 or -1 keeps forever
+	AggregateMonths  int `yaml:"aggregate_months"`   // 1-minute min/max/avg/last rollups; 0 or -1 keeps forever
+	CompactIntervalS int `yaml:"compact_interval_s"` // how often rollups are built and expired data purged (default 300)
+}
+
+// SyncConfig defines batch synchronisation between the fog and the cloud.
+type SyncConfig struct {
+	URL         string `yaml:"url"`           // cloud endpoint, e.g. https://cloud.example.com/lorafog; empty disables sync
+	Token       string `yaml:"token"`         // sent as "Authorization: Bearer <token>"
+	FogID       string `yaml:"fog_id"`        // identifies this fog to the cloud (default hostname)
+	Path        string `yaml:"path"`          // outbox database (default tmp/sync.db)
+	IntervalS   int    `yaml:"interval_s"`    // upload period (default 10)
+	BatchSize   int    `yaml:"batch_size"`    // records per upload (default 500)
+	Compression string `yaml:"compression"`   // gzip (default) | none
+	ConfigPullS int    `yaml:"config_pull_s"` // config/mission pull period (default 60, -1 disables)
+	MaxPending  int    `yaml:"max_pending"`   // records kept per stream while offline; oldest are dropped (default 100000)
+}
+
+// SinkConfig defines one telemetry sink written by the fog in InfluxDB line protocol.
+type SinkConfig struct {
+	Kind        string `yaml:"kind"`        // influx | file
+	Name        string `yaml:"name"`        // component and metrics name (default sink/<kind>)
+	URL         string `yaml:"url"`         // influx: write endpoint, e.g. http://localhost:8086/api/v2/write?org=lorafog&bucket=telemetry
+	Token       string `yaml:"token"`       // influx: sent as "Authorization: Token <token>"
+	Path        string `yaml:"path"`        // file: directory of daily .lp files (default tmp/lineproto)
+	Measurement string `yaml:"measurement"` // default telemetry
+	BatchSize   int    `yaml:"batch_size"`  // points per write (default 500)
+	FlushMs     int    `yaml:"flush_ms"`    // max delay before a partial batch is written (default 1000)
+	QueueSize   int    `yaml:"queue_size"`  // points buffered while the sink is slow or down; newer points are dropped (default 10000)
+}
+
+// IntegrationConfig defines one LoRaWAN network server integration.
+type IntegrationConfig struct {
+	ID        string            `yaml:"id"`         // route name, also the gateway ID of its uplinks
+	Kind      string            `yaml:"kind"`       // ttn | chirpstack
+	Format    string            `yaml:"format"`     // parser for frm_payload (csv/json, default global wire_format)
+	Token     string            `yaml:"token"`      // required; webhooks must send "Authorization: Bearer <token>"
+	APIURL    string            `yaml:"api_url"`    // e.g. https://eu1.cloud.thethings.network or http://chirpstack:8090
+	APIKey    string            `yaml:"api_key"`    // bearer key for the downlink API
+	WebhookID string            `yaml:"webhook_id"` // ttn: webhook used for downlink push
+	FPort     int               `yaml:"f_port"`     // downlink FPort (default 1)
+	Devices   map[string]string `yaml:"devices"`    // device ID or DevEUI -> vehicle ID; other devices may only report the fleet vehicle named like them
+}
+
+// GWMPConfig defines the fog's Semtech UDP packet-forwarder endpoint.
+// Frame payloads are expected to carry wire-format telemetry (CSV or JSON).
+type GWMPConfig struct {
+	Addr     string            `yaml:"addr"`     // UDP listen address, e.g. ":1700"; empty disables it
+	Names    map[string]string `yaml:"names"`    // gateway EUI -> gateway ID; only listed EUIs are accepted, an empty ID keeps the EUI
+	FreqMHz  float64           `yaml:"freq_mhz"` // downlink frequency (default 869.525)
+	DataRate string            `yaml:"datr"`     // downlink data rate (default SF9BW125)
+	CodeRate string            `yaml:"codr"`     // downlink coding rate (default 4/5)
+	Power    int               `yaml:"powe"`     // downlink TX power in dBm (default 14)
+}
+
+// GRPCConfig defines the fog's gateway stream endpoint.
+type GRPCConfig struct {
+	Addr     string `yaml:"addr"`      // listen address, e.g. ":7000"; empty disables it
+	CertFile string `yaml:"cert_file"` // PEM server certificate; required unless insecure
+	KeyFile  string `yaml:"key_file"`  // PEM key of cert_file
+	Insecure bool   `yaml:"insecure"`  // serve without TLS, so tokens travel in clear text; local testing only
+}
+
+// BrokerConfig defines the fog's embedded MQTT broker for gateway transport.
+type BrokerConfig struct {
+	Addr     string `yaml:"addr"`     // listen address, e.g. ":1883"; empty disables the broker
+	Username string `yaml:"username"` // fog account with access to all topics, e.g. for the MQTT bridge; gateways log in with their ID and token
+	Password string `yaml:"password"` // required with username; the broker refuses to start without it
+}
+
+// MQTTConfig defines the fog's MQTT bridge.
+type MQTTConfig struct {
+	Broker      string        `yaml:"broker"`       // e.g. tcp://localhost:1883, ssl://host:8883, ws://host/mqtt
+	ClientID    string        `yaml:"client_id"`    // default lorafog-fog
+	Username    string        `yaml:"username"`     // optional
+	Password    string        `yaml:"password"`     // optional
+	TopicPrefix string        `yaml:"topic_prefix"` // default lorafog
+	QoS         byte          `yaml:"qos"`          // 0, 1 or 2 for publish and subscribe
+	Retain      bool          `yaml:"retain"`       // retain telemetry so new subscribers get the latest value
+	TLS         MQTTTLSConfig `yaml:"tls"`
+}
+
+// MQTTTLSConfig defines TLS for ssl:// and wss:// brokers.
+type MQTTTLSConfig struct {
+	CAFile             string `yaml:"ca_file"`              // PEM CA bundle; system roots when empty
+	CertFile           string `yaml:"cert_file"`            // client certificate for mutual TLS
+	KeyFile            string `yaml:"key_file"`             // client key for mutual TLS
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"` // testing only
+}
+
+// ControlRateConfig defines token-bucket limits for control commands.
+// Zero values keep the defaults; a negative rate disables that limit.
+type ControlRateConfig struct {
+	PerConnectionPerMin int `yaml:"per_connection_per_min"` // per websocket connection (default 60)
+	PerVehiclePerMin    int `yaml:"per_vehicle_per_min"`    // per target vehicle (default 30)
+	Burst               int `yaml:"burst"`                  // commands allowed at once (default 5)
+}
+
+// WebsocketConfig defines per-client queueing and keepalive for fog websocket clients.
+type WebsocketConfig struct {
+	QueueSize      int    `yaml:"queue_size"`       // messages buffered per client (default 64)
+	Policy         string `yaml:"policy"`           // drop-oldest (default) | disconnect
+	WriteTimeoutMs int    `yaml:"write_timeout_ms"` // per-message write deadline (default 5000)
+	PingS          int    `yaml:"ping_s"`           // ping interval; clients silent for 2x are dropped (default 30)
+	// AllowedOrigins are browser origins, e.g. http://localhost:3001, allowed
+	// to open /ws besides the fog itself and app_addr.
+	AllowedOrigins []string `yaml:"allowed_origins"`
 }
 
 // GatewayRegistry defines a gateway registration entry.
@@ -34,26 +196,111 @@ type GatewayRegistry struct {
 
 // GatewayConfig defines configuration for a single gateway instance.
 type GatewayConfig struct {
-	ID       string   `yaml:"id"`
-	URL      string   `yaml:"url"`     // fog server endpoint
-	FogURL   string   `yaml:"fog_url"` // fog server endpoint
-	LoraDev  string   `yaml:"lora_device"`
-	LoraBaud int      `yaml:"lora_baud"`
-	WireIn   string   `yaml:"wire_in"`  // format received from vehicle
-	WireOut  string   `yaml:"wire_out"` // format sent to fog
-	Vehicles []string `yaml:"vehicles"`
+	ID     string `yaml:"id"`
+	URL    string `yaml:"url"`     // fog server endpoint
+	FogURL string `yaml:"fog_url"` // fog server endpoint
+	// FogURLs are fallback fog endpoints for the http transport, tried in
+	// order after fog_url when it is unreachable.
+	FogURLs  []string    `yaml:"fog_urls"`
+	LoraDev  string      `yaml:"lora_device"`
+	LoraBaud int         `yaml:"lora_baud"`
+	WireIn   string      `yaml:"wire_in"`  // format received from vehicle
+	WireOut  string      `yaml:"wire_out"` // format sent to fog
+	Vehicles []string    `yaml:"vehicles"`
+	Radio    RadioConfig `yaml:"radio"`
+	// Token authenticates the gateway to the fog; it must match the fog's
+	// server.gateway_tokens entry for this ID.
+	Token string `yaml:"token"`
+	// HeartbeatS is the registration heartbeat period to the fog in seconds
+	// (default 30, -1 disables).
+	HeartbeatS int `yaml:"heartbeat_s"`
+	// Admission selects which vehicles' frames are forwarded:
+	// static (own vehicles list), allowlist (plus fog fleet, default) or any.
+	Admission string         `yaml:"admission"`
+	Downlink  DownlinkConfig `yaml:"downlink"`
+	// Transport selects how the gateway reaches the fog: http (default),
+	// mqtt (a persistent session to the fog's embedded broker), gwmp
+	// (Semtech UDP packet forwarder), grpc (one bidirectional stream) or
+	// local (direct calls into the fog of the same process).
+	Transport string            `yaml:"transport"`
+	MQTT      GatewayMQTTConfig `yaml:"mqtt"`
+	GWMP      GatewayGWMPConfig `yaml:"gwmp"`
+	GRPC      GatewayGRPCConfig `yaml:"grpc"`
+}
+
+// GatewayGRPCConfig defines the gateway's stream to the fog.
+type GatewayGRPCConfig struct {
+	Server   string `yaml:"server"`   // fog host:port, e.g. localhost:7000
+	Window   int    `yaml:"window"`   // max unacknowledged uplinks in flight (default 32)
+	CAFile   string `yaml:"ca_file"`  // PEM CA bundle for the fog's certificate; system roots when empty
+	Insecure bool   `yaml:"insecure"` // connect without TLS, to a fog with grpc.insecure
+}
+
+// GatewayGWMPConfig makes the gateway act as a Semtech UDP packet forwarder
+// towards the fog's server.gwmp endpoint.
+type GatewayGWMPConfig struct {
+	Server        string  `yaml:"server"`          // fog gwmp endpoint host:port, e.g. localhost:1700
+	EUI           string  `yaml:"eui"`             // 16 hex digits; derived from the gateway ID when empty
+	PullIntervalS int     `yaml:"pull_interval_s"` // PULL_DATA keepalive period (default 10)
+	FreqMHz       float64 `yaml:"freq_mhz"`        // reported uplink frequency (default 868.1)
+	// LoRaWAN maps vehicle ID to its ABP session in a LoRaWAN network server
+	// such as ChirpStack; frames are then sent as LoRaWAN data messages.
+	// Empty sends raw wire lines, which only the fog understands.
+	LoRaWAN map[string]LoRaWANDeviceConfig `yaml:"lorawan"`
+}
+
+// LoRaWANDeviceConfig is the ABP session of one vehicle, as registered in
+// the network server.
+type LoRaWANDeviceConfig struct {
+	DevAddr string `yaml:"dev_addr"`  // 8 hex digits, e.g. 260B1234
+	NwkSKey string `yaml:"nwk_s_key"` // 32 hex digits
+	AppSKey string `yaml:"app_s_key"` // 32 hex digits
+	FPort   int    `yaml:"f_port"`    // uplink port (default 1)
+}
+
+// GatewayMQTTConfig defines the gateway's connection to the fog broker.
+type GatewayMQTTConfig struct {
+	Broker   string `yaml:"broker"`   // e.g. tcp://fog-host:1883
+	Username string `yaml:"username"` // default the gateway ID
+	Password string `yaml:"password"` // default the gateway token
+}
+
+// DownlinkConfig defines when a gateway transmits downlinks to vehicles.
+type DownlinkConfig struct {
+	Class      string `yaml:"class"`        // C: transmit immediately (default); A: after next uplink
+	RxDelayMs  int    `yaml:"rx_delay_ms"`  // class A: delay from uplink to window (default 1000)
+	RxWindowMs int    `yaml:"rx_window_ms"` // class A: window length (default 1000)
+	TTLS       int    `yaml:"ttl_s"`        // class A: pending downlink lifetime (default 600)
+	MaxPending int    `yaml:"max_pending"`  // class A: pending downlinks per vehicle (default 8)
 }
 
 // VehicleConfig defines configuration for a single vehicle agent.
 type VehicleConfig struct {
-	ID                  string `yaml:"id"`
-	WireFormat          string `yaml:"wire_format"`
-	TelemetryIntervalMs int    `yaml:"telemetry_interval_ms"`
-	LoraDev             string `yaml:"lora_device"`
-	LoraBaud            int    `yaml:"lora_baud"`
-	ArduinoID           string `yaml:"arduino_id"`
-	ArduinoDev          string `yaml:"arduino_device"`
-	ArduinoBaud         int    `yaml:"arduino_baud"`
+	ID                  string      `yaml:"id"`
+	WireFormat          string      `yaml:"wire_format"`
+	TelemetryIntervalMs int         `yaml:"telemetry_interval_ms"`
+	LoraDev             string      `yaml:"lora_device"`
+	LoraBaud            int         `yaml:"lora_baud"`
+	ArduinoID           string      `yaml:"arduino_id"`
+	ArduinoDev          string      `yaml:"arduino_device"`
+	ArduinoBaud         int         `yaml:"arduino_baud"`
+	StatusAddr          string      `yaml:"status_addr"`        // optional local /healthz,/readyz,/metrics listener
+	MetricsIntervalS    int         `yaml:"metrics_interval_s"` // push own metrics through the gateway every N seconds (0 disables)
+	FrameCounter        bool        `yaml:"frame_counter"`      // send a frame counter (9th CSV field); needs gateways that accept it
+	Radio               RadioConfig `yaml:"radio"`
+}
+
+// RadioConfig defines LoRa modulation and duty-cycle limits for a LoRa device.
+// Duty-cycle enforcement is disabled when DutyCycle is 0.
+type RadioConfig struct {
+	SpreadingFactor int     `yaml:"spreading_factor"` // 7..12 (default 7)
+	BandwidthKHz    float64 `yaml:"bandwidth_khz"`    // default 125
+	CodingRate      int     `yaml:"coding_rate"`      // 5..8 for 4/5..4/8 (default 5)
+	Preamble        int     `yaml:"preamble"`         // preamble symbols (default 8)
+	DutyCycle       float64 `yaml:"duty_cycle"`       // e.g. 0.01 for 1% (EU868 g/g1 sub-bands)
+	DutyWindowS     int     `yaml:"duty_window_s"`    // budget window in seconds (default 3600)
+	Policy          string  `yaml:"policy"`           // queue | coalesce | reject (default queue)
+	QueueSize       int     `yaml:"queue_size"`       // max queued lines for policy queue (default 16)
 }
 
 // ArduinoConfig defines serial setup for testing
@@ -82,3 +329,11 @@ type VirtualSerialConfig struct {
 	Enabled bool          `yaml:"enabled"`
 	Pairs   []VirtualPair `yaml:"pairs"`
 }
+
+// SupervisorConfig defines restart behaviour for supervised components.
+type SupervisorConfig struct {
+	RestartPolicy string            `yaml:"restart_policy"` // never | on-failure | always (default on-failure)
+	BackoffMinMs  int               `yaml:"backoff_min_ms"` // first restart delay
+	BackoffMaxMs  int               `yaml:"backoff_max_ms"` // upper bound of exponential backoff
+	Overrides     map[string]string `yaml:"overrides"`      // per-component policy, e.g. "gateway/GW01": always
+}
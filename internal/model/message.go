@@ -2,6 +2,11 @@
 // gateways, and the fog server, including telemetry and control messages.
 package model
 
+import (
+	"encoding/json"
+	"time"
+)
+
 type PacketType string
 
 const (
@@ -25,6 +30,58 @@ type VehicleData struct {
 	LeftSpeed   int     `json:"left_speed"`
 	RightSpeed  int     `json:"right_speed"`
 	PID         int     `json:"pid"`
+	FCnt        uint32  `json:"fcnt,omitempty"` // per-vehicle frame counter, 0 if unknown
+}
+
+// VehicleMetrics is a vehicle's own counters, pushed over LoRa so that
+// gateways can expose them for vehicles without an HTTP endpoint.
+type VehicleMetrics struct {
+	VehicleID    string `json:"vehicle_id"`
+	TelemetryTx  uint64 `json:"telemetry_tx"`  // telemetry frames transmitted
+	ControlRx    uint64 `json:"control_rx"`    // control frames received and decoded
+	DecodeErrors uint64 `json:"decode_errors"` // control frames that failed to decode
+	Reconnects   uint64 `json:"reconnects"`    // LoRa serial reopens
+	AirtimeMs    uint64 `json:"airtime_ms"`    // LoRa airtime used, 0 without duty-cycle accounting
+}
+
+// UplinkMeta describes how one gateway received an uplink frame.
+// RSSI and SNR are zero when the LoRa module does not report link quality.
+type UplinkMeta struct {
+	GatewayID  string    `json:"gateway_id"`
+	RSSI       float64   `json:"rssi,omitempty"`
+	SNR        float64   `json:"snr,omitempty"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// HasLink reports whether the meta carries link quality information.
+func (m UplinkMeta) HasLink() bool { return m.RSSI != 0 || m.SNR != 0 }
+
+// BetterThan reports whether m describes a better radio link than o:
+// known link quality beats unknown, then higher RSSI, then higher SNR.
+func (m UplinkMeta) BetterThan(o UplinkMeta) bool {
+	if m.HasLink() != o.HasLink() {
+		return m.HasLink()
+	}
+	if m.RSSI != o.RSSI {
+		return m.RSSI > o.RSSI
+	}
+	return m.SNR > o.SNR
+}
+
+// GatewayUplink is a telemetry line a gateway publishes to the fog's
+// embedded broker, with the link quality the HTTP transport sends as headers.
+type GatewayUplink struct {
+	Data string  `json:"data"` // wire-format telemetry line
+	RSSI float64 `json:"rssi,omitempty"`
+	SNR  float64 `json:"snr,omitempty"`
+}
+
+// Uplink is a de-duplicated telemetry frame with the gateways that heard it.
+// Meta is the copy with the best link quality; HeardBy lists every copy.
+type Uplink struct {
+	Data    VehicleData  `json:"data"`
+	Meta    UplinkMeta   `json:"meta"`
+	HeardBy []UplinkMeta `json:"heard_by"`
 }
 
 // ControlData represents a control command sent from Fog to a vehicle.
@@ -68,8 +125,191 @@ type GpsData struct {
 
 // GatewayRegistration represents information sent by a gateway
 // to the fog when registering itself.
+// Gateways re-send it periodically as a heartbeat.
 type GatewayRegistration struct {
 	GatewayID string   `json:"gateway_id"`
 	URL       string   `json:"url"`
 	Vehicles  []string `json:"vehicles"`
+	Version   string   `json:"version,omitempty"`
+}
+
+// GatewayRegistrationAck is the fog's answer to a registration or heartbeat.
+type GatewayRegistrationAck struct {
+	GatewayID  string `json:"gateway_id"`
+	ExpiresInS int    `json:"expires_in_s"` // gateway expires unless it heartbeats within this time
+	// Vehicles is the fog-authorised fleet; roaming gateways admit frames from these vehicles.
+	Vehicles []string `json:"vehicles,omitempty"`
+}
+
+// GatewayStatus describes a gateway known to the fog registry.
+type GatewayStatus struct {
+	GatewayID    string    `json:"gateway_id"`
+	URL          string    `json:"url"`
+	Status       string    `json:"status"` // static | online | expired
+	Static       bool      `json:"static"`
+	Vehicles     []string  `json:"vehicles"`
+	Version      string    `json:"version,omitempty"`
+	RegisteredAt time.Time `json:"registered_at"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// Event types emitted by the fog.
+const (
+	EventHandover          = "handover"
+	EventGatewayRegistered = "gateway_registered"
+	EventCommandStatus     = "command_status"
+	EventAlert             = "alert"
+	EventCloudUpdate       = "cloud_update"
+)
+
+// Event is a notable occurrence on the fog, such as a vehicle handover
+// between gateways. IDs increase monotonically per fog process.
+type Event struct {
+	ID        uint64    `json:"id"`
+	Type      string    `json:"type"`
+	VehicleID string    `json:"vehicle_id,omitempty"`
+	GatewayID string    `json:"gateway_id,omitempty"`
+	Time      time.Time `json:"time"`
+	Data      any       `json:"data,omitempty"`
+}
+
+// Cloud sync streams: what the fog uploads to the cloud layer.
+const (
+	SyncTelemetry = "telemetry" // de-duplicated uplinks
+	SyncEvents    = "events"    // handovers, registrations and alerts
+	SyncAudit     = "audit"     // command outcomes and applied cloud updates
+)
+
+// SyncBatch is one upload from a fog to the cloud. Seq numbers increase per
+// fog and stream; the cloud may receive a batch twice after a lost answer
+// and should ignore records it already has.
+type SyncBatch struct {
+	FogID    string       `json:"fog_id"`
+	Stream   string       `json:"stream"`
+	FirstSeq uint64       `json:"first_seq"`
+	LastSeq  uint64       `json:"last_seq"`
+	Records  []SyncRecord `json:"records"`
+}
+
+// SyncRecord is one stored telemetry uplink, event or audit record.
+type SyncRecord struct {
+	Seq  uint64          `json:"seq"`
+	Data json.RawMessage `json:"data"`
+}
+
+// CloudUpdate is the configuration and mission state the cloud holds for a
+// fog. A fog applies each Version once.
+type CloudUpdate struct {
+	Version  string        `json:"version"`
+	Fleet    []string      `json:"fleet,omitempty"`    // vehicles added to the roaming fleet; replaces the previous version's list
+	Commands []ControlData `json:"commands,omitempty"` // mission steps, submitted like POST /api/control
+}
+
+// CloudUpdateApplied is the Data of an EventCloudUpdate.
+type CloudUpdateApplied struct {
+	Version  string   `json:"version"`
+	Fleet    int      `json:"fleet"`
+	Commands []string `json:"commands"`         // accepted command IDs
+	Errors   []string `json:"errors,omitempty"` // rejected commands
+}
+
+// SyncStatus reports the fog's cloud synchronisation state.
+type SyncStatus struct {
+	URL           string                      `json:"url"`
+	FogID         string                      `json:"fog_id"`
+	Streams       map[string]SyncStreamStatus `json:"streams"`
+	LastUpload    time.Time                   `json:"last_upload"`
+	LastError     string                      `json:"last_error,omitempty"`
+	ConfigVersion string                      `json:"config_version,omitempty"`
+	Dropped       uint64                      `json:"dropped"`
+}
+
+// SyncStreamStatus reports one stream's outbox.
+type SyncStreamStatus struct {
+	Pending int    `json:"pending"`
+	Cursor  uint64 `json:"cursor"` // last sequence number acknowledged by the cloud
+}
+
+// Handover is the Data of an EventHandover.
+type Handover struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// VehicleStatus describes where the fog last heard a vehicle.
+type VehicleStatus struct {
+	VehicleID      string    `json:"vehicle_id"`
+	ServingGateway string    `json:"serving_gateway"`
+	LastSeen       time.Time `json:"last_seen"`
+	HeardBy        []string  `json:"heard_by"`
+}
+
+// WSClientStats reports the delivery state of one websocket client.
+// LagMs is the enqueue-to-write delay of the last delivered message.
+type WSClientStats struct {
+	Remote      string    `json:"remote"`
+	ConnectedAt time.Time `json:"connected_at"`
+	Queued      int       `json:"queued"`
+	QueueSize   int       `json:"queue_size"`
+	Sent        uint64    `json:"sent"`
+	Dropped     uint64    `json:"dropped"`
+	LagMs       float64   `json:"lag_ms"`
+}
+
+// WSProtocolVersion is the version of the websocket envelope protocol.
+const WSProtocolVersion = 1
+
+// Envelope types sent on the fog websocket besides event types.
+const (
+	EnvelopeTelemetry  = "telemetry"
+	EnvelopeSubscribed = "subscribed"
+	EnvelopeError      = "error"
+	EnvelopeCommandAck = "command_ack"
+)
+
+// Envelope wraps every message the fog pushes on /ws.
+type Envelope struct {
+	V         int       `json:"v"`
+	Type      string    `json:"type"`
+	Vehicle   string    `json:"vehicle,omitempty"`
+	Gateway   string    `json:"gateway,omitempty"`
+	Timestamp time.Time `json:"ts"`
+	Payload   any       `json:"payload,omitempty"`
+}
+
+// WSClientMessage is a message sent by a websocket client.
+// Type is "subscribe", "unsubscribe" or "control"; empty filter lists match
+// everything. Control messages carry Command and an optional RequestID echoed
+// in the command_ack.
+type WSClientMessage struct {
+	Type      string       `json:"type"`
+	Vehicles  []string     `json:"vehicles,omitempty"`
+	Gateways  []string     `json:"gateways,omitempty"`
+	Events    []string     `json:"events,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+	Command   *ControlData `json:"command,omitempty"`
+}
+
+// CommandAck answers a websocket control message. ID identifies the command
+// in later command_status events; Error is set when it was rejected.
+type CommandAck struct {
+	RequestID string `json:"request_id,omitempty"`
+	ID        string `json:"id,omitempty"`
+	Vehicle   string `json:"vehicle,omitempty"`
+	Status    string `json:"status,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Alert is the payload of an EventAlert.
+type Alert struct {
+	Severity string `json:"severity"` // info | warning | critical
+	Message  string `json:"message"`
+}
+
+// CommandStatus is the payload of an EventCommandStatus.
+type CommandStatus struct {
+	ID      string `json:"id,omitempty"`
+	Status  string `json:"status"` // accepted | forwarded | rejected | failed
+	Gateway string `json:"gateway,omitempty"`
+	Error   string `json:"error,omitempty"`
 }
@@ -11,26 +11,34 @@ import (
 )
 
 // CSVParser implements Parser interface using CSV format.
-// Example telemetry CSV: VEHICLE_ID,LAT,LON,HEAD_CUR,HEAD_TAR,LEFT,RIGHT,PID
+// Example telemetry CSV: VEHICLE_ID,LAT,LON,HEAD_CUR,HEAD_TAR,LEFT,RIGHT,PID[,FCNT]
 type CSVParser struct{}
 
 // NewCSVParser creates a new CSV parser instance.
 func NewCSVParser() *CSVParser { return &CSVParser{} }
 
 // EncodeTelemetry converts VehicleData into CSV string.
+// The frame counter is appended as a 9th field only when it is set.
 func (p *CSVParser) EncodeTelemetry(v model.VehicleData) (string, error) {
 	line := fmt.Sprintf("%s,%.6f,%.6f,%d,%d,%d,%d,%d",
 		v.VehicleID, v.Latitude, v.Longitude, v.CurrentHead, v.TargetHead, v.LeftSpeed, v.RightSpeed, v.PID)
+	if v.FCnt != 0 {
+		line += "," + strconv.FormatUint(uint64(v.FCnt), 10)
+	}
 	return line, nil
 }
 
 // DecodeTelemetry parses a CSV telemetry line into VehicleData struct.
 func (p *CSVParser) DecodeTelemetry(line string) (model.VehicleData, error) {
 	fields := strings.Split(strings.TrimSpace(line), ",")
-	if len(fields) != 8 {
-		return model.VehicleData{}, fmt.Errorf("expected 8 fields, got %d", len(fields))
+	if len(fields) != 8 && len(fields) != 9 {
+		return model.VehicleData{}, fmt.Errorf("expected 8 or 9 fields, got %d", len(fields))
 	}
 
+	var fcnt uint64
+	if len(fields) == 9 {
+		fcnt, _ = strconv.ParseUint(fields[8], 10, 32)
+	}
 	latitude, _ := strconv.ParseFloat(fields[1], 64)
 	longitude, _ := strconv.ParseFloat(fields[2], 64)
 	currentHead, _ := strconv.ParseFloat(fields[3], 64)
@@ -48,6 +56,7 @@ func (p *CSVParser) DecodeTelemetry(line string) (model.VehicleData, error) {
 		LeftSpeed:   int(leftSpeed),
 		RightSpeed:  int(rightSpeed),
 		PID:         int(pid),
+		FCnt:        uint32(fcnt),
 	}, nil
 }
 
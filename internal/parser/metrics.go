@@ -0,0 +1,52 @@
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"LoraFog/internal/model"
+)
+
+// MetricsPrefix starts a vehicle metrics frame, whatever the telemetry wire
+// format: #M,VEHICLE_ID,TELEMETRY_TX,CONTROL_RX,DECODE_ERRORS,RECONNECTS,AIRTIME_MS
+const MetricsPrefix = "#M,"
+
+// IsMetrics reports whether line is a vehicle metrics frame.
+func IsMetrics(line string) bool {
+	return strings.HasPrefix(line, MetricsPrefix)
+}
+
+// EncodeMetrics converts VehicleMetrics into a metrics frame.
+func EncodeMetrics(m model.VehicleMetrics) string {
+	return fmt.Sprintf("%s%s,%d,%d,%d,%d,%d", MetricsPrefix,
+		m.VehicleID, m.TelemetryTx, m.ControlRx, m.DecodeErrors, m.Reconnects, m.AirtimeMs)
+}
+
+// DecodeMetrics parses a metrics frame into VehicleMetrics.
+func DecodeMetrics(line string) (model.VehicleMetrics, error) {
+	line = strings.TrimSpace(line)
+	if !IsMetrics(line) {
+		return model.VehicleMetrics{}, fmt.Errorf("not a metrics frame")
+	}
+	fields := strings.Split(strings.TrimPrefix(line, MetricsPrefix), ",")
+	if len(fields) != 6 || fields[0] == "" {
+		return model.VehicleMetrics{}, fmt.Errorf("expected 6 metrics fields, got %d", len(fields))
+	}
+	var n [5]uint64
+	for i, f := range fields[1:] {
+		v, err := strconv.ParseUint(f, 10, 64)
+		if err != nil {
+			return model.VehicleMetrics{}, fmt.Errorf("metrics field %d: %w", i+2, err)
+		}
+		n[i] = v
+	}
+	return model.VehicleMetrics{
+		VehicleID:    fields[0],
+		TelemetryTx:  n[0],
+		ControlRx:    n[1],
+		DecodeErrors: n[2],
+		Reconnects:   n[3],
+		AirtimeMs:    n[4],
+	}, nil
+}
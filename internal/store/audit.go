@@ -0,0 +1,70 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// bucketAudit holds audit records keyed by time; retention never purges it.
+var bucketAudit = []byte("audit")
+
+// AuditRecord is one operator action, such as a control command.
+type AuditRecord struct {
+	Time      time.Time       `json:"time"`
+	Action    string          `json:"action"`
+	VehicleID string          `json:"vehicle_id,omitempty"`
+	Actor     string          `json:"actor,omitempty"`  // session user or remote address
+	Status    int             `json:"status,omitempty"` // HTTP status of the outcome
+	Detail    json.RawMessage `json:"detail,omitempty"`
+}
+
+// AppendAudit stores r under its time, moving it forward by a nanosecond on
+// collision.
+func (s *Store) AppendAudit(r AuditRecord) error {
+	val, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return s.db.Batch(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketAudit)
+		t := r.Time
+		for b.Get(timeKey(t)) != nil {
+			t = t.Add(time.Nanosecond)
+		}
+		return b.Put(timeKey(t), val)
+	})
+}
+
+// Audit calls fn for audit records within [from, to], in time order, until
+// fn returns false. Zero from or to means no bound. key is the time the
+// record is stored under, which differs from r.Time after a collision;
+// resume after it, not after r.Time.
+func (s *Store) Audit(from, to time.Time, fn func(key time.Time, r AuditRecord) bool) error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(bucketAudit).Cursor()
+		k, v := c.First()
+		if !from.IsZero() {
+			k, v = c.Seek(timeKey(from))
+		}
+		var end []byte
+		if !to.IsZero() {
+			end = timeKey(to)
+		}
+		for ; k != nil; k, v = c.Next() {
+			if end != nil && bytes.Compare(k, end) > 0 {
+				break
+			}
+			var r AuditRecord
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			if !fn(keyTime(k), r) {
+				break
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,81 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"LoraFog/internal/model"
+)
+
+// codecV1 is the version byte of the compact VehicleData encoding.
+const codecV1 = 1
+
+// coordScale converts degrees to the stored integer unit (1e-7°, about 1 cm).
+const coordScale = 1e7
+
+var errShortRecord = errors.New("store: truncated record")
+
+// timeKey encodes t as a big-endian nanosecond key, so keys sort by time.
+func timeKey(t time.Time) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(t.UnixNano()))
+	return k
+}
+
+// keyTime decodes a key written by timeKey.
+func keyTime(k []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(k))).UTC()
+}
+
+// encode packs vd without its vehicle ID, which is the bucket name: a version
+// byte, then coordinates in 1e-7° and the remaining fields as varints.
+// A typical record takes 15-25 bytes instead of ~150 as JSON.
+func encode(vd model.VehicleData) []byte {
+	b := make([]byte, 0, 32)
+	b = append(b, codecV1)
+	b = binary.AppendVarint(b, int64(math.Round(vd.Latitude*coordScale)))
+	b = binary.AppendVarint(b, int64(math.Round(vd.Longitude*coordScale)))
+	b = binary.AppendVarint(b, int64(vd.CurrentHead))
+	b = binary.AppendVarint(b, int64(vd.TargetHead))
+	b = binary.AppendVarint(b, int64(vd.LeftSpeed))
+	b = binary.AppendVarint(b, int64(vd.RightSpeed))
+	b = binary.AppendVarint(b, int64(vd.PID))
+	b = binary.AppendUvarint(b, uint64(vd.FCnt))
+	return b
+}
+
+// decode unpacks a record written by encode for vehicle id.
+func decode(id string, b []byte) (model.VehicleData, error) {
+	vd := model.VehicleData{VehicleID: id}
+	if len(b) == 0 {
+		return vd, errShortRecord
+	}
+	if b[0] != codecV1 {
+		return vd, fmt.Errorf("store: unknown record version %d", b[0])
+	}
+	b = b[1:]
+	var ints [7]int64
+	for i := range ints {
+		v, n := binary.Varint(b)
+		if n <= 0 {
+			return vd, errShortRecord
+		}
+		ints[i], b = v, b[n:]
+	}
+	fcnt, n := binary.Uvarint(b)
+	if n <= 0 {
+		return vd, errShortRecord
+	}
+	vd.Latitude = float64(ints[0]) / coordScale
+	vd.Longitude = float64(ints[1]) / coordScale
+	vd.CurrentHead = int(ints[2])
+	vd.TargetHead = int(ints[3])
+	vd.LeftSpeed = int(ints[4])
+	vd.RightSpeed = int(ints[5])
+	vd.PID = int(ints[6])
+	vd.FCnt = uint32(fcnt)
+	return vd, nil
+}
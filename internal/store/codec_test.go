@@ -0,0 +1,71 @@
+package store
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"LoraFog/internal/model"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	cases := []model.VehicleData{
+		{},
+		{Latitude: 10.762622, Longitude: 106.660172, CurrentHead: 90, TargetHead: 95, LeftSpeed: 40, RightSpeed: 42, PID: -3, FCnt: 7},
+		{Latitude: -33.8688197, Longitude: -151.2092955, CurrentHead: 359, TargetHead: -1, LeftSpeed: -255, RightSpeed: 255, PID: -1000},
+		{Latitude: 90, Longitude: 180, FCnt: math.MaxUint32},
+	}
+	for _, vd := range cases {
+		vd.VehicleID = "VH01"
+		got, err := decode("VH01", encode(vd))
+		if err != nil {
+			t.Errorf("decode(encode(%+v)): %v", vd, err)
+			continue
+		}
+		if got != vd {
+			t.Errorf("round trip of %+v gave %+v", vd, got)
+		}
+	}
+
+	// coordinates are kept to 1e-7 degrees
+	vd, err := decode("VH01", encode(model.VehicleData{Latitude: 10.12345678, Longitude: 106.00000004}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vd.Latitude != 10.1234568 || vd.Longitude != 106 {
+		t.Errorf("rounded to %v, %v", vd.Latitude, vd.Longitude)
+	}
+}
+
+func TestDecodeRejectsBadRecords(t *testing.T) {
+	good := encode(model.VehicleData{Latitude: 10.5, FCnt: 300})
+	cases := map[string][]byte{
+		"empty":         nil,
+		"other version": append([]byte{codecV1 + 1}, good[1:]...),
+		"truncated":     good[:len(good)-1],
+		"version only":  good[:1],
+	}
+	for name, b := range cases {
+		if _, err := decode("VH01", b); err == nil {
+			t.Errorf("%s: decoded", name)
+		}
+	}
+}
+
+func TestTimeKeysSortByTime(t *testing.T) {
+	times := []time.Time{
+		time.Unix(0, 0),
+		time.Date(2025, 6, 1, 8, 0, 0, 0, time.UTC),
+		time.Date(2025, 6, 1, 8, 0, 0, 1, time.UTC),
+		time.Date(2038, 1, 19, 3, 14, 8, 0, time.UTC),
+	}
+	for i, at := range times {
+		if got := keyTime(timeKey(at)); !got.Equal(at) {
+			t.Errorf("keyTime(timeKey(%v)) = %v", at, got)
+		}
+		if i > 0 && bytes.Compare(timeKey(times[i-1]), timeKey(at)) >= 0 {
+			t.Errorf("key of %v does not sort after %v", at, times[i-1])
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"LoraFog/internal/model"
+
+	"go.etcd.io/bbolt"
+)
+
+const (
+	// rollupDelay is how long after a minute ends its rollup is built, so
+	// samples still in flight from the fog are included.
+	rollupDelay = time.Minute
+	// rollupChunk is the span of raw samples aggregated per transaction.
+	rollupChunk = time.Hour
+	// purgeChunk is the number of keys deleted per write transaction, so
+	// readers and writers never wait long for the file lock.
+	purgeChunk = 1000
+)
+
+var (
+	bucketMinute   = []byte("1m")
+	keyRolledUntil = []byte("rolled_until")
+)
+
+// Policy says what Compact may delete; a zero time keeps that class forever.
+type Policy struct {
+	RawBefore       time.Time // raw samples older than this are purged once rolled up
+	AggregateBefore time.Time // 1-minute rollups older than this are purged
+}
+
+// CompactStats reports the work done by one Compact call.
+type CompactStats struct {
+	Rollups          int `json:"rollups"`
+	RawPurged        int `json:"raw_purged"`
+	AggregatesPurged int `json:"aggregates_purged"`
+}
+
+// Compact builds 1-minute rollups for every vehicle and purges expired
+// data according to p. Work is split into short transactions: rollups are
+// computed under a read transaction and written an hour at a time, purges
+// delete purgeChunk keys at a time. Raw samples are only purged once rolled
+// up. Audit records are never touched.
+func (s *Store) Compact(ctx context.Context, p Policy) (CompactStats, error) {
+	var st CompactStats
+	ids, err := s.Vehicles()
+	if err != nil {
+		return st, err
+	}
+	end := time.Now().Add(-rollupDelay).Truncate(time.Minute)
+	for _, id := range ids {
+		n, until, err := s.rollup(ctx, id, end)
+		st.Rollups += n
+		if err != nil {
+			return st, err
+		}
+		rawBefore := p.RawBefore
+		if !rawBefore.IsZero() && rawBefore.After(until) {
+			rawBefore = until
+		}
+		n, err = s.purge(ctx, id, bucketRaw, rawBefore)
+		st.RawPurged += n
+		if err != nil {
+			return st, err
+		}
+		n, err = s.purge(ctx, id, bucketMinute, p.AggregateBefore)
+		st.AggregatesPurged += n
+		if err != nil {
+			return st, err
+		}
+	}
+	return st, nil
+}
+
+// rollup aggregates the raw samples of vehicle id from its watermark up to
+// end and advances the watermark. It returns the rollups written and the
+// new watermark. Samples arriving behind the watermark are folded into
+// their rollup by Write instead, see foldLate.
+func (s *Store) rollup(ctx context.Context, id string, end time.Time) (int, time.Time, error) {
+	written := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, time.Time{}, err
+		}
+		var (
+			start, next time.Time
+			accs        = map[time.Time]*rollupAcc{}
+		)
+		err := s.db.View(func(tx *bbolt.Tx) error {
+			vb := tx.Bucket(bucketVehicles).Bucket([]byte(id))
+			raw := vb.Bucket(bucketRaw)
+			if v := vb.Get(keyRolledUntil); len(v) == 8 {
+				start = keyTime(v)
+			}
+			if raw == nil {
+				next = start
+				return nil
+			}
+			c := raw.Cursor()
+			k, v := c.First()
+			if !start.IsZero() {
+				k, v = c.Seek(timeKey(start))
+			}
+			if k == nil {
+				next = start
+				return nil
+			}
+			if first := keyTime(k).Truncate(time.Minute); start.IsZero() || first.After(start) {
+				start = first // skip an idle gap in one step
+			}
+			next = start.Add(rollupChunk)
+			if next.After(end) {
+				next = end
+			}
+			stop := timeKey(next)
+			for ; k != nil && bytes.Compare(k, stop) < 0; k, v = c.Next() {
+				vd, err := decode(id, v)
+				if err != nil {
+					return err
+				}
+				minute := keyTime(k).Truncate(time.Minute)
+				acc := accs[minute]
+				if acc == nil {
+					acc = &rollupAcc{}
+					accs[minute] = acc
+				}
+				acc.add(vd)
+			}
+			return nil
+		})
+		if err != nil {
+			return written, time.Time{}, err
+		}
+		if start.IsZero() || !next.After(start) {
+			return written, start, nil
+		}
+		err = s.db.Batch(func(tx *bbolt.Tx) error {
+			vb := tx.Bucket(bucketVehicles).Bucket([]byte(id))
+			mb, err := vb.CreateBucketIfNotExists(bucketMinute)
+			if err != nil {
+				return err
+			}
+			for minute, acc := range accs {
+				if err := mb.Put(timeKey(minute), acc.encode()); err != nil {
+					return err
+				}
+			}
+			return vb.Put(keyRolledUntil, timeKey(next))
+		})
+		if err != nil {
+			return written, time.Time{}, err
+		}
+		written += len(accs)
+	}
+}
+
+// foldLate merges vd, stored at t, into the rollup of its minute when that
+// minute is already behind the vehicle's watermark in vb, since rollup will
+// not visit it again and the raw sample may be purged.
+func foldLate(vb *bbolt.Bucket, t time.Time, vd model.VehicleData) error {
+	until := vb.Get(keyRolledUntil)
+	if len(until) != 8 || !t.Before(keyTime(until)) {
+		return nil
+	}
+	mb, err := vb.CreateBucketIfNotExists(bucketMinute)
+	if err != nil {
+		return err
+	}
+	minute := t.Truncate(time.Minute)
+	key := timeKey(minute)
+	acc := &rollupAcc{}
+	if b := mb.Get(key); b != nil {
+		r, err := decodeRollup(minute, b)
+		if err != nil {
+			return err
+		}
+		acc = accOf(r)
+	}
+	acc.addEarlier(vd)
+	return mb.Put(key, acc.encode())
+}
+
+// purge deletes keys older than before from a vehicle's sub-bucket, a chunk
+// per transaction. A zero before deletes nothing.
+func (s *Store) purge(ctx context.Context, id string, name []byte, before time.Time) (int, error) {
+	if before.IsZero() {
+		return 0, nil
+	}
+	limit := timeKey(before)
+	deleted := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+		n := 0
+		err := s.db.Update(func(tx *bbolt.Tx) error {
+			b := tx.Bucket(bucketVehicles).Bucket([]byte(id)).Bucket(name)
+			if b == nil {
+				return nil
+			}
+			c := b.Cursor()
+			for k, _ := c.First(); k != nil && bytes.Compare(k, limit) < 0 && n < purgeChunk; k, _ = c.First() {
+				if err := c.Delete(); err != nil {
+					return err
+				}
+				n++
+			}
+			return nil
+		})
+		deleted += n
+		if err != nil || n < purgeChunk {
+			return deleted, err
+		}
+	}
+}
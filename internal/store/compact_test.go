@@ -0,0 +1,161 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"LoraFog/internal/model"
+
+	"go.etcd.io/bbolt"
+)
+
+// newTestStore returns a store on a fresh database.
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "data.db"), 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Logf("close db: %v", err)
+		}
+	})
+	s, err := Open(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// sample is a point of VH01 at t whose latitude and heading are v.
+func sample(t time.Time, v float64) Point {
+	return Point{Time: t, Data: model.VehicleData{VehicleID: "VH01", Latitude: v, CurrentHead: int(v * 10)}}
+}
+
+// rollupAt returns the rollup of VH01 starting at minute.
+func rollupAt(t *testing.T, s *Store, minute time.Time) (Rollup, bool) {
+	t.Helper()
+	var r Rollup
+	var ok bool
+	err := s.Rollups("VH01", minute, minute, func(got Rollup) bool {
+		r, ok = got, true
+		return false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, ok
+}
+
+// rawCount returns the number of raw samples stored for VH01.
+func rawCount(t *testing.T, s *Store) int {
+	t.Helper()
+	n := 0
+	if err := s.Range("VH01", time.Time{}, time.Time{}, nil, func(Point) bool { n++; return true }); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestRollupAndFoldLate(t *testing.T) {
+	s := newTestStore(t)
+	m0 := time.Now().Add(-2 * time.Hour).Truncate(time.Minute)
+	err := s.Write(
+		sample(m0.Add(10*time.Second), 1),
+		sample(m0.Add(20*time.Second), 3),
+		sample(m0.Add(30*time.Second), 2),
+		sample(m0.Add(time.Minute), 5),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := s.Compact(context.Background(), Policy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Rollups != 2 || st.RawPurged != 0 {
+		t.Fatalf("compact stats %+v, want 2 rollups and nothing purged", st)
+	}
+
+	// each step stores one more sample, then checks its minute's rollup
+	cases := []struct {
+		name    string
+		at      time.Time // zero checks m0 without writing
+		v       float64
+		minute  time.Time
+		count   int
+		want    FieldStats // of latitude
+		rolled  bool
+		headMax float64
+	}{
+		{"rolled up", time.Time{}, 0, m0, 3, FieldStats{Min: 1, Max: 3, Avg: 2, Last: 2}, true, 30},
+		{"late sample folds in, last stays", m0.Add(5 * time.Second), 0, m0, 4, FieldStats{Min: 0, Max: 3, Avg: 1.5, Last: 2}, true, 30},
+		{"late sample raises max", m0.Add(time.Second), 7, m0, 5, FieldStats{Min: 0, Max: 7, Avg: 2.6, Last: 2}, true, 70},
+		{"late sample opens an empty minute", m0.Add(5 * time.Minute), 4, m0.Add(5 * time.Minute), 1, FieldStats{Min: 4, Max: 4, Avg: 4, Last: 4}, true, 40},
+		{"sample ahead of the watermark waits", time.Now(), 9, time.Now().Truncate(time.Minute), 0, FieldStats{}, false, 0},
+	}
+	for _, tc := range cases {
+		if !tc.at.IsZero() {
+			if err := s.Write(sample(tc.at, tc.v)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		r, ok := rollupAt(t, s, tc.minute)
+		if ok != tc.rolled {
+			t.Errorf("%s: rollup present %v, want %v", tc.name, ok, tc.rolled)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if got := r.Fields["latitude"]; r.Count != tc.count || got != tc.want {
+			t.Errorf("%s: count %d latitude %+v, want %d %+v", tc.name, r.Count, got, tc.count, tc.want)
+		}
+		if got := r.Fields["current_head"].Max; got != tc.headMax {
+			t.Errorf("%s: current_head max %v, want %v", tc.name, got, tc.headMax)
+		}
+	}
+}
+
+func TestPurgeStopsAtRollupWatermark(t *testing.T) {
+	s := newTestStore(t)
+	m0 := time.Now().Add(-2 * time.Hour).Truncate(time.Minute)
+	for i := range 5 {
+		if err := s.Write(sample(m0.Add(time.Duration(i)*time.Minute), float64(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Write(sample(time.Now(), 9)); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		policy Policy
+		want   CompactStats
+		raw    int
+	}{
+		{"nothing expires", Policy{}, CompactStats{Rollups: 5}, 6},
+		// raw samples up to an hour from now expire, but the newest is not rolled up yet
+		{"raw purge bounded by the watermark", Policy{RawBefore: time.Now().Add(time.Hour)}, CompactStats{RawPurged: 5}, 1},
+		{"aggregates expire by their minute", Policy{AggregateBefore: m0.Add(3 * time.Minute)}, CompactStats{AggregatesPurged: 3}, 1},
+	}
+	for _, tc := range cases {
+		st, err := s.Compact(context.Background(), tc.policy)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if st != tc.want {
+			t.Errorf("%s: stats %+v, want %+v", tc.name, st, tc.want)
+		}
+		if n := rawCount(t, s); n != tc.raw {
+			t.Errorf("%s: %d raw samples left, want %d", tc.name, n, tc.raw)
+		}
+	}
+	if _, ok := rollupAt(t, s, m0.Add(3*time.Minute)); !ok {
+		t.Error("rollup at the aggregate cutoff purged")
+	}
+}
@@ -0,0 +1,171 @@
+package store
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"LoraFog/internal/model"
+
+	"go.etcd.io/bbolt"
+)
+
+// rollupV1 is the version byte of the rollup encoding.
+const rollupV1 = 1
+
+// rollupFields are the numeric fields aggregated per minute, by JSON name.
+var rollupFields = []struct {
+	name string
+	get  func(model.VehicleData) float64
+}{
+	{"latitude", func(vd model.VehicleData) float64 { return vd.Latitude }},
+	{"longitude", func(vd model.VehicleData) float64 { return vd.Longitude }},
+	{"current_head", func(vd model.VehicleData) float64 { return float64(vd.CurrentHead) }},
+	{"target_head", func(vd model.VehicleData) float64 { return float64(vd.TargetHead) }},
+	{"left_speed", func(vd model.VehicleData) float64 { return float64(vd.LeftSpeed) }},
+	{"right_speed", func(vd model.VehicleData) float64 { return float64(vd.RightSpeed) }},
+	{"pid", func(vd model.VehicleData) float64 { return float64(vd.PID) }},
+}
+
+// FieldStats aggregates one field over a rollup interval.
+type FieldStats struct {
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+	Avg  float64 `json:"avg"`
+	Last float64 `json:"last"`
+}
+
+// Rollup is the 1-minute aggregate of a vehicle's samples starting at Start.
+type Rollup struct {
+	Start  time.Time             `json:"start"`
+	Count  int                   `json:"count"`
+	Fields map[string]FieldStats `json:"fields"`
+}
+
+// rollupAcc accumulates the samples of one minute in time order.
+type rollupAcc struct {
+	count int
+	stats []FieldStats // Avg holds the running sum until done
+}
+
+// add folds vd into the accumulator.
+func (a *rollupAcc) add(vd model.VehicleData) {
+	if a.stats == nil {
+		a.stats = make([]FieldStats, len(rollupFields))
+	}
+	for i, f := range rollupFields {
+		v, st := f.get(vd), &a.stats[i]
+		if a.count == 0 || v < st.Min {
+			st.Min = v
+		}
+		if a.count == 0 || v > st.Max {
+			st.Max = v
+		}
+		st.Avg += v
+		st.Last = v
+	}
+	a.count++
+}
+
+// addEarlier folds vd, older than the samples already accumulated, into
+// the accumulator; Last keeps the newest sample.
+func (a *rollupAcc) addEarlier(vd model.VehicleData) {
+	if a.count == 0 {
+		a.add(vd)
+		return
+	}
+	last := make([]float64, len(a.stats))
+	for i, st := range a.stats {
+		last[i] = st.Last
+	}
+	a.add(vd)
+	for i := range a.stats {
+		a.stats[i].Last = last[i]
+	}
+}
+
+// accOf reopens a stored rollup for further samples.
+func accOf(r Rollup) *rollupAcc {
+	a := &rollupAcc{count: r.Count, stats: make([]FieldStats, len(rollupFields))}
+	for i, f := range rollupFields {
+		st := r.Fields[f.name]
+		st.Avg *= float64(r.Count)
+		a.stats[i] = st
+	}
+	return a
+}
+
+// encode packs the finished aggregate: a version byte, the sample count and
+// min/max/avg/last of every rollup field as float64.
+func (a *rollupAcc) encode() []byte {
+	b := make([]byte, 0, 1+binary.MaxVarintLen64+len(rollupFields)*32)
+	b = append(b, rollupV1)
+	b = binary.AppendUvarint(b, uint64(a.count))
+	for _, st := range a.stats {
+		for _, v := range []float64{st.Min, st.Max, st.Avg / float64(a.count), st.Last} {
+			b = binary.BigEndian.AppendUint64(b, math.Float64bits(v))
+		}
+	}
+	return b
+}
+
+// decodeRollup unpacks a rollup written by rollupAcc.encode.
+func decodeRollup(start time.Time, b []byte) (Rollup, error) {
+	r := Rollup{Start: start, Fields: make(map[string]FieldStats, len(rollupFields))}
+	if len(b) == 0 || b[0] != rollupV1 {
+		return r, fmt.Errorf("store: unknown rollup encoding")
+	}
+	count, n := binary.Uvarint(b[1:])
+	if n <= 0 {
+		return r, errShortRecord
+	}
+	b = b[1+n:]
+	if len(b) < len(rollupFields)*32 {
+		return r, errShortRecord
+	}
+	r.Count = int(count)
+	next := func() float64 {
+		v := math.Float64frombits(binary.BigEndian.Uint64(b))
+		b = b[8:]
+		return v
+	}
+	for _, f := range rollupFields {
+		r.Fields[f.name] = FieldStats{Min: next(), Max: next(), Avg: next(), Last: next()}
+	}
+	return r, nil
+}
+
+// Rollups calls fn for the 1-minute rollups of vehicle id starting within
+// [from, to], in time order, until fn returns false. Zero from or to means
+// no bound.
+func (s *Store) Rollups(id string, from, to time.Time, fn func(Rollup) bool) error {
+	return s.db.View(func(tx *bbolt.Tx) error {
+		vb := tx.Bucket(bucketVehicles).Bucket([]byte(id))
+		if vb == nil {
+			return ErrNotFound
+		}
+		mb := vb.Bucket(bucketMinute)
+		if mb == nil {
+			return nil
+		}
+		c := mb.Cursor()
+		k, v := c.First()
+		if !from.IsZero() {
+			k, v = c.Seek(timeKey(from))
+		}
+		for ; k != nil; k, v = c.Next() {
+			if !to.IsZero() && keyTime(k).After(to) {
+				break
+			}
+			r, err := decodeRollup(keyTime(k), v)
+			if err != nil {
+				return err
+			}
+			if !fn(r) {
+				break
+			}
+		}
+		return nil
+	})
+}
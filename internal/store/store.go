@@ -0,0 +1,272 @@
+// Package store keeps vehicle telemetry as per-vehicle time series in BoltDB.
+//
+// Layout:
+//
+//	vehicles/{vehicle_id}/raw  8-byte big-endian unix-nanosecond key -> compact VehicleData
+//	vehicles/{vehicle_id}/1m   minute start key -> min/max/avg/last rollup (see Compact)
+//	latest                     vehicle_id -> 8-byte timestamp + compact VehicleData
+//	audit                      timestamp key -> JSON AuditRecord
+//
+// Keys sort by time, so a vehicle's track is a cursor range scan; the latest
+// index answers "where is every vehicle now" without touching the series.
+package store
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"time"
+
+	"LoraFog/internal/model"
+
+	"go.etcd.io/bbolt"
+)
+
+var (
+	bucketVehicles = []byte("vehicles")
+	bucketRaw      = []byte("raw")
+	bucketLatest   = []byte("latest")
+)
+
+var (
+	// ErrNoVehicle is returned for records without a vehicle ID.
+	ErrNoVehicle = errors.New("store: vehicle_id required")
+	// ErrNotFound is returned for vehicles without stored telemetry.
+	ErrNotFound = errors.New("store: no telemetry for vehicle")
+)
+
+// Point is one stored telemetry sample.
+type Point struct {
+	Time time.Time         `json:"time"`
+	Data model.VehicleData `json:"data"`
+}
+
+// Store reads and writes telemetry series in a BoltDB file it does not own.
+type Store struct {
+	db *bbolt.DB
+}
+
+// Open prepares the top-level buckets in db. A read-only db is used as is.
+func Open(db *bbolt.DB) (*Store, error) {
+	if db.IsReadOnly() {
+		return &Store{db: db}, nil
+	}
+	err := db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{bucketVehicles, bucketLatest, bucketAudit} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// Put stores vd as received at t.
+func (s *Store) Put(vd model.VehicleData, t time.Time) error {
+	return s.Write(Point{Time: t, Data: vd})
+}
+
+// Write stores points through db.Batch, so concurrent writers share one
+// transaction and fsync. Samples with the same timestamp are kept by moving
+// the later one forward by a nanosecond.
+func (s *Store) Write(points ...Point) error {
+	for _, p := range points {
+		if p.Data.VehicleID == "" {
+			return ErrNoVehicle
+		}
+	}
+	return s.db.Batch(func(tx *bbolt.Tx) error {
+		return s.WriteTx(tx, points...)
+	})
+}
+
+// WriteTx stores points inside tx, for callers that must commit them
+// together with changes of their own; see Write.
+func (s *Store) WriteTx(tx *bbolt.Tx, points ...Point) error {
+	vehicles := tx.Bucket(bucketVehicles)
+	latest := tx.Bucket(bucketLatest)
+	for _, p := range points {
+		if p.Data.VehicleID == "" {
+			return ErrNoVehicle
+		}
+		raw, err := rawBucket(vehicles, p.Data.VehicleID)
+		if err != nil {
+			return err
+		}
+		t := p.Time
+		for raw.Get(timeKey(t)) != nil {
+			t = t.Add(time.Nanosecond)
+		}
+		key, val := timeKey(t), encode(p.Data)
+		if err := raw.Put(key, val); err != nil {
+			return err
+		}
+		if err := foldLate(vehicles.Bucket([]byte(p.Data.VehicleID)), t, p.Data); err != nil {
+			return err
+		}
+		id := []byte(p.Data.VehicleID)
+		if cur := latest.Get(id); len(cur) >= 8 && bytes.Compare(cur[:8], key) > 0 {
+			continue // an older sample arrived late
+		}
+		if err := latest.Put(id, append(key, val...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rawBucket returns the raw series bucket of vehicle id, creating it.
+func rawBucket(vehicles *bbolt.Bucket, id string) (*bbolt.Bucket, error) {
+	vb, err := vehicles.CreateBucketIfNotExists([]byte(id))
+	if err != nil {
+		return nil, err
+	}
+	return vb.CreateBucketIfNotExists(bucketRaw)
+}
+
+// Latest returns the newest sample of vehicle id; ok is false if there is none.
+func (s *Store) Latest(id string) (p Point, ok bool, err error) {
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket(bucketLatest).Get([]byte(id))
+		if len(v) < 8 {
+			return nil
+		}
+		p, err = latestPoint(id, v)
+		ok = err == nil
+		return err
+	})
+	return p, ok, err
+}
+
+// LatestAll returns the newest sample of every vehicle, ordered by vehicle ID.
+func (s *Store) LatestAll() ([]Point, error) {
+	var out []Point
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketLatest).ForEach(func(k, v []byte) error {
+			if len(v) < 8 {
+				return nil
+			}
+			p, err := latestPoint(string(k), v)
+			if err != nil {
+				return err
+			}
+			out = append(out, p)
+			return nil
+		})
+	})
+	return out, err
+}
+
+// latestPoint decodes a latest-index value.
+func latestPoint(id string, v []byte) (Point, error) {
+	vd, err := decode(id, v[8:])
+	return Point{Time: keyTime(v[:8]), Data: vd}, err
+}
+
+// Vehicles returns the IDs of vehicles with stored telemetry, sorted.
+func (s *Store) Vehicles() ([]string, error) {
+	var ids []string
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketVehicles).ForEach(func(k, _ []byte) error {
+			ids = append(ids, string(k))
+			return nil
+		})
+	})
+	sort.Strings(ids)
+	return ids, err
+}
+
+// rangeChunk is the number of samples Range reads per transaction, so a slow
+// reader such as an export streamed to a client never holds a long
+// transaction that would stall the writers.
+const rangeChunk = 1000
+
+// BBox is a longitude/latitude bounding box.
+type BBox struct {
+	MinLon, MinLat, MaxLon, MaxLat float64
+}
+
+// Contains reports whether vd's position lies in b; a nil box contains
+// every position.
+func (b *BBox) Contains(vd model.VehicleData) bool {
+	return b == nil || vd.Longitude >= b.MinLon && vd.Longitude <= b.MaxLon &&
+		vd.Latitude >= b.MinLat && vd.Latitude <= b.MaxLat
+}
+
+// Range calls fn for the samples of vehicle id with from <= time <= to and
+// a position inside box, in time order, until fn returns false. Zero from or
+// to means no bound, a nil box no position filter. Samples are read
+// rangeChunk at a time and fn runs outside the read transaction.
+func (s *Store) Range(id string, from, to time.Time, box *BBox, fn func(Point) bool) error {
+	var start, end []byte
+	if !from.IsZero() {
+		start = timeKey(from)
+	}
+	if !to.IsZero() {
+		end = timeKey(to)
+	}
+	for {
+		points, next, err := s.rangeChunk(id, start, end, box)
+		if err != nil {
+			return err
+		}
+		for _, p := range points {
+			if !fn(p) {
+				return nil
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		start = next
+	}
+}
+
+// rangeChunk reads up to rangeChunk samples of Range from key start (nil
+// for the first) and returns the key to continue from, nil at the end.
+// At most ten chunks' worth of keys are scanned, so a box matching little
+// still yields the transaction regularly.
+func (s *Store) rangeChunk(id string, start, end []byte, box *BBox) (points []Point, next []byte, err error) {
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		vehicles := tx.Bucket(bucketVehicles)
+		if vehicles == nil {
+			return ErrNotFound
+		}
+		vb := vehicles.Bucket([]byte(id))
+		if vb == nil {
+			return ErrNotFound
+		}
+		raw := vb.Bucket(bucketRaw)
+		if raw == nil {
+			return nil
+		}
+		c := raw.Cursor()
+		k, v := c.First()
+		if start != nil {
+			k, v = c.Seek(start)
+		}
+		for scanned := 0; k != nil; k, v = c.Next() {
+			if end != nil && bytes.Compare(k, end) > 0 {
+				return nil
+			}
+			if len(points) == rangeChunk || scanned == 10*rangeChunk {
+				next = bytes.Clone(k)
+				return nil
+			}
+			scanned++
+			vd, err := decode(id, v)
+			if err != nil {
+				return err
+			}
+			if box.Contains(vd) {
+				points = append(points, Point{Time: keyTime(k), Data: vd})
+			}
+		}
+		return nil
+	})
+	return points, next, err
+}
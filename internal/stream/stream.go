@@ -0,0 +1,242 @@
+// Package stream provides the Server-Sent Events feed shared by the fog server
+// and the web app. Producers Publish items into a Hub, which keeps a ring of
+// recent items so clients reconnecting with Last-Event-ID miss nothing that
+// is still in history; Handler serves the hub on /api/stream.
+package stream
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHistory is the number of recent items kept for Last-Event-ID replay.
+	DefaultHistory = 512
+	// clientBuffer is the number of items queued per client before it is dropped.
+	clientBuffer = 64
+	// keepAlive is the interval of comment lines that keep idle proxies open.
+	keepAlive = 15 * time.Second
+)
+
+// Item is one SSE message. ID is assigned by the Hub.
+type Item struct {
+	ID      uint64
+	Event   string // SSE event name, e.g. "telemetry" or "handover"
+	Vehicle string // used for ?vehicle= filtering; empty matches every filter
+	Data    []byte // single-line payload, normally JSON
+}
+
+// Hub fans out published items to connected SSE clients.
+type Hub struct {
+	mu     sync.Mutex
+	nextID uint64
+	buf    []Item
+	start  int // index of the oldest item
+	n      int // number of stored items
+	subs   map[chan Item]struct{}
+}
+
+// NewHub creates a hub keeping up to history items for replay.
+func NewHub(history int) *Hub {
+	if history <= 0 {
+		history = DefaultHistory
+	}
+	return &Hub{buf: make([]Item, history), subs: map[chan Item]struct{}{}}
+}
+
+// Publish stores it in history and queues it for every client.
+// Clients whose queue is full are disconnected; they resume with Last-Event-ID.
+func (h *Hub) Publish(it Item) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.nextID++
+	it.ID = h.nextID
+	if h.n < len(h.buf) {
+		h.buf[(h.start+h.n)%len(h.buf)] = it
+		h.n++
+	} else {
+		h.buf[h.start] = it
+		h.start = (h.start + 1) % len(h.buf)
+	}
+	for ch := range h.subs {
+		select {
+		case ch <- it:
+		default:
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe registers a client and returns the history after lastID,
+// atomically with registration so nothing is lost or duplicated.
+func (h *Hub) subscribe(lastID uint64) (chan Item, []Item) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var backlog []Item
+	for i := 0; i < h.n; i++ {
+		it := h.buf[(h.start+i)%len(h.buf)]
+		if it.ID > lastID {
+			backlog = append(backlog, it)
+		}
+	}
+	ch := make(chan Item, clientBuffer)
+	h.subs[ch] = struct{}{}
+	return ch, backlog
+}
+
+// unsubscribe removes a client if the hub has not already dropped it.
+func (h *Hub) unsubscribe(ch chan Item) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// Clients returns the number of connected SSE clients.
+func (h *Hub) Clients() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// filter selects items for one client from ?vehicle= and ?type= parameters.
+// Both accept repeated or comma-separated values; absent means everything.
+type filter struct {
+	vehicles map[string]struct{}
+	types    map[string]struct{}
+}
+
+// parseSet collects repeated and comma-separated query values.
+func parseSet(values []string) map[string]struct{} {
+	var set map[string]struct{}
+	for _, v := range values {
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p == "" {
+				continue
+			}
+			if set == nil {
+				set = map[string]struct{}{}
+			}
+			set[p] = struct{}{}
+		}
+	}
+	return set
+}
+
+// matches reports whether it passes the filter.
+func (f filter) matches(it Item) bool {
+	if f.types != nil {
+		if _, ok := f.types[it.Event]; !ok {
+			return false
+		}
+	}
+	if f.vehicles != nil && it.Vehicle != "" {
+		if _, ok := f.vehicles[it.Vehicle]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// lastEventID reads the resume point from the Last-Event-ID header, or from
+// ?last_event_id= for clients that cannot set headers on the first request.
+// ok is false when the client did not ask to resume.
+func lastEventID(r *http.Request) (id uint64, ok bool, err error) {
+	s := r.Header.Get("Last-Event-ID")
+	if s == "" {
+		s = r.URL.Query().Get("last_event_id")
+	}
+	if s == "" {
+		return 0, false, nil
+	}
+	id, err = strconv.ParseUint(s, 10, 64)
+	return id, true, err
+}
+
+// write sends one item in SSE framing.
+func write(w http.ResponseWriter, it Item) error {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", it.ID, it.Event, it.Data)
+	return err
+}
+
+// Handler serves the hub as text/event-stream. Without Last-Event-ID only
+// new items are sent; with it, retained history after that ID is replayed first.
+func Handler(component string, h *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		last, resume, err := lastEventID(r)
+		if err != nil {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		q := r.URL.Query()
+		f := filter{vehicles: parseSet(q["vehicle"]), types: parseSet(q["type"])}
+
+		ch, backlog := h.subscribe(last)
+		if !resume {
+			backlog = nil
+		}
+		defer h.unsubscribe(ch)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		if _, err := fmt.Fprint(w, "retry: 3000\n\n"); err != nil {
+			return
+		}
+		for _, it := range backlog {
+			if !f.matches(it) {
+				continue
+			}
+			if err := write(w, it); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+		log.Printf("[%s] sse client %s connected (replayed %d)", component, r.RemoteAddr, len(backlog))
+
+		ticker := time.NewTicker(keepAlive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				log.Printf("[%s] sse client %s disconnected", component, r.RemoteAddr)
+				return
+			case it, ok := <-ch:
+				if !ok {
+					log.Printf("[%s] sse client %s dropped: too slow", component, r.RemoteAddr)
+					return
+				}
+				if !f.matches(it) {
+					continue
+				}
+				if err := write(w, it); err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-ticker.C:
+				if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	}
+}